@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// settings that can be changed while the storage is open.
+// each one knows how to show its current value and how to parse a new one,
+// only tunables that are safe to flip at any moment belong here
+// (Compress for example isn't, old values would stop decoding).
+// set runs with optsMu held, it parses and checks the value before it
+// assigns anything and writes only its own field of s.opts: the rest of
+// Options is read without optsMu, and every reader of a field here takes it
+// (MaintenanceMode, resolveWriteOptions, autoCompact).
+type runtimeOption struct {
+	get func(o *Options) string
+	set func(s *Storage, value string) error
+}
+
+var runtimeOptions = map[string]runtimeOption{
+	"maintenance": {
+		get: func(o *Options) string { return strconv.FormatBool(o.Maintenance) },
+		set: func(s *Storage, value string) error {
+			on, err := strconv.ParseBool(value)
+			if err != nil {
+				return err
+			}
+			s.opts.Maintenance = on
+			return nil
+		},
+	},
+	"sync": {
+		get: func(o *Options) string { return o.Sync.String() },
+		set: func(s *Storage, value string) error {
+			policy, err := ParseSyncPolicy(value)
+			if err != nil {
+				return err
+			}
+			s.opts.Sync = policy
+			return nil
+		},
+	},
+	"auto_compact_dead_space": {
+		get: func(o *Options) string { return formatRatio(o.AutoCompact.DeadSpaceRatio) },
+		set: func(s *Storage, value string) error {
+			ratio, err := parseRatio(value)
+			if err != nil {
+				return err
+			}
+			s.opts.AutoCompact.DeadSpaceRatio = ratio
+			return nil
+		},
+	},
+	"auto_compact_tombstones": {
+		get: func(o *Options) string { return strconv.FormatUint(o.AutoCompact.Tombstones, 10) },
+		set: func(s *Storage, value string) error {
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			s.opts.AutoCompact.Tombstones = n
+			return nil
+		},
+	},
+	"auto_compact_fragmentation": {
+		get: func(o *Options) string { return formatRatio(o.AutoCompact.Fragmentation) },
+		set: func(s *Storage, value string) error {
+			ratio, err := parseRatio(value)
+			if err != nil {
+				return err
+			}
+			s.opts.AutoCompact.Fragmentation = ratio
+			return nil
+		},
+	},
+	// resizes the storage's own page cache. a storage opened without a
+	// CacheSize has no budget to change, and a shared BufferPool belongs to
+	// all its storages
+	"cache_size": {
+		get: func(o *Options) string { return strconv.Itoa(o.CacheSize) },
+		set: func(s *Storage, value string) error {
+			pages, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			switch {
+			case pages < 1:
+				return fmt.Errorf("%d pages, want at least 1", pages)
+			case s.opts.BufferPool != nil:
+				return errors.New("the storage shares a BufferPool, resize the pool instead")
+			case s.pool == nil:
+				return errors.New("the storage was opened without a CacheSize")
+			}
+			s.opts.CacheSize = pages
+			s.pool.resize(pages)
+			return nil
+		},
+	},
+}
+
+// a threshold between 0 (off) and 1
+func parseRatio(value string) (float64, error) {
+	ratio, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if ratio < 0 || ratio > 1 {
+		return 0, fmt.Errorf("%v is not between 0 and 1", ratio)
+	}
+	return ratio, nil
+}
+
+func formatRatio(ratio float64) string {
+	return strconv.FormatFloat(ratio, 'g', -1, 64)
+}
+
+// String returns the name used by SetOption and the admin endpoint.
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncOnClose:
+		return "on_close"
+	case SyncAlways:
+		return "always"
+	case SyncGroupCommit:
+		return "group_commit"
+	default:
+		return fmt.Sprintf("SyncPolicy(%d)", int(p))
+	}
+}
+
+// ParseSyncPolicy is the reverse of SyncPolicy.String.
+func ParseSyncPolicy(value string) (SyncPolicy, error) {
+	switch value {
+	case "on_close":
+		return SyncOnClose, nil
+	case "always":
+		return SyncAlways, nil
+	case "group_commit":
+		return SyncGroupCommit, nil
+	default:
+		return 0, fmt.Errorf("unknown sync policy %q (want on_close, always or group_commit)", value)
+	}
+}
+
+// SetOption changes a tunable at runtime, example: db.SetOption("sync", "always")
+func (s *Storage) SetOption(name, value string) error {
+	opt, ok := runtimeOptions[name]
+	if !ok {
+		return fmt.Errorf("unknown or read-only option %q", name)
+	}
+
+	// a bad value fails to parse before set changes anything
+	s.optsMu.Lock()
+	defer s.optsMu.Unlock()
+	if err := opt.set(s, value); err != nil {
+		return fmt.Errorf("option %s: %w", name, err)
+	}
+	return nil
+}
+
+// Option returns the current value of a runtime option.
+func (s *Storage) Option(name string) (string, error) {
+	opt, ok := runtimeOptions[name]
+	if !ok {
+		return "", fmt.Errorf("unknown or read-only option %q", name)
+	}
+	s.optsMu.RLock()
+	defer s.optsMu.RUnlock()
+	return opt.get(&s.opts), nil
+}
+
+// all runtime options with their current values
+func (s *Storage) runtimeOptionValues() map[string]string {
+	s.optsMu.RLock()
+	defer s.optsMu.RUnlock()
+	values := make(map[string]string, len(runtimeOptions))
+	for name, opt := range runtimeOptions {
+		values[name] = opt.get(&s.opts)
+	}
+	return values
+}
+
+// AdminHandler serves the runtime options over HTTP, mount it wherever you like:
+//
+//	http.Handle("/admin/", http.StripPrefix("/admin", db.AdminHandler()))
+//
+//	GET /options          → {"sync": "on_close", ...}
+//	GET /options/sync     → {"sync": "on_close"}
+//	PUT /options/sync     body "always" → {"sync": "always"}
+//	GET /health           → {"Writable": true, ...}, 503 while writes are refused
+func (s *Storage) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" && r.Method == http.MethodGet {
+			health, status := s.Health(), http.StatusOK
+			if !health.Writable {
+				status = http.StatusServiceUnavailable
+			}
+			writeJSON(w, status, health)
+			return
+		}
+		name, found := strings.CutPrefix(r.URL.Path, "/options")
+		name = strings.TrimPrefix(name, "/")
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch {
+		case name == "" && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, s.runtimeOptionValues())
+
+		case name != "" && r.Method == http.MethodGet:
+			value, err := s.Option(name)
+			if err != nil {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{name: value})
+
+		case name != "" && (r.Method == http.MethodPut || r.Method == http.MethodPost):
+			body, err := io.ReadAll(io.LimitReader(r.Body, 4096))
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			if _, known := runtimeOptions[name]; !known {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown or read-only option %q", name)})
+				return
+			}
+			if err := s.SetOption(name, strings.TrimSpace(string(body))); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			value, _ := s.Option(name)
+			writeJSON(w, http.StatusOK, map[string]string{name: value})
+
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// RuntimeOptionNames lists the options SetOption accepts, sorted.
+func RuntimeOptionNames() []string {
+	names := make([]string, 0, len(runtimeOptions))
+	for name := range runtimeOptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
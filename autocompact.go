@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// auto-compaction: Compact runs by itself once the file has gone sparse
+// enough, instead of an operator watching for it. any of three thresholds
+// triggers it:
+//
+//	dead space     share of the file's page bytes no live record uses
+//	tombstones     records deleted since the last compaction (or open)
+//	fragmentation  share of pages that are empty, sitting on the free list
+//
+//	opts.AutoCompact = AutoCompactPolicy{DeadSpaceRatio: 0.5, Fragmentation: 0.25}
+//
+// there is no background goroutine, writes aren't safe to run next to a
+// rewrite, so the check rides along at the end of Sync, at most once per
+// CheckInterval. measuring dead space reads every page, the other two are
+// free. a compaction started this way is counted in Stats by the thresholds
+// that triggered it, together with the bytes it reclaimed.
+
+// AutoCompactPolicy decides when Sync compacts the file, zero thresholds are off.
+type AutoCompactPolicy struct {
+	DeadSpaceRatio float64 // 0.5 = compact once half the page bytes are unused
+	Tombstones     uint64  // compact after this many deletes
+	Fragmentation  float64 // 0.25 = compact once a quarter of the pages are empty
+	// files smaller than this are left alone, rewriting them gains nothing (0 means 64)
+	MinPages uint32
+	// how often Sync checks the thresholds at most (0 means a minute)
+	CheckInterval time.Duration
+}
+
+// CompactTrigger is a threshold that started an automatic compaction.
+type CompactTrigger string
+
+const (
+	TriggerDeadSpace     CompactTrigger = "dead_space"
+	TriggerTombstones    CompactTrigger = "tombstones"
+	TriggerFragmentation CompactTrigger = "fragmentation"
+)
+
+const (
+	defaultAutoCompactMinPages = 64
+	defaultAutoCompactInterval = time.Minute
+)
+
+func (p AutoCompactPolicy) enabled() bool {
+	return p.DeadSpaceRatio > 0 || p.Tombstones > 0 || p.Fragmentation > 0
+}
+
+// called at the end of Sync
+func (s *Storage) autoCompact() error {
+	s.optsMu.RLock()
+	policy := s.opts.AutoCompact
+	s.optsMu.RUnlock()
+	if !policy.enabled() || s.compacting || s.MaintenanceMode() {
+		return nil
+	}
+	now := s.clock().Now()
+	if now.Before(s.nextCompactCheck) {
+		return nil
+	}
+	interval := policy.CheckInterval
+	if interval <= 0 {
+		interval = defaultAutoCompactInterval
+	}
+	s.nextCompactCheck = now.Add(interval)
+
+	triggers, err := s.compactTriggers(policy)
+	if err != nil || len(triggers) == 0 {
+		return err
+	}
+	if _, err := s.compact(triggers); err != nil {
+		return fmt.Errorf("auto compact (%v): %w", triggers, err)
+	}
+	return nil
+}
+
+// returns the thresholds the file is over right now
+func (s *Storage) compactTriggers(policy AutoCompactPolicy) ([]CompactTrigger, error) {
+	minPages := policy.MinPages
+	if minPages == 0 {
+		minPages = defaultAutoCompactMinPages
+	}
+	if s.totalPages < minPages {
+		return nil, nil
+	}
+
+	var triggers []CompactTrigger
+	if policy.DeadSpaceRatio > 0 {
+		live, err := s.liveBytes()
+		if err != nil {
+			return nil, err
+		}
+		dead := 1 - float64(live)/float64(int64(s.totalPages)*PageSize)
+		if dead >= policy.DeadSpaceRatio {
+			triggers = append(triggers, TriggerDeadSpace)
+		}
+	}
+	if policy.Tombstones > 0 && s.deletesSinceCompact >= policy.Tombstones {
+		triggers = append(triggers, TriggerTombstones)
+	}
+	if policy.Fragmentation > 0 && float64(len(s.freePages))/float64(s.totalPages) >= policy.Fragmentation {
+		triggers = append(triggers, TriggerFragmentation)
+	}
+	return triggers, nil
+}
+
+// adds up the size of every record the index points to, headers included
+func (s *Storage) liveBytes() (int64, error) {
+	var live int64
+	for pageID := uint32(0); pageID < s.totalPages; pageID++ {
+		page, err := s.loadPage(pageID)
+		if err != nil {
+			return 0, err
+		}
+		// an unreadable record ends the page, buildIndex stopped there too,
+		// nothing after it is live
+		var lookupErr error
+		page.eachRecord(func(key, _ string, size int) bool {
+			id, ok, err := s.lookup(key)
+			if ok && id == pageID {
+				live += int64(size)
+			}
+			lookupErr = err
+			return err == nil
+		})
+		if lookupErr != nil {
+			return 0, lookupErr
+		}
+	}
+	return live, nil
+}
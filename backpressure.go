@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// BackpressurePolicy decides what a write does when too many dirty pages
+// are waiting in memory (Options.MaxDirtyPages). with SyncOnClose nothing
+// flushes them until Sync/Close, so a long burst of writes would otherwise
+// grow memory without limit.
+type BackpressurePolicy int
+
+const (
+	// BackpressureBlock makes the write flush the dirty pages itself before it
+	// goes ahead, the writer stalls for as long as the flush takes
+	BackpressureBlock BackpressurePolicy = iota
+	// BackpressureSleep delays every write over the limit by StallDelay, giving
+	// a periodic Sync time to catch up. at twice the limit it flushes like Block,
+	// so memory stays bounded even when nothing else syncs
+	BackpressureSleep
+	// BackpressureReject fails the write with ErrOverloaded, the caller decides
+	// whether to Sync, retry later or shed the request
+	BackpressureReject
+)
+
+func (p BackpressurePolicy) String() string {
+	switch p {
+	case BackpressureBlock:
+		return "block"
+	case BackpressureSleep:
+		return "sleep"
+	case BackpressureReject:
+		return "reject"
+	default:
+		return fmt.Sprintf("BackpressurePolicy(%d)", int(p))
+	}
+}
+
+const defaultStallDelay = time.Millisecond
+
+// takes s.mu for a write. under BackpressureSleep a write over the limit
+// waits StallDelay here first, with s.mu let go so readers and the Sync it is
+// waiting for can get in. it happens before the write looks at anything: a
+// write that reads before it writes (Import's conflict check, say) does both
+// under one hold of the lock, and sleeping in between would let another
+// writer in.
+func (s *Storage) lockForWrite() {
+	s.mu.Lock()
+	delay := s.stallDelay()
+	if delay <= 0 {
+		return
+	}
+	s.mu.Unlock()
+	start := s.clock().Now()
+	s.stats.stalls.Add(1)
+	s.clock().Sleep(delay)
+	s.stats.stallNanos.Add(uint64(s.clock().Now().Sub(start)))
+	s.mu.Lock()
+}
+
+// how long a write waits under BackpressureSleep, 0 when it doesn't: under
+// the limit, or at twice the limit where applyBackpressure flushes instead.
+// called with s.mu held.
+func (s *Storage) stallDelay() time.Duration {
+	s.optsMu.RLock()
+	limit, policy, delay := s.opts.MaxDirtyPages, s.opts.Backpressure, s.opts.StallDelay
+	s.optsMu.RUnlock()
+	if policy != BackpressureSleep || limit <= 0 {
+		return 0
+	}
+	if dirty := s.dirtyPages(); dirty < limit || dirty >= 2*limit {
+		return 0
+	}
+	if delay <= 0 {
+		delay = defaultStallDelay
+	}
+	return delay
+}
+
+// checked at the top of every write, before anything is changed, with s.mu
+// held the whole time. the sleep of BackpressureSleep happened already, in
+// lockForWrite.
+func (s *Storage) applyBackpressure() error {
+	if err := s.checkDiskFull(); err != nil {
+		return err
+	}
+	if err := s.checkWALSize(); err != nil {
+		return err
+	}
+	// nothing holds a page yet, the one place dirty pages can be evicted
+	if err := s.trimPages(); err != nil {
+		return err
+	}
+	s.optsMu.RLock()
+	limit, policy := s.opts.MaxDirtyPages, s.opts.Backpressure
+	s.optsMu.RUnlock()
+	if limit <= 0 {
+		return nil
+	}
+	dirty := s.dirtyPages()
+	if dirty < limit {
+		return nil
+	}
+
+	if policy == BackpressureReject {
+		s.stats.rejected.Add(1)
+		return ErrOverloaded
+	}
+	if policy == BackpressureSleep && dirty < 2*limit {
+		return nil
+	}
+
+	start := s.clock().Now()
+	s.stats.stalls.Add(1)
+	defer func() { s.stats.stallNanos.Add(uint64(s.clock().Now().Sub(start))) }()
+	return s.sync()
+}
+
+// counts the pages changed in memory but not written yet
+func (s *Storage) dirtyPages() int {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	n := 0
+	for _, page := range s.pages {
+		if page.IsDirty {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,619 @@
+package main
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// disk-backed B+ tree for the key → page index, used instead of the pageIndex
+// map with Options.BTreeIndex. it lives in <file>.bpt, in nodes of
+// btreeNodeSize bytes, and only a bounded number of nodes is kept in memory,
+// so the index no longer has to fit in RAM, keys come out in order, and a
+// clean open doesn't read the data pages at all.
+//
+//	node 0     header: state, LSN and page counts of the data file it matches,
+//	           root node, node count, key count, the collation the keys are
+//	           sorted by, the data file's free list
+//	node 1..   [leaf u8][count u16][next leaf u32] entries...
+//	           leaf entry:     [keyLen u16][key][pageID u32]
+//	           internal:       [child0 u32] then [keyLen u16][key][child u32] ...
+//
+// internal nodes: keys below keys[i] are under children[i], the rest further
+// right. leaves are chained left to right through next (0 = last leaf).
+//
+// the tree is flushed by Sync, after the data file's header. before the first
+// node is written over, the header is marked as being written, so after a
+// crash the header either matches the data file exactly or says it doesn't,
+// and the index is rebuilt from a scan of the pages like before.
+//
+// deletes don't merge nodes, a tree with many deletes keeps some half empty
+// nodes until the next rebuild (Compact rebuilds it).
+
+const (
+	btreeSuffix          = ".bpt"
+	btreeNodeSize        = 16384      // a record (≤ 4KB) key always fits several times
+	btreeMagic           = 0x54424447 // "GDBT"
+	btreeVersion         = 2
+	btreeNodeHeaderSize  = 1 + 2 + 4
+	btreeHeaderFixedSize = 4 + 4 + 4 + 8 + 4 + 4 + 4 + 4 + 8 + 4 + 4
+	defaultBTreeCache    = 256 // nodes, 4MB
+)
+
+const (
+	btreeClean   = 0 // the nodes on disk are the tree the header describes
+	btreeWriting = 1 // nodes are being written over, don't trust the file
+)
+
+type btreeNode struct {
+	id       uint32
+	leaf     bool
+	keys     []string
+	pageIDs  []uint32 // leaf: where keys[i] lives
+	children []uint32 // internal: len(keys)+1 node IDs
+	next     uint32   // leaf: right sibling
+	dirty    bool
+	elem     *list.Element // position in the LRU list
+}
+
+// what the header says about the data file the tree belongs to
+type btreeMatch struct {
+	lsn        uint64
+	totalPages uint32
+	nextPageID uint32
+	collation  uint32 // collationTag of the order the keys are in
+}
+
+type btree struct {
+	mu        sync.Mutex // reads move nodes in the LRU list, so they lock too
+	file      *os.File
+	state     uint32
+	match     btreeMatch
+	root      uint32
+	nodes     uint32 // node IDs handed out, the header included
+	keys      uint64
+	free      []uint32 // the data file's free list, kept in the header
+	cache     map[uint32]*btreeNode
+	lru       *list.List // front = most recently used
+	maxCached int
+	cmp       func(a, b string) int // key order, nil = byte order (see collation.go)
+}
+
+func openBTree(path string, maxCached int) (*btree, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open btree index: %w", err)
+	}
+	if maxCached <= 0 {
+		maxCached = defaultBTreeCache
+	}
+	t := &btree{file: file, cache: map[uint32]*btreeNode{}, lru: list.New(), maxCached: maxCached}
+	if err := t.readHeader(); err != nil {
+		// new or unusable, start empty, the caller rebuilds it
+		if err := t.reset(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// reports whether the tree on disk is the index of a data file in this state
+func (t *btree) matches(m btreeMatch) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state == btreeClean && t.match == m
+}
+
+// empties the tree, the file is marked as being written until the next flush
+func (t *btree) reset() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cache = map[uint32]*btreeNode{}
+	t.lru.Init()
+	t.keys = 0
+	t.free = nil
+	t.nodes = 1
+	t.match = btreeMatch{}
+	if err := t.file.Truncate(btreeNodeSize); err != nil {
+		return fmt.Errorf("reset btree index: %w", err)
+	}
+	t.state = btreeClean // so markWriting writes the header
+	if err := t.markWriting(); err != nil {
+		return err
+	}
+	t.root = t.newNode(true).id
+	return nil
+}
+
+func (t *btree) readHeader() error {
+	buf := make([]byte, btreeNodeSize)
+	if _, err := t.file.ReadAt(buf, 0); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(buf[0:4]) != btreeMagic || binary.LittleEndian.Uint32(buf[4:8]) != btreeVersion {
+		return errors.New("not a btree index file")
+	}
+	t.state = binary.LittleEndian.Uint32(buf[8:12])
+	t.match.lsn = binary.LittleEndian.Uint64(buf[12:20])
+	t.match.totalPages = binary.LittleEndian.Uint32(buf[20:24])
+	t.match.nextPageID = binary.LittleEndian.Uint32(buf[24:28])
+	t.root = binary.LittleEndian.Uint32(buf[28:32])
+	t.nodes = binary.LittleEndian.Uint32(buf[32:36])
+	t.keys = binary.LittleEndian.Uint64(buf[36:44])
+	freeCount := int(binary.LittleEndian.Uint32(buf[44:48]))
+	t.match.collation = binary.LittleEndian.Uint32(buf[48:52])
+	t.free = nil
+	for i := 0; i < freeCount; i++ {
+		at := btreeHeaderFixedSize + 4*i
+		t.free = append(t.free, binary.LittleEndian.Uint32(buf[at:at+4]))
+	}
+	if t.root == 0 || t.root >= t.nodes {
+		return errors.New("btree index header points outside the file")
+	}
+	return nil
+}
+
+func (t *btree) writeHeader() error {
+	buf := make([]byte, btreeNodeSize)
+	binary.LittleEndian.PutUint32(buf[0:4], btreeMagic)
+	binary.LittleEndian.PutUint32(buf[4:8], btreeVersion)
+	binary.LittleEndian.PutUint32(buf[8:12], t.state)
+	binary.LittleEndian.PutUint64(buf[12:20], t.match.lsn)
+	binary.LittleEndian.PutUint32(buf[20:24], t.match.totalPages)
+	binary.LittleEndian.PutUint32(buf[24:28], t.match.nextPageID)
+	binary.LittleEndian.PutUint32(buf[28:32], t.root)
+	binary.LittleEndian.PutUint32(buf[32:36], t.nodes)
+	binary.LittleEndian.PutUint64(buf[36:44], t.keys)
+	// a free list too long for the header is cut, those pages just aren't
+	// reused until a GC or scan finds them again
+	free := t.free
+	if max := (btreeNodeSize - btreeHeaderFixedSize) / 4; len(free) > max {
+		free = free[:max]
+	}
+	binary.LittleEndian.PutUint32(buf[44:48], uint32(len(free)))
+	binary.LittleEndian.PutUint32(buf[48:52], t.match.collation)
+	for i, id := range free {
+		at := btreeHeaderFixedSize + 4*i
+		binary.LittleEndian.PutUint32(buf[at:at+4], id)
+	}
+	if _, err := t.file.WriteAt(buf, 0); err != nil {
+		return fmt.Errorf("write btree index header: %w", err)
+	}
+	return syncFile(t.file)
+}
+
+// called before any node on disk is written over
+func (t *btree) markWriting() error {
+	if t.state == btreeWriting {
+		return nil
+	}
+	t.state = btreeWriting
+	return t.writeHeader()
+}
+
+// writes every dirty node and then a clean header saying which data file
+// state the tree matches
+func (t *btree) flush(m btreeMatch, free []uint32) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, n := range t.cache {
+		if n.dirty {
+			if err := t.writeNode(n); err != nil {
+				return err
+			}
+		}
+	}
+	if err := syncFile(t.file); err != nil {
+		return fmt.Errorf("sync btree index: %w", err)
+	}
+	t.state, t.match, t.free = btreeClean, m, append([]uint32(nil), free...)
+	return t.writeHeader()
+}
+
+func (t *btree) close() error {
+	return t.file.Close()
+}
+
+func (t *btree) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return int(t.keys)
+}
+
+func (n *btreeNode) size() int {
+	size := btreeNodeHeaderSize
+	if !n.leaf {
+		size += 4 // child0
+	}
+	for _, key := range n.keys {
+		size += 2 + len(key) + 4
+	}
+	return size
+}
+
+func (t *btree) newNode(leaf bool) *btreeNode {
+	n := &btreeNode{id: t.nodes, leaf: leaf, dirty: true}
+	t.nodes++
+	n.elem = t.lru.PushFront(n)
+	t.cache[n.id] = n
+	return n
+}
+
+func (t *btree) node(id uint32) (*btreeNode, error) {
+	if n, ok := t.cache[id]; ok {
+		t.lru.MoveToFront(n.elem)
+		return n, nil
+	}
+	buf := make([]byte, btreeNodeSize)
+	if _, err := t.file.ReadAt(buf, int64(id)*btreeNodeSize); err != nil {
+		return nil, fmt.Errorf("read btree node %d: %w", id, err)
+	}
+	n, err := decodeBTreeNode(id, buf)
+	if err != nil {
+		return nil, err
+	}
+	n.elem = t.lru.PushFront(n)
+	t.cache[id] = n
+	return n, nil
+}
+
+func (t *btree) writeNode(n *btreeNode) error {
+	if err := t.markWriting(); err != nil {
+		return err
+	}
+	buf := make([]byte, btreeNodeSize)
+	if n.leaf {
+		buf[0] = 1
+	}
+	binary.LittleEndian.PutUint16(buf[1:3], uint16(len(n.keys)))
+	binary.LittleEndian.PutUint32(buf[3:7], n.next)
+	at := btreeNodeHeaderSize
+	if !n.leaf {
+		binary.LittleEndian.PutUint32(buf[at:at+4], n.children[0])
+		at += 4
+	}
+	for i, key := range n.keys {
+		binary.LittleEndian.PutUint16(buf[at:at+2], uint16(len(key)))
+		at += 2 + copy(buf[at+2:], key)
+		if n.leaf {
+			binary.LittleEndian.PutUint32(buf[at:at+4], n.pageIDs[i])
+		} else {
+			binary.LittleEndian.PutUint32(buf[at:at+4], n.children[i+1])
+		}
+		at += 4
+	}
+	if _, err := t.file.WriteAt(buf, int64(n.id)*btreeNodeSize); err != nil {
+		return fmt.Errorf("write btree node %d: %w", n.id, err)
+	}
+	n.dirty = false
+	return nil
+}
+
+func decodeBTreeNode(id uint32, buf []byte) (*btreeNode, error) {
+	bad := func() (*btreeNode, error) { return nil, fmt.Errorf("btree node %d is damaged", id) }
+	n := &btreeNode{id: id, leaf: buf[0] == 1}
+	count := int(binary.LittleEndian.Uint16(buf[1:3]))
+	n.next = binary.LittleEndian.Uint32(buf[3:7])
+	at := btreeNodeHeaderSize
+	if !n.leaf {
+		n.children = append(n.children, binary.LittleEndian.Uint32(buf[at:at+4]))
+		at += 4
+	}
+	for i := 0; i < count; i++ {
+		if at+2 > len(buf) {
+			return bad()
+		}
+		keyLen := int(binary.LittleEndian.Uint16(buf[at : at+2]))
+		at += 2
+		if at+keyLen+4 > len(buf) {
+			return bad()
+		}
+		n.keys = append(n.keys, string(buf[at:at+keyLen]))
+		at += keyLen
+		ref := binary.LittleEndian.Uint32(buf[at : at+4])
+		at += 4
+		if n.leaf {
+			n.pageIDs = append(n.pageIDs, ref)
+		} else {
+			n.children = append(n.children, ref)
+		}
+	}
+	return n, nil
+}
+
+// drops the least recently used nodes over the limit, writing the dirty ones.
+// only called between operations, never while one holds node pointers.
+func (t *btree) trim() error {
+	for len(t.cache) > t.maxCached {
+		n := t.lru.Back().Value.(*btreeNode)
+		if n.dirty {
+			if err := t.writeNode(n); err != nil {
+				return err
+			}
+		}
+		t.lru.Remove(n.elem)
+		delete(t.cache, n.id)
+	}
+	return nil
+}
+
+// which child of an internal node key belongs under
+func (t *btree) childIndex(n *btreeNode, key string) int {
+	return sort.Search(len(n.keys), func(i int) bool { return t.compare(n.keys[i], key) > 0 })
+}
+
+// the first of the sorted keys that isn't below key
+func (t *btree) search(keys []string, key string) int {
+	if t.cmp == nil {
+		return sort.SearchStrings(keys, key)
+	}
+	return sort.Search(len(keys), func(i int) bool { return t.cmp(keys[i], key) >= 0 })
+}
+
+func (t *btree) compare(a, b string) int {
+	if t.cmp == nil {
+		return strings.Compare(a, b)
+	}
+	return t.cmp(a, b)
+}
+
+// finds the leaf key belongs in
+func (t *btree) leafFor(key string) (*btreeNode, error) {
+	n, err := t.node(t.root)
+	for err == nil && !n.leaf {
+		n, err = t.node(n.children[t.childIndex(n, key)])
+	}
+	return n, err
+}
+
+func (t *btree) get(key string) (uint32, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.trim()
+	leaf, err := t.leafFor(key)
+	if err != nil {
+		return 0, false, err
+	}
+	i := t.search(leaf.keys, key)
+	if i < len(leaf.keys) && leaf.keys[i] == key {
+		return leaf.pageIDs[i], true, nil
+	}
+	return 0, false, nil
+}
+
+// the greatest key below key. usually the leaf key would be in has it, the
+// leaves further left are only tried when that one has nothing smaller (or
+// was emptied by deletes).
+func (t *btree) floor(key string) (string, uint32, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.trim()
+	return t.floorIn(t.root, key)
+}
+
+func (t *btree) floorIn(id uint32, key string) (string, uint32, bool, error) {
+	n, err := t.node(id)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if n.leaf {
+		i := t.search(n.keys, key)
+		if i == 0 {
+			return "", 0, false, nil
+		}
+		return n.keys[i-1], n.pageIDs[i-1], true, nil
+	}
+	// children right of the one key is under only hold bigger keys
+	for i := t.search(n.keys, key); i >= 0; i-- {
+		k, pageID, found, err := t.floorIn(n.children[i], key)
+		if err != nil || found {
+			return k, pageID, found, err
+		}
+	}
+	return "", 0, false, nil
+}
+
+// the greatest key of all, from the rightmost leaf that isn't empty
+func (t *btree) last() (string, uint32, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.trim()
+	return t.lastIn(t.root)
+}
+
+func (t *btree) lastIn(id uint32) (string, uint32, bool, error) {
+	n, err := t.node(id)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if n.leaf {
+		if len(n.keys) == 0 {
+			return "", 0, false, nil
+		}
+		return n.keys[len(n.keys)-1], n.pageIDs[len(n.keys)-1], true, nil
+	}
+	for i := len(n.children) - 1; i >= 0; i-- {
+		k, pageID, found, err := t.lastIn(n.children[i])
+		if err != nil || found {
+			return k, pageID, found, err
+		}
+	}
+	return "", 0, false, nil
+}
+
+type btreeSplit struct {
+	key   string // first key of the new right node
+	right uint32
+}
+
+func (t *btree) put(key string, pageID uint32) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	split, err := t.insert(t.root, key, pageID)
+	if err != nil {
+		return err
+	}
+	if split != nil {
+		root := t.newNode(false)
+		root.keys = []string{split.key}
+		root.children = []uint32{t.root, split.right}
+		t.root = root.id
+	}
+	return t.trim()
+}
+
+func (t *btree) insert(id uint32, key string, pageID uint32) (*btreeSplit, error) {
+	n, err := t.node(id)
+	if err != nil {
+		return nil, err
+	}
+	if n.leaf {
+		i := t.search(n.keys, key)
+		if i < len(n.keys) && n.keys[i] == key {
+			n.pageIDs[i] = pageID
+			n.dirty = true
+			return nil, nil
+		}
+		n.keys = insertAt(n.keys, i, key)
+		n.pageIDs = insertAt(n.pageIDs, i, pageID)
+		t.keys++
+	} else {
+		i := t.childIndex(n, key)
+		split, err := t.insert(n.children[i], key, pageID)
+		if err != nil || split == nil {
+			return nil, err
+		}
+		n.keys = insertAt(n.keys, i, split.key)
+		n.children = insertAt(n.children, i+1, split.right)
+	}
+	n.dirty = true
+	if n.size() <= btreeNodeSize {
+		return nil, nil
+	}
+	return t.split(n), nil
+}
+
+// moves the upper half (by bytes) of an overfull node into a new right sibling
+func (t *btree) split(n *btreeNode) *btreeSplit {
+	mid, size := 0, 0
+	for mid < len(n.keys)-1 && size < n.size()/2 {
+		size += 2 + len(n.keys[mid]) + 4
+		mid++
+	}
+	right := t.newNode(n.leaf)
+	if n.leaf {
+		right.keys = append([]string(nil), n.keys[mid:]...)
+		right.pageIDs = append([]uint32(nil), n.pageIDs[mid:]...)
+		n.keys, n.pageIDs = n.keys[:mid:mid], n.pageIDs[:mid:mid]
+		right.next, n.next = n.next, right.id
+		return &btreeSplit{key: right.keys[0], right: right.id}
+	}
+	// the middle key moves up, it isn't kept in either half
+	sep := n.keys[mid]
+	right.keys = append([]string(nil), n.keys[mid+1:]...)
+	right.children = append([]uint32(nil), n.children[mid+1:]...)
+	n.keys, n.children = n.keys[:mid:mid], n.children[:mid+1:mid+1]
+	return &btreeSplit{key: sep, right: right.id}
+}
+
+func (t *btree) delete(key string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.trim()
+	leaf, err := t.leafFor(key)
+	if err != nil {
+		return false, err
+	}
+	i := t.search(leaf.keys, key)
+	if i == len(leaf.keys) || leaf.keys[i] != key {
+		return false, nil
+	}
+	leaf.keys = append(leaf.keys[:i], leaf.keys[i+1:]...)
+	leaf.pageIDs = append(leaf.pageIDs[:i], leaf.pageIDs[i+1:]...)
+	leaf.dirty = true
+	t.keys--
+	return true, nil
+}
+
+// calls fn for every key with start <= key < end in order (end "" = no
+// bound) until it returns false. fn must not use the tree.
+func (t *btree) ascend(start, end string, fn func(key string, pageID uint32) bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.trim()
+	leaf, err := t.leafFor(start)
+	if err != nil {
+		return err
+	}
+	i := t.search(leaf.keys, start)
+	for {
+		for ; i < len(leaf.keys); i++ {
+			if end != "" && t.compare(leaf.keys[i], end) >= 0 {
+				return nil
+			}
+			if !fn(leaf.keys[i], leaf.pageIDs[i]) {
+				return nil
+			}
+		}
+		next := leaf.next
+		if next == 0 {
+			return nil
+		}
+		// a long scan mustn't pull the whole tree into memory
+		if err := t.trim(); err != nil {
+			return err
+		}
+		if leaf, err = t.node(next); err != nil {
+			return err
+		}
+		i = 0
+	}
+}
+
+// about how many keys start <= key < end (end "" = no bound) holds, from
+// random walks down the tree (see estimate.go)
+func (t *btree) estimate(start, end string, walks int) (float64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.trim()
+	total := 0.0
+	for w := 0; w < walks; w++ {
+		weight := 1.0
+		n, err := t.node(t.root)
+		for err == nil && !n.leaf {
+			// the children that can hold keys of the range
+			lo, hi := t.childIndex(n, start), len(n.children)-1
+			if end != "" {
+				hi = t.childIndex(n, end)
+			}
+			weight *= float64(hi - lo + 1)
+			n, err = t.node(n.children[lo+rand.Intn(hi-lo+1)])
+		}
+		if err != nil {
+			return 0, err
+		}
+		lo, hi := t.search(n.keys, start), len(n.keys)
+		if end != "" {
+			hi = t.search(n.keys, end)
+		}
+		if hi > lo {
+			total += weight * float64(hi-lo)
+		}
+	}
+	return total / float64(walks), nil
+}
+
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
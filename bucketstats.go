@@ -0,0 +1,147 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// per-bucket metrics: the same Get/Put/Delete counters as Stats, split by the
+// bucket each key belongs to, so an operator can see which tenant is causing
+// the load. there are no real buckets, Options.MetricsBucket decides the label:
+//
+//	opts.MetricsBucket = KeyPrefixBucket(":")
+//	db.Put("tenant1:order:9", ...)   → counted under "tenant1"
+//	db.Get("nocolon")                → counted under ""
+//
+// every distinct label costs memory and a time series in whatever scrapes
+// them, so only the first Options.MaxMetricsBuckets labels get their own
+// counters, later ones are added up under BucketOther.
+
+// BucketOther is the label keys are counted under once the label cap is reached.
+const BucketOther = "_other"
+
+const defaultMaxMetricsBuckets = 100
+
+// KeyPrefixBucket labels a key with everything before the first sep.
+func KeyPrefixBucket(sep string) func(key string) string {
+	return func(key string) string {
+		bucket, _, found := strings.Cut(key, sep)
+		if !found {
+			return ""
+		}
+		return bucket
+	}
+}
+
+// BucketSnapshot is the counters of one bucket.
+type BucketSnapshot struct {
+	Gets         uint64
+	Puts         uint64
+	Deletes      uint64
+	BytesRead    uint64        // value bytes returned by Get
+	BytesWritten uint64        // value bytes passed to Put (before compression)
+	Latency      time.Duration // total time spent in the calls, divide by the ops for the average
+}
+
+func (b BucketSnapshot) sub(prev BucketSnapshot) BucketSnapshot {
+	return BucketSnapshot{
+		Gets:         b.Gets - prev.Gets,
+		Puts:         b.Puts - prev.Puts,
+		Deletes:      b.Deletes - prev.Deletes,
+		BytesRead:    b.BytesRead - prev.BytesRead,
+		BytesWritten: b.BytesWritten - prev.BytesWritten,
+		Latency:      b.Latency - prev.Latency,
+	}
+}
+
+type bucketOp int
+
+const (
+	bucketGet bucketOp = iota
+	bucketPut
+	bucketDelete
+)
+
+// nil when Options.MetricsBucket isn't set
+type bucketStats struct {
+	label func(key string) string
+	max   int
+	now   func() time.Time
+
+	mu       sync.Mutex
+	counters map[string]*BucketSnapshot
+}
+
+func newBucketStats(opts Options, now func() time.Time) *bucketStats {
+	if opts.MetricsBucket == nil {
+		return nil
+	}
+	max := opts.MaxMetricsBuckets
+	if max <= 0 {
+		max = defaultMaxMetricsBuckets
+	}
+	return &bucketStats{label: opts.MetricsBucket, max: max, now: now, counters: map[string]*BucketSnapshot{}}
+}
+
+// counts one call, start is when it began
+func (b *bucketStats) observe(key string, op bucketOp, bytes int, start time.Time) {
+	if b == nil {
+		return
+	}
+	elapsed := b.now().Sub(start)
+	label := b.label(key)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.counters[label]
+	if !ok {
+		if len(b.counters) >= b.max {
+			label = BucketOther
+			c = b.counters[label]
+		}
+		if c == nil {
+			c = &BucketSnapshot{}
+			b.counters[label] = c
+		}
+	}
+	switch op {
+	case bucketGet:
+		c.Gets++
+		c.BytesRead += uint64(bytes)
+	case bucketPut:
+		c.Puts++
+		c.BytesWritten += uint64(bytes)
+	case bucketDelete:
+		c.Deletes++
+	}
+	c.Latency += elapsed
+}
+
+func (b *bucketStats) snapshot(reset bool) map[string]BucketSnapshot {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	snap := make(map[string]BucketSnapshot, len(b.counters))
+	for label, c := range b.counters {
+		snap[label] = *c
+	}
+	if reset {
+		// labels start over too, a tenant that went quiet frees its slot
+		b.counters = map[string]*BucketSnapshot{}
+	}
+	return snap
+}
+
+func subBuckets(cur, prev map[string]BucketSnapshot) map[string]BucketSnapshot {
+	if cur == nil {
+		return nil
+	}
+	diff := make(map[string]BucketSnapshot, len(cur))
+	for label, b := range cur {
+		diff[label] = b.sub(prev[label])
+	}
+	return diff
+}
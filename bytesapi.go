@@ -0,0 +1,29 @@
+package main
+
+// []byte versions of Put and Get. a Go string holds any bytes, NULs and
+// invalid UTF-8 included, and the pages store them as they are, so these only
+// save the caller the conversion:
+//
+//	db.PutBytes([]byte{0x00, 0xFF}, payload)
+//	payload, err := db.GetBytes([]byte{0x00, 0xFF})
+//
+// the slices are copied, the caller can reuse them once the call returns.
+
+// PutBytes is Put for a key and value held as []byte.
+func (s *Storage) PutBytes(key, value []byte, opts ...WriteOption) error {
+	return s.Put(string(key), string(value), opts...)
+}
+
+// GetBytes is Get returning the value as a new []byte.
+func (s *Storage) GetBytes(key []byte) ([]byte, error) {
+	value, err := s.Get(string(key))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+// DeleteBytes is Delete for a key held as []byte.
+func (s *Storage) DeleteBytes(key []byte, opts ...WriteOption) error {
+	return s.Delete(string(key), opts...)
+}
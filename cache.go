@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// cache mode: the database sits in front of a slower store (a service, a SQL
+// database, object storage...) that the application reaches through plain
+// callbacks. reads that miss locally are loaded from the backing store and
+// kept on disk, so they survive a restart, until their TTL runs out. writes go
+// to the backing store first and then locally, the local copy is never ahead
+// of the source of truth.
+//
+//	cache := NewCache(db, Backing{Load: fetchUser, Store: saveUser, TTL: time.Hour})
+//	cache.Get("user:1") → local hit, or fetchUser("user:1") and keep it for an hour
+
+// Backing is the store a Cache reads through to and writes through to.
+type Backing struct {
+	// Load fetches a key the cache doesn't have (or has expired),
+	// found=false means the backing store doesn't have it either
+	Load func(key string) (value string, found bool, err error)
+	// Store and Delete are called before the local write, nil means the
+	// cache is read-through only and Put/Delete just change the local copy
+	Store  func(key, value string) error
+	Delete func(key string) error
+	// how long a value is served locally before it's loaded again, 0 = forever
+	TTL time.Duration
+}
+
+// Cache is a Storage used as a persistent read-through/write-through cache.
+// every value it writes carries its expiry time, so the database should only
+// be used through the Cache (or keep its keys under a prefix nothing else uses).
+type Cache struct {
+	db      *Storage
+	backing Backing
+}
+
+// NewCache puts db in front of backing.
+func NewCache(db *Storage, backing Backing) *Cache {
+	return &Cache{db: db, backing: backing}
+}
+
+// every cached value is stored as [expiry, unix nanos, 0 = never][value]
+const cacheExpirySize = 8
+
+// Get returns the value of key, loading it from the backing store when the
+// local copy is missing or expired.
+func (c *Cache) Get(key string) (string, error) {
+	if stored, err := c.db.Get(key); err == nil && len(stored) >= cacheExpirySize {
+		expiry := int64(binary.LittleEndian.Uint64([]byte(stored[:cacheExpirySize])))
+		if expiry == 0 || c.db.clock().Now().UnixNano() < expiry {
+			return stored[cacheExpirySize:], nil
+		}
+	}
+
+	value, found, err := c.backing.Load(key)
+	if err != nil {
+		return "", fmt.Errorf("cache: loading %q: %w", key, err)
+	}
+	if !found {
+		// gone from the source, don't keep serving an expired copy
+		c.db.Delete(key)
+		return "", ErrKeyNotFound
+	}
+	if err := c.keep(key, value); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// Put writes value to the backing store, then to the cache.
+func (c *Cache) Put(key, value string) error {
+	if c.backing.Store != nil {
+		if err := c.backing.Store(key, value); err != nil {
+			return fmt.Errorf("cache: storing %q: %w", key, err)
+		}
+	}
+	return c.keep(key, value)
+}
+
+// Delete removes key from the backing store, then from the cache.
+func (c *Cache) Delete(key string) error {
+	if c.backing.Delete != nil {
+		if err := c.backing.Delete(key); err != nil {
+			return fmt.Errorf("cache: deleting %q: %w", key, err)
+		}
+	}
+	return c.Invalidate(key)
+}
+
+// Invalidate drops the local copy only, the next Get loads it again.
+// a key that isn't cached is already invalid, that's not an error.
+func (c *Cache) Invalidate(key string) error {
+	c.db.mu.RLock()
+	_, cached, err := c.db.lookup(key)
+	c.db.mu.RUnlock()
+	if err != nil || !cached {
+		return err
+	}
+	return c.db.Delete(key)
+}
+
+func (c *Cache) keep(key, value string) error {
+	var expiry int64
+	if c.backing.TTL > 0 {
+		expiry = c.db.clock().Now().Add(c.backing.TTL).UnixNano()
+	}
+	stored := binary.LittleEndian.AppendUint64(make([]byte, 0, cacheExpirySize+len(value)), uint64(expiry))
+	return c.db.Put(key, string(append(stored, value...)))
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// checkpoints: a checkpoint is a Sync, the dirty pages go to disk, the
+// header records the LSN they cover (LastLSN) and the WAL is emptied, so the
+// next open has nothing to replay. with SyncOnClose and no one calling Sync
+// that never happens until Close, and the WAL (and with it the time recovery
+// takes after a crash) grows with every write.
+//
+// the background checkpointer takes care of it, it runs when either limit is
+// reached, whichever comes first:
+//
+//	opts.CheckpointInterval = time.Minute    // at least once a minute when there are changes
+//	opts.CheckpointWALBytes = 64 << 20       // or as soon as the WAL reaches 64MB
+//
+// a checkpoint logs a checkpoint-begin entry before it writes the pages and
+// a checkpoint-end entry right before it empties the WAL, both carry the LSN
+// it covers. a reader of the WAL that got the end has every entry the
+// checkpoint took away, a begin without an end is a checkpoint that failed
+// or crashed.
+//
+// it takes the write lock like Sync does, writes wait for it. a failed
+// background checkpoint is counted in Stats (CheckpointErrors) and tried again
+// at the next tick, Checkpoint reports the error to its caller.
+//
+// when checkpoints stop working (a stuck disk, a checkpointer that keeps
+// failing, nobody calling Sync), Options.MaxWALBytes stops the WAL from
+// filling the disk. a write that finds the WAL at the limit checkpoints
+// first, or with WALFullReject fails with ErrWALFull and leaves it to the
+// caller. a failed checkpoint fails the write with ErrWALFull as well. the
+// check comes before the write, the WAL can end up one write over the limit.
+
+// WALFullPolicy decides what a write does when the WAL has reached
+// Options.MaxWALBytes.
+type WALFullPolicy int
+
+const (
+	// WALFullCheckpoint makes the write checkpoint before it goes ahead
+	WALFullCheckpoint WALFullPolicy = iota
+	// WALFullReject fails the write with ErrWALFull
+	WALFullReject
+)
+
+func (p WALFullPolicy) String() string {
+	switch p {
+	case WALFullCheckpoint:
+		return "checkpoint"
+	case WALFullReject:
+		return "reject"
+	default:
+		return fmt.Sprintf("WALFullPolicy(%d)", int(p))
+	}
+}
+
+// Checkpoint writes every change to the pages and empties the WAL, it returns
+// the LSN the pages are now current up to.
+func (s *Storage) Checkpoint() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opts.ReadOnly {
+		return 0, ErrOpenedReadOnly
+	}
+	lsn := s.lsn
+	if err := s.checkpoint(); err != nil {
+		return 0, err
+	}
+	return lsn, nil
+}
+
+// Checkpoint for callers that hold s.mu already
+func (s *Storage) checkpoint() error {
+	start, written := s.clock().Now(), s.stats.bytesWritten.Load()
+	s.checkpointLSN = s.lsn
+	if err := s.logCheckpointMarker(LogTypeCheckpointBegin, s.checkpointLSN); err != nil {
+		return err
+	}
+	s.checkpointing = true
+	defer func() { s.checkpointing = false }()
+	if err := s.sync(); err != nil {
+		return err
+	}
+	s.stats.checkpoints.Add(1)
+	s.stats.recordTiming(&s.stats.lastCheckpoint, start, int64(s.stats.bytesWritten.Load()-written))
+	return nil
+}
+
+// logs a checkpoint-begin or -end entry for a checkpoint covering lsn. the
+// markers take LSNs like any entry and the header records them, so they
+// aren't handed out again after a reopen.
+func (s *Storage) logCheckpointMarker(typ byte, lsn uint64) error {
+	if s.wal == nil {
+		return nil
+	}
+	return s.logWrite(typ, "", strconv.FormatUint(lsn, 10), 0)
+}
+
+// checked at the top of every write, with s.mu held
+func (s *Storage) checkWALSize() error {
+	s.optsMu.RLock()
+	limit, policy := s.opts.MaxWALBytes, s.opts.WALFull
+	s.optsMu.RUnlock()
+	if limit <= 0 || s.wal.Size() < limit {
+		return nil
+	}
+	s.stats.walFull.Add(1)
+	if policy == WALFullReject {
+		return ErrWALFull
+	}
+	if err := s.checkpoint(); err != nil {
+		return fmt.Errorf("%w: checkpoint failed: %w", ErrWALFull, err)
+	}
+	if s.wal.Size() >= limit {
+		return ErrWALFull // the checkpoint couldn't empty it
+	}
+	return nil
+}
+
+// starts the background checkpointer when a limit is set, called once the
+// storage is open
+func (s *Storage) startCheckpointer() {
+	if s.opts.ReadOnly || (s.opts.CheckpointInterval <= 0 && s.opts.CheckpointWALBytes <= 0) {
+		return
+	}
+	s.checkpointWake = make(chan struct{}, 1)
+	s.checkpointStop = make(chan struct{})
+	s.checkpointDone = make(chan struct{})
+	go s.runCheckpointer()
+}
+
+func (s *Storage) runCheckpointer() {
+	defer close(s.checkpointDone)
+	// the interval goes by the storage's clock, a fake one in tests
+	var tick <-chan time.Time
+	if s.opts.CheckpointInterval > 0 {
+		tick = s.clock().After(s.opts.CheckpointInterval)
+	}
+	for {
+		select {
+		case <-s.checkpointStop:
+			return
+		case <-tick:
+			tick = s.clock().After(s.opts.CheckpointInterval)
+		case <-s.checkpointWake:
+		}
+		if !s.needsCheckpoint() {
+			continue
+		}
+		if _, err := s.Checkpoint(); err != nil {
+			s.stats.checkpointErrors.Add(1)
+		}
+	}
+}
+
+// nothing logged and nothing dirty, a checkpoint would only rewrite the header
+func (s *Storage) needsCheckpoint() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.wal.Size() > 0 || s.dirtyPages() > 0
+}
+
+// called by writers after they logged, wakes the checkpointer once the WAL
+// has reached CheckpointWALBytes
+func (s *Storage) nudgeCheckpointer() {
+	if s.checkpointWake == nil || s.opts.CheckpointWALBytes <= 0 || s.wal.Size() < s.opts.CheckpointWALBytes {
+		return
+	}
+	select {
+	case s.checkpointWake <- struct{}{}:
+	default: // already woken
+	}
+}
+
+// stops the checkpointer and waits for a checkpoint in progress, called by
+// Close before it takes the lock
+func (s *Storage) stopCheckpointer() {
+	if s.checkpointStop == nil {
+		return
+	}
+	close(s.checkpointStop)
+	<-s.checkpointDone
+	s.checkpointStop = nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+
+	"godata/pagefmt"
+)
+
+// page checksums: every page of a new file ends in a CRC32 of the rest of
+// it, written by writePage and checked when readPage brings the page in, so
+// a flipped bit on disk is a loud error (ErrChecksumMismatch) instead of
+// records that quietly read back wrong:
+//
+//	[count][record][record] ... free ...  [crc32 u32]
+//	└──────────── checked ──────────────┘
+//
+// the checksum sits at the end so the records keep their offsets, a page
+// just holds 4 bytes less. whether a file has checksums is a header flag:
+// files from before them have none and stay that way, and
+// Options.DisablePageChecksums creates new files without them.
+//
+// Options.Checksum picks another algorithm for a new file, kept as a header
+// flag. CRC32C (Castagnoli) catches more of the errors a page suffers than
+// IEEE does, and it is the CRC disks, filesystems and tools outside Go use,
+// so they can check the pages too. xxHash64 (its low 32 bits, the field is
+// 4 bytes) is the fastest where hash/crc32 has no CPU instructions to use,
+// on amd64 and arm64 it does and the three are a few hundred nanoseconds a
+// page. Migrate switches an existing file over.
+//
+// the WAL checksums its entries with the file's algorithm. the WAL has no
+// header to keep it in, so every entry says which one in the top bits of its
+// type byte: entries from before keep reading as CRC32, and a WAL that
+// outlives a Migrate holds entries of both.
+
+// ChecksumAlgorithm is how pages and WAL entries are checksummed.
+type ChecksumAlgorithm byte
+
+const (
+	ChecksumCRC32    ChecksumAlgorithm = iota // CRC32 (IEEE), the default
+	ChecksumCRC32C                            // CRC32C (Castagnoli)
+	ChecksumXXHash64                          // xxHash64, the low 32 bits
+)
+
+func (a ChecksumAlgorithm) String() string {
+	switch a {
+	case ChecksumCRC32:
+		return "crc32"
+	case ChecksumCRC32C:
+		return "crc32c"
+	case ChecksumXXHash64:
+		return "xxhash64"
+	default:
+		return fmt.Sprintf("ChecksumAlgorithm(%d)", int(a))
+	}
+}
+
+// the header flag that says a is used
+func (a ChecksumAlgorithm) flag() uint32 {
+	switch a {
+	case ChecksumCRC32C:
+		return pagefmt.FlagCRC32C
+	case ChecksumXXHash64:
+		return pagefmt.FlagXXHash64
+	}
+	return 0
+}
+
+func (a ChecksumAlgorithm) sum(data []byte) uint32 {
+	return pagefmt.Checksum(data, a.flag())
+}
+
+func checksumAlgorithm(flags uint32) ChecksumAlgorithm {
+	switch {
+	case flags&pagefmt.FlagXXHash64 != 0:
+		return ChecksumXXHash64
+	case flags&pagefmt.FlagCRC32C != 0:
+		return ChecksumCRC32C
+	}
+	return ChecksumCRC32
+}
+
+// the flags this build reads files with, a file with any other set was
+// written by a newer one (see fileformat.go)
+const knownFlags = pagefmt.FlagPageChecksums | pagefmt.FlagRecordVersions | pagefmt.FlagSlottedPages | pagefmt.FlagCRC32C |
+	pagefmt.FlagXXHash64 | pagefmt.FlagPageLSN
+
+// flags a new file is created with
+func (s *Storage) newFileFlags() uint32 {
+	flags := pagefmt.FlagPageLSN
+	if !s.opts.DisablePageChecksums {
+		flags |= pagefmt.FlagPageChecksums | s.opts.Checksum.flag()
+	}
+	if s.opts.RecordVersions {
+		flags |= pagefmt.FlagRecordVersions
+	}
+	if s.opts.SlottedPages {
+		flags |= pagefmt.FlagSlottedPages
+	}
+	return flags
+}
+
+// flags the header is written with, the ones the file already has
+func (s *Storage) headerFlags() uint32 {
+	var flags uint32
+	if s.checksums {
+		flags |= pagefmt.FlagPageChecksums
+	}
+	flags |= s.checksumAlg.flag()
+	if s.versioned {
+		flags |= pagefmt.FlagRecordVersions
+	}
+	if s.slotted {
+		flags |= pagefmt.FlagSlottedPages
+	}
+	if s.pageLSNs {
+		flags |= pagefmt.FlagPageLSN
+	}
+	return flags
+}
+
+func hasPageChecksums(flags uint32) bool {
+	return flags&pagefmt.FlagPageChecksums != 0
+}
+
+// bytes of a page records can use
+func (p *Page) capacity() int {
+	n := PageSize
+	if p.checksummed {
+		n -= pagefmt.ChecksumSize
+	}
+	if p.hasLSN {
+		n -= pagefmt.PageLSNSize
+	}
+	return n
+}
+
+// the same for a page that doesn't exist yet
+func (s *Storage) pageCapacity() int {
+	n := PageSize
+	if s.checksums {
+		n -= pagefmt.ChecksumSize
+	}
+	if s.pageLSNs {
+		n -= pagefmt.PageLSNSize
+	}
+	return n
+}
+
+// called by writePage right before the bytes go out
+func (p *Page) stampChecksum() {
+	if p.checksummed {
+		pagefmt.StampChecksumFlags(p.Data[:], pagefmt.FlagPageChecksums|p.checksumAlg.flag())
+	}
+}
+
+func (s *Storage) checkPageChecksum(pageID uint32, data []byte) error {
+	if !s.checksums {
+		return nil
+	}
+	if err := pagefmt.CheckChecksumFlags(data, s.headerFlags()); err != nil {
+		return corruptedPage("verify page checksum", pageID, s.pageOffset(pageID), err)
+	}
+	return nil
+}
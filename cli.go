@@ -0,0 +1,443 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// the godata command line tool: godata <command> [flags] <file>
+// every command is a small function that gets its own arguments,
+// so adding one is just adding an entry to the commands table.
+
+type command struct {
+	summary string                    // one line shown in the usage text
+	run     func(args []string) error // runs the command with everything after its name
+}
+
+var commands = map[string]command{
+	"compact": {"rewrite a database without its dead space, or --estimate what that would reclaim", runCompact},
+	"export":  {"write every record as NDJSON or Parquet", runExport},
+	"gc":      {"reclaim orphaned pages onto the free list", runGC},
+	"import":  {"load an NDJSON export into a database", runImport},
+	"repair":  {"check a file that won't open, --out salvages its records into a new one", runRepair},
+	"stress":  {"run a long mixed workload checked against a shadow model", runStress},
+	"verify":  {"check every page of a database file", runVerify},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(ExitFailure)
+	}
+
+	name, args := os.Args[1], os.Args[2:]
+	if name == "help" || name == "-h" || name == "--help" {
+		usage()
+		return
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "godata: unknown command %q\n\n", name)
+		usage()
+		os.Exit(ExitFailure)
+	}
+
+	if err := cmd.run(args); err != nil {
+		fmt.Fprintf(os.Stderr, "godata %s: %v\n", name, err)
+		os.Exit(exitCode(err))
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: godata <command> [flags] <file>")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, name := range commandNames() {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nexit status: 0 ok, 1 error, 2 file not found, 3 corruption detected, 4 locked")
+}
+
+// parses the flags of a command and returns the database file argument
+func parseFileArgs(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("expected exactly one database file, got %d arguments", fs.NArg())
+	}
+	return fs.Arg(0), nil
+}
+
+// opens the database a command works on. NewStorage would happily create
+// a new empty file for a typo'd name, which is never what a command wants.
+func openExisting(filename string) (*Storage, error) {
+	if _, err := os.Stat(filename); err != nil {
+		return nil, err
+	}
+	return NewStorage(filename)
+}
+
+// godata verify [--parallel N] [--direct] [--output table|json|raw] <file>
+func runVerify(args []string) error {
+	fs := newFlagSet("verify")
+	parallel := fs.Int("parallel", 1, "number of pages checked at the same time")
+	direct := fs.Bool("direct", false, "read pages with O_DIRECT, bypassing the OS page cache")
+	output := outputFlag(fs)
+	filename, err := parseFileArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(filename); err != nil {
+		return err
+	}
+	opts := DefaultOptions()
+	opts.DirectIO = *direct
+	db, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := db.Verify(*parallel)
+	if err != nil {
+		return err
+	}
+
+	problems := []map[string]any{}
+	lines := []string{"none"}
+	if len(report.Problems) > 0 {
+		lines = nil
+	}
+	for _, p := range report.Problems {
+		problems = append(problems, map[string]any{"page": p.PageID, "error": p.Err.Error()})
+		lines = append(lines, fmt.Sprintf("page %d: %v", p.PageID, p.Err))
+	}
+	var out cliResult
+	out.add("pages", report.Pages)
+	out.add("bytes", report.Bytes)
+	out.add("workers", report.Workers)
+	out.add("direct io", report.DirectIO)
+	out.addText("duration", report.Duration.Seconds(), report.Duration.String())
+	out.addText("throughput", report.Throughput(), fmt.Sprintf("%.1f MB/s", report.Throughput()))
+	out.addText("checksum", fmt.Sprintf("%08X", report.Checksum), "")
+	out.addText("problems", problems, strings.Join(lines, "\n"))
+	if err := out.print(os.Stdout, *output); err != nil {
+		return err
+	}
+
+	if !report.OK() {
+		return fmt.Errorf("%d of %d pages failed verification: %w", len(report.Problems), report.Pages, ErrCorrupted)
+	}
+	return nil
+}
+
+// godata repair [--out new-file] [--output table|json|raw] <file>
+// the file is read raw, not opened, so it works on one that is cut off or
+// has pages of garbage (see repair.go). without --out it is only checked.
+func runRepair(args []string) error {
+	fs := newFlagSet("repair")
+	out := fs.String("out", "", "write the records that can be read into this new database")
+	output := outputFlag(fs)
+	filename, err := parseFileArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+
+	var report RepairReport
+	if *out == "" {
+		report.Integrity, err = CheckIntegrity(filename)
+	} else {
+		report, err = Repair(filename, *out, DefaultOptions())
+	}
+	if err != nil {
+		return err
+	}
+
+	integrity := report.Integrity
+	problems := []map[string]any{}
+	lines := []string{"none"}
+	if len(integrity.Problems) > 0 {
+		lines = nil
+	}
+	for _, p := range integrity.Problems {
+		problems = append(problems, map[string]any{"page": p.PageID, "error": p.Err.Error()})
+		lines = append(lines, fmt.Sprintf("page %d: %v", p.PageID, p.Err))
+	}
+	var result cliResult
+	result.add("pages", integrity.Pages)
+	result.add("pages read", integrity.PagesRead)
+	result.add("cold pages", integrity.ColdPages)
+	result.add("records", integrity.Records)
+	result.add("truncated", integrity.Truncated)
+	result.addText("problems", problems, strings.Join(lines, "\n"))
+	if *out != "" {
+		result.add("records written", report.Records)
+		result.add("suspect records", report.Suspect)
+		result.add("wal entries replayed", report.Replayed)
+	}
+	if err := result.print(os.Stdout, *output); err != nil {
+		return err
+	}
+
+	if *out == "" && !integrity.OK() {
+		return fmt.Errorf("%d of %d pages damaged or missing: %w", len(integrity.Problems), integrity.Pages, ErrCorrupted)
+	}
+	return nil
+}
+
+// godata gc [--output table|json|raw] <file>
+func runGC(args []string) error {
+	fs := newFlagSet("gc")
+	output := outputFlag(fs)
+	filename, err := parseFileArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+
+	db, err := openExisting(filename)
+	if err != nil {
+		return err
+	}
+	defer db.Close() // writes the wiped pages back
+
+	report, err := db.GC()
+	if err != nil {
+		return err
+	}
+
+	orphaned := append([]uint32{}, report.Orphaned...) // [] rather than null in json
+	var out cliResult
+	out.add("pages scanned", report.PagesScanned)
+	out.addText("orphaned pages", orphaned, fmt.Sprintf("%d %v", len(orphaned), orphaned))
+	out.add("stale records", report.StaleRecords)
+	out.add("free pages", report.FreePages)
+	return out.print(os.Stdout, *output)
+}
+
+// godata compact [--estimate] [--output table|json|raw] <file>
+func runCompact(args []string) error {
+	fs := newFlagSet("compact")
+	estimate := fs.Bool("estimate", false, "only report what a compaction would reclaim, don't rewrite")
+	output := outputFlag(fs)
+	filename, err := parseFileArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+
+	db, err := openExisting(filename)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var out cliResult
+	if *estimate {
+		est, err := db.EstimateCompaction()
+		if err != nil {
+			return err
+		}
+		out.add("pages", est.Pages)
+		out.add("pages after", est.LivePages)
+		out.add("live records", est.LiveRecords)
+		out.add("stale records", est.StaleRecords)
+		out.addText("live bytes", est.LiveBytes, fmt.Sprintf("%d bytes", est.LiveBytes))
+		out.addText("reclaimable", est.ReclaimBytes, fmt.Sprintf("%d bytes", est.ReclaimBytes))
+		out.addText("expected duration", est.Duration.Seconds(), est.Duration.Round(time.Millisecond).String())
+		return out.print(os.Stdout, *output)
+	}
+
+	report, err := db.Compact()
+	if err != nil {
+		return err
+	}
+	out.add("pages before", report.PagesBefore)
+	out.add("pages after", report.PagesAfter)
+	out.add("records", report.Records)
+	out.addText("reclaimed", report.ReclaimedBytes, fmt.Sprintf("%d bytes", report.ReclaimedBytes))
+	out.addText("duration", report.Duration.Seconds(), report.Duration.Round(time.Millisecond).String())
+	return out.print(os.Stdout, *output)
+}
+
+// godata stress [--hours H | --duration D] [--mix 70r/25w/5d] [--output table|json|raw] <file>
+// the file is created if it doesn't exist, stress runs belong on a scratch database.
+// the report lines go to stdout with table output, to stderr otherwise
+func runStress(args []string) error {
+	fs := newFlagSet("stress")
+	hours := fs.Float64("hours", 0, "how many hours to run")
+	duration := fs.Duration("duration", time.Minute, "how long to run, ignored when --hours is set")
+	mix := fs.String("mix", "70r/25w/5d", "share of reads, writes and deletes")
+	keys := fs.Int("keys", 10000, "number of distinct keys")
+	valueSize := fs.Int("value-size", 200, "largest value written")
+	reportEvery := fs.Duration("report-every", time.Minute, "time between report lines")
+	verifyEvery := fs.Duration("verify-every", 5*time.Minute, "time between full checks against the shadow model")
+	seed := fs.Int64("seed", time.Now().UnixNano(), "random seed")
+	output := outputFlag(fs)
+	filename, err := parseFileArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+
+	progress := os.Stdout
+	if *output != "table" {
+		progress = os.Stderr
+	}
+	cfg := StressConfig{
+		Duration:    *duration,
+		Keys:        *keys,
+		ValueSize:   *valueSize,
+		ReportEvery: *reportEvery,
+		VerifyEvery: *verifyEvery,
+		Seed:        *seed,
+		Out:         progress,
+	}
+	if *hours > 0 {
+		cfg.Duration = time.Duration(*hours * float64(time.Hour))
+	}
+	if cfg.ReadPct, cfg.WritePct, cfg.DeletePct, err = ParseMix(*mix); err != nil {
+		return err
+	}
+
+	db, err := NewStorage(filename)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	fmt.Fprintf(progress, "seed %d, mix %s, %s\n", cfg.Seed, *mix, cfg.Duration)
+	summary, err := RunStress(db, cfg)
+	if err != nil {
+		return fmt.Errorf("after %d operations: %w", summary.Ops, err)
+	}
+
+	var out cliResult
+	out.add("seed", cfg.Seed)
+	out.add("operations", summary.Ops)
+	out.add("verifications", summary.Verified)
+	out.addText("heap growth", summary.HeapGrowth, fmt.Sprintf("%d bytes", summary.HeapGrowth))
+	out.addText("latency drift", summary.LatencyDrift, fmt.Sprintf("%.2fx (p50 last interval / first)", summary.LatencyDrift))
+	return out.print(os.Stdout, *output)
+}
+
+// godata export [--format ndjson|parquet] [--out file] [--output table|json|raw] <file>
+// without --out the export goes to stdout and the summary to stderr
+func runExport(args []string) error {
+	fs := newFlagSet("export")
+	out := fs.String("out", "", "write the export to this file instead of stdout")
+	format := fs.String("format", "ndjson", "ndjson, or parquet for DuckDB/Spark")
+	output := outputFlag(fs)
+	filename, err := parseFileArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+	export := (*Storage).Export
+	switch *format {
+	case "ndjson":
+	case "parquet":
+		export = (*Storage).ExportParquet
+	default:
+		return fmt.Errorf("unknown format %q (want ndjson or parquet)", *format)
+	}
+
+	db, err := openExisting(filename)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	w, summaryTo := os.Stdout, os.Stderr
+	if *out != "" {
+		if w, err = os.Create(*out); err != nil {
+			return err
+		}
+		defer w.Close()
+		summaryTo = os.Stdout
+	}
+
+	header, err := export(db, w)
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		// a close error means the last buffered lines may not have made it
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	var result cliResult
+	result.add("format", *format)
+	result.add("records", header.Records)
+	result.add("lsn", header.LSN)
+	return result.print(summaryTo, *output)
+}
+
+// godata import [--in file.ndjson] [--on-conflict skip|overwrite|fail] [--output table|json|raw] <file>
+// the database is created if it doesn't exist, restoring into a new file is the usual case
+func runImport(args []string) error {
+	fs := newFlagSet("import")
+	in := fs.String("in", "", "read the export from this file instead of stdin")
+	onConflict := fs.String("on-conflict", "fail", "what to do with keys that already hold another value: skip, overwrite or fail")
+	output := outputFlag(fs)
+	filename, err := parseFileArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkOutputFormat(*output); err != nil {
+		return err
+	}
+	policy, err := ParseConflictPolicy(*onConflict)
+	if err != nil {
+		return err
+	}
+
+	r := os.Stdin
+	if *in != "" {
+		if r, err = os.Open(*in); err != nil {
+			return err
+		}
+		defer r.Close()
+	}
+
+	db, err := NewStorage(filename)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// the counts are printed even when the import failed, they say how far it got
+	report, err := db.Import(r, policy)
+	var out cliResult
+	out.add("records", report.Records)
+	out.add("export lsn", report.Header.LSN)
+	out.add("inserted", report.Inserted)
+	out.add("unchanged", report.Unchanged)
+	out.add("overwritten", report.Overwritten)
+	out.add("skipped", report.Skipped)
+	out.add("conflicts", len(report.Conflicts))
+	if printErr := out.print(os.Stdout, *output); printErr != nil && err == nil {
+		err = printErr
+	}
+	return err
+}
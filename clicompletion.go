@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// exit codes, so scripts can tell failures apart without parsing messages:
+//
+//	godata verify orders.db; case $? in 3) restore-backup ;; 4) sleep 5; retry ;; esac
+const (
+	ExitOK       = 0
+	ExitFailure  = 1 // anything else, bad flags included
+	ExitNotFound = 2 // the database (or input) file doesn't exist
+	ExitCorrupt  = 3 // the file failed verification or couldn't be parsed
+	ExitLocked   = 4 // another process has the database open
+)
+
+// picks the exit code for the error a command returned
+func exitCode(err error) int {
+	var se *StorageError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrLocked):
+		return ExitLocked
+	case errors.Is(err, os.ErrNotExist):
+		return ExitNotFound
+	case errors.Is(err, ErrCorrupted):
+		return ExitCorrupt
+	case errors.As(err, &se) && strings.HasPrefix(se.Op, "parse"):
+		return ExitCorrupt
+	}
+	return ExitFailure
+}
+
+// completion needs every command's flags. they're defined inside the
+// commands, so it runs each one with -h: parsing stops there before the
+// command does anything, and newFlagSet keeps the flag set it built.
+var (
+	collectFlags  bool
+	collectedFlag *flag.FlagSet
+)
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	if collectFlags {
+		fs.SetOutput(io.Discard)
+		collectedFlag = fs
+	}
+	return fs
+}
+
+type completionFlag struct {
+	name, usage string
+	isBool      bool
+}
+
+func commandFlags(name string) []completionFlag {
+	collectFlags, collectedFlag = true, nil
+	defer func() { collectFlags = false }()
+	commands[name].run([]string{"-h"})
+	if collectedFlag == nil {
+		return nil
+	}
+
+	var flags []completionFlag
+	collectedFlag.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{f.Name, f.Usage, ok && b.IsBoolFlag()})
+	})
+	return flags
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// registered here rather than in the table, the table can't refer to a
+// function that reads the table
+func init() {
+	commands["completion"] = command{"print a bash, zsh or fish completion script", runCompletion}
+}
+
+// godata completion bash|zsh|fish
+//
+//	source <(godata completion bash)
+//	godata completion zsh > "${fpath[1]}/_godata"
+//	godata completion fish > ~/.config/fish/completions/godata.fish
+func runCompletion(args []string) error {
+	if collectFlags {
+		return flag.ErrHelp // no flags of its own
+	}
+	if len(args) != 1 {
+		return errors.New("expected one shell: bash, zsh or fish")
+	}
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(os.Stdout)
+	case "zsh":
+		writeZshCompletion(os.Stdout)
+	case "fish":
+		writeFishCompletion(os.Stdout)
+	default:
+		return fmt.Errorf("unknown shell %q (want bash, zsh or fish)", args[0])
+	}
+	return nil
+}
+
+func writeBashCompletion(w io.Writer) {
+	fmt.Fprintln(w, "# bash completion for godata")
+	fmt.Fprintln(w, "_godata() {")
+	fmt.Fprintln(w, `	local cur="${COMP_WORDS[COMP_CWORD]}"`)
+	fmt.Fprintln(w, `	if [ "$COMP_CWORD" -eq 1 ]; then`)
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(commandNames(), " "))
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, `	local flags=""`)
+	fmt.Fprintln(w, `	case "${COMP_WORDS[1]}" in`)
+	for _, name := range commandNames() {
+		var flags []string
+		for _, f := range commandFlags(name) {
+			flags = append(flags, "--"+f.name)
+		}
+		fmt.Fprintf(w, "\t%s) flags=%q ;;\n", name, strings.Join(flags, " "))
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, `	if [[ "$cur" == -* ]]; then`)
+	fmt.Fprintln(w, `		COMPREPLY=($(compgen -W "$flags" -- "$cur"))`)
+	fmt.Fprintln(w, "\telse")
+	fmt.Fprintln(w, `		COMPREPLY=($(compgen -f -- "$cur"))`)
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o filenames -F _godata godata")
+}
+
+// zsh _arguments specs use [ ] : and ' as syntax
+func zshEscape(s string) string {
+	return strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+}
+
+func writeZshCompletion(w io.Writer) {
+	fmt.Fprintln(w, "#compdef godata")
+	fmt.Fprintln(w, "_godata() {")
+	fmt.Fprintln(w, "\tlocal -a commands")
+	fmt.Fprintln(w, "\tcommands=(")
+	for _, name := range commandNames() {
+		fmt.Fprintf(w, "\t\t'%s:%s'\n", name, zshEscape(commands[name].summary))
+	}
+	fmt.Fprintln(w, "\t)")
+	fmt.Fprintln(w, "\tif (( CURRENT == 2 )); then")
+	fmt.Fprintln(w, "\t\t_describe 'command' commands")
+	fmt.Fprintln(w, "\t\treturn")
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "\tshift words; (( CURRENT-- ))")
+	fmt.Fprintln(w, "\tcase $words[1] in")
+	for _, name := range commandNames() {
+		fmt.Fprintf(w, "\t%s)\n\t\t_arguments \\\n", name)
+		for _, f := range commandFlags(name) {
+			value := ":value:"
+			if f.isBool {
+				value = ""
+			}
+			fmt.Fprintf(w, "\t\t\t'--%s[%s]%s' \\\n", f.name, zshEscape(f.usage), value)
+		}
+		fmt.Fprintln(w, "\t\t\t'*:file:_files' ;;")
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, `_godata "$@"`)
+}
+
+func writeFishCompletion(w io.Writer) {
+	quote := strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace
+	fmt.Fprintln(w, "# fish completion for godata")
+	fmt.Fprintln(w, "complete -c godata -f")
+	for _, name := range commandNames() {
+		fmt.Fprintf(w, "complete -c godata -n __fish_use_subcommand -a %s -d '%s'\n", name, quote(commands[name].summary))
+	}
+	for _, name := range commandNames() {
+		cond := fmt.Sprintf("'__fish_seen_subcommand_from %s'", name)
+		fmt.Fprintf(w, "complete -c godata -n %s -F\n", cond)
+		for _, f := range commandFlags(name) {
+			requires := " -r"
+			if f.isBool {
+				requires = ""
+			}
+			fmt.Fprintf(w, "complete -c godata -n %s -l %s%s -d '%s'\n", cond, f.name, requires, quote(f.usage))
+		}
+	}
+}
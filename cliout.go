@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// every command prints its result through here, so they all take
+// --output table|json|raw:
+//
+//	table  aligned "name: value" lines for people (the default)
+//	json   one JSON object, for jq:  godata gc --output json db | jq .free_pages
+//	raw    just the values, one per line in table order, for shell scripts
+//
+// a command builds its result as an ordered list of fields, the JSON keys are
+// the table labels with spaces turned into underscores.
+
+type outputField struct {
+	name  string // table label
+	value any    // what json gets
+	text  string // what table and raw show, empty means fmt.Sprint(value)
+}
+
+type cliResult []outputField
+
+// adds a field shown as fmt.Sprint(value)
+func (r *cliResult) add(name string, value any) {
+	*r = append(*r, outputField{name: name, value: value})
+}
+
+// adds a field whose text differs from the JSON value (units, hex, lists...)
+func (r *cliResult) addText(name string, value any, text string) {
+	*r = append(*r, outputField{name: name, value: value, text: text})
+}
+
+func outputFlag(fs *flag.FlagSet) *string {
+	return fs.String("output", "table", "result format: table, json or raw")
+}
+
+func checkOutputFormat(format string) error {
+	switch format {
+	case "table", "json", "raw":
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q (want table, json or raw)", format)
+}
+
+func (r cliResult) print(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		obj := make(map[string]any, len(r))
+		for _, f := range r {
+			obj[strings.ReplaceAll(f.name, " ", "_")] = f.value
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(obj)
+
+	case "raw":
+		for _, f := range r {
+			fmt.Fprintln(w, f.shown())
+		}
+		return nil
+
+	default:
+		width := 0
+		for _, f := range r {
+			width = max(width, len(f.name))
+		}
+		for _, f := range r {
+			// multi-line values continue under the first line
+			text := strings.ReplaceAll(f.shown(), "\n", "\n"+strings.Repeat(" ", width+2))
+			fmt.Fprintf(w, "%-*s %s\n", width+1, f.name+":", text)
+		}
+		return nil
+	}
+}
+
+func (f outputField) shown() string {
+	if f.text != "" {
+		return f.text
+	}
+	return fmt.Sprint(f.value)
+}
@@ -0,0 +1,32 @@
+package main
+
+import "time"
+
+// Clock is where the storage gets the time from. the real clock is used
+// normally, tests and the deterministic simulator (storagetest.Simulate)
+// plug in a fake one so timing dependent behaviour is reproducible.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	// After sends the time on the channel once d has passed, like time.After
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// the configured clock, or the real one
+func (s *Storage) clock() Clock {
+	return clockOf(s.opts)
+}
+
+// the clock opts ask for, for before there is a Storage
+func clockOf(opts Options) Clock {
+	if opts.Clock != nil {
+		return opts.Clock
+	}
+	return realClock{}
+}
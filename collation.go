@@ -0,0 +1,174 @@
+package main
+
+import (
+	"hash/crc32"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// collations: the order keys are kept in by the index and come out of Scan
+// in, and what start and end of a range mean. byte order by default, which
+// isn't every application's idea of order ("Zoe" before "adam", "file10"
+// before "file9"), Options.Collation picks another:
+//
+//	opts.Collation = NaturalCollation // file1, file2, ..., file10
+//	it := db.Scan("file2", "file10")  // file2 .. file9
+//
+//	BinaryCollation   byte order, the default
+//	FoldCollation     case-insensitive, "adam" < "Bob" < "carl"
+//	NaturalCollation  runs of digits compare as numbers, "v9" < "v10"
+//
+// any other order (a locale's collation, say) is a CollationFunc. a
+// collation has to be a total order in which only a key equals itself: the
+// index tells keys apart with it, two keys it calls equal would be one. the
+// ones here break ties with byte order ("Bob" < "bob").
+//
+// prefixes stop being ranges: under NaturalCollation "a10" sorts after
+// "a2" although it starts with "a1", so the storage's own prefix scans
+// (retention, RenamePrefix, GC) go through every key with any collation but
+// the binary one, and EstimateCount counts exactly. the B+ tree file records
+// the collation's name and is rebuilt when it is opened with another one.
+// sealed snapshots and the persistent index are byte order whatever the
+// collation is.
+
+// Collation orders keys, see collation.go.
+type Collation interface {
+	// Name identifies the order, an index sorted under another name is rebuilt
+	Name() string
+	// Compare returns -1, 0 or +1 like strings.Compare, 0 only for a == b
+	Compare(a, b string) int
+}
+
+var (
+	BinaryCollation  Collation = CollationFunc("binary", strings.Compare)
+	FoldCollation    Collation = CollationFunc("fold", compareFold)
+	NaturalCollation Collation = CollationFunc("natural", compareNatural)
+)
+
+// CollationFunc makes compare a Collation called name.
+func CollationFunc(name string, compare func(a, b string) int) Collation {
+	return collationFunc{name, compare}
+}
+
+type collationFunc struct {
+	name    string
+	compare func(a, b string) int
+}
+
+func (c collationFunc) Name() string            { return c.name }
+func (c collationFunc) Compare(a, b string) int { return c.compare(a, b) }
+
+// the collation in use, the binary one without Options.Collation
+func collationOf(opts Options) Collation {
+	if opts.Collation == nil {
+		return BinaryCollation
+	}
+	return opts.Collation
+}
+
+// what the B+ tree header records about the collation
+func collationTag(c Collation) uint32 {
+	return crc32.ChecksumIEEE([]byte(c.Name()))
+}
+
+func (s *Storage) binaryOrder() bool {
+	return s.collation.Name() == BinaryCollation.Name()
+}
+
+func (s *Storage) sortKeys(keys []string) {
+	if s.binaryOrder() {
+		sort.Strings(keys)
+		return
+	}
+	sort.Slice(keys, func(i, j int) bool { return s.collation.Compare(keys[i], keys[j]) < 0 })
+}
+
+// calls fn for every key starting with prefix in key order until it returns
+// false. under the binary collation that's a range, under any other every
+// key is looked at.
+func (s *Storage) indexPrefix(prefix string, fn func(key string, pageID uint32) bool) error {
+	if s.binaryOrder() {
+		return s.indexRange(prefix, prefixEnd(prefix), fn)
+	}
+	return s.indexRange("", "", func(key string, pageID uint32) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return true
+		}
+		return fn(key, pageID)
+	})
+}
+
+func compareFold(a, b string) int {
+	x, y := a, b
+	for x != "" && y != "" {
+		rx, nx := utf8.DecodeRuneInString(x)
+		ry, ny := utf8.DecodeRuneInString(y)
+		if lx, ly := unicode.ToLower(rx), unicode.ToLower(ry); lx != ly {
+			if lx < ly {
+				return -1
+			}
+			return 1
+		}
+		x, y = x[nx:], y[ny:]
+	}
+	if c := shorterFirst(x, y); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b) // the same but for case
+}
+
+func compareNatural(a, b string) int {
+	x, y := a, b
+	for x != "" && y != "" {
+		if isDigit(x[0]) && isDigit(y[0]) {
+			dx, dy := digitRun(x), digitRun(y)
+			// leading zeros don't count, then the longer number is bigger
+			nx, ny := strings.TrimLeft(x[:dx], "0"), strings.TrimLeft(y[:dy], "0")
+			if len(nx) != len(ny) {
+				if len(nx) < len(ny) {
+					return -1
+				}
+				return 1
+			}
+			if c := strings.Compare(nx, ny); c != 0 {
+				return c
+			}
+			x, y = x[dx:], y[dy:]
+			continue
+		}
+		if x[0] != y[0] {
+			if x[0] < y[0] {
+				return -1
+			}
+			return 1
+		}
+		x, y = x[1:], y[1:]
+	}
+	if c := shorterFirst(x, y); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b) // "a01" and "a1"
+}
+
+// what's left of two keys once one ran out, the one that ran out is smaller
+func shorterFirst(x, y string) int {
+	switch {
+	case x == "" && y != "":
+		return -1
+	case x != "" && y == "":
+		return 1
+	}
+	return 0
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func digitRun(s string) int {
+	n := 0
+	for n < len(s) && isDigit(s[n]) {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"godata/pagefmt"
+)
+
+// compaction rewrites the file with only the live records, packed page after
+// page in key order, and drops the space deletes, moved updates and orphaned
+// pages left behind:
+//
+//	before: [a b _ _][_ _ _ _][c _ _ _][d e _ _]   4 pages
+//	after:  [a b c d][e _ _ _]                     2 pages, the file shrinks
+//
+// the new file is written next to the old one (<file>.compact), synced and
+// renamed over it, so a crash leaves either the old file or the new one. it
+// needs disk space for a second copy of the live data while it runs.
+//
+// EstimateCompaction does the same scan and packing without writing
+// anything, to decide whether a rewrite is worth a maintenance window.
+
+const compactSuffix = ".compact"
+
+// CompactionEstimate says what Compact would do right now.
+type CompactionEstimate struct {
+	Pages        uint32        // pages in the file now
+	LivePages    uint32        // pages after compaction
+	LiveRecords  int           // records that are kept
+	StaleRecords int           // records nothing points to (orphaned copies)
+	LiveBytes    int64         // record bytes kept, headers included
+	ReclaimBytes int64         // how much smaller the file gets, negative if it would grow
+	Duration     time.Duration // rough guess, scaled from how long the scan took
+}
+
+// CompactReport says what a Compact did.
+type CompactReport struct {
+	PagesBefore    uint32
+	PagesAfter     uint32
+	Records        int
+	ReclaimedBytes int64
+	Duration       time.Duration
+}
+
+type liveRecord struct {
+	key, value string // value as stored, still encoded
+}
+
+// EstimateCompaction reports how much space Compact would reclaim and how long
+// it would roughly take, without changing anything.
+func (s *Storage) EstimateCompaction() (CompactionEstimate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	est, _, _, err := s.planCompaction()
+	return est, err
+}
+
+// reads every live record and works out the packed layout. starts holds the
+// index of the first record of each new page.
+func (s *Storage) planCompaction() (est CompactionEstimate, records []liveRecord, starts []int, err error) {
+	start := s.clock().Now()
+	est.Pages = s.totalPages
+
+	for pageID := uint32(0); pageID < s.totalPages; pageID++ {
+		page, err := s.loadPage(pageID)
+		if err != nil {
+			return est, nil, nil, err
+		}
+		seen := 0
+		var lookupErr error
+		readErr := page.eachRecord(func(key, value string, size int) bool {
+			seen++
+			id, ok, err := s.lookup(key)
+			if lookupErr = err; err != nil {
+				return false
+			}
+			if !ok || id != pageID {
+				est.StaleRecords++
+				return true
+			}
+			records = append(records, liveRecord{key, value})
+			est.LiveBytes += int64(size)
+			return true
+		})
+		if lookupErr != nil {
+			return est, nil, nil, lookupErr
+		}
+		if readErr != nil {
+			// buildIndex stopped at the same place, the rest was never live
+			est.StaleRecords += int(page.RecordCount) - seen
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return s.collation.Compare(records[i].key, records[j].key) < 0 })
+
+	// first fit in key order, a record that doesn't fit starts the next page
+	capacity := s.pageCapacity()
+	used := capacity
+	for i, r := range records {
+		size := pagefmt.RecordHeaderSize + s.slotSize() + len(r.key) + len(r.value)
+		if used+size > capacity {
+			starts = append(starts, i)
+			used = s.pageHeaderSize()
+		}
+		used += size
+	}
+
+	est.LiveRecords = len(records)
+	est.LivePages = uint32(len(starts))
+	// the old layout fills gaps anywhere in the file, so in rare cases packing
+	// in key order needs a page more than it has
+	est.ReclaimBytes = (int64(est.Pages) - int64(est.LivePages)) * PageSize
+	// writing the packed pages is assumed to go about as fast as reading the
+	// old ones did, on top of doing that read again
+	scan := s.clock().Now().Sub(start)
+	est.Duration = scan
+	if est.Pages > 0 {
+		est.Duration += time.Duration(float64(scan) * float64(est.LivePages) / float64(est.Pages))
+	}
+	return est, records, starts, nil
+}
+
+// Compact rewrites the file with only the live records and shrinks it to the
+// pages they need. everything is synced first, the rewrite logs a begin and
+// end marker to the WAL.
+//
+// the file lock is let go between closing the old file and opening the new
+// one, so don't compact a file another process is waiting to open.
+func (s *Storage) Compact() (CompactReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact(nil)
+}
+
+// triggers are the thresholds that started an automatic compaction, nil when
+// it was asked for
+func (s *Storage) compact(triggers []CompactTrigger) (CompactReport, error) {
+	if err := s.checkWritable(); err != nil {
+		return CompactReport{}, err
+	}
+	// the Sync below mustn't start another one
+	s.compacting = true
+	defer func() { s.compacting = false }()
+	if err := s.sync(); err != nil {
+		return CompactReport{}, err
+	}
+
+	start := s.clock().Now()
+	est, records, starts, err := s.planCompaction()
+	if err != nil {
+		return CompactReport{}, err
+	}
+	report := CompactReport{PagesBefore: est.Pages, PagesAfter: est.LivePages, Records: est.LiveRecords}
+
+	detail := fmt.Sprintf("%d pages → %d", est.Pages, est.LivePages)
+	if _, err := s.wal.AppendCompaction(false, detail); err != nil {
+		return report, fmt.Errorf("compact: %w", err)
+	}
+
+	filename := s.file.Name()
+	if err := s.writeCompacted(filename+compactSuffix, records, starts); err != nil {
+		os.Remove(filename + compactSuffix)
+		return report, fmt.Errorf("compact: %w", err)
+	}
+	// the new layout has the same LSN, an index file would look valid for it
+	if err := s.dropIndex(); err != nil {
+		return report, fmt.Errorf("compact: %w", err)
+	}
+	// the new file holds every record, the cold tier's copies are old now
+	cold := s.coldPageIDs()
+	if err := s.swapFile(filename + compactSuffix); err != nil {
+		return report, fmt.Errorf("compact: %w", err)
+	}
+	s.dropColdCopies(cold)
+
+	if _, err := s.wal.AppendCompaction(true, detail); err != nil {
+		return report, fmt.Errorf("compact: %w", err)
+	}
+	report.ReclaimedBytes = est.ReclaimBytes
+	report.Duration = s.clock().Now().Sub(start)
+	s.deletesSinceCompact = 0
+	s.stats.countCompaction(report.ReclaimedBytes, triggers)
+	s.stats.recordTiming(&s.stats.lastCompaction, start, int64(est.Pages)*int64(s.pageSize))
+	return report, nil
+}
+
+// writes a complete database file holding records, packed as planned
+func (s *Storage) writeCompacted(path string, records []liveRecord, starts []int) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pages := uint32(len(starts))
+	header := pagefmt.Header{
+		Magic:      MagicNumber,
+		Version:    Version,
+		PageSize:   PageSize,
+		TotalPages: pages,
+		NextPageID: pages,
+		LastLSN:    s.lsn,
+		AppliedLSN: s.appliedLSN,
+		Engine:     uint32(s.engine.ID()),
+		Flags:      s.headerFlags(),
+	}
+	// header and pages are back to back, one sequential write
+	w := bufio.NewWriterSize(f, 64*PageSize)
+	if _, err := w.Write(header.Encode()); err != nil {
+		return err
+	}
+	for i, first := range starts {
+		end := len(records)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		page := make([]pagefmt.Record, 0, end-first)
+		for _, r := range records[first:end] {
+			page = append(page, pagefmt.Record{Key: []byte(r.key), Value: []byte(r.value)})
+		}
+		data, err := pagefmt.EncodePageFlags(page, s.headerFlags())
+		if err != nil {
+			return fmt.Errorf("page %d: %w", i, err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return syncFile(f)
+}
+
+// renames path over the database file and switches to it
+func (s *Storage) swapFile(path string) error {
+	filename := s.file.Name()
+	// a page ID in the double-write buffer means another page in the new file
+	if err := s.clearDoubleWrite(); err != nil {
+		return err
+	}
+	// its handle would keep reading the old file
+	s.closeVerifier()
+	// Windows can't rename over an open file, closing also drops the lock
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	renameErr := os.Rename(path, filename)
+
+	// whether the rename worked or not, filename is a complete database again
+	file, err := os.OpenFile(filename, os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("reopen %s: %w", filename, err)
+	}
+	if err := lockFile(file, true); err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.preallocatedPages = 0
+	if err := s.reload(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		os.Remove(path)
+		return renameErr
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// conditional writes: the existence check and the write happen under one
+// hold of the write lock, so two callers racing for the same key can't both
+// win. the SETNX pattern for registrations, job claims and dedup:
+//
+//	if ok, _ := db.PutIfAbsent("job:42:owner", workerID); !ok {
+//		return // another worker has it
+//	}
+//
+// neither looks at the current value. Update does: it hands the current
+// value to a function and writes what comes back, all under the write lock,
+// so a read-modify-write can't lose a concurrent change:
+//
+//	db.Update("hits", func(old string, exists bool) (string, bool) {
+//		n, _ := strconv.Atoi(old)
+//		return strconv.Itoa(n + 1), true
+//	})
+//
+// for counters like that one there is Increment, which keeps the number as
+// decimal text and is one WAL entry like any Put.
+
+// PutIfAbsent writes value only when key doesn't exist yet and reports
+// whether it did.
+func (s *Storage) PutIfAbsent(key, value string, opts ...WriteOption) (bool, error) {
+	return s.putIf(key, value, false, opts)
+}
+
+// ReplaceIfPresent writes value only when key exists already and reports
+// whether it did.
+func (s *Storage) ReplaceIfPresent(key, value string, opts ...WriteOption) (bool, error) {
+	return s.putIf(key, value, true, opts)
+}
+
+// Update calls fn with the current value of key (exists=false when there is
+// none) and writes what it returns, keep=false deletes the key instead.
+// fn runs with the storage locked, it must not call back into it.
+func (s *Storage) Update(key string, fn func(old string, exists bool) (new string, keep bool), opts ...WriteOption) error {
+	s.lockForWrite()
+	err := s.update(key, fn, opts)
+	lsn := s.lsn
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.awaitCommit(lsn, opts)
+}
+
+func (s *Storage) update(key string, fn func(string, bool) (string, bool), opts []WriteOption) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	_, exists, err := s.lookup(key)
+	if err != nil {
+		return err
+	}
+	var old string
+	if exists {
+		if old, err = s.get(key); err != nil {
+			return err
+		}
+	}
+	value, keep := fn(old, exists)
+	switch {
+	case keep:
+		return s.putValue(key, value, opts)
+	case exists:
+		return s.deleteValue(key, opts)
+	}
+	return nil
+}
+
+// Increment adds delta to the integer stored under key and returns the sum,
+// a missing key counts as 0 and a negative delta decrements. a value that
+// isn't an integer, or a sum that overflows int64, fails with
+// ErrInvalidValue and changes nothing.
+func (s *Storage) Increment(key string, delta int64, opts ...WriteOption) (int64, error) {
+	s.lockForWrite()
+	n, err := s.increment(key, delta, opts)
+	lsn := s.lsn
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return n, s.awaitCommit(lsn, opts)
+}
+
+func (s *Storage) increment(key string, delta int64, opts []WriteOption) (int64, error) {
+	if err := s.checkWritable(); err != nil {
+		return 0, err
+	}
+	_, exists, err := s.lookup(key)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if exists {
+		old, err := s.get(key)
+		if err != nil {
+			return 0, err
+		}
+		if n, err = strconv.ParseInt(old, 10, 64); err != nil {
+			return 0, fmt.Errorf("increment %q: value is not an integer: %w", key, ErrInvalidValue)
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, fmt.Errorf("increment %q: %d%+d overflows: %w", key, n, delta, ErrInvalidValue)
+	}
+	n += delta
+	if err := s.putValue(key, strconv.FormatInt(n, 10), opts); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *Storage) putIf(key, value string, present bool, opts []WriteOption) (bool, error) {
+	s.lockForWrite()
+	_, exists, err := s.lookup(key)
+	if err != nil || exists != present {
+		s.mu.Unlock()
+		return false, err
+	}
+	err = s.putValue(key, value, opts)
+	lsn := s.lsn
+	s.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	return true, s.awaitCommit(lsn, opts)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// crash points - named places in the write path where the process can be told
+// to die, so the crash test (tests/crash_test.go) can check what recovery
+// finds on disk after a crash at exactly that moment.
+//
+// a point is armed with an environment variable, name:N crashes on the N-th
+// time the point is reached:
+//
+//	GODATA_CRASH_AT=before-header-write:2 ./godata ...
+//
+// nothing is armed in normal runs and every check is a single string compare.
+const (
+	CrashBeforePageWrite   = "before-page-write"   // a page is about to be written
+	CrashMidPageWrite      = "mid-page-write"      // only the first half of a page reached the file
+	CrashBeforeHeaderWrite = "before-header-write" // all pages of a sync are written, the header isn't
+	CrashMidHeaderWrite    = "mid-header-write"    // only the first bytes of the header reached the file
+	CrashAfterWALAppend    = "after-wal-append"    // a write is in the WAL, its page isn't touched yet
+)
+
+// CrashPoints lists every point, for tools that want to try them all.
+var CrashPoints = []string{CrashBeforePageWrite, CrashMidPageWrite, CrashBeforeHeaderWrite, CrashMidHeaderWrite, CrashAfterWALAppend}
+
+// exit code used when a crash point fires, so a parent process can tell a
+// planned crash from a real failure
+const CrashExitCode = 86
+
+var crashArmed, crashAfter = parseCrashEnv(os.Getenv("GODATA_CRASH_AT"))
+var crashHits int
+
+func parseCrashEnv(value string) (string, int) {
+	if value == "" {
+		return "", 0
+	}
+	name, count, found := strings.Cut(value, ":")
+	if !found {
+		return name, 1
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 1 {
+		return name, 1
+	}
+	return name, n
+}
+
+// crashPoint kills the process if `name` is the armed point and it has been
+// reached enough times. torn, if not nil, runs first so a point can leave a
+// half-done write behind.
+func crashPoint(name string, torn func()) {
+	if crashArmed != name {
+		return
+	}
+	crashHits++
+	if crashHits < crashAfter {
+		return
+	}
+	if torn != nil {
+		torn()
+	}
+	fmt.Fprintf(os.Stderr, "godata: crashing at %s (hit %d)\n", name, crashHits)
+	os.Exit(CrashExitCode)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"unsafe"
+)
+
+// direct I/O - reading the data file without going through the OS page cache.
+// GoData keeps its own page cache, so for big one-off streams (verify scans)
+// letting the OS cache the same bytes again just pushes useful data out of RAM.
+//
+// O_DIRECT has strict rules: the buffer address, the file offset and the
+// length all have to be multiples of the disk block size. our pages start at
+// 64 + id*4096 so they never line up, a page always straddles two blocks:
+//
+//	block 0: [0 ............ 4095]  block 1: [4096 ......... 8191]
+//	         [header][page 0 ...........................][page 1 ...
+//	                 64                                  4160
+//
+// so we read the aligned blocks around the page and copy the page out.
+const directIOAlign = 4096
+
+// returns a slice of `size` bytes whose first byte sits on a directIOAlign boundary.
+// Go has no aligned allocation, so we over-allocate and skip ahead.
+func alignedBlock(size int) []byte {
+	buf := make([]byte, size+directIOAlign)
+	shift := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlign - 1))
+	if shift != 0 {
+		shift = directIOAlign - shift
+	}
+	return buf[shift : shift+size]
+}
+
+// a second read-only handle on the data file opened with direct I/O
+type directReader struct {
+	file *os.File
+}
+
+// opens the direct handle, fails on platforms or filesystems without support (tmpfs for example)
+func openDirectReader(path string) (*directReader, error) {
+	file, err := openDirect(path)
+	if err != nil {
+		return nil, fmt.Errorf("direct I/O not available: %w", err)
+	}
+	return &directReader{file: file}, nil
+}
+
+// reads len(dst) bytes at offset into dst using only aligned reads.
+// scratch must come from alignedBlock and be big enough for the aligned span
+// (pageSpan tells how big), each goroutine needs its own.
+func (d *directReader) ReadAt(dst []byte, offset int64, scratch []byte) error {
+	start := offset &^ (directIOAlign - 1)
+	end := (offset + int64(len(dst)) + directIOAlign - 1) &^ (directIOAlign - 1)
+	span := scratch[:end-start]
+
+	n, err := d.file.ReadAt(span, start)
+	// the last page may end inside the final block, a short read is fine
+	// as long as the bytes we want are there
+	if n < int(offset-start)+len(dst) {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	copy(dst, span[offset-start:])
+	return nil
+}
+
+func (d *directReader) Close() error {
+	return d.file.Close()
+}
+
+// size of the scratch buffer needed to read one page with directReader
+func directPageSpan(pageSize int) int {
+	return pageSize + directIOAlign
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// only Linux has O_DIRECT, everywhere else DirectIO falls back to normal reads
+func openDirect(path string) (*os.File, error) {
+	return nil, errors.New("O_DIRECT is not supported on this platform")
+}
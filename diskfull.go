@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// disk full: a write that runs into ENOSPC (or a quota) switches the storage
+// to read-only until there is room again, instead of failing one write here
+// and another there and leaving half of something on disk:
+//
+//	WAL append   the partial entry is cut off again (see WAL.appendEntry),
+//	             the write fails and nothing changed
+//	Sync         pages that didn't make it stay dirty, the header still has
+//	             the old LSN and the WAL still has every change, the next
+//	             Sync (or a crash and a replay) writes them again
+//
+// from then on writes fail with ErrDiskFull straight away, reads keep
+// working. every Options.DiskFullRetry (1s by default) a write tries a Sync
+// first, and any Sync that goes through (the checkpointer's, a caller's)
+// means there is room again: writes are back without anyone reopening the
+// storage. Health says which state the storage is in.
+
+const defaultDiskFullRetry = time.Second
+
+// marks the storage full when err is a disk full error, and says so in the
+// error. called with s.mu held exclusively.
+func (s *Storage) diskFullError(err error) error {
+	if err == nil || !isDiskFull(err) {
+		return err
+	}
+	now := s.clock().Now()
+	if s.diskFullSince.CompareAndSwap(0, now.UnixNano()) {
+		s.stats.diskFull.Add(1)
+	}
+	s.diskFullProbe = now
+	return fmt.Errorf("%w: %w", ErrDiskFull, err)
+}
+
+// checked at the top of every write, with s.mu held exclusively. while the
+// disk is full it fails, except every DiskFullRetry it tries a Sync to see
+// whether there is room again.
+func (s *Storage) checkDiskFull() error {
+	if s.diskFullSince.Load() == 0 {
+		return nil
+	}
+	s.optsMu.RLock()
+	retry := s.opts.DiskFullRetry
+	s.optsMu.RUnlock()
+	if retry <= 0 {
+		retry = defaultDiskFullRetry
+	}
+	if s.clock().Now().Sub(s.diskFullProbe) < retry {
+		return ErrDiskFull
+	}
+	s.diskFullProbe = s.clock().Now()
+	if err := s.sync(); err != nil {
+		if errors.Is(err, ErrDiskFull) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", ErrDiskFull, err)
+	}
+	return nil
+}
+
+// Health is what a readiness check wants to know about the storage.
+type Health struct {
+	Writable      bool      // writes are accepted right now
+	ReadOnly      bool      // opened with Options.ReadOnly
+	Maintenance   bool      // maintenance mode is on
+	DiskFull      bool      // writes are refused until there is disk space again
+	DiskFullSince time.Time // when the disk ran full, zero when it isn't
+}
+
+// Health reports whether the storage takes writes, and why not. it doesn't
+// take the storage lock, a health check never waits for a Sync.
+func (s *Storage) Health() Health {
+	h := Health{ReadOnly: s.opts.ReadOnly, Maintenance: s.MaintenanceMode()}
+	if since := s.diskFullSince.Load(); since != 0 {
+		h.DiskFull, h.DiskFullSince = true, time.Unix(0, since)
+	}
+	h.Writable = !h.ReadOnly && !h.Maintenance && !h.DiskFull
+	return h
+}
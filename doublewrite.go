@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+// torn pages: writePage puts 4096 bytes over the old page, and a disk only
+// promises a sector (512 bytes, 4KB on newer ones) goes down whole. a power
+// cut in the middle of the write leaves a page that's part old and part
+// new. with page checksums opening the file fails on it, without them its
+// records are garbage, and the WAL can't help: it replays writes on top of
+// pages and needs them whole.
+//
+// with Options.DoubleWrite every page goes to <file>.dwb first, with its ID
+// and a checksum, and that is fsynced before the page is written in place:
+//
+//	writePage(7)  →  .dwb: [magic][page 7][4096 bytes][crc] fsync  →  file: page 7, fsync
+//
+// opening the file puts an image from the .dwb that checks out back over its
+// page: a crash during the write in place left that page torn, and the image
+// is what it was going to be. a crash during the .dwb write leaves an image
+// that doesn't check out, and the page in the file wasn't touched yet. the
+// image of a write that finished is the page as it is, putting it back
+// changes nothing. a .dwb a crashed run left behind is honored when the file
+// is opened without DoubleWrite too, and removed after. Compact empties it
+// before the new file takes the old one's place, a page ID there means
+// another page.
+//
+// the price is a second write and fsync for every page written.
+
+const (
+	doubleWriteSuffix = ".dwb"
+	doubleWriteMagic  = 0x57444447 // "GDDW"
+	doubleWriteSize   = 4 + 4 + PageSize + 4
+)
+
+// opens the double-write buffer next to the data file and puts back the page
+// it holds, fresh is set when the data file was just created (a buffer from
+// an older file with the same name means nothing)
+func (s *Storage) openDoubleWrite(fresh bool) error {
+	if s.opts.ReadOnly {
+		return nil
+	}
+	path := s.file.Name() + doubleWriteSuffix
+	flags := os.O_RDWR
+	if s.opts.DoubleWrite {
+		flags |= os.O_CREATE
+	}
+	dwb, err := os.OpenFile(path, flags, 0644)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // DoubleWrite is off and was off last time
+	}
+	if err != nil {
+		return fmt.Errorf("open double-write buffer: %w", err)
+	}
+	s.dwb = dwb
+	if !fresh {
+		if err := s.restoreTornPage(); err != nil {
+			return err
+		}
+	}
+	if !s.opts.DoubleWrite {
+		// left by a run that had it on, its page is back and it's done
+		if err := s.closeDoubleWrite(); err != nil {
+			return err
+		}
+		return os.Remove(path)
+	}
+	return s.clearDoubleWrite()
+}
+
+// writes the page the buffer holds back in place, when it holds one whole
+func (s *Storage) restoreTornPage() error {
+	buf := make([]byte, doubleWriteSize)
+	if n, _ := s.dwb.ReadAt(buf, 0); n != doubleWriteSize {
+		return nil // empty, or cut off while it was written
+	}
+	end := doubleWriteSize - 4
+	if binary.LittleEndian.Uint32(buf[0:4]) != doubleWriteMagic ||
+		crc32.ChecksumIEEE(buf[:end]) != binary.LittleEndian.Uint32(buf[end:]) {
+		return nil
+	}
+	id := binary.LittleEndian.Uint32(buf[4:8])
+	offset := s.pageOffset(id)
+	if _, err := s.file.WriteAt(buf[8:end], offset); err != nil {
+		return &StorageError{Op: "restore page", PageID: int64(id), Offset: offset, Err: err}
+	}
+	if err := syncFile(s.file); err != nil {
+		return &StorageError{Op: "restore page", PageID: int64(id), Offset: offset, Err: err}
+	}
+	s.stats.pagesRestored.Add(1)
+	return nil
+}
+
+// called by writePage before the page goes in place, the image is on disk
+// once it returns
+func (s *Storage) doubleWrite(page *Page) error {
+	if s.dwb == nil {
+		return nil
+	}
+	buf := make([]byte, doubleWriteSize)
+	end := doubleWriteSize - 4
+	binary.LittleEndian.PutUint32(buf[0:4], doubleWriteMagic)
+	binary.LittleEndian.PutUint32(buf[4:8], page.ID)
+	copy(buf[8:end], page.Data[:])
+	binary.LittleEndian.PutUint32(buf[end:], crc32.ChecksumIEEE(buf[:end]))
+	if _, err := s.dwb.WriteAt(buf, 0); err != nil {
+		return &StorageError{Op: "double-write page", PageID: int64(page.ID), Offset: 0, Err: err}
+	}
+	if err := syncFile(s.dwb); err != nil {
+		return &StorageError{Op: "double-write page", PageID: int64(page.ID), Offset: 0, Err: err}
+	}
+	return nil
+}
+
+// empties the buffer, for when its page ID stops meaning the same page
+func (s *Storage) clearDoubleWrite() error {
+	if s.dwb == nil {
+		return nil
+	}
+	if err := s.dwb.Truncate(0); err != nil {
+		return fmt.Errorf("clear double-write buffer: %w", err)
+	}
+	return syncFile(s.dwb)
+}
+
+func (s *Storage) closeDoubleWrite() error {
+	if s.dwb == nil {
+		return nil
+	}
+	err := s.dwb.Close()
+	s.dwb = nil
+	return err
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// storage engines: the page layout, i.e. which page a new record goes on.
+// everything else (pages, WAL, index, Sync, Compact, the tools) is the same
+// for all of them, so they share one API. the engine is picked when the file
+// is created (Options.Engine) and recorded in the header, a file is always
+// opened with the engine it was created with:
+//
+//	heap    first page with room, the file fills up evenly (the default, and
+//	        what files from before engines existed use)
+//	sorted  every page holds a run of neighbouring keys, a full page is split
+//	        in two. ranges of keys sit on few pages, pair it with BTreeIndex,
+//	        finding a key's neighbour in the map index reads every key
+//	append  always the last page written, for keys that only grow (see
+//	        timeseries.go)
+//
+// an LSM layout would be one more engine here, it needs more than placement
+// (sorted runs and merges) and isn't written yet.
+
+// EngineID identifies a layout in the file header.
+type EngineID uint32
+
+const (
+	EngineHeap   EngineID = 0
+	EngineSorted EngineID = 1
+	EngineAppend EngineID = 2
+)
+
+// Engine decides where records are placed.
+type Engine interface {
+	ID() EngineID
+	Name() string
+	// the page a new record of size bytes (header included) goes on, nil
+	// means a new page. the key's old record, if any, is already gone.
+	place(s *Storage, key string, size int) (*Page, error)
+}
+
+var engines = map[EngineID]Engine{
+	EngineHeap:   heapEngine{},
+	EngineSorted: sortedEngine{},
+	EngineAppend: appendEngine{},
+}
+
+func engineByID(id EngineID) (Engine, error) {
+	engine, ok := engines[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage engine %d", id)
+	}
+	return engine, nil
+}
+
+// Engine returns the name of the layout the file was created with.
+func (s *Storage) Engine() string {
+	return s.engine.Name()
+}
+
+// bytes in use on the page, record count included
+func (p *Page) usedSpace() int {
+	if p.slotted {
+		return p.slottedUsedSpace()
+	}
+	used := 2 // Record count header
+	if err := p.eachRecord(func(_, _ string, size int) bool {
+		used += size
+		return true
+	}); err != nil {
+		return len(p.Data) // can't tell, don't put anything else on it
+	}
+	return used
+}
+
+type heapEngine struct{}
+
+func (heapEngine) ID() EngineID { return EngineHeap }
+func (heapEngine) Name() string { return "heap" }
+
+func (heapEngine) place(s *Storage, key string, size int) (*Page, error) {
+	// Try to find a page with space (simple linear search for now)
+	for pageID := uint32(0); pageID < s.totalPages; pageID++ {
+		page, err := s.loadPage(pageID)
+		if err != nil {
+			continue
+		}
+		if page.usedSpace()+size <= page.capacity() {
+			return page, nil
+		}
+	}
+	return nil, nil
+}
+
+type sortedEngine struct{}
+
+func (sortedEngine) ID() EngineID { return EngineSorted }
+func (sortedEngine) Name() string { return "sorted" }
+
+// the key goes next to the key just below it, or the one just above it when
+// it's the smallest. no neighbour at all means an empty file.
+func (sortedEngine) place(s *Storage, key string, size int) (*Page, error) {
+	_, pageID, found, err := s.indexFloor(key)
+	if err == nil && !found {
+		err = s.indexRange(key, "", func(_ string, id uint32) bool {
+			pageID, found = id, true
+			return false
+		})
+	}
+	if err != nil || !found {
+		return nil, err
+	}
+	page, err := s.loadPage(pageID)
+	if err != nil {
+		return nil, err
+	}
+	if page.usedSpace()+size <= page.capacity() {
+		return page, nil
+	}
+
+	right, first, err := s.splitPage(page)
+	if err != nil || right == nil {
+		return nil, err
+	}
+	target := page
+	if s.collation.Compare(key, first) >= 0 {
+		target = right
+	}
+	if target.usedSpace()+size > target.capacity() {
+		return nil, nil // a big record next to big neighbours, it gets a page of its own
+	}
+	return target, nil
+}
+
+// moves the upper half of page's live records (by key) to a new page. the
+// moved records aren't in the WAL, so they have to be on disk before the old
+// page loses them: the new page and every other dirty page are written, then
+// the page counts in the header, and the old page last. a crash in between
+// leaves a record on both pages, with the same value, rather than on none.
+// first is the smallest key moved, the page is nil when it has nothing to
+// split.
+func (s *Storage) splitPage(page *Page) (*Page, string, error) {
+	type record struct{ key, value string }
+	var live []record
+	var lookupErr error
+	page.eachRecord(func(key, value string, _ int) bool {
+		// an orphaned copy stays behind, GC deals with it
+		id, ok, err := s.lookup(key)
+		if ok && id == page.ID {
+			live = append(live, record{key, value})
+		}
+		lookupErr = err
+		return err == nil
+	})
+	if lookupErr != nil {
+		return nil, "", lookupErr
+	}
+	if len(live) < 2 {
+		return nil, "", nil
+	}
+	sort.Slice(live, func(i, j int) bool { return s.collation.Compare(live[i].key, live[j].key) < 0 })
+
+	right := s.allocateNewPage()
+	for _, r := range live[len(live)/2:] {
+		page.deleteRecord(r.key)
+		if err := right.addRecord(r.key, r.value); err != nil {
+			return nil, "", err
+		}
+		if err := s.indexPut(r.key, right.ID); err != nil {
+			return nil, "", err
+		}
+	}
+	page.IsDirty = true
+	if err := s.writePage(right); err != nil {
+		return nil, "", err
+	}
+	for _, p := range s.pages {
+		if p.IsDirty && p != page {
+			if err := s.writePage(p); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	if err := s.writePageCounts(); err != nil {
+		return nil, "", err
+	}
+	if err := s.writePage(page); err != nil {
+		return nil, "", err
+	}
+	return right, live[len(live)/2].key, nil
+}
+
+// writes TotalPages and NextPageID into the header and leaves the LSN alone,
+// everything the WAL got since the last Sync is still replayed after a crash
+func (s *Storage) writePageCounts() error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint32(buf[0:4], s.totalPages)
+	binary.LittleEndian.PutUint32(buf[4:8], s.nextPageID)
+	if _, err := s.file.WriteAt(buf[:], 12); err != nil {
+		return &StorageError{Op: "write header", PageID: -1, Offset: 12, Err: err}
+	}
+	return syncFile(s.file)
+}
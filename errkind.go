@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// error kinds: the handful of categories a client can do something about,
+// so a server in front of the storage (HTTP, gRPC, RESP) answers every
+// protocol the same way for the same error, and clients branch on the kind
+// instead of the message:
+//
+//	kind          errors                                        HTTP  gRPC                 RESP
+//	not_found     ErrKeyNotFound                                404   NOT_FOUND            NOTFOUND
+//	conflict      ErrKeyExists, ErrImportConflict, ErrLeaseHeld,  409   FAILED_PRECONDITION  CONFLICT
+//	              ErrStaleToken
+//	invalid       ErrInvalidValue                               400   INVALID_ARGUMENT     INVALID
+//	quota         ErrWALFull, ErrDiskFull                       507   RESOURCE_EXHAUSTED   QUOTA
+//	unavailable   ErrOverloaded, ErrLocked (retry later)        503   UNAVAILABLE          TRYAGAIN
+//	read_only     ErrReadOnlyMode, ErrOpenedReadOnly            503   FAILED_PRECONDITION  READONLY
+//	corrupted     ErrCorrupted, ErrChecksumMismatch             500   DATA_LOSS            CORRUPT
+//	internal      everything else                               500   INTERNAL             ERR
+//
+// the storage doesn't run a gRPC or RESP server itself, the codes are here
+// for the one the application puts in front of it. gRPC codes are the plain
+// numbers from google.golang.org/grpc/codes, so this package doesn't need it.
+
+// ErrorKind is the category of an error, see KindOf.
+type ErrorKind int
+
+const (
+	KindInternal ErrorKind = iota
+	KindNotFound
+	KindConflict
+	KindInvalid
+	KindQuota
+	KindUnavailable
+	KindReadOnly
+	KindCorrupted
+)
+
+// the sentinel errors of each kind, checked in order with errors.Is.
+// corruption goes first, a damaged page is never "just" a missing key
+var errorKinds = []struct {
+	kind ErrorKind
+	errs []error
+}{
+	{KindCorrupted, []error{ErrCorrupted, ErrChecksumMismatch}},
+	{KindNotFound, []error{ErrKeyNotFound}},
+	{KindConflict, []error{ErrKeyExists, ErrImportConflict, ErrLeaseHeld, ErrStaleToken}},
+	{KindInvalid, []error{ErrInvalidValue}},
+	{KindQuota, []error{ErrWALFull, ErrDiskFull}},
+	{KindUnavailable, []error{ErrOverloaded, ErrLocked}},
+	{KindReadOnly, []error{ErrReadOnlyMode, ErrOpenedReadOnly}},
+}
+
+// KindOf returns the category err falls in, KindInternal when it is none of
+// the storage's sentinel errors (or nil).
+func KindOf(err error) ErrorKind {
+	if err == nil {
+		return KindInternal
+	}
+	for _, k := range errorKinds {
+		for _, target := range k.errs {
+			if errors.Is(err, target) {
+				return k.kind
+			}
+		}
+	}
+	return KindInternal
+}
+
+// String returns the machine-readable name, "not_found", "quota", ...
+func (k ErrorKind) String() string {
+	switch k {
+	case KindNotFound:
+		return "not_found"
+	case KindConflict:
+		return "conflict"
+	case KindInvalid:
+		return "invalid"
+	case KindQuota:
+		return "quota"
+	case KindUnavailable:
+		return "unavailable"
+	case KindReadOnly:
+		return "read_only"
+	case KindCorrupted:
+		return "corrupted"
+	default:
+		return "internal"
+	}
+}
+
+// HTTPStatus returns the status code a response with this kind of error gets.
+func (k ErrorKind) HTTPStatus() int {
+	switch k {
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindConflict:
+		return http.StatusConflict
+	case KindInvalid:
+		return http.StatusBadRequest
+	case KindQuota:
+		return http.StatusInsufficientStorage
+	case KindUnavailable, KindReadOnly:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// GRPCCode returns the gRPC status code (the value of codes.Code).
+func (k ErrorKind) GRPCCode() uint32 {
+	switch k {
+	case KindNotFound:
+		return 5 // NOT_FOUND
+	case KindConflict, KindReadOnly:
+		return 9 // FAILED_PRECONDITION
+	case KindInvalid:
+		return 3 // INVALID_ARGUMENT
+	case KindQuota:
+		return 8 // RESOURCE_EXHAUSTED
+	case KindUnavailable:
+		return 14 // UNAVAILABLE
+	case KindCorrupted:
+		return 15 // DATA_LOSS
+	default:
+		return 13 // INTERNAL
+	}
+}
+
+// RESPPrefix returns the first word of a RESP error reply, the message goes
+// after it: "-NOTFOUND key not found\r\n".
+func (k ErrorKind) RESPPrefix() string {
+	switch k {
+	case KindNotFound:
+		return "NOTFOUND"
+	case KindConflict:
+		return "CONFLICT"
+	case KindInvalid:
+		return "INVALID"
+	case KindQuota:
+		return "QUOTA"
+	case KindUnavailable:
+		return "TRYAGAIN"
+	case KindReadOnly:
+		return "READONLY"
+	case KindCorrupted:
+		return "CORRUPT"
+	default:
+		return "ERR"
+	}
+}
+
+// WriteHTTPError answers an HTTP request with err: the status of its kind
+// and {"error": message, "kind": name} as the body.
+func WriteHTTPError(w http.ResponseWriter, err error) {
+	kind := KindOf(err)
+	writeJSON(w, kind.HTTPStatus(), map[string]string{"error": err.Error(), "kind": kind.String()})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"godata/pagefmt"
+)
+
+// ErrUnsupportedFormat is returned when opening a file written by a newer
+// build: a header version or a format flag this one doesn't know.
+var ErrUnsupportedFormat = errors.New("unsupported file format")
+
+// ErrKeyShredded is returned (wrapped) by reads of a value that was
+// encrypted with a bucket key Keyring.Shred destroyed.
+var ErrKeyShredded = errors.New("encryption key was shredded")
+
+// ErrReadOnlyMode is returned by writes while the storage is in maintenance mode.
+var ErrReadOnlyMode = errors.New("storage is in read-only maintenance mode")
+
+// ErrOpenedReadOnly is returned by writes on a storage opened with Options.ReadOnly.
+var ErrOpenedReadOnly = errors.New("storage was opened read-only")
+
+// ErrLocked is returned when another process already has the database open.
+var ErrLocked = errors.New("database is locked by another process")
+
+// ErrImportConflict is returned by Import with ConflictFail when a record
+// in the import already exists with a different value.
+var ErrImportConflict = errors.New("import conflicts with an existing key")
+
+// ErrOverloaded is returned by writes when Options.MaxDirtyPages is reached
+// and the backpressure policy is BackpressureReject.
+var ErrOverloaded = errors.New("too many unflushed changes, write rejected")
+
+// ErrWALFull is returned by writes when the WAL has reached Options.MaxWALBytes
+// and the policy is WALFullReject, or the checkpoint that should have emptied
+// it failed.
+var ErrWALFull = errors.New("write-ahead log is full")
+
+// ErrDiskFull is returned by writes while the disk is full (see diskfull.go),
+// the write that ran into it wraps the OS error as well.
+var ErrDiskFull = errors.New("disk is full, writes are suspended")
+
+// ErrNoColdTier is returned when a page is in the cold tier and the storage
+// was opened without Options.ColdTier, or MoveColdPages is called without one.
+var ErrNoColdTier = errors.New("page is in the cold tier, Options.ColdTier isn't set")
+
+// ErrInvalidValue is returned by Put when a validator rejects the value,
+// the error wraps the validator's own error as well, and by Increment when
+// the value isn't an integer.
+var ErrInvalidValue = errors.New("invalid value")
+
+// ErrKeyExists is returned by Rename, RenamePrefix and Copy when a new name
+// is already taken by a key that isn't being moved itself.
+var ErrKeyExists = errors.New("key already exists")
+
+// ErrInvalidSwap is returned by MultiSwap when its keys aren't a permutation
+// of themselves, a value would be lost or end up under two keys.
+var ErrInvalidSwap = errors.New("swap targets must be the swapped keys, each once")
+
+// ErrLeaseHeld is returned by PutWithLease while another holder's lease on
+// the key hasn't expired.
+var ErrLeaseHeld = errors.New("lease is held by someone else")
+
+// ErrStaleToken is returned by PutFenced and RenewLease when the token isn't
+// the key's latest one, a newer holder has taken the lease since.
+var ErrStaleToken = errors.New("fencing token is stale")
+
+// ErrKeyNotFound is returned by Get, Delete and the other calls that need an
+// existing key when there is none.
+var ErrKeyNotFound = errors.New("key not found")
+
+// ErrVersionGone is returned by GetAt for an LSN older than every open
+// snapshot, the versions from back then weren't kept.
+var ErrVersionGone = errors.New("version is no longer kept")
+
+// ErrSnapshotClosed is returned by the reads of a closed Snapshot.
+var ErrSnapshotClosed = errors.New("snapshot is closed")
+
+// ErrPageFull is returned when a record doesn't fit in the room left on a page.
+var ErrPageFull = errors.New("page full: not enough space for record")
+
+// ErrCorrupted is wrapped, in a StorageError saying which page and offset,
+// by every error that means the file's bytes are damaged: a checksum
+// mismatch, a record running past its page, a key the index puts on a page
+// that doesn't have it.
+var ErrCorrupted = errors.New("corruption detected")
+
+// ErrChecksumMismatch is returned (wrapped in a StorageError) when a page read
+// from disk doesn't match its checksum, the file is damaged. errors.Is matches
+// ErrCorrupted on it as well.
+var ErrChecksumMismatch = pagefmt.ErrChecksum
+
+// StorageError says where in the file a low-level operation failed, every
+// I/O and parse error from the page and header code comes wrapped in one:
+//
+//	var se *StorageError
+//	if errors.As(err, &se) {
+//		log.Printf("%s failed on page %d at byte %d: %v", se.Op, se.PageID, se.Offset, se.Err)
+//	}
+type StorageError struct {
+	Op     string // what was being done: "read page", "write header", "sync", "parse record", ...
+	PageID int64  // page involved, -1 for the file header and whole-file operations
+	Offset int64  // byte offset in the file where it happened
+	Err    error  // the underlying error
+}
+
+func (e *StorageError) Error() string {
+	if e.PageID < 0 {
+		return fmt.Sprintf("%s at offset %d: %v", e.Op, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("%s, page %d at offset %d: %v", e.Op, e.PageID, e.Offset, e.Err)
+}
+
+func (e *StorageError) Unwrap() error { return e.Err }
+
+// a StorageError for damaged bytes on a page, it matches ErrCorrupted and
+// whatever err matches
+func corruptedPage(op string, pageID uint32, offset int64, err error) error {
+	return &StorageError{Op: op, PageID: int64(pageID), Offset: offset, Err: fmt.Errorf("%w: %w", ErrCorrupted, err)}
+}
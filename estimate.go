@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+// approximate counts: EstimateCount answers "about how many keys start with
+// prefix" from a sample of the index, in the same time however many keys
+// there are, for dashboards showing prefixes too big to count with a Scan.
+//
+//	map index  the first estimateSample keys the map hands out (its order is
+//	           random), the share of them with the prefix times the key
+//	           count. exact when there aren't more keys than that
+//	B+ tree    estimateWalks random walks from the root down to a leaf, each
+//	           only going into children that can hold the prefix. a walk
+//	           counts the leaf's matching keys times the number of choices
+//	           it had on the way down, the estimate is the average of the
+//	           walks. exact when the prefix is all in one leaf
+//
+// small prefixes in a big index come out rough (the map sample can miss
+// them completely), Scan counts those quickly enough anyway.
+
+const (
+	estimateSample = 1024
+	estimateWalks  = 64
+)
+
+// EstimateCount returns about how many keys start with prefix (see
+// estimate.go). an empty prefix counts every key, exactly. the storage's own
+// records (see iterator.go) aren't counted.
+func (s *Storage) EstimateCount(prefix string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if isReservedKey(prefix) {
+		return 0, nil // every key with it is one of the storage's own
+	}
+	count, err := s.estimateCount(prefix)
+	if err != nil {
+		return 0, err
+	}
+	// the storage's own keys with the prefix are counted exactly and taken off
+	for _, reserved := range reservedPrefixes {
+		if !strings.HasPrefix(reserved, prefix) {
+			continue
+		}
+		err := s.indexPrefix(reserved, func(string, uint32) bool {
+			count--
+			return true
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return max(count, 0), nil
+}
+
+func (s *Storage) estimateCount(prefix string) (int, error) {
+	if prefix == "" {
+		return s.indexLen(), nil
+	}
+	if !s.binaryOrder() {
+		// the keys with the prefix aren't one range (see collation.go)
+		count := 0
+		err := s.indexPrefix(prefix, func(string, uint32) bool {
+			count++
+			return true
+		})
+		return count, err
+	}
+	if s.btree != nil {
+		estimate, err := s.btree.estimate(prefix, prefixEnd(prefix), estimateWalks)
+		return int(math.Round(estimate)), err
+	}
+
+	sampled, matched := 0, 0
+	for key := range s.pageIndex {
+		if strings.HasPrefix(key, prefix) {
+			matched++
+		}
+		if sampled++; sampled == estimateSample {
+			break
+		}
+	}
+	if sampled < estimateSample {
+		return matched, nil // that was every key
+	}
+	return int(math.Round(float64(matched) / float64(sampled) * float64(len(s.pageIndex)))), nil
+}
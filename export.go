@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// exports are NDJSON: one header line describing the export, then one line
+// per record, sorted by key so two exports of the same data are identical.
+//
+//	{"format":"godata-export","version":1,"lsn":1042,"records":2,"created":"2024-01-01T00:00:00Z"}
+//	{"key":"user:1","value":"isabella"}
+//	{"key":"blob:7","value_b64":"AAEC/w=="}
+//
+// values that aren't valid UTF-8 go in value_b64, JSON strings can't carry them.
+
+const (
+	ExportFormat  = "godata-export"
+	ExportVersion = 1
+)
+
+// ExportHeader is the first line of an export.
+type ExportHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	LSN     uint64    `json:"lsn"` // every write up to and including this one is in the export
+	Records int       `json:"records"`
+	Created time.Time `json:"created"`
+}
+
+// ExportRecord is one record line of an export.
+type ExportRecord struct {
+	Key      string  `json:"key"`
+	Value    *string `json:"value,omitempty"`
+	ValueB64 []byte  `json:"value_b64,omitempty"`
+}
+
+// Data returns the value of the record, whichever field it was stored in.
+func (r ExportRecord) Data() string {
+	if r.Value != nil {
+		return *r.Value
+	}
+	return string(r.ValueB64)
+}
+
+type snapshotRecord struct {
+	key, value string
+}
+
+// Export writes every record to w as NDJSON. the records are copied out of
+// the pages before the first line is written, so the export is the database
+// at one point in time (the LSN in the header): writes made while a slow w
+// is still draining don't end up in it. the LSN is what a later incremental
+// export starts from. the storage's own records (leases, migration
+// positions, chunks, see iterator.go) aren't exported, a streamed value is
+// exported whole and Import streams it back in.
+func (s *Storage) Export(w io.Writer) (ExportHeader, error) {
+	lsn, records, err := s.snapshotRecords(true)
+	if err != nil {
+		return ExportHeader{}, err
+	}
+
+	header := ExportHeader{
+		Format:  ExportFormat,
+		Version: ExportVersion,
+		LSN:     lsn,
+		Records: len(records),
+		Created: s.clock().Now().UTC(),
+	}
+
+	return header, writeExport(w, header, records)
+}
+
+// writes the header line and one line per record
+func writeExport(w io.Writer, header ExportHeader, records []snapshotRecord) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw) // Encode adds the newline
+	if err := enc.Encode(header); err != nil {
+		return fmt.Errorf("export header: %w", err)
+	}
+	for _, r := range records {
+		line := ExportRecord{Key: r.key}
+		if utf8.ValidString(r.value) {
+			value := r.value
+			line.Value = &value
+		} else {
+			line.ValueB64 = []byte(r.value)
+		}
+		if err := enc.Encode(line); err != nil {
+			return fmt.Errorf("export %q: %w", r.key, err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	return nil
+}
+
+// copies every live record (decoded) and the LSN they're current as of.
+// exported leaves out the storage's own records and puts streamed values
+// together, the others are copied like the pages hold them.
+func (s *Storage) snapshotRecords(exported bool) (uint64, []snapshotRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lsn := s.lsn
+	records := make([]snapshotRecord, 0, s.indexLen())
+	var failed error
+	err := s.indexRange("", "", func(key string, pageID uint32) bool {
+		if exported && isReservedKey(key) {
+			return true
+		}
+		page, err := s.loadPage(pageID)
+		if err != nil {
+			failed = err
+			return false
+		}
+		stored, found := page.findRecord(key)
+		if !found {
+			failed = fmt.Errorf("snapshot: %q missing from page %d", key, pageID)
+			return false
+		}
+		value, err := s.decodeValue(key, stored)
+		if err != nil {
+			failed = fmt.Errorf("snapshot: %q: %w", key, err)
+			return false
+		}
+		records = append(records, snapshotRecord{key, value})
+		return true
+	})
+	if err == nil {
+		err = failed
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if exported {
+		for i, r := range records {
+			if records[i].value, err = s.resolveStream(r.value); err != nil {
+				return 0, nil, fmt.Errorf("snapshot: %q: %w", r.key, err)
+			}
+		}
+	}
+	return lsn, records, nil
+}
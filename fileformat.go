@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// file format: the header's Version is the layout of the header and of a
+// record, it stays 1 while the format grows through header flags, one per
+// feature (page checksums, record versions, slotted pages, page LSNs, see
+// pagefmt). a file says which ones it uses and a build opens it when it
+// knows them all: a file from a newer build, with a flag this one doesn't
+// know or a higher Version, fails to open with ErrUnsupportedFormat instead
+// of being misread.
+//
+// the options that pick a feature only apply to new files, an existing file
+// keeps the ones it was created with. Migrate rewrites a file with the ones
+// its options ask for, and keeps the old file as <file>.bak:
+//
+//	opts := DefaultOptions()
+//	opts.SlottedPages = true
+//	report, err := Migrate("orders.db", opts)
+//	// report.From and report.To are the header flags before and after
+//
+// the file is opened first, which replays its WAL and puts back a torn page,
+// then every live record is copied into <file>.migrate in key order. the
+// file and the files next to it (WAL, index, ...) are renamed to the
+// backup's name and the new ones take their place. values are copied the
+// way they are stored, so opts needs the pipeline (Compress, Transformers)
+// the file was written with. a crash before the renames leaves the file as
+// it was, one during them leaves the old file as <file>.bak and the new one
+// as <file>.migrate. the file can't be open while it runs.
+
+const (
+	backupSuffix  = ".bak"
+	migrateSuffix = ".migrate"
+)
+
+// the files a database is made of, a migration moves them together
+var databaseSuffixes = []string{"", ".wal", indexSuffix, btreeSuffix, warmSuffix, checkpointSuffix, doubleWriteSuffix}
+
+// FormatMigration is the result of a Migrate.
+type FormatMigration struct {
+	From    uint32 // header flags before
+	To      uint32 // header flags after, From when there was nothing to do
+	Records int    // records copied
+	Backup  string // where the old file is, "" when nothing changed
+}
+
+// Migrate rewrites the database file at path with the format features opts
+// asks for, see fileformat.go. a file that has them already isn't touched.
+func Migrate(path string, opts Options) (FormatMigration, error) {
+	var report FormatMigration
+	// opening it would create it
+	if _, err := os.Stat(path); err != nil {
+		return report, fmt.Errorf("migrate: %w", err)
+	}
+	src, err := NewStorageWithOptions(path, opts)
+	if err != nil {
+		return report, fmt.Errorf("migrate: %w", err)
+	}
+	src.mu.Lock()
+	report.From, report.To = src.headerFlags(), src.newFileFlags()
+	var records []liveRecord
+	switch {
+	case report.From == report.To:
+	case len(src.coldPageIDs()) > 0:
+		// the backup would need them, and the new file's pages have the same IDs
+		err = fmt.Errorf("migrate: %d pages are in the cold tier, Compact brings them back", len(src.coldPageIDs()))
+	default:
+		if err = src.sync(); err == nil {
+			_, records, _, err = src.planCompaction()
+		}
+	}
+	src.mu.Unlock()
+	if closeErr := src.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || report.From == report.To {
+		report.To = report.From
+		return report, err
+	}
+	backup := path + backupSuffix
+	if _, err := os.Stat(backup); err == nil {
+		return report, fmt.Errorf("migrate: %s: %w", backup, os.ErrExist)
+	}
+
+	tmp := path + migrateSuffix
+	removeDatabase(tmp) // left by a migration that crashed
+	if err := copyRecords(tmp, opts, records, src.versioned); err != nil {
+		removeDatabase(tmp)
+		return report, fmt.Errorf("migrate: %w", err)
+	}
+	report.Records = len(records)
+
+	for _, suffix := range databaseSuffixes {
+		if err := os.Rename(path+suffix, backup+suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return report, fmt.Errorf("migrate: %w", err)
+		}
+	}
+	report.Backup = backup
+	for _, suffix := range databaseSuffixes {
+		if err := os.Rename(tmp+suffix, path+suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return report, fmt.Errorf("migrate: %w", err)
+		}
+	}
+	return report, nil
+}
+
+// writes records, with their values as stored, into a new database at path
+func copyRecords(path string, opts Options, records []liveRecord, versioned bool) error {
+	db, err := NewStorageWithOptions(path, opts)
+	if err != nil {
+		return err
+	}
+	stored := &Page{versioned: versioned}
+	db.mu.Lock()
+	for _, r := range records {
+		// the pipeline doesn't run again, the LSN is the new file's
+		if err = db.put(r.key, stored.stripVersion(r.value), writeOptions{}, 0); err != nil {
+			err = fmt.Errorf("%q: %w", r.key, err)
+			break
+		}
+	}
+	db.mu.Unlock()
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func removeDatabase(path string) {
+	for _, suffix := range databaseSuffixes {
+		os.Remove(path + suffix)
+	}
+}
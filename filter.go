@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// scan filters: ScanWhere takes a small expression and only stops on the
+// records it matches, so a caller looking for a handful of records among
+// many doesn't get every value handed back to look at itself:
+//
+//	it, err := db.ScanWhere("user:", "user;", `json.status == "active" && json.age >= 18`)
+//
+// the expression language:
+//
+//	key, value       the record's key and (decoded) value, as strings
+//	json.a.b.0       a field of the value parsed as JSON, numbers index arrays,
+//	                 null when it isn't there or the value isn't JSON
+//	"text" 42 -1.5   string and number literals, true, false and null
+//	== != < <= > >=  comparisons, numbers with numbers, strings with strings.
+//	                 anything else is only ever equal to itself
+//	&& || ! ( )      the usual, && binds tighter than ||
+//
+// a bare operand is a condition too: `json.enabled` is json.enabled == true.
+// the value is parsed as JSON once per record, and only when the expression
+// has a json operand.
+
+// Filter is a parsed filter expression, see ScanWhere.
+type Filter struct {
+	src      string
+	root     filterNode
+	needJSON bool
+}
+
+// ParseFilter parses a filter expression, for checking one before it is used
+// or using one for many scans.
+func ParseFilter(expr string) (*Filter, error) {
+	p := &filterParser{src: expr}
+	if err := p.lex(); err != nil {
+		return nil, fmt.Errorf("filter %q: %w", expr, err)
+	}
+	f := &Filter{src: expr}
+	root, err := p.parseOr(f)
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q at %d", p.tokens[p.pos].text, p.tokens[p.pos].at)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("filter %q: %w", expr, err)
+	}
+	f.root = root
+	return f, nil
+}
+
+func (f *Filter) String() string { return f.src }
+
+// Match reports whether a record passes the filter.
+func (f *Filter) Match(key, value string) bool {
+	rec := filterRecord{key: key, value: value}
+	if f.needJSON {
+		if json.Unmarshal([]byte(value), &rec.doc) != nil {
+			rec.doc = nil
+		}
+	}
+	return truthy(f.root.eval(&rec))
+}
+
+// ScanWhere is Scan, skipping the records expr doesn't match.
+func (s *Storage) ScanWhere(start, end, expr string) (*Iterator, error) {
+	filter, err := ParseFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	it := s.Scan(start, end)
+	it.filter = filter
+	return it, nil
+}
+
+type filterRecord struct {
+	key, value string
+	doc        any // the value as JSON, nil when it isn't
+}
+
+type filterNode interface {
+	eval(rec *filterRecord) any // string, float64, bool or nil
+}
+
+type (
+	literalNode struct{ v any }
+	fieldNode   struct{ name string } // key or value
+	jsonNode    struct{ path []string }
+	notNode     struct{ x filterNode }
+	logicNode   struct {
+		and  bool
+		l, r filterNode
+	}
+	compareNode struct {
+		op   string
+		l, r filterNode
+	}
+)
+
+func (n literalNode) eval(*filterRecord) any { return n.v }
+
+func (n fieldNode) eval(rec *filterRecord) any {
+	if n.name == "key" {
+		return rec.key
+	}
+	return rec.value
+}
+
+func (n jsonNode) eval(rec *filterRecord) any {
+	v := rec.doc
+	for _, name := range n.path {
+		switch doc := v.(type) {
+		case map[string]any:
+			v = doc[name]
+		case []any:
+			i, err := strconv.Atoi(name)
+			if err != nil || i < 0 || i >= len(doc) {
+				return nil
+			}
+			v = doc[i]
+		default:
+			return nil
+		}
+	}
+	switch v.(type) {
+	case string, float64, bool:
+		return v
+	}
+	return nil // objects and arrays only equal nothing
+}
+
+func (n notNode) eval(rec *filterRecord) any { return !truthy(n.x.eval(rec)) }
+
+func (n logicNode) eval(rec *filterRecord) any {
+	if n.and {
+		return truthy(n.l.eval(rec)) && truthy(n.r.eval(rec))
+	}
+	return truthy(n.l.eval(rec)) || truthy(n.r.eval(rec))
+}
+
+func (n compareNode) eval(rec *filterRecord) any {
+	l, r := n.l.eval(rec), n.r.eval(rec)
+	var cmp int
+	switch a := l.(type) {
+	case float64:
+		b, ok := r.(float64)
+		if !ok {
+			return n.op == "!="
+		}
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		}
+	case string:
+		b, ok := r.(string)
+		if !ok {
+			return n.op == "!="
+		}
+		cmp = strings.Compare(a, b)
+	default: // bool and null
+		switch n.op {
+		case "==":
+			return l == r
+		case "!=":
+			return l != r
+		}
+		return false
+	}
+	switch n.op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func truthy(v any) bool {
+	b, ok := v.(bool)
+	return ok && b
+}
+
+type filterToken struct {
+	kind byte // 'i'dent, 's'tring, 'n'umber, 'o'perator
+	text string
+	at   int
+}
+
+type filterParser struct {
+	src    string
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) lex() error {
+	src := p.src
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(src) && src[end] != '"' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return fmt.Errorf("unterminated string at %d", i)
+			}
+			text, err := strconv.Unquote(src[i : end+1])
+			if err != nil {
+				return fmt.Errorf("bad string at %d: %w", i, err)
+			}
+			p.tokens = append(p.tokens, filterToken{'s', text, i})
+			i = end + 1
+		case c == '-' || c >= '0' && c <= '9':
+			end := i + 1
+			for end < len(src) && strings.IndexByte("0123456789.eE+-", src[end]) >= 0 {
+				end++
+			}
+			p.tokens = append(p.tokens, filterToken{'n', src[i:end], i})
+			i = end
+		case c == '_' || unicode.IsLetter(rune(c)):
+			end := i + 1
+			for end < len(src) && (src[end] == '_' || src[end] == '.' || unicode.IsLetter(rune(src[end])) || unicode.IsDigit(rune(src[end]))) {
+				end++
+			}
+			p.tokens = append(p.tokens, filterToken{'i', src[i:end], i})
+			i = end
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")"} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			p.tokens = append(p.tokens, filterToken{'o', op, i})
+			i += len(op)
+		}
+	}
+	return nil
+}
+
+// the next token if it is the operator op
+func (p *filterParser) accept(op string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == 'o' && p.tokens[p.pos].text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr(f *Filter) (filterNode, error) {
+	l, err := p.parseAnd(f)
+	for err == nil && p.accept("||") {
+		var r filterNode
+		if r, err = p.parseAnd(f); err == nil {
+			l = logicNode{and: false, l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *filterParser) parseAnd(f *Filter) (filterNode, error) {
+	l, err := p.parseUnary(f)
+	for err == nil && p.accept("&&") {
+		var r filterNode
+		if r, err = p.parseUnary(f); err == nil {
+			l = logicNode{and: true, l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *filterParser) parseUnary(f *Filter) (filterNode, error) {
+	if p.accept("!") {
+		x, err := p.parseUnary(f)
+		return notNode{x}, err
+	}
+	if p.accept("(") {
+		x, err := p.parseOr(f)
+		if err == nil && !p.accept(")") {
+			err = fmt.Errorf("missing ) at %d", p.at())
+		}
+		return x, err
+	}
+	l, err := p.parseOperand(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			r, err := p.parseOperand(f)
+			return compareNode{op: op, l: l, r: r}, err
+		}
+	}
+	return compareNode{op: "==", l: l, r: literalNode{true}}, nil
+}
+
+func (p *filterParser) parseOperand(f *Filter) (filterNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("expression ends early")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	switch tok.kind {
+	case 's':
+		return literalNode{tok.text}, nil
+	case 'n':
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q at %d", tok.text, tok.at)
+		}
+		return literalNode{n}, nil
+	case 'i':
+		switch tok.text {
+		case "true", "false":
+			return literalNode{tok.text == "true"}, nil
+		case "null":
+			return literalNode{nil}, nil
+		case "key", "value":
+			return fieldNode{tok.text}, nil
+		}
+		if path, ok := strings.CutPrefix(tok.text, "json."); ok && path != "" {
+			f.needJSON = true
+			return jsonNode{strings.Split(path, ".")}, nil
+		}
+		return nil, fmt.Errorf("unknown name %q at %d (want key, value or json.<field>)", tok.text, tok.at)
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", tok.text, tok.at)
+}
+
+// where the parser is, for errors
+func (p *filterParser) at() int {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].at
+	}
+	return len(p.src)
+}
@@ -0,0 +1,101 @@
+package main
+
+// free list - pages inside TotalPages that hold no records and can be handed
+// out again by allocateNewPage instead of growing the file.
+// it isn't stored on disk: an empty page has RecordCount 0, so buildIndex can
+// find them again on every open.
+
+// remembers an empty page for reuse, ignoring ones already on the list
+func (s *Storage) addFreePage(pageID uint32) {
+	for _, id := range s.freePages {
+		if id == pageID {
+			return
+		}
+	}
+	s.freePages = append(s.freePages, pageID)
+}
+
+// pops a page from the free list. entries are checked before use,
+// because a free page can be filled by Put's first-fit search in the meantime.
+func (s *Storage) takeFreePage() *Page {
+	for len(s.freePages) > 0 {
+		id := s.freePages[len(s.freePages)-1]
+		s.freePages = s.freePages[:len(s.freePages)-1]
+
+		page, err := s.loadPage(id)
+		if err != nil || page.RecordCount != 0 {
+			continue
+		}
+		return page
+	}
+	return nil
+}
+
+// GCReport says what a GC pass found.
+type GCReport struct {
+	PagesScanned uint32   // pages looked at
+	Orphaned     []uint32 // pages that had records but none the index points to, now cleared
+	StaleRecords int      // records dropped from those pages
+	FreePages    int      // size of the free list after the pass
+	// chunks of streamed values no manifest points to, deleted (see stream.go)
+	OrphanedChunks int
+}
+
+// GC finds orphaned pages: pages that still contain records, but none of them
+// is the live copy of its key (the index points somewhere else or the key is
+// gone). that happens after crashes or interrupted rewrites. orphaned pages are
+// wiped and put on the free list so new records can reuse the space.
+func (s *Storage) GC() (GCReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkWritable(); err != nil {
+		return GCReport{}, err
+	}
+
+	report := GCReport{PagesScanned: s.totalPages}
+	// first, so the pages they leave empty are found below
+	dropped, err := s.dropOrphanedChunks()
+	if err != nil {
+		return report, err
+	}
+	report.OrphanedChunks = dropped
+	for pageID := uint32(0); pageID < s.totalPages; pageID++ {
+		page, err := s.loadPage(pageID)
+		if err != nil {
+			return report, err
+		}
+		if page.RecordCount == 0 {
+			s.addFreePage(pageID)
+			continue
+		}
+
+		// an unreadable tail ends the walk, whatever we counted so far decides
+		live := 0
+		var lookupErr error
+		page.eachRecord(func(key, _ string, _ int) bool {
+			id, ok, err := s.lookup(key)
+			if ok && id == pageID {
+				live++
+			}
+			lookupErr = err
+			return err == nil
+		})
+		if lookupErr != nil {
+			return report, lookupErr
+		}
+		if live > 0 {
+			continue
+		}
+
+		// nothing in here is reachable, wipe it
+		report.Orphaned = append(report.Orphaned, pageID)
+		report.StaleRecords += int(page.RecordCount)
+		page.Data = [PageSize]byte{}
+		page.RecordCount = 0
+		page.IsDirty = true
+		s.addFreePage(pageID)
+	}
+
+	report.FreePages = len(s.freePages)
+	return report, nil
+}
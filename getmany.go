@@ -0,0 +1,98 @@
+package main
+
+import "sort"
+
+// batch reads: GetMany looks every key up first and groups them by the page
+// they're on, then loads each page once, in file order, and takes all of its
+// keys off it in one pass over the records. 200 keys on 30 pages are 30 page
+// loads and 30 record scans instead of 200 of each, and a page isn't pushed
+// out of a small cache by the other keys' pages before its last key is read:
+//
+//	keys   a b c d e f        page 3: a c f   → one load, one scan
+//	index  3 7 3 7 9 3   →    page 7: b d     → one load, one scan
+//	                          page 9: e       → one load, one scan
+//
+// keys in the value cache don't need their page at all. the whole call holds
+// the read lock, so the values are all from the same moment.
+
+// GetMany returns the values of keys, a key that doesn't exist is left out
+// of the map.
+func (s *Storage) GetMany(keys []string) (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.stats.gets.Add(uint64(len(keys)))
+
+	values := make(map[string]string, len(keys))
+	byPage := make(map[uint32][]string)
+	seen := make(map[string]bool, len(keys))
+	var err error
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if value, ok := s.values.get(key); ok {
+			s.stats.valueHits.Add(1)
+			if values[key], err = s.resolveStream(value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		pageID, exists, err := s.lookup(key)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			byPage[pageID] = append(byPage[pageID], key)
+		}
+	}
+
+	pageIDs := make([]uint32, 0, len(byPage))
+	for pageID := range byPage {
+		pageIDs = append(pageIDs, pageID)
+	}
+	sort.Slice(pageIDs, func(i, j int) bool { return pageIDs[i] < pageIDs[j] })
+	for _, pageID := range pageIDs {
+		page, err := s.loadPage(pageID)
+		if err != nil {
+			return nil, err
+		}
+		stored := page.findRecords(byPage[pageID])
+		for _, key := range byPage[pageID] {
+			value, found := stored[key]
+			if !found {
+				return nil, missingRecord(key, pageID)
+			}
+			decoded, err := s.decodeValue(key, value)
+			if err != nil {
+				return nil, err
+			}
+			if s.values != nil {
+				s.stats.valueMisses.Add(1)
+				if !s.values.put(key, decoded) {
+					s.stats.valueRejected.Add(1)
+				}
+			}
+			if values[key], err = s.resolveStream(decoded); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return values, nil
+}
+
+// findRecord for many keys in one pass, the map holds the ones found
+func (p *Page) findRecords(keys []string) map[string]string {
+	wanted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		wanted[key] = true
+	}
+	found := make(map[string]string, len(keys))
+	p.eachRecord(func(key, value string, _ int) bool {
+		if _, dup := found[key]; wanted[key] && !dup { // the first copy, like findRecord
+			found[key] = p.stripVersion(value)
+		}
+		return len(found) < len(wanted)
+	})
+	return found
+}
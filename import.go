@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ConflictPolicy decides what Import does with a key that already exists
+// with a different value. identical values are never a conflict, so a restore
+// can be run again after it was interrupted.
+type ConflictPolicy int
+
+const (
+	ConflictFail      ConflictPolicy = iota // stop before writing anything
+	ConflictSkip                            // keep the value in the database
+	ConflictOverwrite                       // replace it with the imported value
+)
+
+func (p ConflictPolicy) String() string {
+	switch p {
+	case ConflictFail:
+		return "fail"
+	case ConflictSkip:
+		return "skip"
+	case ConflictOverwrite:
+		return "overwrite"
+	default:
+		return fmt.Sprintf("ConflictPolicy(%d)", int(p))
+	}
+}
+
+// ParseConflictPolicy is the reverse of ConflictPolicy.String.
+func ParseConflictPolicy(value string) (ConflictPolicy, error) {
+	switch value {
+	case "fail":
+		return ConflictFail, nil
+	case "skip":
+		return ConflictSkip, nil
+	case "overwrite":
+		return ConflictOverwrite, nil
+	default:
+		return 0, fmt.Errorf("unknown conflict policy %q (want skip, overwrite or fail)", value)
+	}
+}
+
+// ImportReport counts what happened to every record of an import.
+type ImportReport struct {
+	Header      ExportHeader // header line of the import
+	Records     int          // record lines read
+	Inserted    int          // keys that didn't exist
+	Unchanged   int          // keys that already held the same value
+	Overwritten int          // conflicts replaced (ConflictOverwrite)
+	Skipped     int          // conflicts left alone (ConflictSkip)
+	Conflicts   []string     // conflicting keys (ConflictFail), nothing was written
+}
+
+// the longest line Import reads. a streamed value is exported whole on one
+// line, so this is its limit too
+const maxImportLine = 256 << 20
+
+// Import reads an export written by Export and puts its records into the
+// database. the whole input is read and checked before the first write,
+// so a malformed line or a conflict under ConflictFail leaves the database
+// untouched.
+func (s *Storage) Import(r io.Reader, policy ConflictPolicy) (ImportReport, error) {
+	s.lockForWrite()
+	defer s.mu.Unlock()
+	var report ImportReport
+	if err := s.checkWritable(); err != nil {
+		return report, err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxImportLine)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return report, fmt.Errorf("import: %w", err)
+		}
+		return report, fmt.Errorf("import: empty input, expected a %s header", ExportFormat)
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &report.Header); err != nil {
+		return report, fmt.Errorf("import header: %w", err)
+	}
+	if report.Header.Format != ExportFormat || report.Header.Version != ExportVersion {
+		return report, fmt.Errorf("import: not a %s v%d file (format %q, version %d)",
+			ExportFormat, ExportVersion, report.Header.Format, report.Header.Version)
+	}
+
+	var records []ExportRecord
+	for line := 2; scanner.Scan(); line++ {
+		var rec ExportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return report, fmt.Errorf("import line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return report, fmt.Errorf("import: %w", err)
+	}
+	report.Records = len(records)
+
+	// sort every record into insert / unchanged / conflict before writing
+	var writes []ExportRecord
+	for _, rec := range records {
+		current, err := s.get(rec.Key)
+		switch {
+		case err != nil:
+			report.Inserted++
+			writes = append(writes, rec)
+		case current == rec.Data():
+			report.Unchanged++
+		case policy == ConflictOverwrite:
+			report.Overwritten++
+			writes = append(writes, rec)
+		case policy == ConflictSkip:
+			report.Skipped++
+		default:
+			report.Conflicts = append(report.Conflicts, rec.Key)
+		}
+	}
+	if len(report.Conflicts) > 0 {
+		return report, fmt.Errorf("%w: %d keys, first %q", ErrImportConflict, len(report.Conflicts), report.Conflicts[0])
+	}
+
+	for _, rec := range writes {
+		var err error
+		if s.fitsInPage(rec.Key, rec.Data()) {
+			err = s.putValue(rec.Key, rec.Data(), nil)
+		} else {
+			// a streamed value, exported whole
+			err = s.putStreamed(rec.Key, rec.Data(), s.resolveWriteOptions(nil))
+		}
+		if err != nil {
+			return report, fmt.Errorf("import %q: %w", rec.Key, err)
+		}
+	}
+	return report, nil
+}
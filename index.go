@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+)
+
+// the key → page index is either the pageIndex map (the default) or the B+
+// tree in btree.go (Options.BTreeIndex). everything goes through these, so
+// the rest of the storage doesn't care which one it is. all of them expect
+// s.mu to be held.
+
+// where key is stored
+func (s *Storage) lookup(key string) (uint32, bool, error) {
+	if s.btree != nil {
+		return s.btree.get(key)
+	}
+	pageID, exists := s.pageIndex[key]
+	return pageID, exists, nil
+}
+
+func (s *Storage) indexPut(key string, pageID uint32) error {
+	if s.btree != nil {
+		return s.btree.put(key, pageID)
+	}
+	s.pageIndex[key] = pageID
+	return nil
+}
+
+func (s *Storage) indexDelete(key string) error {
+	if s.btree != nil {
+		_, err := s.btree.delete(key)
+		return err
+	}
+	delete(s.pageIndex, key)
+	return nil
+}
+
+// how many keys the storage holds
+func (s *Storage) indexLen() int {
+	if s.btree != nil {
+		return s.btree.len()
+	}
+	return len(s.pageIndex)
+}
+
+// calls fn for every key with start <= key < end in key order (end "" = no
+// bound) until it returns false. fn must not use the index.
+func (s *Storage) indexRange(start, end string, fn func(key string, pageID uint32) bool) error {
+	if s.btree != nil {
+		return s.btree.ascend(start, end, fn)
+	}
+	keys := make([]string, 0, len(s.pageIndex))
+	for key := range s.pageIndex {
+		if s.keyInRange(key, start, end) {
+			keys = append(keys, key)
+		}
+	}
+	s.sortKeys(keys)
+	for _, key := range keys {
+		if !fn(key, s.pageIndex[key]) {
+			break
+		}
+	}
+	return nil
+}
+
+// start <= key < end in the collation's order (end "" = no bound)
+func (s *Storage) keyInRange(key, start, end string) bool {
+	cmp := s.collation.Compare
+	return cmp(key, start) >= 0 && (end == "" || cmp(key, end) < 0)
+}
+
+// the greatest key below key, for placing a record next to its neighbour
+func (s *Storage) indexFloor(key string) (string, uint32, bool, error) {
+	if s.btree != nil {
+		return s.btree.floor(key)
+	}
+	var floor string
+	found := false
+	cmp := s.collation.Compare
+	for k := range s.pageIndex {
+		if cmp(k, key) < 0 && (!found || cmp(k, floor) > 0) {
+			floor, found = k, true
+		}
+	}
+	return floor, s.pageIndex[floor], found, nil
+}
+
+// the greatest key of all
+func (s *Storage) indexLast() (string, uint32, bool, error) {
+	if s.btree != nil {
+		return s.btree.last()
+	}
+	var last string
+	found := false
+	for k := range s.pageIndex {
+		if !found || s.collation.Compare(k, last) > 0 {
+			last, found = k, true
+		}
+	}
+	return last, s.pageIndex[last], found, nil
+}
+
+// empties the index before it is filled again from the pages
+func (s *Storage) resetIndex() error {
+	if s.btree != nil {
+		return s.btree.reset()
+	}
+	s.pageIndex = make(map[string]uint32)
+	return nil
+}
+
+// the data file state the B+ tree has to match to be used as is
+func (s *Storage) btreeMatch() btreeMatch {
+	return btreeMatch{lsn: s.lsn, totalPages: s.totalPages, nextPageID: s.nextPageID, collation: collationTag(s.collation)}
+}
+
+// opens <file>.bpt and uses it when it matches the header, rebuilds it from
+// the pages otherwise
+func (s *Storage) loadBTree(ctx context.Context) error {
+	if s.btree.matches(s.btreeMatch()) {
+		s.freePages = append([]uint32(nil), s.btree.free...)
+		return nil
+	}
+	if err := s.btree.reset(); err != nil {
+		return err
+	}
+	return s.buildIndex(ctx)
+}
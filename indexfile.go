@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// persistent index: buildIndex reads every page on open, which takes a while
+// on a big file. with Options.IndexFile, Close writes the key → page index and
+// the free list to <file>.idx, and the next open loads that instead:
+//
+//	[magic "GDIX" u32][version u32][LastLSN u64][TotalPages u32][NextPageID u32]
+//	[keys u32] [keyLen u16][pageID u32][key] ...
+//	[free u32] [pageID u32] ...
+//	[crc32 of everything before it u32]
+//
+// the index only describes the file as it was at that Close. it is used when
+// the header still has the same LastLSN, TotalPages and NextPageID, any write
+// since then (or a crash before the index was written) changes the LSN, and
+// the open falls back to the scan. Compact moves records without a new LSN,
+// so it removes the index file before it swaps the data file. tools that
+// rewrite pages behind the storage's back have to delete it too.
+
+const (
+	indexSuffix  = ".idx"
+	indexMagic   = 0x58494447 // "GDIX"
+	indexVersion = 1
+)
+
+// writes the index file, called by Close
+func (s *Storage) saveIndex() error {
+	if !s.opts.IndexFile || s.btree != nil {
+		return nil
+	}
+	path := s.file.Name() + indexSuffix
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return fmt.Errorf("save index: %w", err)
+	}
+	defer f.Close()
+
+	crc := crc32.NewIEEE()
+	w := bufio.NewWriter(io.MultiWriter(f, crc))
+	var buf [8]byte
+	u16 := func(v uint16) { binary.LittleEndian.PutUint16(buf[:2], v); w.Write(buf[:2]) }
+	u32 := func(v uint32) { binary.LittleEndian.PutUint32(buf[:4], v); w.Write(buf[:4]) }
+
+	u32(indexMagic)
+	u32(indexVersion)
+	binary.LittleEndian.PutUint64(buf[:], s.lsn)
+	w.Write(buf[:])
+	u32(s.totalPages)
+	u32(s.nextPageID)
+
+	// sorted, so the same index gives the same file
+	keys := make([]string, 0, len(s.pageIndex))
+	for key := range s.pageIndex {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	u32(uint32(len(keys)))
+	for _, key := range keys {
+		u16(uint16(len(key)))
+		u32(s.pageIndex[key])
+		w.WriteString(key)
+	}
+	u32(uint32(len(s.freePages)))
+	for _, id := range s.freePages {
+		u32(id)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("save index: %w", err)
+	}
+	binary.LittleEndian.PutUint32(buf[:4], crc.Sum32())
+	if _, err := f.Write(buf[:4]); err != nil {
+		return fmt.Errorf("save index: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("save index: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("save index: %w", err)
+	}
+	return nil
+}
+
+// removes the index file, for changes that move records without a new LSN
+func (s *Storage) dropIndex() error {
+	err := os.Remove(s.file.Name() + indexSuffix)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// fills the index from the index file when it matches the header, from a
+// scan of every page otherwise
+func (s *Storage) loadIndex(ctx context.Context) error {
+	if s.btree != nil {
+		return s.loadBTree(ctx)
+	}
+	if s.opts.IndexFile {
+		index, free, err := s.readIndexFile()
+		if err == nil {
+			s.pageIndex, s.freePages = index, free
+			return nil
+		}
+		// whatever was wrong with it, the scan below gives the right answer
+		if !errors.Is(err, os.ErrNotExist) {
+			s.openInfo.IndexRebuilt = true
+			s.openWarning("index file not used (%v), the index was rebuilt from the pages", err)
+		}
+	}
+	return s.buildIndex(ctx)
+}
+
+func (s *Storage) readIndexFile() (map[string]uint32, []uint32, error) {
+	data, err := os.ReadFile(s.file.Name() + indexSuffix)
+	if err != nil {
+		return nil, nil, err
+	}
+	stale := errors.New("stale or damaged index file")
+	if len(data) < 36 {
+		return nil, nil, stale
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum ||
+		binary.LittleEndian.Uint32(body[0:4]) != indexMagic ||
+		binary.LittleEndian.Uint32(body[4:8]) != indexVersion ||
+		binary.LittleEndian.Uint64(body[8:16]) != s.lsn ||
+		binary.LittleEndian.Uint32(body[16:20]) != s.totalPages ||
+		binary.LittleEndian.Uint32(body[20:24]) != s.nextPageID {
+		return nil, nil, stale
+	}
+
+	pos := 24
+	next := func(n int) []byte {
+		if pos+n > len(body) {
+			return nil
+		}
+		pos += n
+		return body[pos-n : pos]
+	}
+	count := next(4)
+	if count == nil {
+		return nil, nil, stale
+	}
+	index := make(map[string]uint32, binary.LittleEndian.Uint32(count))
+	for i := binary.LittleEndian.Uint32(count); i > 0; i-- {
+		head := next(6)
+		if head == nil {
+			return nil, nil, stale
+		}
+		pageID := binary.LittleEndian.Uint32(head[2:6])
+		key := next(int(binary.LittleEndian.Uint16(head[0:2])))
+		if key == nil || pageID >= s.totalPages {
+			return nil, nil, stale
+		}
+		index[string(key)] = pageID
+	}
+	count = next(4)
+	if count == nil {
+		return nil, nil, stale
+	}
+	var free []uint32
+	for i := binary.LittleEndian.Uint32(count); i > 0; i-- {
+		id := next(4)
+		if id == nil {
+			return nil, nil, stale
+		}
+		free = append(free, binary.LittleEndian.Uint32(id))
+	}
+	if pos != len(body) {
+		return nil, nil, stale
+	}
+	return index, free, nil
+}
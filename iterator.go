@@ -0,0 +1,110 @@
+package main
+
+import "strings"
+
+// ordered scans. a scan takes the matching keys out of the index in order
+// when it starts (sorting them first with the map index, the B+ tree has them
+// sorted already), the values are read one by one as the cursor gets to them:
+//
+//	it := db.Scan("user:", "user;")   // every key starting with "user:"
+//	for it.Next() {
+//		fmt.Println(it.Key(), it.Value())
+//	}
+//	if err := it.Err(); err != nil { ... }
+//
+// the key list is fixed when the scan starts: a key added later isn't seen,
+// a key deleted before the cursor reaches it is skipped, and an updated one
+// shows the value it has when it is reached. writes from other goroutines can
+// go ahead between two Next calls.
+//
+// the storage keeps records of its own in the same keyspace: leases,
+// migration positions, the chunks of streamed values. the WAL, recovery and
+// compaction treat them like any other record, but scans, EstimateCount and
+// exports leave them out, a caller only sees the keys it wrote.
+var reservedPrefixes = []string{LeaseKeyPrefix, MigrationKeyPrefix, StreamChunkPrefix}
+
+func isReservedKey(key string) bool {
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Iterator is a cursor over keys in sorted order, see Scan.
+type Iterator struct {
+	s          *Storage
+	keys       []string
+	pos        int
+	key, value string
+	err        error
+	filter     *Filter   // only matching records, nil for all (see filter.go)
+	snap       *Snapshot // values as of a snapshot, nil for current ones (see mvcc.go)
+}
+
+// Scan returns a cursor over the keys k with start <= k < end in sorted
+// order. an empty end means no upper bound.
+func (s *Storage) Scan(start, end string) *Iterator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys []string
+	err := s.indexRange(start, end, func(key string, _ uint32) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return &Iterator{s: s, keys: keys, err: err}
+}
+
+// Iterator returns a cursor over every key in sorted order.
+func (s *Storage) Iterator() *Iterator {
+	return s.Scan("", "")
+}
+
+// Next moves to the next key, it returns false at the end or on an error.
+func (it *Iterator) Next() bool {
+	it.s.mu.RLock()
+	defer it.s.mu.RUnlock()
+	for it.err == nil && it.pos < len(it.keys) {
+		key := it.keys[it.pos]
+		it.pos++
+		if isReservedKey(key) {
+			continue
+		}
+		value, exists, err := it.read(key)
+		if err != nil {
+			it.err = err
+			break
+		}
+		if !exists {
+			continue // deleted since the scan started
+		}
+		if it.filter != nil && !it.filter.Match(key, value) {
+			continue
+		}
+		it.key, it.value = key, value
+		return true
+	}
+	it.key, it.value = "", ""
+	return false
+}
+
+func (it *Iterator) read(key string) (value string, exists bool, err error) {
+	if it.snap != nil {
+		return it.snap.read(key)
+	}
+	if _, exists, err = it.s.lookup(key); err != nil || !exists {
+		return "", false, err
+	}
+	value, err = it.s.get(key)
+	return value, err == nil, err
+}
+
+// Key returns the current key.
+func (it *Iterator) Key() string { return it.key }
+
+// Value returns the current value, decoded like Get returns it.
+func (it *Iterator) Value() string { return it.value }
+
+// Err returns the error that stopped the scan, nil when it ran to the end.
+func (it *Iterator) Err() error { return it.err }
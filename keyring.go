@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// per-bucket encryption: a Keyring is a ValueTransformer that encrypts every
+// value with AES-256-GCM under a key of its own for each bucket (tenant,
+// namespace), so a tenant can be deleted by destroying its key
+// (crypto-shredding) instead of finding and overwriting every copy of its
+// values, in pages, the WAL, backups and replicas:
+//
+//	ring, err := OpenKeyring("app.db.keys", master, KeyPrefixBucket(":"))
+//	opts.Transformers = []ValueTransformer{ring}
+//	db, err := NewStorageWithOptions("app.db", opts)
+//
+//	db.Put("tenant7:order:9", "...")  // encrypted with tenant7's key
+//	ring.Shred("tenant7")             // every tenant7 value is unreadable now
+//
+// a bucket's data key is made the first time one of its values is written:
+// 32 random bytes, kept in the keyring file wrapped (AES-GCM) by the master
+// key, which is never stored. a value starts with the ID of the key it was
+// encrypted with and its nonce:
+//
+//	[key id u32][nonce 12][ciphertext + tag 16]
+//
+// Shred removes the bucket's key from the file (written and synced before
+// it returns), the values it encrypted fail to decode with ErrKeyShredded
+// from then on. a later write to the bucket gets a new key, and the old
+// values stay unreadable. the record keys aren't encrypted, and a Get of a
+// shredded value fails instead of reporting it missing: delete the
+// tenant's keys after shredding (Delete doesn't read the value). the
+// value cache holds decoded values until they're evicted or overwritten, an
+// old copy of the keyring file (a backup) can still unwrap a shredded key,
+// both need taking care of for the shredding to mean anything.
+
+const keyringNonceSize = 12
+
+// Keyring encrypts values with a key per bucket, see keyring.go. it is safe
+// for concurrent use.
+type Keyring struct {
+	path   string
+	master cipher.AEAD
+	bucket func(key string) string
+
+	mu      sync.RWMutex
+	file    keyringFile
+	ciphers map[uint32]cipher.AEAD // unwrapped, by key ID
+	current map[string]uint32      // the key ID new values of a bucket get
+}
+
+// the keyring file, JSON
+type keyringFile struct {
+	NextID uint32       `json:"next_id"`
+	Keys   []wrappedKey `json:"keys"`
+}
+
+type wrappedKey struct {
+	ID      uint32 `json:"id"`
+	Bucket  string `json:"bucket"`
+	Wrapped []byte `json:"wrapped"` // nonce + the data key sealed by the master key
+}
+
+// OpenKeyring opens (or creates) the keyring file at path. master is the
+// 32 byte key the data keys are wrapped with, bucket says which bucket a
+// key belongs to (KeyPrefixBucket, for one).
+func OpenKeyring(path string, master []byte, bucket func(key string) string) (*Keyring, error) {
+	if len(master) != 32 {
+		return nil, fmt.Errorf("keyring: master key is %d bytes, want 32", len(master))
+	}
+	aead, err := newGCM(master)
+	if err != nil {
+		return nil, fmt.Errorf("keyring: %w", err)
+	}
+	k := &Keyring{
+		path:    path,
+		master:  aead,
+		bucket:  bucket,
+		file:    keyringFile{NextID: 1},
+		ciphers: make(map[uint32]cipher.AEAD),
+		current: make(map[string]uint32),
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("keyring: %w", err)
+	}
+	if err := json.Unmarshal(data, &k.file); err != nil {
+		return nil, fmt.Errorf("keyring %s: %w", path, err)
+	}
+	for _, w := range k.file.Keys {
+		if err := k.unwrap(w); err != nil {
+			return nil, fmt.Errorf("keyring %s: key %d of %q: %w", path, w.ID, w.Bucket, err)
+		}
+		k.current[w.Bucket] = w.ID
+	}
+	return k, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// opens a wrapped data key with the master key, a wrong master fails here
+func (k *Keyring) unwrap(w wrappedKey) error {
+	if len(w.Wrapped) < keyringNonceSize {
+		return errors.New("wrapped key too short")
+	}
+	nonce, sealed := w.Wrapped[:keyringNonceSize], w.Wrapped[keyringNonceSize:]
+	dataKey, err := k.master.Open(nil, nonce, sealed, []byte(w.Bucket))
+	if err != nil {
+		return fmt.Errorf("can't unwrap, wrong master key? %w", err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+	k.ciphers[w.ID] = aead
+	return nil
+}
+
+// the key new values of bucket are encrypted with, made and saved the first
+// time. called with k.mu held for writing.
+func (k *Keyring) keyFor(bucket string) (uint32, cipher.AEAD, error) {
+	if id, ok := k.current[bucket]; ok {
+		return id, k.ciphers[id], nil
+	}
+	dataKey := make([]byte, 32)
+	nonce := make([]byte, keyringNonceSize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return 0, nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, nil, err
+	}
+	w := wrappedKey{
+		ID:      k.file.NextID,
+		Bucket:  bucket,
+		Wrapped: k.master.Seal(nonce, nonce, dataKey, []byte(bucket)),
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return 0, nil, err
+	}
+	// on disk before a value needs it
+	next := k.file
+	next.NextID++
+	next.Keys = append(append([]wrappedKey(nil), k.file.Keys...), w)
+	if err := k.save(next); err != nil {
+		return 0, nil, err
+	}
+	k.file = next
+	k.ciphers[w.ID] = aead
+	k.current[bucket] = w.ID
+	return w.ID, aead, nil
+}
+
+// writes the keyring file whole and syncs it, the old one stays until the rename
+func (k *Keyring) save(file keyringFile) error {
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	tmp := k.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("save keyring: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("save keyring: %w", err)
+	}
+	if err := syncFile(f); err != nil {
+		f.Close()
+		return fmt.Errorf("save keyring: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("save keyring: %w", err)
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		return fmt.Errorf("save keyring: %w", err)
+	}
+	return nil
+}
+
+// Encode encrypts value with the key of key's bucket.
+func (k *Keyring) Encode(key string, value []byte) ([]byte, error) {
+	bucket := k.bucket(key)
+	k.mu.RLock()
+	id, ok := k.current[bucket]
+	aead := k.ciphers[id]
+	k.mu.RUnlock()
+	if !ok {
+		var err error
+		k.mu.Lock()
+		id, aead, err = k.keyFor(bucket)
+		k.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	out := make([]byte, 4+keyringNonceSize, 4+keyringNonceSize+len(value)+aead.Overhead())
+	binary.LittleEndian.PutUint32(out[0:4], id)
+	if _, err := rand.Read(out[4:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, out[4:], value, nil), nil
+}
+
+// Decode decrypts a value Encode stored, with the key it names.
+func (k *Keyring) Decode(key string, stored []byte) ([]byte, error) {
+	if len(stored) < 4+keyringNonceSize {
+		return nil, fmt.Errorf("%w: encrypted value is %d bytes", ErrCorrupted, len(stored))
+	}
+	id := binary.LittleEndian.Uint32(stored[0:4])
+	k.mu.RLock()
+	aead, ok := k.ciphers[id]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: key %d", ErrKeyShredded, id)
+	}
+	value, err := aead.Open(nil, stored[4:4+keyringNonceSize], stored[4+keyringNonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+	return value, nil
+}
+
+// Shred destroys the keys of bucket, its values can't be decrypted anymore.
+// the keyring file is synced before it returns. a bucket without a key has
+// nothing to shred.
+func (k *Keyring) Shred(bucket string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	next := k.file
+	next.Keys = nil
+	var gone []uint32
+	for _, w := range k.file.Keys {
+		if w.Bucket == bucket {
+			gone = append(gone, w.ID)
+			continue
+		}
+		next.Keys = append(next.Keys, w)
+	}
+	if len(gone) == 0 {
+		return nil
+	}
+	if err := k.save(next); err != nil {
+		return err
+	}
+	k.file = next
+	for _, id := range gone {
+		delete(k.ciphers, id)
+	}
+	delete(k.current, bucket)
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// leases with fencing tokens, for leader election and locks. PutWithLease
+// takes a lease on a key for ttl and returns a token, a number that goes up
+// by one every time someone new takes the lease. the holder then writes with
+// PutFenced, which only goes through with the key's latest token:
+//
+//	A: PutWithLease("leader", "A", 10s) → token 7
+//	A: pauses for 30s (GC, network...), the lease runs out
+//	B: PutWithLease("leader", "B", 10s) → token 8
+//	A: PutFenced("leader", "A", 7) → ErrStaleToken, A's late write can't clobber B's
+//
+// the lease lives next to the key, under LeaseKeyPrefix, as [token 8][expiry
+// unix nanos 8]. it stays after it expires, that's what keeps the next token
+// above the last one, across restarts too. taking a lease writes the value
+// and the lease record in one WAL transaction (see rename.go).
+//
+// plain Put and Delete don't look at leases, a key used this way should
+// only be written through the lease calls.
+
+// LeaseKeyPrefix is where lease records are kept, "__lease__:leader" is the
+// lease of "leader". scans, counts and exports leave them out (see iterator.go).
+const LeaseKeyPrefix = "__lease__:"
+
+const leaseRecordSize = 16
+
+// PutWithLease takes the lease on key for ttl, when no one holds it or the
+// last holder's ran out, writes value and returns the new fencing token.
+func (s *Storage) PutWithLease(key, value string, ttl time.Duration, opts ...WriteOption) (uint64, error) {
+	s.lockForWrite()
+	token, err := s.putWithLease(key, value, ttl, opts)
+	lsn := s.lsn
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return token, s.awaitCommit(lsn, opts)
+}
+
+func (s *Storage) putWithLease(key, value string, ttl time.Duration, opts []WriteOption) (uint64, error) {
+	if err := s.checkWritable(); err != nil {
+		return 0, err
+	}
+	if err := s.applyBackpressure(); err != nil {
+		return 0, err
+	}
+	last, expires, err := s.readLease(key)
+	if err != nil {
+		return 0, err
+	}
+	now := s.clock().Now()
+	if now.Before(expires) {
+		return 0, fmt.Errorf("lease on %q until %s: %w", key, expires.Format(time.RFC3339), ErrLeaseHeld)
+	}
+
+	token := last + 1
+	leaseKey, lease, err := s.encodeLease(key, token, now.Add(ttl))
+	if err != nil {
+		return 0, err
+	}
+	if err := s.validateValue(key, value); err != nil {
+		return 0, err
+	}
+	stored, err := s.encodeValue(key, value)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.checkRecordSize(key, stored); err != nil {
+		return 0, err
+	}
+	ops := []txOp{
+		{typ: LogTypePut, key: key, value: stored},
+		{typ: LogTypePut, key: leaseKey, value: lease},
+	}
+	if err := s.applyTx(ops, s.resolveWriteOptions(opts)); err != nil {
+		return 0, err
+	}
+	s.stats.puts.Add(1)
+	return token, nil
+}
+
+// PutFenced writes value only when token is the latest one handed out for
+// key, expired or not, and fails with ErrStaleToken otherwise.
+func (s *Storage) PutFenced(key, value string, token uint64, opts ...WriteOption) error {
+	s.lockForWrite()
+	err := s.checkToken(key, token)
+	if err == nil {
+		err = s.putValue(key, value, opts)
+	}
+	lsn := s.lsn
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.awaitCommit(lsn, opts)
+}
+
+// RenewLease extends the lease of the holder of token to ttl from now.
+func (s *Storage) RenewLease(key string, token uint64, ttl time.Duration, opts ...WriteOption) error {
+	s.lockForWrite()
+	err := s.checkToken(key, token)
+	if err == nil {
+		err = s.renewLease(key, token, ttl, opts)
+	}
+	lsn := s.lsn
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.awaitCommit(lsn, opts)
+}
+
+func (s *Storage) renewLease(key string, token uint64, ttl time.Duration, opts []WriteOption) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	leaseKey, lease, err := s.encodeLease(key, token, s.clock().Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+	return s.put(leaseKey, lease, s.resolveWriteOptions(opts), 0)
+}
+
+// Lease returns the latest token handed out for key and when its lease runs
+// out, token 0 when there never was one.
+func (s *Storage) Lease(key string) (token uint64, expires time.Time, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.readLease(key)
+}
+
+func (s *Storage) checkToken(key string, token uint64) error {
+	last, _, err := s.readLease(key)
+	if err != nil {
+		return err
+	}
+	if last == 0 || token != last {
+		return fmt.Errorf("token %d for %q, latest is %d: %w", token, key, last, ErrStaleToken)
+	}
+	return nil
+}
+
+func (s *Storage) readLease(key string) (uint64, time.Time, error) {
+	leaseKey := LeaseKeyPrefix + key
+	if _, exists, err := s.lookup(leaseKey); err != nil || !exists {
+		return 0, time.Time{}, err
+	}
+	lease, err := s.get(leaseKey)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if len(lease) != leaseRecordSize {
+		return 0, time.Time{}, fmt.Errorf("lease of %q is %d bytes, want %d", key, len(lease), leaseRecordSize)
+	}
+	token := binary.LittleEndian.Uint64([]byte(lease[0:8]))
+	expires := time.Unix(0, int64(binary.LittleEndian.Uint64([]byte(lease[8:16]))))
+	return token, expires, nil
+}
+
+// the lease record of key, encoded for storing
+func (s *Storage) encodeLease(key string, token uint64, expires time.Time) (string, string, error) {
+	var lease [leaseRecordSize]byte
+	binary.LittleEndian.PutUint64(lease[0:8], token)
+	binary.LittleEndian.PutUint64(lease[8:16], uint64(expires.UnixNano()))
+	leaseKey := LeaseKeyPrefix + key
+	stored, err := s.encodeValue(leaseKey, string(lease[:]))
+	return leaseKey, stored, err
+}
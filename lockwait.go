@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// how often waitLock retries, doubling from the first up to the last
+const (
+	lockRetryMin = 5 * time.Millisecond
+	lockRetryMax = 250 * time.Millisecond
+)
+
+// keeps trying the (non-blocking) file lock until it's free, ctx is done or
+// limit has passed (0 = no limit besides ctx). the platform locks can't be
+// interrupted once they block, so polling is what makes the wait cancellable.
+func waitLock(ctx context.Context, f *os.File, limit time.Duration) error {
+	if limit > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limit)
+		defer cancel()
+	}
+
+	retry := lockRetryMin
+	for {
+		err := lockFile(f, true)
+		if !errors.Is(err, ErrLocked) {
+			return err // locked it, or failed for another reason
+		}
+
+		timer := time.NewTimer(retry)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("waiting for %s: %w (%w)", f.Name(), ErrLocked, ctx.Err())
+		case <-timer.C:
+		}
+		retry = min(2*retry, lockRetryMax)
+	}
+}
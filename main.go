@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"         // lets the caller bound how long opening may take
 	"encoding/binary" // convert numbers into bytes
 	"errors"          // creating error message
 	"fmt"             // for printing and formatting any strings
+	"io"              // for databases read from something other than a file
 	"os"              // for file opterations like create,open,read,write
+	"sync"            // for locks shared with other goroutines
+	"sync/atomic"     // for state a health check reads without the lock
+	"time"            // for scheduling auto-compaction checks
 )
 
 // database rules
@@ -22,16 +27,131 @@ type Page struct {
 	Data        [PageSize]byte // the 4KD of storage for the key-value pairs
 	IsDirty     bool           // check for if the page has been changed since it was loaded from the disk. if yes, db saves it.
 	RecordCount uint16         // count of how many key-value pairs are stored in the page.
+	// the last bytes hold the page checksum, records have to stop before them (see checksum.go)
+	checksummed bool
+	// how that checksum is computed
+	checksumAlg ChecksumAlgorithm
+	// every value starts with the LSN of its write (see mvcc.go)
+	versioned bool
+	// records are found through a slot directory at the end (see slotted.go)
+	slotted bool
+	// the bytes before the checksum hold the page LSN (see recovery.go)
+	hasLSN bool
+	// the LSN the page was last written at, every change up to it is on disk
+	lsn uint64
+	// read from the cold tier, the file has a stub in its place (see tiering.go)
+	cold bool
 }
 
 // The database storage manager - keeps track of where every page is stored
 type Storage struct {
-	file       *os.File          // actual database file on the disk
-	pageSize   int               // how big each page is (will be 4096 bytes)
-	pageIndex  map[string]uint32 // key to page ID mapping: map that gives us "key'user:1' is stored in page 1"
-	pages      map[uint32]*Page  // the loaded pages cache: is the pages we've loaded into memory
-	nextPageID uint32            // which ID to give the next new page
-	totalPages uint32            // how many pages exist in total
+	file       *os.File           // actual database file on the disk
+	pageSize   int                // how big each page is (will be 4096 bytes)
+	pageIndex  map[string]uint32  // key to page ID mapping: map that gives us "key'user:1' is stored in page 1"
+	pages      map[uint32]*Page   // the loaded pages cache: is the pages we've loaded into memory
+	nextPageID uint32             // which ID to give the next new page
+	totalPages uint32             // how many pages exist in total
+	opts       Options            // settings the storage was opened with (sync policy, etc.)
+	optsMu     sync.RWMutex       // guards opts, SetOption can change them from another goroutine
+	stats      Stats              // read/write counters (see stats.go)
+	pipeline   []ValueTransformer // value encode/decode stages built from opts (see transform.go)
+	freePages  []uint32           // empty pages that can be reused before growing the file (see gc.go)
+	// how many pages worth of disk space have been reserved with preallocate
+	preallocatedPages uint32
+	values            *valueCache // decoded values of hot keys, nil when Options.ValueCacheSize is 0
+	// guards pages and loading, so Gets can run at the same time
+	cacheMu sync.Mutex
+	// pages being read from disk right now (see loadPage)
+	loading map[uint32]*pageLoad
+	// log sequence number of the last write, every Put/Delete bumps it and
+	// the header stores it, so it only ever grows over the life of the file
+	lsn uint64
+	// last LSN from a primary's WAL applied here (see replicate.go), stored in
+	// the header so it survives restarts
+	appliedLSN uint64
+	// every Put/Delete is logged here before it touches a page, nil when read-only (see recovery.go)
+	wal *WAL
+	// auto-compaction state (see autocompact.go): a rewrite is running, when
+	// Sync may check the thresholds again, deletes since the last rewrite
+	compacting          bool
+	nextCompactCheck    time.Time
+	deletesSinceCompact uint64
+	// one writer or many readers: Put, Delete, Sync and the other calls that
+	// change the file hold it exclusively, Get, Scan, Export and Verify share
+	// it. the page cache underneath has its own lock (cacheMu), so readers
+	// can load pages at the same time. exported methods take it, the
+	// lowercase ones they call expect it to be held.
+	mu sync.RWMutex
+	// cache warm-up (see warm.go): when each page was last used, guarded by
+	// cacheMu, and the background load of last run's warm set
+	pageUse  map[uint32]uint64
+	useTick  uint64
+	warmDone <-chan struct{}
+	// the key index on disk, used instead of pageIndex when
+	// Options.BTreeIndex is set (see btree.go and index.go)
+	btree *btree
+	// page layout, from the header (see engine.go)
+	engine Engine
+	// the page the append engine writes to, unknown after open (see timeseries.go)
+	tailPage uint32
+	hasTail  bool
+	// pages carry a CRC32 in their last bytes, from the header flags (see checksum.go)
+	checksums bool
+	// how pages and WAL entries are checksummed, from the header flags (see checksum.go)
+	checksumAlg ChecksumAlgorithm
+	// records carry the LSN of their write, from the header flags (see mvcc.go)
+	versioned bool
+	// pages are slotted, from the header flags (see slotted.go)
+	slotted bool
+	// pages carry the LSN they were written at, from the header flags (see recovery.go)
+	pageLSNs bool
+	// when Sync may enforce the prefix retention policies again (see retention.go)
+	nextRetentionCheck time.Time
+	// the page cache budget, nil without Options.CacheSize or
+	// Options.BufferPool (see pagecache.go)
+	pool *BufferPool
+	// the background checkpointer, nil channels when it isn't running (see checkpoint.go)
+	checkpointWake chan struct{}
+	checkpointStop chan struct{}
+	checkpointDone chan struct{}
+	// set while a checkpoint's Sync runs, it logs the checkpoint-end marker
+	// for the LSN the checkpoint covers
+	checkpointing bool
+	checkpointLSN uint64
+	// unix nanos of when the disk ran full, 0 while writes are fine, and when
+	// a write last tried whether there is room again (see diskfull.go)
+	diskFullSince atomic.Int64
+	diskFullProbe time.Time
+	// the chunk generations PutReader is writing right now, GC leaves them
+	// alone (see stream.go)
+	streaming map[uint64]bool
+	// cold tiering (see tiering.go): when each page was last used, guarded
+	// by cacheMu and nil without Options.ColdTier, the time pages nobody
+	// used since count from, and the pages known to be in the tier
+	pageAccess map[uint32]time.Time
+	tierStart  time.Time
+	coldMu     sync.Mutex
+	coldPages  map[uint32]bool
+	// the background snapshots, nil channels when they aren't running (see
+	// snapshotstore.go)
+	snapshotStop chan struct{}
+	snapshotDone chan struct{}
+	// the order of keys, Options.Collation or byte order (see collation.go)
+	collation Collation
+	// snapshots (see mvcc.go): how many are open at each LSN, and the
+	// versions writes replaced while any is open, oldest first
+	snapshotPins map[uint64]int
+	history      map[string][]keyVersion
+	// the double-write buffer, nil without Options.DoubleWrite (see doublewrite.go)
+	dwb *os.File
+	// reads written pages back past the OS cache, with Options.VerifyWrites
+	// and DirectIO, opened on the first write (see writeverify.go)
+	verifier *directReader
+	// where the pages are read from when the storage was opened from a
+	// reader, file is nil then (see reader.go)
+	source io.ReaderAt
+	// what opening took and found (see openinfo.go)
+	openInfo OpenInfo
 }
 
 // when opening a db file, we need to know how its organized, its a header tag that acts like a table of contents
@@ -41,15 +161,55 @@ type Header struct {
 	PageSize   uint32 // the size of the pages (4096 bytes)
 	TotalPages uint32 // how many pages are in the database
 	NextPageID uint32 // What ID the next new page will be
+	LastLSN    uint64 // sequence number of the last write (0 in files from before it existed)
+	AppliedLSN uint64 // last replicated WAL entry applied, 0 when the file isn't a replica
+	Engine     uint32 // page layout the file was created with, an EngineID (0, the heap, in older files)
+	Flags      uint32 // format features, pagefmt.FlagPageChecksums (0 in older files)
 }
 
 // tries to open an existing file for reading/writing.
 // if it fails = file doesnt exist, so we create a new file.
 func NewStorage(filename string) (*Storage, error) {
-	// first try to open existing file
-	// if successful: file = our opened file
-	// if something went wrong: err contains the error.
-	file, err := os.OpenFile(filename, os.O_RDWR, 0644)
+	return NewStorageWithOptions(filename, DefaultOptions())
+}
+
+// same as NewStorage but lets the caller pick the settings (see options.go)
+// if another process has the file open it fails with ErrLocked right away,
+// or keeps trying for up to opts.LockWait
+func NewStorageWithOptions(filename string, opts Options) (*Storage, error) {
+	return openStorage(context.Background(), filename, opts, opts.LockWait > 0)
+}
+
+// NewStorageContext opens like NewStorageWithOptions, but waits for the file
+// lock and rebuilds the index only until ctx is done, so a service can put a
+// deadline on its startup instead of hanging while another process holds
+// the database. opts.LockWait, if set, limits the lock wait on top of ctx.
+//
+//	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+//	defer cancel()
+//	db, err := NewStorageContext(ctx, "app.db", DefaultOptions())
+//	// errors.Is(err, ErrLocked) && errors.Is(err, context.DeadlineExceeded) → still locked after 30s
+func NewStorageContext(ctx context.Context, filename string, opts Options) (*Storage, error) {
+	return openStorage(ctx, filename, opts, true)
+}
+
+// waitForLock=false tries the lock once, like opening always did before
+func openStorage(ctx context.Context, filename string, opts Options, waitForLock bool) (*Storage, error) {
+	start := clockOf(opts).Now()
+	var file *os.File
+	var err error
+	if opts.ReadOnly {
+		// a reader never creates the file, and never writes to it
+		file, err = os.Open(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open db file read-only: %w", err)
+		}
+	} else {
+		// first try to open existing file
+		// if successful: file = our opened file
+		// if something went wrong: err contains the error.
+		file, err = os.OpenFile(filename, os.O_RDWR, 0644)
+	}
 
 	// if there is an error in opening the file, the file doesnt exist, so create it
 	if err != nil {
@@ -60,37 +220,90 @@ func NewStorage(filename string) (*Storage, error) {
 		}
 	}
 
-	// creates the Storage struct and initialize the pageIndex and pages mappings,
-	// which both start as empty. sets the file we opened/created to the storage.
-	storage := &Storage{
-		file:      file,
-		pageSize:  PageSize,
-		pageIndex: make(map[string]uint32),
-		pages:     make(map[uint32]*Page),
+	// only one process may have the database open, a second one writing
+	// pages behind our back would corrupt the file (see platform_*.go)
+	lock := func() error { return lockFile(file, true) }
+	if waitForLock {
+		lock = func() error { return waitLock(ctx, file, opts.LockWait) }
+	}
+	if opts.ReadOnly {
+		// the lock is the writer's, a reader sits next to it (see readonly.go)
+		lock = func() error { return nil }
+	}
+	if err := lock(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// sets the file we opened/created to the storage.
+	storage := newStorage(opts)
+	storage.file = file
+	// a reader can't use the tree, the writer changes it in place
+	if opts.BTreeIndex && !opts.ReadOnly {
+		if storage.btree, err = openBTree(filename+btreeSuffix, opts.BTreeCacheNodes); err != nil {
+			file.Close()
+			return nil, err
+		}
+		storage.btree.cmp = storage.collation.Compare
+	}
+	fail := func(err error) (*Storage, error) {
+		if storage.btree != nil {
+			storage.btree.close()
+		}
+		storage.closeDoubleWrite()
+		file.Close() // closing also releases the lock
+		return nil, err
 	}
 
 	// checks if the file is new (empty) or if it exists
 	stat, err := file.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("failed to stat file: %w", err)
+		return fail(fmt.Errorf("failed to stat file: %w", err))
 	}
 
 	// if the size is 0 then that it is an empty file, so we set up a new db
 	// stat.Size checks how many bytes are in the file
-	if stat.Size() == 0 {
+	// a file shorter than the header is treated the same way: the very first
+	// header write was cut off by a crash, and no page can have been written
+	// before it, so there is nothing in there to lose
+	if stat.Size() < HeaderSize && opts.ReadOnly {
+		return fail(fmt.Errorf("%s has no header yet, nothing to read", filename))
+	}
+	// a page torn by a crash is put back before anything reads it (see doublewrite.go)
+	if err := storage.openDoubleWrite(stat.Size() < HeaderSize); err != nil {
+		return fail(err)
+	}
+	if stat.Size() < HeaderSize {
+		storage.openInfo.Created = true
 		// initializes a new file, with header
 		if err := storage.initializeNewFile(); err != nil {
-			return nil, err
+			return fail(err)
+		}
+		// a tree left over from an older file of the same name means nothing
+		if err := storage.resetIndex(); err != nil {
+			return fail(err)
 		}
 	} else {
 		if err := storage.loadHeader(); err != nil {
-			return nil, err
+			return fail(err)
 		}
-		if err := storage.buildIndex(); err != nil {
-			return nil, err
+		if err := storage.loadIndex(ctx); err != nil {
+			return fail(err)
 		}
 	}
 
+	// replays whatever the last run logged but never got into the pages (see recovery.go)
+	if !opts.ReadOnly {
+		if err := storage.openWAL(stat.Size() < HeaderSize); err != nil {
+			return fail(err)
+		}
+	}
+	storage.finishOpen(start)
+	storage.startTiering()
+	storage.startWarmUp()
+	storage.startCheckpointer()
+	storage.startSnapshotter()
+
 	return storage, nil
 	// METHOD LOGIC:
 	// 1. Try to open file "test.db"
@@ -109,6 +322,26 @@ func NewStorage(filename string) (*Storage, error) {
 	//            → Build index by scanning existing data
 }
 
+// creates the Storage struct and initialize the pageIndex and pages mappings,
+// which both start as empty. the caller gives it something to read from.
+func newStorage(opts Options) *Storage {
+	storage := &Storage{
+		pageSize:  PageSize,
+		pageIndex: make(map[string]uint32),
+		pages:     make(map[uint32]*Page),
+		loading:   make(map[uint32]*pageLoad),
+		opts:      opts,
+		pipeline:  buildPipeline(opts),
+		values:    valueCacheFor(opts),
+		collation: collationOf(opts),
+	}
+	storage.pool = bufferPoolFor(opts)
+	storage.stats.clock = storage.clock()
+	storage.stats.buckets = newBucketStats(opts, storage.stats.now)
+	storage.stats.since.Store(storage.clock().Now().UnixNano())
+	return storage
+}
+
 // we a have new empty file, that we want to become a database.
 func (s *Storage) initializeNewFile() error {
 	// we create the header struct for it.
@@ -119,12 +352,25 @@ func (s *Storage) initializeNewFile() error {
 		PageSize:   uint32(s.pageSize), // 4096 bytes per page
 		TotalPages: 0,                  // 0 (no data pages exist in the db yet)
 		NextPageID: 0,                  // WHen we create the first page, it will start as page 0)
+		// the page layout and format features, fixed from here on
+		Engine: uint32(s.opts.Engine),
+		Flags:  s.newFileFlags(),
+	}
+	engine, err := engineByID(s.opts.Engine)
+	if err != nil {
+		return err
 	}
 
 	// updates the in-memory Storage object to match the header.
 	// tracks the state of the db
 	s.nextPageID = 0
 	s.totalPages = 0
+	s.engine = engine
+	s.checksums = hasPageChecksums(header.Flags)
+	s.checksumAlg = checksumAlgorithm(header.Flags)
+	s.versioned = hasRecordVersions(header.Flags)
+	s.slotted = hasSlottedPages(header.Flags)
+	s.pageLSNs = hasPageLSNs(header.Flags)
 
 	// calls another function to actually write the 64 bytes to the file.
 	return s.writeHeader(&header) //passes a pointer address to the header
@@ -168,16 +414,32 @@ func (s *Storage) writeHeader(header *Header) error {
 	binary.LittleEndian.PutUint32(headerBytes[8:12], header.PageSize)
 	binary.LittleEndian.PutUint32(headerBytes[12:16], header.TotalPages)
 	binary.LittleEndian.PutUint32(headerBytes[16:20], header.NextPageID)
+	binary.LittleEndian.PutUint64(headerBytes[20:28], header.LastLSN)
+	binary.LittleEndian.PutUint64(headerBytes[28:36], header.AppliedLSN)
+	binary.LittleEndian.PutUint32(headerBytes[36:40], header.Engine)
+	binary.LittleEndian.PutUint32(headerBytes[40:44], header.Flags)
+
+	// crash test hooks, no-ops unless a crash point is armed (see crashpoint.go)
+	crashPoint(CrashBeforeHeaderWrite, nil)
+	crashPoint(CrashMidHeaderWrite, func() {
+		// the magic, version, page size and half of TotalPages land on disk
+		s.file.WriteAt(headerBytes[:14], 0)
+	})
 
 	// writes data starting a speicif position : WriteAt(data, offset)
 	// will write all 64 bytes to the start of the file.
 	_, err := s.file.WriteAt(headerBytes, 0)
 	if err != nil {
-		return fmt.Errorf("failed to write header: %w", err)
+		return &StorageError{Op: "write header", PageID: -1, Offset: 0, Err: err}
 	}
+	s.stats.bytesWritten.Add(HeaderSize)
+	s.stats.syncs.Add(1)
 	// forces the OS to wrtie the data to the disk
 	// without doing this, the data could sit in memory and be lost with program crash
-	return s.file.Sync()
+	if err := syncFile(s.file); err != nil {
+		return &StorageError{Op: "sync header", PageID: -1, Offset: 0, Err: err}
+	}
+	return nil
 	// 	CREATING A NEW DATABASE:
 	// 1. User runs: NewStorage("test.db")
 	//    ↓
@@ -203,9 +465,9 @@ func (s *Storage) loadHeader() error {
 	headerBytes := make([]byte, HeaderSize)
 
 	// opens and reads the file header from the start
-	_, err := s.file.ReadAt(headerBytes, 0)
+	_, err := s.readAt(headerBytes, 0)
 	if err != nil {
-		return fmt.Errorf("failed to read header: %w", err)
+		return &StorageError{Op: "read header", PageID: -1, Offset: 0, Err: err}
 	}
 
 	// converts the BYTES back into numbers
@@ -216,23 +478,44 @@ func (s *Storage) loadHeader() error {
 		PageSize:   binary.LittleEndian.Uint32(headerBytes[8:12]),
 		TotalPages: binary.LittleEndian.Uint32(headerBytes[12:16]),
 		NextPageID: binary.LittleEndian.Uint32(headerBytes[16:20]),
+		// older files have zeros here, which reads as "no writes counted yet"
+		LastLSN:    binary.LittleEndian.Uint64(headerBytes[20:28]),
+		AppliedLSN: binary.LittleEndian.Uint64(headerBytes[28:36]),
+		Engine:     binary.LittleEndian.Uint32(headerBytes[36:40]),
+		Flags:      binary.LittleEndian.Uint32(headerBytes[40:44]),
 	}
 
 	// validates the header info
+	// offsets point at the field that's wrong
 	if header.Magic != MagicNumber {
-		return errors.New("invalid file format: magic number mismatch")
+		return &StorageError{Op: "parse header", PageID: -1, Offset: 0, Err: errors.New("invalid file format: magic number mismatch")}
 	}
 	if header.Version != Version {
-		return fmt.Errorf("incorrect version %d", header.Version)
+		return &StorageError{Op: "parse header", PageID: -1, Offset: 4, Err: fmt.Errorf("%w: version %d, this build reads %d", ErrUnsupportedFormat, header.Version, Version)}
+	}
+	if unknown := header.Flags &^ knownFlags; unknown != 0 {
+		return &StorageError{Op: "parse header", PageID: -1, Offset: 40, Err: fmt.Errorf("%w: flags %#x", ErrUnsupportedFormat, unknown)}
 	}
 	if header.PageSize != uint32(s.pageSize) {
-		return fmt.Errorf("page size mismatch: expected %d, got %d", s.pageSize, header.PageSize)
+		return &StorageError{Op: "parse header", PageID: -1, Offset: 8, Err: fmt.Errorf("page size mismatch: expected %d, got %d", s.pageSize, header.PageSize)}
+	}
+	engine, err := engineByID(EngineID(header.Engine))
+	if err != nil {
+		return &StorageError{Op: "parse header", PageID: -1, Offset: 36, Err: err}
 	}
 
 	// updates the Storage object
 	// sets the variables to match the file
 	s.nextPageID = header.NextPageID
 	s.totalPages = header.TotalPages
+	s.lsn = header.LastLSN
+	s.appliedLSN = header.AppliedLSN
+	s.engine = engine
+	s.checksums = hasPageChecksums(header.Flags)
+	s.checksumAlg = checksumAlgorithm(header.Flags)
+	s.versioned = hasRecordVersions(header.Flags)
+	s.slotted = hasSlottedPages(header.Flags)
+	s.pageLSNs = hasPageLSNs(header.Flags)
 
 	return nil
 	// 	LOADING EXISTING DATABASE:
@@ -248,6 +531,10 @@ func (s *Storage) loadHeader() error {
 	//    - Bytes 8-11 → PageSize
 	//    - Bytes 12-15 → TotalPages
 	//    - Bytes 16-19 → NextPageID
+	//    - Bytes 20-27 → LastLSN
+	//    - Bytes 28-35 → AppliedLSN
+	//    - Bytes 36-39 → Engine
+	//    - Bytes 40-43 → Flags
 	//    ↓
 	// 5. VALIDATE everything:
 	//    ✓ Magic = "MYDB"? (Is this our file?)
@@ -261,9 +548,13 @@ func (s *Storage) loadHeader() error {
 
 // we opened an existing database, there are pages with data,
 // but dont know what kets are stored and where
-func (s *Storage) buildIndex() error {
+func (s *Storage) buildIndex(ctx context.Context) error {
 	// loops through all the pages. s.totalPages = 3 it loops though pageID 0,1,2
 	for pageID := uint32(0); pageID < s.totalPages; pageID++ {
+		// a big file takes a while, give up if the caller's deadline passed
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("building index stopped at page %d of %d: %w", pageID, s.totalPages, err)
+		}
 
 		// loads each page into memory
 		page, err := s.loadPage(pageID)
@@ -271,46 +562,32 @@ func (s *Storage) buildIndex() error {
 			return fmt.Errorf("failed to load page %d during index build: %w", pageID, err)
 		}
 
-		// Scan records in the page add to index
-		// RecordCount contains the number of key value pairs in the page
-		offset := 2 // skips the RecordCount header the first 2 butes of each page contains record count.
-		for i := uint16(0); i < page.RecordCount; i++ {
-
-			if offset+4 > len(page.Data) {
-				break
-			}
-
-			// 	Page Data (4096 bytes):
-			//	0  1
-			// [2][0]     ← Record Count (2 bytes) offset=2 skips it
-			//	2  3  4  5
-			// [6][0][4][0] ← Record 1 header (key length, value length)
-			//   6    7    8    9	10   11
-			// ['u']['s']['e']['r'][':']['1'] ← Record 1 data (key + value)
-			//  12   13   14   15
-			// ['j']['o']['h']['n']
-
-			// page.Data[2:4] contains key length
-			// page.Data[4:6] contains value length
-			keyLen := binary.LittleEndian.Uint16(page.Data[offset : offset+2])
-			valueLen := binary.LittleEndian.Uint16(page.Data[offset+2 : offset+4])
-			// move the position forward by 4 bytes to get to the value indexes
-			offset += 4
-
-			// makes sure we dont read past the end of the page.
-			if offset+int(keyLen)+int(valueLen) > len(page.Data) {
-				break
-			}
+		// empty pages go on the free list so they get reused
+		if page.RecordCount == 0 {
+			s.addFreePage(pageID)
+			continue
+		}
 
-			// key is recorded using the current offset and the key length.
-			// converts the bytes into a string (key)
-			key := string(page.Data[offset : offset+int(keyLen)])
+		// Scan records in the page add to index
+		// 	Page Data (4096 bytes):
+		//	0  1
+		// [2][0]     ← Record Count (2 bytes) offset=2 skips it
+		//	2  3  4  5
+		// [6][0][4][0] ← Record 1 header (key length, value length)
+		//   6    7    8    9	10   11
+		// ['u']['s']['e']['r'][':']['1'] ← Record 1 data (key + value)
+		//  12   13   14   15
+		// ['j']['o']['h']['n']
+		//
+		// a record that doesn't parse ends the page, nothing after it is read
+		var indexErr error
+		page.eachRecord(func(key, _ string, _ int) bool {
 			// adds to key to index: "key _ is stored in page 0"
-			s.pageIndex[key] = pageID
-
-			// the offset moves up past the key and value,
-			// to record the next key and value length and continue the loop until the page ends.
-			offset += int(keyLen) + int(valueLen)
+			indexErr = s.indexPut(key, pageID)
+			return indexErr == nil
+		})
+		if indexErr != nil {
+			return indexErr
 		}
 	}
 	return nil
@@ -346,7 +623,12 @@ func (s *Storage) buildIndex() error {
 
 // calculates the exact address where the page is stored in the file
 func (s *Storage) pageOffset(pageID uint32) int64 {
-	return int64(HeaderSize + pageID*uint32(s.pageSize))
+	return int64(HeaderSize) + int64(pageID)*int64(s.pageSize)
+}
+
+// the same for code that has a page but no Storage (every file uses PageSize)
+func pageFileOffset(pageID uint32) int64 {
+	return int64(HeaderSize) + int64(pageID)*PageSize
 }
 
 //0-63 : the header
@@ -363,30 +645,105 @@ func (s *Storage) pageOffset(pageID uint32) int64 {
 // pageID = 2
 // offset = 64 + (2 * 4096) = 64 + 8192 = 8256
 
+// a page read that's in progress. when several goroutines want the same
+// page that isn't cached, the first one reads it and the others wait for
+// it, so the disk is hit once instead of once per caller:
+//
+//	Get A: miss → loading[3] = call → ReadAt ──────→ pages[3] = page, close(done)
+//	Get B: miss → loading[3] exists → wait on done ─→ same page
+type pageLoad struct {
+	done chan struct{} // closed when page/err are set
+	page *Page
+	err  error
+}
+
 func (s *Storage) loadPage(pageID uint32) (*Page, error) {
 	// checks if the page is in cache already
 	// looks in the in-memory cache (the s.pages map)
 	// **reading directly from memory is 1000x faster than reading from the disk
+	s.cacheMu.Lock()
+	s.notePageUse(pageID)
+	s.notePageAccess(pageID)
 	if page, exists := s.pages[pageID]; exists {
+		s.pool.touch(s, pageID)
+		s.cacheMu.Unlock()
+		s.stats.cacheHits.Add(1)
 		return page, nil
 	}
+	s.stats.cacheMisses.Add(1)
+	if call, inFlight := s.loading[pageID]; inFlight {
+		s.cacheMu.Unlock()
+		s.stats.coalesced.Add(1)
+		<-call.done
+		return call.page, call.err
+	}
+	call := &pageLoad{done: make(chan struct{})}
+	s.loading[pageID] = call
+	s.cacheMu.Unlock()
+
+	call.page, call.err = s.readPage(pageID)
+
+	s.cacheMu.Lock()
+	if call.err == nil {
+		// Cache the loaded page
+		// stores the page in memory cache for faster future access
+		s.pages[pageID] = call.page
+		s.pool.touch(s, pageID)
+		s.evictCleanPages()
+	}
+	delete(s.loading, pageID)
+	s.cacheMu.Unlock()
+	close(call.done)
 
+	return call.page, call.err
+}
+
+// reads from the file, or from the reader the storage was opened from
+func (s *Storage) readAt(buf []byte, offset int64) (int, error) {
+	if s.source != nil {
+		return s.source.ReadAt(buf, offset)
+	}
+	return s.file.ReadAt(buf, offset)
+}
+
+// reads a page from disk, loadPage is the one that caches it
+func (s *Storage) readPage(pageID uint32) (*Page, error) {
 	// reads the page from disk
 	offset := s.pageOffset(pageID)       // uses the pageOffset() function to find the exact byte position
 	pageData := make([]byte, s.pageSize) // creates a 4096 byte array to hold the page data to hold the data read from disk
 
-	_, err := s.file.ReadAt(pageData, offset) // reads exactly 4096 bytes starting at the calculated offset
+	_, err := s.readAt(pageData, offset) // reads exactly 4096 bytes starting at the calculated offset
 	// ReadAt lets you read from any position in the file
 	// example: we want Page 1 which starts from 4160-8255.
 	// so it will be: s.file.ReadAt(pageData, 4160)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read page %d: %w", pageID, err)
+		return nil, &StorageError{Op: "read page", PageID: int64(pageID), Offset: offset, Err: err}
+	}
+	s.stats.pageReads.Add(1)
+	s.stats.bytesRead.Add(uint64(s.pageSize))
+	// a flipped bit fails here instead of turning into garbage records
+	if err := s.checkPageChecksum(pageID, pageData); err != nil {
+		return nil, err
+	}
+	// a stub, the records are in the cold tier
+	cold := isColdStub(pageData)
+	if cold {
+		if err := s.readColdPage(pageID, pageData); err != nil {
+			return nil, err
+		}
 	}
 
 	// creates a page object
 	page := &Page{
-		ID:      pageID,
-		IsDirty: false,
+		ID:          pageID,
+		IsDirty:     false,
+		checksummed: s.checksums,
+		checksumAlg: s.checksumAlg,
+		versioned:   s.versioned,
+		slotted:     s.slotted,
+		hasLSN:      s.pageLSNs,
+		lsn:         s.pageLSN(pageData),
+		cold:        cold,
 	}
 	copy(page.Data[:], pageData)
 	// creates a new page struct and sets the ID and marks it as clean (isDirty = false because it has not been changed ie it matches whats on the disk)
@@ -395,6 +752,9 @@ func (s *Storage) loadPage(pageID uint32) (*Page, error) {
 	if len(pageData) >= 2 {
 		page.RecordCount = binary.LittleEndian.Uint16(pageData[0:2])
 	}
+	if err := s.checkSlots(page); err != nil {
+		return nil, err
+	}
 	// bytes 0-1: Record count (how many key-value pairs are in this page)
 	// bytes 2+: Actual records (key-value pairs)
 
@@ -407,10 +767,6 @@ func (s *Storage) loadPage(pageID uint32) (*Page, error) {
 	// Little Endian is the least significant bit first: 0x03, 0x00
 	// so when we get the pageData it would be: binary.LittleEndian.Uint16([0x03, 0x00]) = 3
 
-	// Cache the loaded page
-	// stores the page in memory cache for faster future access
-	s.pages[pageID] = page
-
 	return page, nil
 }
 
@@ -428,17 +784,42 @@ func (s *Storage) writePage(page *Page) error {
 	// gets the exact byte position when the page would be found in the file
 	offset := s.pageOffset(page.ID)
 
+	page.stampLSN(s.lsn)
+	page.stampChecksum()
+
+	crashPoint(CrashBeforePageWrite, nil)
+	if err := s.doubleWrite(page); err != nil {
+		return err
+	}
+	crashPoint(CrashMidPageWrite, func() {
+		// the first half of the page lands on disk, the rest is still the old page
+		s.file.WriteAt(page.Data[:PageSize/2], offset)
+	})
+
 	// writes the new pages 4096 bytes to disk
 	_, err := s.file.WriteAt(page.Data[:], offset)
 	if err != nil {
-		return fmt.Errorf("failed to write page %d: %w", page.ID, err)
+		return &StorageError{Op: "write page", PageID: int64(page.ID), Offset: offset, Err: err}
 	}
+	s.stats.pageWrites.Add(1)
+	s.stats.bytesWritten.Add(uint64(len(page.Data)))
+	s.stats.syncs.Add(1)
 
 	page.IsDirty = false
 	// the page in disk now match what is in memory
 	// we dont have to waste time to write it in disk until it changes again.
 
-	return s.file.Sync()
+	if err := syncFile(s.file); err != nil {
+		return &StorageError{Op: "sync page", PageID: int64(page.ID), Offset: offset, Err: err}
+	}
+	if err := s.verifyWrite(page, offset); err != nil {
+		page.IsDirty = true // written again by the next flush
+		return err
+	}
+	if page.cold {
+		s.dropColdCopy(page)
+	}
+	return nil
 	//force disk write, forces the os to write to disk, without it, the data could sit in os buffers and lost when power is off
 }
 
@@ -466,6 +847,11 @@ func (s *Storage) writePage(page *Page) error {
 // }
 
 func (s *Storage) allocateNewPage() *Page {
+	// reuse an empty page from the free list before growing the file
+	if page := s.takeFreePage(); page != nil {
+		return page
+	}
+
 	// Creates a new page object using the next availble page id,
 	// the page only exists in memory and needs to be written to the disk, so isDirty is true
 	// and the RecordCount is 0 beccause the new page starts as empty.
@@ -473,6 +859,11 @@ func (s *Storage) allocateNewPage() *Page {
 		ID:          s.nextPageID,
 		IsDirty:     true,
 		RecordCount: 0,
+		checksummed: s.checksums,
+		checksumAlg: s.checksumAlg,
+		versioned:   s.versioned,
+		slotted:     s.slotted,
+		hasLSN:      s.pageLSNs,
 	}
 
 	//initialize the pages header record count as 0
@@ -483,14 +874,32 @@ func (s *Storage) allocateNewPage() *Page {
 
 	//adds to cache
 	//stores the new page in the in-memory cache
+	s.cacheMu.Lock()
 	s.pages[page.ID] = page
+	s.pool.touch(s, page.ID)
+	s.notePageAccess(page.ID)
+	s.cacheMu.Unlock()
 	//update the metadata: nextPageID and totalPages is incremented to keep track of correct page number
 	s.nextPageID++
 	s.totalPages++
+	s.growPreallocation()
 
 	return page
 }
 
+// with PreallocatePages set, disk space is reserved in chunks ahead of the
+// pages actually in use, so the file doesn't fragment one page at a time.
+// it's only an optimization, if the platform can't do it we carry on.
+func (s *Storage) growPreallocation() {
+	if s.opts.PreallocatePages == 0 || s.totalPages <= s.preallocatedPages {
+		return
+	}
+	target := s.totalPages + s.opts.PreallocatePages
+	if err := preallocate(s.file, s.pageOffset(target)); err == nil {
+		s.preallocatedPages = target
+	}
+}
+
 // allocateNewPage() is called when:
 
 // Database is empty: First page creation
@@ -504,6 +913,10 @@ func (s *Storage) updateHeader() error {
 		PageSize:   uint32(s.pageSize),
 		TotalPages: s.totalPages,
 		NextPageID: s.nextPageID,
+		LastLSN:    s.lsn,
+		AppliedLSN: s.appliedLSN,
+		Engine:     uint32(s.engine.ID()),
+		Flags:      s.headerFlags(),
 		//The first three fields never change, but the last two are dynamic and reflect our current database state.
 	}
 	//writeHeader() function to actually save these values to the file.
@@ -522,8 +935,29 @@ func (s *Storage) updateHeader() error {
 	// Data loss! Page 2 exists but we don't know about it
 }
 
-func (s *Storage) Close() error {
-	// Like Save all and exit it makes sure everything in memory gets written to disk before shutting down.
+// Sync writes every dirty page and the header to disk.
+// with SyncOnClose this is how a caller makes everything written so far durable.
+func (s *Storage) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sync()
+}
+
+// Sync for callers that hold s.mu already
+func (s *Storage) sync() (err error) {
+	if s.opts.ReadOnly {
+		return nil // nothing is ever dirty
+	}
+	// a Sync that went through means there is disk space (again)
+	defer func() {
+		if err = s.diskFullError(err); err == nil {
+			s.diskFullSince.Store(0)
+		}
+	}()
+	// expired pages are wiped in memory and written out with the rest
+	if err := s.applyRetention(); err != nil {
+		return err
+	}
 	// goes through each page in the database to check if dirty (new changes)
 	for _, page := range s.pages {
 		if page.IsDirty {
@@ -532,11 +966,93 @@ func (s *Storage) Close() error {
 			}
 		}
 	}
+	// pages nobody used for a while go to the cold tier, clean ones only
+	if err := s.applyTiering(); err != nil {
+		return err
+	}
+	// everything is clean now, the cache can shrink back to its budget
+	s.cacheMu.Lock()
+	s.evictCleanPages()
+	s.cacheMu.Unlock()
+
+	// before the header, which records its LSN
+	if s.checkpointing {
+		if err := s.logCheckpointMarker(LogTypeCheckpointEnd, s.checkpointLSN); err != nil {
+			return err
+		}
+	}
 
 	//update header metadata
 	if err := s.updateHeader(); err != nil {
-		return err // Stop if header update fails
+		return err
+	}
+	// the tree goes after the header, so it never claims an LSN the pages don't have
+	if s.btree != nil {
+		if err := s.btree.flush(s.btreeMatch(), s.freePages); err != nil {
+			return err
+		}
+	}
+	// everything the WAL holds is in the pages now
+	if s.wal != nil {
+		if err := s.wal.Truncate(); err != nil {
+			return err
+		}
+	}
+	if s.opts.AnnounceCheckpoints {
+		if err := s.announceCheckpoint(); err != nil {
+			return err
+		}
+	}
+	return s.autoCompact()
+}
+
+// flushes a single page plus the header, used when one write has to be durable
+// the header is needed too because the write may have allocated a new page
+func (s *Storage) syncPage(page *Page) error {
+	if page.IsDirty {
+		if err := s.writePage(page); err != nil {
+			return s.diskFullError(err)
+		}
+	}
+	return s.diskFullError(s.updateHeader())
+}
+
+func (s *Storage) Close() error {
+	// they take the lock themselves, so they have to be gone before Close takes it
+	s.stopCheckpointer()
+	s.stopSnapshotter()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.source != nil {
+		return nil // the reader is the caller's
+	}
+	if s.opts.ReadOnly {
+		return s.file.Close()
+	}
+	// Like Save all and exit it makes sure everything in memory gets written to disk before shutting down.
+	if err := s.sync(); err != nil {
+		return err // Stop if a page or header write fails
+	}
+	if err := s.saveWarmSet(); err != nil {
+		return err
+	}
+	if err := s.saveIndex(); err != nil {
+		return err
 	}
+	if err := s.wal.Close(); err != nil {
+		return err
+	}
+	if s.btree != nil {
+		if err := s.btree.close(); err != nil {
+			return err
+		}
+	}
+	if err := s.closeDoubleWrite(); err != nil {
+		return err
+	}
+	s.closeVerifier()
+	s.pool.forget(s)
+	unlockFile(s.file) // closing releases it anyway, this just makes it explicit
 	return s.file.Close()
 }
 
@@ -569,7 +1085,7 @@ func deserializeRecord(data []byte, offset int) (key, value string, bytesRead in
 	// offset is still 2
 	// need at least 4 bytes to read the header (2 for keyLen + 2 for valueLen)
 	if offset+4 > len(data) {
-		return "", "", 0, errors.New("insufficient data for record header")
+		return "", "", 0, fmt.Errorf("%w: insufficient data for record header", ErrCorrupted)
 	}
 
 	// Example: data[2:4] = [0x06, 0x00] → keyLen = 6
@@ -582,7 +1098,7 @@ func deserializeRecord(data []byte, offset int) (key, value string, bytesRead in
 	//make sure I actually have 9 bytes of data available
 	// prevents reading beyond the end of the data array
 	if offset+totalLen > len(data) {
-		return "", "", 0, errors.New("insufficient data for complete record")
+		return "", "", 0, fmt.Errorf("%w: insufficient data for complete record", ErrCorrupted)
 	}
 	// Extract key string from data
 	// Example: offset=2, keyLen=6
@@ -608,13 +1124,17 @@ func deserializeRecord(data []byte, offset int) (key, value string, bytesRead in
 // finds the end of existing records in a page and appends the new record there.
 func (p *Page) addRecord(key, value string) error {
 	// Serioalize the key and value into record = [0x05, 0x00, 0x03, 0x00, 'u, 's', 'e', 'r', '2', 'c', 'a', 'm']
+	if p.slotted {
+		return p.addSlotted(key, value)
+	}
 	record := serializeRecord(key, value)
 
 	// Find where records end in the page, goes through all records on the page using the recordcount
 	offset := 2 // Skip record count
 	for i := uint16(0); i < p.RecordCount; i++ {
 		if offset+4 > len(p.Data) {
-			return errors.New("corrupted page: invalid record offset")
+			return corruptedPage("parse record", p.ID, pageFileOffset(p.ID)+int64(offset),
+				fmt.Errorf("record %d of %d starts past the end", i, p.RecordCount))
 		}
 
 		keyLen := binary.LittleEndian.Uint16(p.Data[offset : offset+2])
@@ -630,8 +1150,8 @@ func (p *Page) addRecord(key, value string) error {
 	// [15+] is empty space
 	//
 	// Check if there's enough space
-	if offset+len(record) > len(p.Data) {
-		return errors.New("page full: not enough space for record")
+	if offset+len(record) > p.capacity() {
+		return ErrPageFull
 	}
 	// offset = 15           				// Used space
 	// len(record) = 13	        			// New record size
@@ -650,8 +1170,18 @@ func (p *Page) addRecord(key, value string) error {
 	return nil
 }
 
-// scans through all record in the page for a matching key
+// scans through all record in the page for a matching key, the value
+// without the LSN of a versioned page
 func (p *Page) findRecord(key string) (value string, found bool) {
+	value, found = p.findStoredRecord(key)
+	return p.stripVersion(value), found
+}
+
+// findRecord with the value as it is on the page
+func (p *Page) findStoredRecord(key string) (value string, found bool) {
+	if p.slotted {
+		return p.findSlotted(key)
+	}
 	//skips the record count
 	offset := 2
 
@@ -673,10 +1203,45 @@ func (p *Page) findRecord(key string) (value string, found bool) {
 	return "", false
 }
 
+// puts value in place of key's record. ErrPageFull means the old record is
+// gone and the new one didn't fit next to its neighbours.
+func (p *Page) replaceRecord(key, value string) error {
+	if p.slotted {
+		return p.replaceSlotted(key, value)
+	}
+	p.deleteRecord(key)
+	return p.addRecord(key, value)
+}
+
+// calls fn with every record of the page, in the order they're stored (key
+// order on a slotted page), until it returns false. size is the record's
+// bytes, header included. a record that doesn't parse ends the walk with an
+// error, the ones before it were passed to fn.
+func (p *Page) eachRecord(fn func(key, value string, size int) bool) error {
+	if p.slotted {
+		return p.eachSlot(fn)
+	}
+	offset := 2 // skip the record count
+	for i := uint16(0); i < p.RecordCount; i++ {
+		key, value, size, err := deserializeRecord(p.Data[:], offset)
+		if err != nil {
+			return err
+		}
+		if !fn(key, value, size) {
+			return nil
+		}
+		offset += size
+	}
+	return nil
+}
+
 // remove data from a page
 // finds a removes a specific key-value pair from the page, and then shifts
 // all the remaining data left to fill the gap.
 func (p *Page) deleteRecord(key string) bool {
+	if p.slotted {
+		return p.deleteSlotted(key)
+	}
 	// method is called to delete the 2nd record: deleteRecord("user:1")
 
 	offset := 2 // skip record count - the first 2 bytes
@@ -735,7 +1300,64 @@ func (p *Page) deleteRecord(key string) bool {
 
 // Storage.Put() - used for Inserting or Updating Data
 // method called to update user:1 = db.Put("user:1", "leonor")
-func (s *Storage) Put(key, value string) error {
+// opts can override the sync policy for this one write: db.Put("user:1", "leonor", WithSync())
+func (s *Storage) Put(key, value string, opts ...WriteOption) error {
+	s.lockForWrite()
+	err := s.putValue(key, value, opts)
+	lsn := s.lsn
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// outside the lock, so the writers behind this one can log theirs and
+	// share the fsync (SyncGroupCommit)
+	return s.awaitCommit(lsn, opts)
+}
+
+// Put for callers that hold s.mu already
+func (s *Storage) putValue(key, value string, opts []WriteOption) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	if err := s.validateValue(key, value); err != nil {
+		return err
+	}
+	if err := s.applyBackpressure(); err != nil {
+		return err
+	}
+	wo := s.resolveWriteOptions(opts)
+	s.stats.puts.Add(1)
+	if b := s.stats.buckets; b != nil {
+		defer b.observe(key, bucketPut, len(value), s.stats.now())
+	}
+
+	// the page only ever sees the encoded value (compressed, encrypted, ...)
+	stored, err := s.encodeValue(key, value)
+	if err != nil {
+		return err
+	}
+	return s.put(key, stored, wo, 0)
+}
+
+// writes an already encoded value. lsn is 0 for a new write, which gets
+// logged first, recovery passes the LSN of the entry it replays.
+func (s *Storage) put(key, value string, wo writeOptions, lsn uint64) error {
+	s.values.remove(key)
+
+	// checked before it's logged so the WAL never holds a write that can't be replayed
+	if err := s.checkRecordSize(key, value); err != nil {
+		return err
+	}
+	if err := s.logWrite(LogTypePut, key, value, lsn); err != nil {
+		return err
+	}
+	if err := s.keepVersion(key); err != nil {
+		return err
+	}
+
+	// set when an update had to move the record to another page
+	var movedFrom *Page
+
 	// Case 1: Key exists already
 	// Check if key already exists
 	// looks in the in-memory index - the fast lookup map
@@ -743,14 +1365,19 @@ func (s *Storage) Put(key, value string) error {
 	// we avoid scanning through all the pages on the disk (very slow)
 	//
 	// s.pageIndex["user:1"] → returns pageID = 0, exists = true
-	if pageID, exists := s.pageIndex[key]; exists {
+	pageID, exists, err := s.lookup(key)
+	if err != nil {
+		return err
+	}
+	if exists {
 		// loads page 0 from disk (or cache is already loaded)
 		page, err := s.loadPage(pageID)
 		if err != nil {
 			return err
 		}
 
-		// delete old record and add new one
+		// delete old record and add new one (a slotted page can put
+		// the new one over the old, see replaceRecord)
 		//BEFORE deleteRecord:
 		//[0-1]:   RecordCount = 2
 		//[2-14]:  "user:1" = "isa"      ← DELETE THIS
@@ -760,45 +1387,37 @@ func (s *Storage) Put(key, value string) error {
 		//[0-1]:   RecordCount = 1
 		//[2-14]:  "user:2" = "cam"          ← Shifted left!
 		//[15+]:   empty space
-		page.deleteRecord(key)
-		if err := page.addRecord(key, value); err != nil {
+		if err := page.replaceRecord(key, s.stampVersion(value)); err == nil {
+			//AFTER addRecord:
+			//[0-1]:   RecordCount = 2
+			//[2-14]:  "user:2" = "cam"
+			//[15-30]: "user:1" = "leonor"  ← NEW! (might be different size)
+			//[31+]:   empty space
+			if wo.sync {
+				return s.syncPage(page)
+			}
+			return nil
+		}
+
+		// the new value is bigger and doesn't fit next to its old neighbours,
+		// the old record is already gone, so carry on like it's a new key and
+		// place it on a page with room (Case 2). the old page has to be
+		// flushed as well if this write syncs.
+		if err := s.indexDelete(key); err != nil {
 			return err
 		}
-		//AFTER addRecord:
-		//[0-1]:   RecordCount = 2
-		//[2-14]:  "user:2" = "cam"
-		//[15-30]: "user:1" = "leonor"  ← NEW! (might be different size)
-		//[31+]:   empty space
-		return nil
+		if page.RecordCount == 0 {
+			s.addFreePage(pageID)
+		}
+		movedFrom = page
 	}
 
 	// Case 2: Key doesn't exist - find a page with space or create new page
 	// method called: db.Put("user:3", "alice")  exists = false
-	var targetPage *Page
-
-	// Try to find a page with space (simple linear search for now)
-	for pageID := uint32(0); pageID < s.totalPages; pageID++ {
-		page, err := s.loadPage(pageID)
-		if err != nil {
-			continue
-		}
-
-		// Estimate if record will fit
-		recordSize := 4 + len(key) + len(value)
-		usedSpace := 2 // Record count header
-		for i := uint16(0); i < page.RecordCount; i++ {
-			if usedSpace+4 > len(page.Data) {
-				break
-			}
-			keyLen := binary.LittleEndian.Uint16(page.Data[usedSpace : usedSpace+2])
-			valueLen := binary.LittleEndian.Uint16(page.Data[usedSpace+2 : usedSpace+4])
-			usedSpace += 4 + int(keyLen) + int(valueLen)
-		}
-
-		if usedSpace+recordSize <= len(page.Data) {
-			targetPage = page
-			break
-		}
+	// the engine picks the page (see engine.go)
+	targetPage, err := s.engine.place(s, key, 4+s.versionSize()+s.slotSize()+len(key)+len(value))
+	if err != nil {
+		return err
 	}
 
 	// If no page has space, allocate a new one
@@ -807,20 +1426,70 @@ func (s *Storage) Put(key, value string) error {
 	}
 
 	// Add the record
-	if err := targetPage.addRecord(key, value); err != nil {
+	if err := targetPage.addRecord(key, s.stampVersion(value)); err != nil {
 		return err
 	}
 
 	// Update index
-	s.pageIndex[key] = targetPage.ID
+	if err := s.indexPut(key, targetPage.ID); err != nil {
+		return err
+	}
+
+	if wo.sync {
+		if movedFrom != nil && movedFrom != targetPage {
+			if err := s.syncPage(movedFrom); err != nil {
+				return err
+			}
+		}
+		return s.syncPage(targetPage)
+	}
+	return nil
+}
 
+// a record has to fit in an empty page
+// [count 2][keyLen 2][valLen 2][key][value]
+func (s *Storage) checkRecordSize(key, value string) error {
+	if size := 4 + s.versionSize() + len(key) + len(value); s.pageHeaderSize()+s.slotSize()+size > s.pageCapacity() {
+		return fmt.Errorf("record for %q is %d bytes, more than fits in a page", key, size)
+	}
 	return nil
 }
 
 func (s *Storage) Get(key string) (string, error) {
-	pageID, exists := s.pageIndex[key]
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.stats.gets.Add(1)
+	if s.stats.buckets != nil {
+		start := s.stats.now()
+		value, err := s.get(key)
+		s.stats.buckets.observe(key, bucketGet, len(value), start)
+		return value, err
+	}
+	return s.get(key)
+}
+
+// a streamed value comes back whole, put together from its chunks (see stream.go)
+func (s *Storage) get(key string) (string, error) {
+	value, err := s.getValue(key)
+	if err != nil {
+		return "", err
+	}
+	return s.resolveStream(value)
+}
+
+// the value as the key's record holds it, a streamed value's manifest
+func (s *Storage) getValue(key string) (string, error) {
+	if value, ok := s.values.get(key); ok {
+		s.stats.valueHits.Add(1)
+		return value, nil
+	}
+
+	pageID, exists, err := s.lookup(key)
+	if err != nil {
+		return "", err
+	}
 	if !exists {
-		return "", errors.New("key not found")
+		return "", ErrKeyNotFound
 	}
 
 	page, err := s.loadPage(pageID)
@@ -830,16 +1499,71 @@ func (s *Storage) Get(key string) (string, error) {
 
 	value, found := page.findRecord(key)
 	if !found {
-		return "", errors.New("key not found in expected page")
+		return "", missingRecord(key, pageID)
 	}
 
-	return value, nil
+	decoded, err := s.decodeValue(key, value)
+	if err != nil {
+		return "", err
+	}
+	if s.values != nil {
+		s.stats.valueMisses.Add(1)
+		if !s.values.put(key, decoded) {
+			s.stats.valueRejected.Add(1)
+		}
+	}
+	return decoded, nil
+}
+
+// the index says key is on pageID and the page doesn't have it (or can't be
+// read far enough to tell)
+func missingRecord(key string, pageID uint32) error {
+	return corruptedPage("find record", pageID, pageFileOffset(pageID), fmt.Errorf("%q is indexed on this page but isn't on it", key))
+}
+
+func (s *Storage) Delete(key string, opts ...WriteOption) error {
+	s.lockForWrite()
+	err := s.deleteValue(key, opts)
+	lsn := s.lsn
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.awaitCommit(lsn, opts)
 }
 
-func (s *Storage) Delete(key string) error {
-	pageID, exists := s.pageIndex[key]
+// Delete for callers that hold s.mu already
+func (s *Storage) deleteValue(key string, opts []WriteOption) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	if err := s.applyBackpressure(); err != nil {
+		return err
+	}
+	wo := s.resolveWriteOptions(opts)
+	s.stats.deletes.Add(1)
+	if b := s.stats.buckets; b != nil {
+		defer b.observe(key, bucketDelete, 0, s.stats.now())
+	}
+	return s.deleteKey(key, wo, 0)
+}
+
+// lsn works like it does for put
+func (s *Storage) deleteKey(key string, wo writeOptions, lsn uint64) error {
+	s.values.remove(key)
+
+	pageID, exists, err := s.lookup(key)
+	if err != nil {
+		return err
+	}
 	if !exists {
-		return errors.New("key not found")
+		return ErrKeyNotFound
+	}
+	if err := s.logWrite(LogTypeDelete, key, "", lsn); err != nil {
+		return err
+	}
+	if err := s.keepVersion(key); err != nil {
+		return err
 	}
 
 	page, err := s.loadPage(pageID)
@@ -848,11 +1572,22 @@ func (s *Storage) Delete(key string) error {
 	}
 
 	if !page.deleteRecord(key) {
-		return errors.New("key not found in expected page")
+		return missingRecord(key, pageID)
 	}
 
 	// Remove from index
-	delete(s.pageIndex, key)
+	if err := s.indexDelete(key); err != nil {
+		return err
+	}
+	s.deletesSinceCompact++
 
+	// the page just became empty, it can be handed out again
+	if page.RecordCount == 0 {
+		s.addFreePage(pageID)
+	}
+
+	if wo.sync {
+		return s.syncPage(page)
+	}
 	return nil
 }
@@ -0,0 +1,257 @@
+package main
+
+import "fmt"
+
+// key migrations: MigrateKeys runs a function over every key and rewrites
+// the keyspace with what it returns, for applications changing their key
+// format ("user:42" → "users/42"), their values, or both:
+//
+//	report, err := db.MigrateKeys(func(key, value string) (string, string, bool) {
+//		if id, ok := strings.CutPrefix(key, "user:"); ok {
+//			return "users/" + id, value, true
+//		}
+//		return key, value, true // not ours, or in the new format already
+//	}, WithMigrationName("users-v2"))
+//
+// keys are visited in order, a batch at a time (1000 by default). a batch is
+// one WAL transaction (see rename.go), it goes in whole or not at all, and
+// the lock is let go between batches so reads and writes get in.
+//
+// with a name the migration can be resumed: every batch records the last
+// key it did under MigrationKeyPrefix+name, in the same transaction, and a
+// MigrateKeys with the same name after a crash (or an error) starts after
+// it. the record goes once the migration is done. a new key that sorts
+// after the key it came from is seen again by a resumed migration, fn has
+// to return a key already in the new format as it is. a single run never
+// shows fn a key it wrote.
+//
+// returning the same key and value leaves the key alone, keep = false
+// deletes it. a new key that exists already, or that two keys map to, fails
+// the batch with ErrKeyExists. keys written between two batches behind the
+// position the migration is at aren't migrated, run it again (or stop the
+// writers) to catch those. the storage's own records (leases, migration
+// positions, chunks, see iterator.go) are left alone.
+
+// MigrationKeyPrefix is where resumable migrations keep their position,
+// "__migrate__:users-v2" for the one named "users-v2".
+const MigrationKeyPrefix = "__migrate__:"
+
+const defaultMigrateBatch = 1000
+
+// MigrateFunc returns what oldKey and its value become, keep = false
+// deletes the key.
+type MigrateFunc func(oldKey, value string) (newKey, newValue string, keep bool)
+
+// MigrateReport counts what a migration did so far.
+type MigrateReport struct {
+	Scanned  int    // keys fn was called with
+	Changed  int    // keys that got a new name, a new value or both
+	Deleted  int    // keys fn dropped
+	Batches  int    // transactions written
+	Position string // the last key done, a resumed migration starts after it
+	Resumed  bool   // started where an earlier run with the same name stopped
+}
+
+// MigrateOption changes how MigrateKeys runs.
+type MigrateOption func(*migrateOptions)
+
+type migrateOptions struct {
+	name     string
+	batch    int
+	progress func(MigrateReport)
+}
+
+// WithMigrationName makes the migration resumable under name.
+func WithMigrationName(name string) MigrateOption {
+	return func(o *migrateOptions) { o.name = name }
+}
+
+// WithMigrationBatch sets how many keys go in one transaction.
+func WithMigrationBatch(keys int) MigrateOption {
+	return func(o *migrateOptions) { o.batch = keys }
+}
+
+// WithMigrationProgress calls fn with the report after every batch.
+func WithMigrationProgress(fn func(MigrateReport)) MigrateOption {
+	return func(o *migrateOptions) { o.progress = fn }
+}
+
+// MigrateKeys rewrites every key with fn, in batches (see migrate.go).
+func (s *Storage) MigrateKeys(fn MigrateFunc, opts ...MigrateOption) (MigrateReport, error) {
+	mo := migrateOptions{batch: defaultMigrateBatch}
+	for _, opt := range opts {
+		opt(&mo)
+	}
+	if mo.batch <= 0 {
+		mo.batch = defaultMigrateBatch
+	}
+
+	var report MigrateReport
+	if mo.name != "" {
+		s.mu.Lock()
+		position, found, err := s.migrationPosition(mo.name)
+		s.mu.Unlock()
+		if err != nil {
+			return report, err
+		}
+		report.Position, report.Resumed = position, found
+	}
+	// before the first batch there is no position yet, "" is a key too
+	started := report.Resumed
+	written := make(map[string]bool)
+	for {
+		s.lockForWrite()
+		done, err := s.migrateBatch(fn, &mo, &report, started, written)
+		lsn := s.lsn
+		s.mu.Unlock()
+		if err != nil {
+			return report, err
+		}
+		if err := s.awaitCommit(lsn, nil); err != nil {
+			return report, err
+		}
+		if done {
+			return report, nil
+		}
+		started = true
+		if mo.progress != nil {
+			mo.progress(report)
+		}
+	}
+}
+
+// where the migration called name got to, found is false when it isn't running
+func (s *Storage) migrationPosition(name string) (position string, found bool, err error) {
+	if _, exists, err := s.lookup(MigrationKeyPrefix + name); err != nil || !exists {
+		return "", false, err
+	}
+	position, err = s.get(MigrationKeyPrefix + name)
+	return position, err == nil, err
+}
+
+// migrates the next batch of keys after report.Position, done is set once
+// there are none left. written holds the keys this run wrote, fn doesn't
+// see them again.
+func (s *Storage) migrateBatch(fn MigrateFunc, mo *migrateOptions, report *MigrateReport, started bool, written map[string]bool) (bool, error) {
+	if err := s.checkWritable(); err != nil {
+		return false, err
+	}
+	if err := s.applyBackpressure(); err != nil {
+		return false, err
+	}
+
+	last := report.Position
+	var keys []string
+	err := s.indexRange(report.Position, "", func(key string, _ uint32) bool {
+		if started && key == report.Position {
+			return true // done in the batch before
+		}
+		last = key
+		if !isReservedKey(key) && !written[key] {
+			keys = append(keys, key)
+		}
+		return len(keys) < mo.batch
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(keys) == 0 { // the range only stops early on a full batch
+		return true, s.finishMigration(mo.name)
+	}
+
+	type change struct {
+		from, to, value string
+		keep            bool
+	}
+	var changes []change
+	deleting := make(map[string]bool)
+	for _, key := range keys {
+		value, err := s.get(key)
+		if err != nil {
+			return false, fmt.Errorf("migrate %q: %w", key, err)
+		}
+		to, newValue, keep := fn(key, value)
+		if keep && to == key && newValue == value {
+			continue
+		}
+		changes = append(changes, change{from: key, to: to, value: newValue, keep: keep})
+		if !keep || to != key {
+			deleting[key] = true
+		}
+	}
+
+	// everything that can fail is checked before the first WAL entry
+	var deletes, puts []txOp
+	targets := make(map[string]bool)
+	for _, c := range changes {
+		if deleting[c.from] {
+			deletes = append(deletes, txOp{typ: LogTypeDelete, key: c.from})
+		}
+		if !c.keep {
+			continue
+		}
+		if targets[c.to] {
+			return false, fmt.Errorf("migrate %q to %q: %w", c.from, c.to, ErrKeyExists)
+		}
+		targets[c.to] = true
+		if c.to != c.from {
+			if _, exists, err := s.lookup(c.to); err != nil {
+				return false, err
+			} else if exists && !deleting[c.to] {
+				return false, fmt.Errorf("migrate %q to %q: %w", c.from, c.to, ErrKeyExists)
+			}
+		}
+		if err := s.validateValue(c.to, c.value); err != nil {
+			return false, fmt.Errorf("migrate %q: %w", c.from, err)
+		}
+		stored, err := s.encodeValue(c.to, c.value)
+		if err != nil {
+			return false, fmt.Errorf("migrate %q: %w", c.from, err)
+		}
+		if err := s.checkRecordSize(c.to, stored); err != nil {
+			return false, fmt.Errorf("migrate %q: %w", c.from, err)
+		}
+		puts = append(puts, txOp{typ: LogTypePut, key: c.to, value: stored})
+	}
+	ops := append(deletes, puts...)
+	if mo.name != "" {
+		recordKey := MigrationKeyPrefix + mo.name
+		position, err := s.encodeValue(recordKey, last)
+		if err != nil {
+			return false, err
+		}
+		ops = append(ops, txOp{typ: LogTypePut, key: recordKey, value: position})
+	}
+	if len(ops) > 0 {
+		if err := s.applyTx(ops, s.resolveWriteOptions(nil)); err != nil {
+			return false, err
+		}
+	}
+
+	s.stats.deletes.Add(uint64(len(deletes)))
+	s.stats.puts.Add(uint64(len(puts)))
+	for _, op := range puts {
+		written[op.key] = true
+	}
+	report.Scanned += len(keys)
+	report.Changed += len(puts)
+	report.Deleted += len(changes) - len(puts)
+	report.Batches++
+	report.Position = last
+	return false, nil
+}
+
+// deletes the position record of a finished migration
+func (s *Storage) finishMigration(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, exists, err := s.lookup(MigrationKeyPrefix + name); err != nil || !exists {
+		return err
+	}
+	if err := s.deleteKey(MigrationKeyPrefix+name, s.resolveWriteOptions(nil), 0); err != nil {
+		return err
+	}
+	s.stats.deletes.Add(1)
+	return nil
+}
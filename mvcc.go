@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"godata/pagefmt"
+)
+
+// record versions: every write has an LSN, its place in the WAL. a file
+// created with Options.RecordVersions stores it with the record as well, in
+// front of the value (see pagefmt), so GetWithVersion can say which write a
+// value came from:
+//
+//	[keyLen][valueLen][key][lsn u64][value]
+//
+// snapshots: Snapshot pins the database as it is at the current LSN, and
+// until it is closed every write keeps the version it replaced (in memory,
+// with the LSN of the write that replaced it). a snapshot's reads pick the
+// version that was current at its LSN, so a reader sees one consistent
+// state over many Gets and a Scan without holding a lock in between and
+// without keeping writers out:
+//
+//	snap := db.Snapshot()
+//	defer snap.Close()
+//	from, _ := snap.Get("account:1")
+//	to, _ := snap.Get("account:2") // the same moment as account:1
+//
+//	     write a=2 (LSN 8)    write a=3 (LSN 9)
+//	a:  [1 until 8] ──────── [2 until 9] ─────── current 3
+//	    snapshot at 7 reads 1, at 8 reads 2, at 9 and later 3
+//
+// versions older than the oldest open snapshot are dropped, with none open
+// nothing is kept. GetAt reads any LSN from the oldest open snapshot on, and
+// earlier ones as long as the key's record is older still (which takes
+// record versions to know), anything else is ErrVersionGone.
+//
+// snapshots live as long as the Storage, they don't survive a Close. a
+// version inside a transaction (rename.go) can show part of it, a snapshot
+// never does: it is taken between writes. a read-only storage's Refresh
+// changes the pages without writes, its snapshots see the new data. files
+// with record versions can't be read by builds from before the flag, they'd
+// take the LSN for part of the value.
+
+// keyVersion is a value a write replaced, kept for the open snapshots
+type keyVersion struct {
+	stored string // encoded, the way the record held it
+	exists bool   // false when the write created the key
+	until  uint64 // the LSN of the write that replaced it
+}
+
+func hasRecordVersions(flags uint32) bool {
+	return flags&pagefmt.FlagRecordVersions != 0
+}
+
+// bytes a record's LSN takes on the page
+func (s *Storage) versionSize() int {
+	if s.versioned {
+		return pagefmt.VersionSize
+	}
+	return 0
+}
+
+// puts the LSN of the write being applied (s.lsn) in front of value
+func (s *Storage) stampVersion(value string) string {
+	if !s.versioned {
+		return value
+	}
+	var lsn [pagefmt.VersionSize]byte
+	binary.LittleEndian.PutUint64(lsn[:], s.lsn)
+	return string(lsn[:]) + value
+}
+
+// the value of a page record without its LSN
+func (p *Page) stripVersion(value string) string {
+	if !p.versioned || len(value) < pagefmt.VersionSize {
+		return value
+	}
+	return value[pagefmt.VersionSize:]
+}
+
+// the LSN stored with key's record, 0 when the page doesn't keep them
+func (p *Page) findVersion(key string) (uint64, bool) {
+	value, found := p.findStoredRecord(key)
+	if !found || !p.versioned {
+		return 0, found
+	}
+	lsn, _, _ := pagefmt.SplitVersion([]byte(value))
+	return lsn, true
+}
+
+// GetWithVersion returns the value of key and the LSN of the write that
+// stored it, the LSN is 0 in files without record versions.
+func (s *Storage) GetWithVersion(key string) (value string, version uint64, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.stats.gets.Add(1)
+	if value, err = s.get(key); err != nil {
+		return "", 0, err
+	}
+	version, _, err = s.recordVersion(key)
+	return value, version, err
+}
+
+// the LSN of key's record, 0 when the file doesn't store them
+func (s *Storage) recordVersion(key string) (uint64, bool, error) {
+	pageID, exists, err := s.lookup(key)
+	if err != nil || !exists || !s.versioned {
+		return 0, exists, err
+	}
+	page, err := s.loadPage(pageID)
+	if err != nil {
+		return 0, false, err
+	}
+	lsn, found := page.findVersion(key)
+	if !found {
+		return 0, false, missingRecord(key, pageID)
+	}
+	return lsn, true, nil
+}
+
+// GetAt returns the value key had once every write up to the LSN version
+// was applied (see mvcc.go).
+func (s *Storage) GetAt(key string, version uint64) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.stats.gets.Add(1)
+	if version < s.versionFloor() {
+		// nothing kept from back then, only a record older still answers
+		since, exists, err := s.recordVersion(key)
+		if err != nil {
+			return "", err
+		}
+		if !exists || since == 0 || since > version {
+			return "", ErrVersionGone
+		}
+	}
+	return s.getAt(key, version)
+}
+
+// the oldest LSN whose versions are all kept
+func (s *Storage) versionFloor() uint64 {
+	floor := s.lsn
+	for lsn := range s.snapshotPins {
+		floor = min(floor, lsn)
+	}
+	return floor
+}
+
+// the version of key that was current at lsn, ok is false when it's the
+// current one
+func (s *Storage) versionAt(key string, lsn uint64) (keyVersion, bool) {
+	for _, v := range s.history[key] {
+		if v.until > lsn {
+			return v, true
+		}
+	}
+	return keyVersion{}, false
+}
+
+// getValue at lsn, a streamed value's manifest as it is
+func (s *Storage) valueAt(key string, lsn uint64) (string, error) {
+	v, ok := s.versionAt(key, lsn)
+	if !ok {
+		return s.getValue(key)
+	}
+	if !v.exists {
+		return "", ErrKeyNotFound
+	}
+	return s.decodeValue(key, v.stored)
+}
+
+// get at lsn, streamed values put together from their chunks at lsn
+func (s *Storage) getAt(key string, lsn uint64) (string, error) {
+	value, err := s.valueAt(key, lsn)
+	if err != nil {
+		return "", err
+	}
+	return s.assembleStream(value, func(chunk string) (string, error) { return s.valueAt(chunk, lsn) })
+}
+
+// called by put and deleteKey once the write is logged (s.lsn is its LSN)
+// and before it changes anything, keeps what key holds for the open
+// snapshots
+func (s *Storage) keepVersion(key string) error {
+	if len(s.snapshotPins) == 0 {
+		return nil
+	}
+	v := keyVersion{until: s.lsn}
+	pageID, exists, err := s.lookup(key)
+	if err != nil {
+		return err
+	}
+	if exists {
+		page, err := s.loadPage(pageID)
+		if err != nil {
+			return err
+		}
+		if v.stored, v.exists = page.findRecord(key); !v.exists {
+			return missingRecord(key, pageID)
+		}
+	}
+	if s.history == nil {
+		s.history = make(map[string][]keyVersion)
+	}
+	s.history[key] = append(s.history[key], v)
+	s.stats.versionsKept.Add(1)
+	return nil
+}
+
+// drops the versions no open snapshot reads anymore, called when one closes
+func (s *Storage) pruneVersions() {
+	if len(s.snapshotPins) == 0 {
+		s.history = nil
+		return
+	}
+	floor := s.versionFloor()
+	for key, versions := range s.history {
+		keep := 0
+		for keep < len(versions) && versions[keep].until <= floor {
+			keep++
+		}
+		if keep == len(versions) {
+			delete(s.history, key)
+		} else if keep > 0 {
+			s.history[key] = append([]keyVersion(nil), versions[keep:]...)
+		}
+	}
+}
+
+// Snapshot is a consistent view of the database at one LSN, see mvcc.go.
+type Snapshot struct {
+	s      *Storage
+	lsn    uint64
+	closed bool
+}
+
+// Snapshot pins the database as it is now. Close the snapshot when done,
+// the versions it keeps cost memory.
+func (s *Storage) Snapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.snapshotPins == nil {
+		s.snapshotPins = make(map[uint64]int)
+	}
+	s.snapshotPins[s.lsn]++
+	return &Snapshot{s: s, lsn: s.lsn}
+}
+
+// LSN returns the LSN the snapshot reads at.
+func (sn *Snapshot) LSN() uint64 { return sn.lsn }
+
+// Get returns the value key had when the snapshot was taken.
+func (sn *Snapshot) Get(key string) (string, error) {
+	sn.s.mu.RLock()
+	defer sn.s.mu.RUnlock()
+	if sn.closed {
+		return "", ErrSnapshotClosed
+	}
+	sn.s.stats.gets.Add(1)
+	return sn.s.getAt(key, sn.lsn)
+}
+
+// Scan returns a cursor over the keys start <= k < end had when the
+// snapshot was taken, with their values back then.
+func (sn *Snapshot) Scan(start, end string) *Iterator {
+	s := sn.s
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if sn.closed {
+		return &Iterator{s: s, err: ErrSnapshotClosed}
+	}
+	// the keys there are now, and the ones writes since changed
+	seen := make(map[string]bool)
+	var keys []string
+	err := s.indexRange(start, end, func(key string, _ uint32) bool {
+		seen[key] = true
+		keys = append(keys, key)
+		return true
+	})
+	for key := range s.history {
+		if _, changed := s.versionAt(key, sn.lsn); changed && !seen[key] && s.keyInRange(key, start, end) {
+			keys = append(keys, key)
+		}
+	}
+	s.sortKeys(keys)
+	return &Iterator{s: s, keys: keys, err: err, snap: sn}
+}
+
+// Close lets go of the snapshot, the versions only it needed are dropped.
+func (sn *Snapshot) Close() error {
+	s := sn.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sn.closed {
+		return nil
+	}
+	sn.closed = true
+	if s.snapshotPins[sn.lsn]--; s.snapshotPins[sn.lsn] == 0 {
+		delete(s.snapshotPins, sn.lsn)
+	}
+	s.pruneVersions()
+	return nil
+}
+
+// the value of key for an iterator over a snapshot, exists is false when
+// the key wasn't there at the snapshot's LSN
+func (sn *Snapshot) read(key string) (value string, exists bool, err error) {
+	if sn.closed {
+		return "", false, ErrSnapshotClosed
+	}
+	value, err = sn.s.getAt(key, sn.lsn)
+	if errors.Is(err, ErrKeyNotFound) {
+		return "", false, nil
+	}
+	return value, err == nil, err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// open telemetry: what opening the file took and found, for an application
+// to log at startup and alert on when it wasn't a clean one:
+//
+//	db, err := NewStorage("orders.db")
+//	info := db.OpenInfo()
+//	log.Printf("opened in %s, %d pages read, %d WAL entries replayed", info.Duration, info.PagesRead, info.Replayed)
+//	for _, w := range info.Warnings {
+//		log.Printf("orders.db: %s", w)
+//	}
+//
+// a clean open after a Close has nothing to replay and no warnings. a
+// replay means the last run didn't close the storage (a crash, a kill, a
+// Close that was never called), a restored page means it died in the
+// middle of writing one. PagesRead counts the pages read (and, in a file
+// with checksums, checked) to build the index and replay the WAL, an
+// IndexFile or the BTreeIndex saves most of them.
+
+// OpenInfo says what opening a storage took and what it had to fix.
+type OpenInfo struct {
+	Created       bool          // the file was new
+	Replayed      int           // WAL entries applied on top of the pages
+	PagesRestored uint64        // torn pages put back from the double-write buffer
+	PagesRead     uint64        // pages read to build the index and replay the WAL
+	IndexRebuilt  bool          // Options.IndexFile was set and the file was stale or damaged
+	Duration      time.Duration // from the open call to the storage being ready, the lock wait included
+	Warnings      []string      // what wasn't a clean open, in the order it was found
+}
+
+// Recovered is true when the open replayed the WAL or put back a torn page,
+// the last run ended without a Close.
+func (i OpenInfo) Recovered() bool {
+	return i.Replayed > 0 || i.PagesRestored > 0
+}
+
+// OpenInfo returns what opening the storage took and found, see openinfo.go.
+func (s *Storage) OpenInfo() OpenInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	info := s.openInfo
+	info.Warnings = append([]string(nil), info.Warnings...)
+	return info
+}
+
+func (s *Storage) openWarning(format string, args ...any) {
+	s.openInfo.Warnings = append(s.openInfo.Warnings, fmt.Sprintf(format, args...))
+}
+
+// called once the storage is ready, start is when the open was called
+func (s *Storage) finishOpen(start time.Time) {
+	info := &s.openInfo
+	info.PagesRestored = s.stats.pagesRestored.Load()
+	info.PagesRead = s.stats.pageReads.Load()
+	info.Duration = s.clock().Now().Sub(start)
+	if info.PagesRestored > 0 {
+		s.openWarning("%d torn pages put back from the double-write buffer", info.PagesRestored)
+	}
+	if info.Replayed > 0 {
+		s.openWarning("%d WAL entries replayed, the last run didn't close the storage", info.Replayed)
+	}
+}
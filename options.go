@@ -0,0 +1,215 @@
+package main
+
+import "time"
+
+// SyncPolicy decides when changes are forced down to the physical disk.
+type SyncPolicy int
+
+const (
+	// SyncOnClose keeps dirty pages in memory and writes them on Sync or Close.
+	// fastest, but anything written since the last Sync is lost on a crash.
+	SyncOnClose SyncPolicy = iota
+	// SyncAlways writes the touched page and the header, and fsyncs, before Put/Delete return.
+	SyncAlways
+	// SyncGroupCommit makes Put/Delete wait until their WAL entry is fsynced,
+	// the pages are written on Sync like with SyncOnClose. writers arriving
+	// together share one fsync (see WAL.SyncTo), so it is as durable as
+	// SyncAlways at a fraction of the fsyncs.
+	SyncGroupCommit
+)
+
+// Options holds the settings a Storage is opened with.
+type Options struct {
+	Sync     SyncPolicy // global durability policy, can be overridden per call with WithSync/WithNoSync
+	Compress bool       // deflate values before they are stored
+	// maintenance mode: Put/Delete fail with ErrReadOnlyMode, reads keep working.
+	// can be flipped at runtime with SetMaintenanceMode or SetOption("maintenance", "true")
+	Maintenance bool
+	// keys starting with one of these are never compressed (already-compressed images, random tokens, ...)
+	IncompressiblePrefixes []string
+	Transformers           []ValueTransformer // extra encode/decode stages applied after compression (see transform.go)
+	// read large sequential streams (verify scans) with O_DIRECT where supported,
+	// falls back to normal reads when the platform or filesystem can't do it
+	DirectIO bool
+	// reserve disk space this many pages ahead of the last page (0 = off)
+	PreallocatePages uint32
+	Clock            Clock // time source, nil means the real clock (see clock.go)
+	// key prefix → validator run on every Put (see validate.go), more can be
+	// added after opening with RegisterValidator
+	Validators map[string]Validator
+	// number of decoded values kept in memory for the hottest keys, on top of
+	// the page cache (0 = off, see valuecache.go)
+	ValueCacheSize int
+	// backpressure: once this many dirty pages are waiting to be written,
+	// writes are slowed down, made to flush, or rejected (0 = no limit, see backpressure.go)
+	MaxDirtyPages int
+	Backpressure  BackpressurePolicy
+	StallDelay    time.Duration // how long BackpressureSleep delays a write, 0 means 1ms
+	// how long opening waits for another process to release the file lock,
+	// 0 fails with ErrLocked straight away (NewStorageContext waits until its ctx is done)
+	LockWait time.Duration
+	// open an existing file read-only next to the process that writes it, without
+	// taking the lock, for reporting jobs. the view stays as it was when opened
+	// until Refresh picks up a newer checkpoint (see readonly.go)
+	ReadOnly bool
+	// after every Sync write <file>.checkpoint, which read-only connections
+	// check to know when there is something new to Refresh to
+	AnnounceCheckpoints bool
+	// label per-bucket metrics (ops, bytes, latency) with the bucket a key belongs
+	// to, nil = off. KeyPrefixBucket(":") counts "user:1" under "user" (see bucketstats.go)
+	MetricsBucket     func(key string) string
+	MaxMetricsBuckets int // cap on distinct labels, the rest count under BucketOther (0 means 100)
+	// compact on Sync once the file has too much dead space, too many deletes
+	// or too many empty pages, zero value = off (see autocompact.go). the
+	// thresholds can be changed at runtime with SetOption("auto_compact_...")
+	AutoCompact AutoCompactPolicy
+	// on Close remember this many most recently used pages, and load them in
+	// the background on the next open (0 = off, see warm.go)
+	WarmPages int
+	// save the key index to <file>.idx on Close and load it on the next open
+	// instead of reading every page, when nothing changed in between (see indexfile.go)
+	IndexFile bool
+	// keep the key index in a B+ tree in <file>.bpt instead of a map in
+	// memory: keys come out in order, memory stays bounded however many keys
+	// there are, and a clean open doesn't read the pages (see btree.go).
+	// IndexFile has nothing left to do next to it. ignored when read-only.
+	BTreeIndex bool
+	// how many tree nodes (16KB each) stay in memory (0 means 256)
+	BTreeCacheNodes int
+	// the order of keys in the index and in scans (nil = byte order, see
+	// collation.go)
+	Collation Collation
+	// page layout of a new file, an existing file keeps the one it was
+	// created with (see engine.go)
+	Engine EngineID
+	// drop pages whose records are all older than this on every Sync, meant
+	// for EngineAppend (0 = keep everything, see timeseries.go)
+	Retention time.Duration
+	// the time a key was written at, for Retention. nil reads keys made by
+	// TimeKey, false means the key has no time and is kept
+	KeyTime func(key string) (time.Time, bool)
+	// prune keys by prefix, by age or count, on Sync (see retention.go)
+	RetentionPolicies []RetentionPolicy
+	// how often Sync enforces RetentionPolicies at most (0 = every Sync)
+	RetentionInterval time.Duration
+	// create new files without page checksums, for tools that need the old
+	// page layout. existing files keep what they have (see checksum.go)
+	DisablePageChecksums bool
+	// create new files that store the LSN of its write with every record, for
+	// GetWithVersion and GetAt. existing files keep what they have (see mvcc.go)
+	RecordVersions bool
+	// pages kept in memory at most, least recently used ones are dropped
+	// (dirty ones written first) when there are more (0 = no limit, see
+	// pagecache.go)
+	CacheSize int
+	// a page cache budget shared with other storages opened with the same
+	// pool, CacheSize is ignored when set (see pagecache.go)
+	BufferPool *BufferPool
+	// checkpoint (Sync) in the background this often when there are changes,
+	// and as soon as the WAL reaches CheckpointWALBytes, 0 = no such limit
+	// (see checkpoint.go)
+	CheckpointInterval time.Duration
+	CheckpointWALBytes int64
+	// the most bytes the WAL may hold, a write that finds it full checkpoints
+	// first or fails with ErrWALFull, depending on WALFull (0 = no limit, see
+	// checkpoint.go)
+	MaxWALBytes int64
+	WALFull     WALFullPolicy
+	// how often a write tries a Sync while the disk is full, to find out
+	// whether there is room again (0 means 1s, see diskfull.go)
+	DiskFullRetry time.Duration
+	// where pages nobody used for ColdAfter go, Sync moves them there and a
+	// read brings them back (nil or 0 = no tiering, see tiering.go)
+	ColdTier  ColdTier
+	ColdAfter time.Duration
+	// where the background snapshots go and how often, the label a key's
+	// bucket gets (nil = one bucket for everything, see snapshotstore.go)
+	SnapshotStore    SnapshotStore
+	SnapshotInterval time.Duration
+	SnapshotBucket   func(key string) string
+	// which keys a full value cache takes in, AdmitTinyLFU keeps the hot ones
+	// through scans (AdmitAll = plain LRU, see valuecache.go)
+	ValueCacheAdmission AdmissionPolicy
+	// every page goes to <file>.dwb before it is written in place, so a crash
+	// mid-write can't leave a torn page (see doublewrite.go)
+	DoubleWrite bool
+	// create new files with slotted pages: deletes and shrinking updates don't
+	// move records, a page is searched by key. existing files keep what they
+	// have (see slotted.go)
+	SlottedPages bool
+	// how new files checksum their pages and WAL entries, CRC32 (IEEE) by
+	// default. existing files keep theirs (see checksum.go)
+	Checksum ChecksumAlgorithm
+	// read every page back after it's written and compare, for disks that
+	// can't be trusted. with DirectIO the read skips the OS cache and
+	// reaches the disk (see writeverify.go)
+	VerifyWrites bool
+}
+
+// DefaultOptions returns the settings NewStorage uses.
+func DefaultOptions() Options {
+	return Options{
+		Sync: SyncOnClose,
+	}
+}
+
+// WriteOption changes how a single Put or Delete behaves.
+// example: db.Put("order:42", "paid", WithSync())
+type WriteOption func(*writeOptions)
+
+// the resolved settings for one write
+type writeOptions struct {
+	sync   bool // flush and fsync before returning
+	commit bool // wait for the WAL entry to be fsynced (SyncGroupCommit)
+}
+
+// WithSync forces this write to be on disk before the call returns,
+// even if the storage was opened with SyncOnClose.
+func WithSync() WriteOption {
+	return func(o *writeOptions) { o.sync = true }
+}
+
+// WithNoSync lets this write stay in memory until the next Sync/Close,
+// even if the storage was opened with SyncAlways.
+func WithNoSync() WriteOption {
+	return func(o *writeOptions) { o.sync, o.commit = false, false }
+}
+
+// SetMaintenanceMode turns maintenance mode on or off, so an operator can
+// quiesce writes before a backup, migration or failover without closing the db.
+// Sync still works while it's on, so pending changes can be flushed.
+func (s *Storage) SetMaintenanceMode(on bool) {
+	s.optsMu.Lock()
+	s.opts.Maintenance = on
+	s.optsMu.Unlock()
+}
+
+// MaintenanceMode reports whether writes are currently rejected.
+func (s *Storage) MaintenanceMode() bool {
+	s.optsMu.RLock()
+	defer s.optsMu.RUnlock()
+	return s.opts.Maintenance
+}
+
+// starts from the global policy and applies the per-call overrides in order,
+// so the last option passed wins.
+func (s *Storage) resolveWriteOptions(opts []WriteOption) writeOptions {
+	s.optsMu.RLock()
+	wo := writeOptions{sync: s.opts.Sync == SyncAlways, commit: s.opts.Sync == SyncGroupCommit}
+	s.optsMu.RUnlock()
+	for _, opt := range opts {
+		opt(&wo)
+	}
+	return wo
+}
+
+// checked at the top of every write
+func (s *Storage) checkWritable() error {
+	if s.opts.ReadOnly {
+		return ErrOpenedReadOnly
+	}
+	if s.MaintenanceMode() {
+		return ErrReadOnlyMode
+	}
+	return nil
+}
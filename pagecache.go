@@ -0,0 +1,244 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// page cache budget: with Options.CacheSize set, at most that many pages stay
+// in memory, the least recently used ones go first. without it every page
+// ever loaded stays, which on a big file ends up being the whole file.
+//
+//	front                                              back
+//	[ 12 ] [ 3 ] [ 40 (dirty) ] [ 7 ] ... [ 5 (dirty) ] [ 9 ]  ← evicted first
+//
+// a clean page is simply dropped, the disk has the same bytes. a dirty page
+// has to be written first, and the WAL is fsynced before that, so a page on
+// disk is never ahead of the log that explains it: a crash after the write
+// replays the WAL over it, which lands on the same result.
+//
+// clean pages are dropped by loadPage as soon as the cache is over budget,
+// the page it was asked for stays (the caller is about to use it). dirty
+// pages are only written at the top of a write (see applyBackpressure),
+// where nothing is holding on to a page, and they all go clean on Sync.
+// between two writes the cache can be over budget by the pages one write
+// touched.
+//
+// several storages in one process can share one budget instead, by opening
+// them with the same Options.BufferPool:
+//
+//	pool := NewBufferPool(25000) // ~100MB of pages for all of them
+//	opts.BufferPool = pool
+//	users, _ := NewStorageWithOptions("users.db", opts)
+//	orders, _ := NewStorageWithOptions("orders.db", opts)
+//
+// the recency order is then across all of them, a busy storage takes pages
+// from an idle one. another storage's page is only evicted while nothing is
+// using that storage (its lock is free), a clean page a writer is holding
+// must not go, so a pool can run over budget for as long as every other
+// storage is busy. dirty pages are only ever written by their own storage.
+//
+// pages leave the cache for other reasons too, and a cache miss after any of
+// them looks the same. Stats counts each apart: PageEvictions for the budget,
+// PagesInvalidated for the whole cache thrown away by Refresh and Compact
+// (the page IDs can mean other pages after them), PagesEvictedCold for pages
+// moved to the cold tier. misses with PageEvictions climbing want a bigger
+// CacheSize, the others won't go away with one.
+
+// BufferPool is a page cache budget, private to one storage (CacheSize) or
+// shared by several (Options.BufferPool).
+type BufferPool struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // poolEntry, most recently used at the front
+	elems map[poolEntry]*list.Element
+}
+
+type poolEntry struct {
+	s      *Storage
+	pageID uint32
+}
+
+// NewBufferPool returns a pool that keeps at most pages pages in memory.
+func NewBufferPool(pages int) *BufferPool {
+	if pages < 1 {
+		pages = 1
+	}
+	return &BufferPool{size: pages, order: list.New(), elems: make(map[poolEntry]*list.Element)}
+}
+
+// changes the budget, pages over it go with the next eviction
+func (p *BufferPool) resize(pages int) {
+	p.mu.Lock()
+	p.size = pages
+	p.mu.Unlock()
+}
+
+// Len returns how many pages the pool's storages have in memory together.
+func (p *BufferPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.order.Len()
+}
+
+// the pool a storage is opened with, nil for no budget
+func bufferPoolFor(opts Options) *BufferPool {
+	if opts.BufferPool != nil {
+		return opts.BufferPool
+	}
+	if opts.CacheSize > 0 {
+		return NewBufferPool(opts.CacheSize)
+	}
+	return nil
+}
+
+// marks a page of s as just used, called with s.cacheMu held
+func (p *BufferPool) touch(s *Storage, pageID uint32) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := poolEntry{s, pageID}
+	if elem, ok := p.elems[e]; ok {
+		p.order.MoveToFront(elem)
+		return
+	}
+	p.elems[e] = p.order.PushFront(e)
+}
+
+// takes every page of s out, when its cache is thrown away or closed
+func (p *BufferPool) forget(s *Storage) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for elem := p.order.Front(); elem != nil; {
+		next := elem.Next()
+		if e := elem.Value.(poolEntry); e.s == s {
+			p.order.Remove(elem)
+			delete(p.elems, e)
+		}
+		elem = next
+	}
+}
+
+// takes one page of s out, when s dropped it itself
+func (p *BufferPool) remove(s *Storage, pageID uint32) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e := poolEntry{s, pageID}
+	if elem, ok := p.elems[e]; ok {
+		p.order.Remove(elem)
+		delete(p.elems, e)
+	}
+}
+
+// drops clean pages from the back until the pool is within budget, never
+// the most recently used one. called with s.cacheMu held.
+func (s *Storage) evictCleanPages() {
+	p := s.pool
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for elem := p.order.Back(); elem != nil && p.order.Len() > p.size; {
+		prev := elem.Prev()
+		if prev == nil {
+			break // the front
+		}
+		e := elem.Value.(poolEntry)
+		if e.s == s || e.s.tryLockIdle() {
+			if e.s.dropCleanPage(e.pageID) {
+				p.order.Remove(elem)
+				delete(p.elems, e)
+			}
+			if e.s != s {
+				e.s.unlockIdle()
+			}
+		}
+		elem = prev
+	}
+}
+
+// locks another storage of the pool, only when no one is using it. it
+// never waits, for either lock, so storages evicting each other's pages
+// can't deadlock: a reader of the other one holds its cacheMu while it
+// trims the pool too.
+func (s *Storage) tryLockIdle() bool {
+	if !s.mu.TryLock() {
+		return false
+	}
+	if !s.cacheMu.TryLock() {
+		s.mu.Unlock()
+		return false
+	}
+	return true
+}
+
+func (s *Storage) unlockIdle() {
+	s.cacheMu.Unlock()
+	s.mu.Unlock()
+}
+
+// called with s.cacheMu held
+func (s *Storage) dropCleanPage(pageID uint32) bool {
+	if page := s.pages[pageID]; page != nil && page.IsDirty {
+		return false
+	}
+	delete(s.pages, pageID)
+	s.stats.pageEvictions.Add(1)
+	return true
+}
+
+// brings the pool back within budget, writing this storage's dirty pages out
+// where clean ones aren't enough. the caller holds s.mu exclusively and no page.
+func (s *Storage) trimPages() error {
+	p := s.pool
+	if p == nil {
+		return nil
+	}
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	s.evictCleanPages()
+
+	// no other storage touches s's pages while s.mu is held, the list can be
+	// let go of during the writes
+	var victims []*Page
+	p.mu.Lock()
+	over := p.order.Len() - p.size
+	for elem := p.order.Back(); elem != nil && len(victims) < over; elem = elem.Prev() {
+		e := elem.Value.(poolEntry)
+		if page := s.pages[e.pageID]; e.s == s && page != nil && page.IsDirty {
+			victims = append(victims, page)
+		}
+	}
+	p.mu.Unlock()
+	if len(victims) == 0 {
+		return nil
+	}
+
+	// the log first, then the pages it covers
+	if s.wal != nil {
+		if err := s.wal.Sync(); err != nil {
+			return err
+		}
+	}
+	for _, page := range victims {
+		if err := s.writePage(page); err != nil {
+			return err
+		}
+		s.dropCleanPage(page.ID)
+		p.mu.Lock()
+		e := poolEntry{s, page.ID}
+		p.order.Remove(p.elems[e])
+		delete(p.elems, e)
+		p.mu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,460 @@
+// Package pagefmt reads and writes the godata file format without opening a
+// database, for forensic, repair and migration tools that work on raw bytes.
+//
+// a database file is a 64 byte header followed by fixed size pages:
+//
+//	offset 0      header (HeaderSize bytes, little endian)
+//	              0  magic "MYDB"   uint32
+//	              4  version        uint32
+//	              8  page size      uint32
+//	              12 total pages    uint32
+//	              16 next page ID   uint32
+//	              20 last LSN       uint64 (zero in files written before it existed)
+//	              28 applied LSN    uint64 (replicas only, see ApplyReplicated)
+//	              36 engine         uint32 (page layout, 0 = heap, see Options.Engine)
+//	              40 flags          uint32 (FlagPageChecksums, FlagRecordVersions, FlagSlottedPages, FlagCRC32C, FlagXXHash64, FlagPageLSN)
+//	offset 64     page 0
+//	offset 64+4096 page 1 ...
+//
+// a page starts with its record count (uint16), followed by the records
+// back to back, the rest of the page is unused:
+//
+//	[count u16] [keyLen u16][valueLen u16][key][value] [keyLen u16]...
+//
+// in files with FlagPageChecksums the last ChecksumSize bytes of every page
+// hold a CRC32 (IEEE) of the bytes before them, and records stop short of it.
+// a page of nothing but zeros (never written) has no checksum and is valid.
+// with FlagCRC32C as well the checksum is a CRC32C (Castagnoli) instead, with
+// FlagXXHash64 the low 32 bits of an xxHash64 (see Checksum).
+//
+// in files with FlagPageLSN the PageLSNSize bytes in front of the checksum
+// (the last ones of a page without checksums) hold the LSN the page was
+// written at: it has every change up to that one. records and slots stop
+// short of it, PageLSN reads it.
+//
+// in files with FlagRecordVersions every value starts with the LSN of the
+// write that stored it (VersionSize bytes, little endian), the pipeline's
+// bytes follow. SplitVersion takes it off.
+//
+// in files with FlagSlottedPages a page is slotted instead: the record count
+// is followed by where the records end (uint16), the records grow from the
+// front, with holes where deleted ones were, and a slot directory grows from
+// the back (before the checksum). slot i is the uint16 offset of a record,
+// the slots are ordered by key bytes:
+//
+//	[count u16][end u16] [record] [hole] [record] ... free ... [slot 1][slot 0] [crc]
+//
+// an end of 0 is a page that was never written, its records would start at
+// SlottedHeaderSize. ParsePageFlags and EncodePageFlags handle both layouts.
+//
+// a page whose record count is ColdPageCount is a stub: its records were
+// moved to the database's cold tier (Options.ColdTier), the file only keeps
+// the page's place. it parses as a page without records.
+//
+// values are stored the way the database's pipeline left them: with
+// Options.Compress every value starts with a marker byte (0 = raw,
+// 1 = deflate), user transformers (encryption, ...) apply on top.
+package pagefmt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+const (
+	PageSize          = 4096
+	HeaderSize        = 64
+	Magic             = 0x4D594442 // "MYDB"
+	Version           = 1
+	RecordCountSize   = 2      // the record count at the start of every page
+	RecordHeaderSize  = 4      // key length + value length in front of every record
+	ChecksumSize      = 4      // page CRC at the end of every page, with FlagPageChecksums
+	ColdPageCount     = 0xFFFF // record count of a page that is in the cold tier
+	VersionSize       = 8      // LSN in front of every value, with FlagRecordVersions
+	SlottedHeaderSize = 4      // record count + end of the records, with FlagSlottedPages
+	SlotSize          = 2      // a slot directory entry, with FlagSlottedPages
+	PageLSNSize       = 8      // LSN the page was written at, before the checksum, with FlagPageLSN
+)
+
+// header flags
+const (
+	FlagPageChecksums  uint32 = 1 << 0
+	FlagRecordVersions uint32 = 1 << 1
+	FlagSlottedPages   uint32 = 1 << 2
+	FlagCRC32C         uint32 = 1 << 3
+	FlagXXHash64       uint32 = 1 << 4
+	FlagPageLSN        uint32 = 1 << 5
+)
+
+// ErrChecksum is returned (wrapped) when a page's bytes don't match its checksum.
+var ErrChecksum = errors.New("page checksum mismatch")
+
+// Header is the decoded file header.
+type Header struct {
+	Magic      uint32
+	Version    uint32
+	PageSize   uint32
+	TotalPages uint32
+	NextPageID uint32
+	LastLSN    uint64
+	AppliedLSN uint64
+	Engine     uint32
+	Flags      uint32
+}
+
+// ParseHeader decodes the first HeaderSize bytes of a file and checks that
+// it is a godata file this package understands.
+func ParseHeader(data []byte) (Header, error) {
+	if len(data) < HeaderSize {
+		return Header{}, fmt.Errorf("header needs %d bytes, got %d", HeaderSize, len(data))
+	}
+	h := Header{
+		Magic:      binary.LittleEndian.Uint32(data[0:4]),
+		Version:    binary.LittleEndian.Uint32(data[4:8]),
+		PageSize:   binary.LittleEndian.Uint32(data[8:12]),
+		TotalPages: binary.LittleEndian.Uint32(data[12:16]),
+		NextPageID: binary.LittleEndian.Uint32(data[16:20]),
+		LastLSN:    binary.LittleEndian.Uint64(data[20:28]),
+		AppliedLSN: binary.LittleEndian.Uint64(data[28:36]),
+		Engine:     binary.LittleEndian.Uint32(data[36:40]),
+		Flags:      binary.LittleEndian.Uint32(data[40:44]),
+	}
+	switch {
+	case h.Magic != Magic:
+		return h, errors.New("not a godata file: magic number mismatch")
+	case h.Version != Version:
+		return h, fmt.Errorf("unsupported version %d", h.Version)
+	case h.PageSize != PageSize:
+		return h, fmt.Errorf("unsupported page size %d", h.PageSize)
+	}
+	return h, nil
+}
+
+// Encode returns the HeaderSize bytes of h.
+func (h Header) Encode() []byte {
+	data := make([]byte, HeaderSize)
+	binary.LittleEndian.PutUint32(data[0:4], h.Magic)
+	binary.LittleEndian.PutUint32(data[4:8], h.Version)
+	binary.LittleEndian.PutUint32(data[8:12], h.PageSize)
+	binary.LittleEndian.PutUint32(data[12:16], h.TotalPages)
+	binary.LittleEndian.PutUint32(data[16:20], h.NextPageID)
+	binary.LittleEndian.PutUint64(data[20:28], h.LastLSN)
+	binary.LittleEndian.PutUint64(data[28:36], h.AppliedLSN)
+	binary.LittleEndian.PutUint32(data[36:40], h.Engine)
+	binary.LittleEndian.PutUint32(data[40:44], h.Flags)
+	return data
+}
+
+// SplitVersion returns the LSN a value of a file with FlagRecordVersions
+// starts with and the value after it, ok is false when it's too short to
+// have one.
+func SplitVersion(value []byte) (lsn uint64, rest []byte, ok bool) {
+	if len(value) < VersionSize {
+		return 0, value, false
+	}
+	return binary.LittleEndian.Uint64(value[:VersionSize]), value[VersionSize:], true
+}
+
+// PageOffset is where page id starts in the file.
+func PageOffset(id uint32) int64 {
+	return HeaderSize + int64(id)*PageSize
+}
+
+// ReadPage reads the raw bytes of page id, r is usually the *os.File.
+func ReadPage(r io.ReaderAt, id uint32) ([]byte, error) {
+	data := make([]byte, PageSize)
+	if _, err := r.ReadAt(data, PageOffset(id)); err != nil {
+		return nil, fmt.Errorf("page %d: %w", id, err)
+	}
+	return data, nil
+}
+
+// Record is one key/value pair of a page. Key and Value point into the page
+// bytes, copy them to keep them after the page buffer is reused.
+type Record struct {
+	Key    []byte
+	Value  []byte
+	Offset int // where the record header starts, from the start of the page
+}
+
+// CorruptError is returned when a page's records don't add up.
+type CorruptError struct {
+	Record int // index of the bad record
+	Count  int // record count from the page header
+	Offset int // where the bad record starts, from the start of the page
+	Reason string
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("record %d of %d at offset %d: %s", e.Record, e.Count, e.Offset, e.Reason)
+}
+
+// ParsePage decodes every record of a raw page. on a corrupt page it
+// returns the records before the bad one along with a *CorruptError.
+func ParsePage(data []byte) ([]Record, error) {
+	if len(data) < RecordCountSize {
+		return nil, &CorruptError{Reason: "page too short for the record count"}
+	}
+	if IsColdPage(data) {
+		return nil, nil
+	}
+	count := int(binary.LittleEndian.Uint16(data[0:2]))
+	records := make([]Record, 0, count)
+	offset := RecordCountSize
+	for i := 0; i < count; i++ {
+		if offset+RecordHeaderSize > len(data) {
+			return records, &CorruptError{Record: i, Count: count, Offset: offset, Reason: "header runs past the page"}
+		}
+		keyLen := int(binary.LittleEndian.Uint16(data[offset : offset+2]))
+		valueLen := int(binary.LittleEndian.Uint16(data[offset+2 : offset+4]))
+		start := offset + RecordHeaderSize
+		end := start + keyLen + valueLen
+		if end > len(data) {
+			return records, &CorruptError{Record: i, Count: count, Offset: offset,
+				Reason: fmt.Sprintf("needs %d bytes, page ends at %d", end-offset, len(data))}
+		}
+		records = append(records, Record{
+			Key:    data[start : start+keyLen],
+			Value:  data[start+keyLen : end],
+			Offset: offset,
+		})
+		offset = end
+	}
+	return records, nil
+}
+
+// ParsePageFlags is ParsePage for a page of a file with the given header
+// flags, slotted or not. a slotted page's records have to lie between its
+// header and its slot directory, the slots in key order.
+func ParsePageFlags(data []byte, flags uint32) ([]Record, error) {
+	if flags&FlagSlottedPages == 0 || IsColdPage(data) {
+		return ParsePage(data)
+	}
+	if len(data) < PageSize {
+		return nil, &CorruptError{Reason: fmt.Sprintf("page cut off at %d bytes, the slot directory is missing", len(data))}
+	}
+	limit := recordsLimit(flags)
+	count := int(binary.LittleEndian.Uint16(data[0:2]))
+	end := int(binary.LittleEndian.Uint16(data[2:4]))
+	if end == 0 {
+		end = SlottedHeaderSize
+	}
+	dir := limit - count*SlotSize
+	if end < SlottedHeaderSize || end > dir {
+		return nil, &CorruptError{Count: count, Reason: fmt.Sprintf("records end at %d, the slot directory starts at %d", end, dir)}
+	}
+	records := make([]Record, 0, count)
+	for i := 0; i < count; i++ {
+		at := limit - (i+1)*SlotSize
+		offset := int(binary.LittleEndian.Uint16(data[at : at+SlotSize]))
+		if offset < SlottedHeaderSize || offset+RecordHeaderSize > end {
+			return records, &CorruptError{Record: i, Count: count, Offset: offset, Reason: "slot points outside the records"}
+		}
+		keyLen := int(binary.LittleEndian.Uint16(data[offset : offset+2]))
+		valueLen := int(binary.LittleEndian.Uint16(data[offset+2 : offset+4]))
+		start := offset + RecordHeaderSize
+		if start+keyLen+valueLen > end {
+			return records, &CorruptError{Record: i, Count: count, Offset: offset,
+				Reason: fmt.Sprintf("needs %d bytes, the records end at %d", RecordHeaderSize+keyLen+valueLen, end)}
+		}
+		r := Record{Key: data[start : start+keyLen], Value: data[start+keyLen : start+keyLen+valueLen], Offset: offset}
+		if i > 0 && bytes.Compare(records[i-1].Key, r.Key) > 0 {
+			return records, &CorruptError{Record: i, Count: count, Offset: offset, Reason: "slot out of key order"}
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// IsColdPage reports whether a raw page is the stub of a page that was moved
+// to the cold tier.
+func IsColdPage(data []byte) bool {
+	return len(data) >= RecordCountSize && binary.LittleEndian.Uint16(data[0:2]) == ColdPageCount
+}
+
+// EncodePage builds a page holding records, for tools that rewrite pages.
+func EncodePage(records []Record) ([]byte, error) {
+	return encodePage(records, PageSize)
+}
+
+// EncodeChecksummedPage builds a page for a file with FlagPageChecksums, the
+// records leave room for the checksum, which is filled in.
+func EncodeChecksummedPage(records []Record) ([]byte, error) {
+	data, err := encodePage(records, PageSize-ChecksumSize)
+	if err != nil {
+		return nil, err
+	}
+	StampChecksum(data)
+	return data, nil
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksum returns the checksum of data in a file with the given header
+// flags: CRC32 (IEEE), CRC32C with FlagCRC32C, the low 32 bits of xxHash64
+// with FlagXXHash64. the WAL checksums its entries with it too.
+func Checksum(data []byte, flags uint32) uint32 {
+	switch {
+	case flags&FlagXXHash64 != 0:
+		return uint32(XXHash64(data))
+	case flags&FlagCRC32C != 0:
+		return crc32.Checksum(data, castagnoli)
+	}
+	return crc32.ChecksumIEEE(data)
+}
+
+func encodePage(records []Record, limit int) ([]byte, error) {
+	data := make([]byte, PageSize)
+	if len(records) > 0xFFFF {
+		return nil, fmt.Errorf("%d records don't fit in a page", len(records))
+	}
+	binary.LittleEndian.PutUint16(data[0:2], uint16(len(records)))
+	offset := RecordCountSize
+	for i, r := range records {
+		if len(r.Key) > 0xFFFF || len(r.Value) > 0xFFFF {
+			return nil, fmt.Errorf("record %d: key or value longer than 65535 bytes", i)
+		}
+		end := offset + RecordHeaderSize + len(r.Key) + len(r.Value)
+		if end > limit {
+			return nil, fmt.Errorf("record %d: page full after %d bytes", i, offset)
+		}
+		binary.LittleEndian.PutUint16(data[offset:offset+2], uint16(len(r.Key)))
+		binary.LittleEndian.PutUint16(data[offset+2:offset+4], uint16(len(r.Value)))
+		copy(data[offset+RecordHeaderSize:], r.Key)
+		copy(data[offset+RecordHeaderSize+len(r.Key):], r.Value)
+		offset = end
+	}
+	return data, nil
+}
+
+// EncodePageFlags builds a page for a file with the given header flags,
+// slotted and checksummed as they say.
+func EncodePageFlags(records []Record, flags uint32) ([]byte, error) {
+	checksums := flags&FlagPageChecksums != 0
+	limit := recordsLimit(flags)
+	if flags&FlagSlottedPages == 0 {
+		data, err := encodePage(records, limit)
+		if err == nil && checksums {
+			StampChecksumFlags(data, flags)
+		}
+		return data, err
+	}
+	if len(records) > 0xFFFF {
+		return nil, fmt.Errorf("%d records don't fit in a page", len(records))
+	}
+	data := make([]byte, PageSize)
+	slots := make([]int, len(records))
+	offset := SlottedHeaderSize
+	for i, r := range records {
+		if len(r.Key) > 0xFFFF || len(r.Value) > 0xFFFF {
+			return nil, fmt.Errorf("record %d: key or value longer than 65535 bytes", i)
+		}
+		end := offset + RecordHeaderSize + len(r.Key) + len(r.Value)
+		if end+len(records)*SlotSize > limit {
+			return nil, fmt.Errorf("record %d: page full after %d bytes", i, offset)
+		}
+		binary.LittleEndian.PutUint16(data[offset:offset+2], uint16(len(r.Key)))
+		binary.LittleEndian.PutUint16(data[offset+2:offset+4], uint16(len(r.Value)))
+		copy(data[offset+RecordHeaderSize:], r.Key)
+		copy(data[offset+RecordHeaderSize+len(r.Key):], r.Value)
+		slots[i] = offset
+		offset = end
+	}
+	binary.LittleEndian.PutUint16(data[0:2], uint16(len(records)))
+	binary.LittleEndian.PutUint16(data[2:4], uint16(offset))
+	// the records stay in the order given, the slots go in key order
+	sort.SliceStable(slots, func(i, j int) bool {
+		return bytes.Compare(keyAt(data, slots[i]), keyAt(data, slots[j])) < 0
+	})
+	for i, slot := range slots {
+		at := limit - (i+1)*SlotSize
+		binary.LittleEndian.PutUint16(data[at:at+SlotSize], uint16(slot))
+	}
+	if checksums {
+		StampChecksumFlags(data, flags)
+	}
+	return data, nil
+}
+
+// where the records (and slots) of a page of a file with flags have to end
+func recordsLimit(flags uint32) int {
+	limit := PageSize
+	if flags&FlagPageChecksums != 0 {
+		limit -= ChecksumSize
+	}
+	if flags&FlagPageLSN != 0 {
+		limit -= PageLSNSize
+	}
+	return limit
+}
+
+// PageLSN returns the LSN a page of a file with FlagPageLSN was written at,
+// 0 for a page that never was or a file without them.
+func PageLSN(data []byte, flags uint32) uint64 {
+	if flags&FlagPageLSN == 0 || len(data) < PageSize {
+		return 0
+	}
+	at := recordsLimit(flags)
+	return binary.LittleEndian.Uint64(data[at : at+PageLSNSize])
+}
+
+// SetPageLSN writes lsn into a page of a file with FlagPageLSN, before its
+// checksum is stamped.
+func SetPageLSN(data []byte, flags uint32, lsn uint64) {
+	if flags&FlagPageLSN == 0 {
+		return
+	}
+	at := recordsLimit(flags)
+	binary.LittleEndian.PutUint64(data[at:at+PageLSNSize], lsn)
+}
+
+// the key of the record at offset
+func keyAt(data []byte, offset int) []byte {
+	keyLen := int(binary.LittleEndian.Uint16(data[offset : offset+2]))
+	return data[offset+RecordHeaderSize : offset+RecordHeaderSize+keyLen]
+}
+
+// StampChecksum writes the checksum of a PageSize page into its last bytes.
+func StampChecksum(data []byte) {
+	StampChecksumFlags(data, FlagPageChecksums)
+}
+
+// StampChecksumFlags is StampChecksum for a file with the given header flags.
+func StampChecksumFlags(data []byte, flags uint32) {
+	end := PageSize - ChecksumSize
+	binary.LittleEndian.PutUint32(data[end:PageSize], Checksum(data[:end], flags))
+}
+
+// CheckChecksum reports whether a PageSize page matches its checksum, the
+// error wraps ErrChecksum.
+func CheckChecksum(data []byte) error {
+	return CheckChecksumFlags(data, FlagPageChecksums)
+}
+
+// CheckChecksumFlags is CheckChecksum for a file with the given header flags.
+func CheckChecksumFlags(data []byte, flags uint32) error {
+	end := PageSize - ChecksumSize
+	stored := binary.LittleEndian.Uint32(data[end:PageSize])
+	computed := Checksum(data[:end], flags)
+	if stored == computed {
+		return nil
+	}
+	if stored == 0 && allZero(data[:end]) {
+		return nil // never written
+	}
+	return fmt.Errorf("%w: stored %08x, computed %08x", ErrChecksum, stored, computed)
+}
+
+func allZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
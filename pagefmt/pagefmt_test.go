@@ -0,0 +1,156 @@
+package pagefmt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEncodeParseRoundTrip(t *testing.T) {
+	records := []Record{
+		{Key: []byte("user:1"), Value: []byte("isabella")},
+		{Key: []byte("user:2"), Value: []byte{}},
+		{Key: []byte("blob"), Value: []byte{0, 1, 0xff}},
+	}
+	page, err := EncodePage(records)
+	if err != nil {
+		t.Fatalf("EncodePage failed: %v", err)
+	}
+
+	got, err := ParsePage(page)
+	if err != nil {
+		t.Fatalf("ParsePage failed: %v", err)
+	}
+	if len(got) != len(records) {
+		t.Fatalf("Expected %d records, got %d", len(records), len(got))
+	}
+	for i := range records {
+		if !bytes.Equal(got[i].Key, records[i].Key) || !bytes.Equal(got[i].Value, records[i].Value) {
+			t.Errorf("Record %d: got %q=%q", i, got[i].Key, got[i].Value)
+		}
+	}
+	if got[1].Offset != RecordCountSize+RecordHeaderSize+len("user:1")+len("isabella") {
+		t.Errorf("Unexpected offset %d for the second record", got[1].Offset)
+	}
+}
+
+func TestParsePage_Corrupt(t *testing.T) {
+	page, _ := EncodePage([]Record{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("b"), Value: []byte("2")}})
+	page[10], page[11] = 0xff, 0xff // second record (at 2+4+1+1 = 8), its value length
+
+	records, err := ParsePage(page)
+	var ce *CorruptError
+	if !errors.As(err, &ce) || ce.Record != 1 || ce.Offset != 8 {
+		t.Fatalf("Expected the second record to be reported, got %v", err)
+	}
+	if len(records) != 1 || string(records[0].Key) != "a" {
+		t.Errorf("Expected the records before the bad one, got %v", records)
+	}
+}
+
+func TestParsePage_ColdStub(t *testing.T) {
+	page := make([]byte, PageSize)
+	page[0], page[1] = 0xff, 0xff
+	if records, err := ParsePage(page); !IsColdPage(page) || len(records) != 0 || err != nil {
+		t.Errorf("Expected a stub without records, got %v, %v", records, err)
+	}
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	h := Header{Magic: Magic, Version: Version, PageSize: PageSize, TotalPages: 3, NextPageID: 3, LastLSN: 42, AppliedLSN: 7}
+	got, err := ParseHeader(h.Encode())
+	if err != nil || got != h {
+		t.Errorf("Expected %+v, got %+v (%v)", h, got, err)
+	}
+
+	h.Magic = 0
+	if _, err := ParseHeader(h.Encode()); err == nil {
+		t.Errorf("Expected a bad magic number to be rejected")
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	page, err := EncodeChecksummedPage([]Record{{Key: []byte("a"), Value: []byte("1")}})
+	if err != nil {
+		t.Fatalf("EncodeChecksummedPage failed: %v", err)
+	}
+	if err := CheckChecksum(page); err != nil {
+		t.Errorf("Expected a fresh page to check out, got %v", err)
+	}
+	page[7] ^= 0x10
+	if err := CheckChecksum(page); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Expected ErrChecksum after a bit flip, got %v", err)
+	}
+	if err := CheckChecksum(make([]byte, PageSize)); err != nil {
+		t.Errorf("Expected a page that was never written to pass, got %v", err)
+	}
+
+	// the records have to leave room for the checksum
+	big := []Record{{Key: []byte("k"), Value: make([]byte, PageSize-RecordCountSize-RecordHeaderSize-1)}}
+	if _, err := EncodePage(big); err != nil {
+		t.Errorf("Expected a full page without checksum, got %v", err)
+	}
+	if _, err := EncodeChecksummedPage(big); err == nil {
+		t.Error("Expected a record running into the checksum to be refused")
+	}
+}
+
+func TestSlottedPageRoundTrip(t *testing.T) {
+	flags := FlagPageChecksums | FlagSlottedPages
+	records := []Record{
+		{Key: []byte("user:2"), Value: []byte("cam")},
+		{Key: []byte("user:1"), Value: []byte("isabella")},
+	}
+	page, err := EncodePageFlags(records, flags)
+	if err != nil {
+		t.Fatalf("EncodePageFlags failed: %v", err)
+	}
+	if err := CheckChecksum(page); err != nil {
+		t.Errorf("Expected the page checksummed, got %v", err)
+	}
+	got, err := ParsePageFlags(page, flags)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("ParsePageFlags = %d records, %v", len(got), err)
+	}
+	// in key order, the records themselves stay where they were put
+	if string(got[0].Key) != "user:1" || got[0].Offset <= got[1].Offset {
+		t.Errorf("Expected user:1 first by slot and second on the page, got %q at %d", got[0].Key, got[0].Offset)
+	}
+
+	// swapping the two slots breaks the key order
+	at := PageSize - ChecksumSize - 2*SlotSize
+	copy(page[at:], []byte{page[at+2], page[at+3], page[at], page[at+1]})
+	var corrupt *CorruptError
+	if _, err := ParsePageFlags(page, flags); !errors.As(err, &corrupt) || corrupt.Record != 1 {
+		t.Errorf("Expected record 1 out of order, got %v", err)
+	}
+	if _, err := ParsePageFlags(make([]byte, PageSize), flags); err != nil {
+		t.Errorf("Expected a page that was never written to parse, got %v", err)
+	}
+}
+
+func TestXXHash64(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		want  uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"asdf", 0x415872f599cea71e},
+		{"Call me Ishmael. Some years ago--never mind how long precisely-", 0x02a2e85470d6fd96},
+	} {
+		if got := XXHash64([]byte(tt.input)); got != tt.want {
+			t.Errorf("XXHash64(%q) = %016x, want %016x", tt.input, got, tt.want)
+		}
+	}
+
+	page, _ := EncodePage([]Record{{Key: []byte("a"), Value: []byte("1")}})
+	flags := FlagPageChecksums | FlagXXHash64
+	StampChecksumFlags(page, flags)
+	if err := CheckChecksumFlags(page, flags); err != nil {
+		t.Errorf("Expected the page to check out, got %v", err)
+	}
+	if err := CheckChecksum(page); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Expected a CRC32 check to fail, got %v", err)
+	}
+}
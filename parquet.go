@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Parquet export, so exported data can be queried straight from DuckDB or
+// Spark. this is a deliberately small writer for exactly one schema:
+//
+//	key       BYTE_ARRAY (UTF8 when every key is valid UTF-8)
+//	value     BYTE_ARRAY (UTF8 when every value is valid UTF-8)
+//	size      INT64      length of the value in bytes
+//	timestamp INT64      TIMESTAMP_MILLIS
+//
+// every column is REQUIRED, PLAIN encoded and uncompressed, one data page per
+// column per row group. records don't carry their own write time, so
+// timestamp is when the snapshot was taken, the same for every row. the
+// snapshot LSN goes in the file's key/value metadata as "godata.lsn".
+//
+// file layout:
+//
+//	"PAR1" [row group: page header + page, one per column]... footer len(footer) "PAR1"
+//
+// the page headers and the footer are thrift structs in the compact protocol.
+
+const parquetRowsPerGroup = 64 * 1024
+
+// parquet enums, numbered like parquet.thrift
+const (
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetRequired = 0
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	parquetDataPage = 0
+)
+
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32 // -1 means no converted type
+}
+
+// ExportParquet writes the same snapshot Export does as a Parquet file.
+func (s *Storage) ExportParquet(w io.Writer) (ExportHeader, error) {
+	lsn, records, err := s.snapshotRecords(true)
+	if err != nil {
+		return ExportHeader{}, err
+	}
+	header := ExportHeader{
+		Format:  "parquet",
+		Version: 1,
+		LSN:     lsn,
+		Records: len(records),
+		Created: s.clock().Now().UTC(),
+	}
+
+	keysUTF8, valuesUTF8 := true, true
+	for _, r := range records {
+		keysUTF8 = keysUTF8 && utf8.ValidString(r.key)
+		valuesUTF8 = valuesUTF8 && utf8.ValidString(r.value)
+	}
+	columns := []parquetColumn{
+		{"key", parquetByteArray, utf8Converted(keysUTF8)},
+		{"value", parquetByteArray, utf8Converted(valuesUTF8)},
+		{"size", parquetInt64, -1},
+		{"timestamp", parquetInt64, parquetTimestampMillis},
+	}
+	millis := header.Created.UnixMilli()
+
+	pw := &parquetWriter{w: bufio.NewWriter(w)}
+	pw.write([]byte("PAR1"))
+
+	// an empty export still gets one (empty) row group
+	var groups []parquetRowGroup
+	for start := 0; ; start += parquetRowsPerGroup {
+		end := min(start+parquetRowsPerGroup, len(records))
+		rows := records[start:end]
+
+		group := parquetRowGroup{rows: int64(len(rows))}
+		for c := range columns {
+			var page []byte
+			for _, r := range rows {
+				switch c {
+				case 0:
+					page = binary.LittleEndian.AppendUint32(page, uint32(len(r.key)))
+					page = append(page, r.key...)
+				case 1:
+					page = binary.LittleEndian.AppendUint32(page, uint32(len(r.value)))
+					page = append(page, r.value...)
+				case 2:
+					page = binary.LittleEndian.AppendUint64(page, uint64(len(r.value)))
+				case 3:
+					page = binary.LittleEndian.AppendUint64(page, uint64(millis))
+				}
+			}
+			group.chunks = append(group.chunks, pw.writeColumnChunk(len(rows), page))
+		}
+		groups = append(groups, group)
+		if end == len(records) {
+			break
+		}
+	}
+
+	footer := parquetFooter(columns, groups, int64(len(records)), lsn)
+	pw.write(footer)
+	pw.write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer))))
+	pw.write([]byte("PAR1"))
+	if pw.err == nil {
+		pw.err = pw.w.Flush()
+	}
+	if pw.err != nil {
+		return header, fmt.Errorf("parquet export: %w", pw.err)
+	}
+	return header, nil
+}
+
+func utf8Converted(valid bool) int32 {
+	if valid {
+		return parquetUTF8
+	}
+	return -1
+}
+
+// keeps track of the file offset, the footer points at every page
+type parquetWriter struct {
+	w      *bufio.Writer
+	offset int64
+	err    error
+}
+
+func (pw *parquetWriter) write(p []byte) {
+	if pw.err != nil {
+		return
+	}
+	n, err := pw.w.Write(p)
+	pw.offset += int64(n)
+	pw.err = err
+}
+
+type parquetChunk struct {
+	offset int64 // where the page header starts
+	values int64
+	size   int64 // page header + page
+}
+
+type parquetRowGroup struct {
+	rows   int64
+	chunks []parquetChunk
+}
+
+func (pw *parquetWriter) writeColumnChunk(values int, page []byte) parquetChunk {
+	var t thriftWriter
+	t.beginStruct() // PageHeader
+	t.i32(1, parquetDataPage)
+	t.i32(2, int32(len(page))) // uncompressed
+	t.i32(3, int32(len(page))) // compressed, the same, there's no codec
+	t.structField(5)           // DataPageHeader
+	t.i32(1, int32(values))
+	t.i32(2, parquetPlain)
+	t.i32(3, parquetRLE) // definition levels, none written for REQUIRED columns
+	t.i32(4, parquetRLE) // repetition levels, same
+	t.endStruct()
+	t.endStruct()
+
+	chunk := parquetChunk{offset: pw.offset, values: int64(values), size: int64(len(t.buf) + len(page))}
+	pw.write(t.buf)
+	pw.write(page)
+	return chunk
+}
+
+func parquetFooter(columns []parquetColumn, groups []parquetRowGroup, rows int64, lsn uint64) []byte {
+	var t thriftWriter
+	t.beginStruct() // FileMetaData
+	t.i32(1, 1)
+
+	t.list(2, thriftStruct, len(columns)+1) // schema, the root first
+	t.beginStruct()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(columns)))
+	t.endStruct()
+	for _, c := range columns {
+		t.beginStruct()
+		t.i32(1, c.typ)
+		t.i32(3, parquetRequired)
+		t.binary(4, c.name)
+		if c.converted >= 0 {
+			t.i32(6, c.converted)
+		}
+		t.endStruct()
+	}
+
+	t.i64(3, rows)
+
+	t.list(4, thriftStruct, len(groups))
+	for _, g := range groups {
+		t.beginStruct() // RowGroup
+		t.list(1, thriftStruct, len(g.chunks))
+		var total int64
+		for i, chunk := range g.chunks {
+			total += chunk.size
+			t.beginStruct() // ColumnChunk
+			t.i64(2, chunk.offset)
+			t.structField(3) // ColumnMetaData
+			t.i32(1, columns[i].typ)
+			t.list(2, thriftI32, 2)
+			t.listI32(parquetPlain)
+			t.listI32(parquetRLE)
+			t.list(3, thriftBinary, 1)
+			t.listBinary(columns[i].name)
+			t.i32(4, 0) // UNCOMPRESSED
+			t.i64(5, chunk.values)
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64(2, total)
+		t.i64(3, g.rows)
+		t.endStruct()
+	}
+
+	t.list(5, thriftStruct, 1) // key_value_metadata
+	t.beginStruct()
+	t.binary(1, "godata.lsn")
+	t.binary(2, fmt.Sprint(lsn))
+	t.endStruct()
+
+	t.binary(6, "godata")
+	t.endStruct()
+	return t.buf
+}
+
+// just enough of the thrift compact protocol to write the structs above
+type thriftWriter struct {
+	buf  []byte
+	last []int16 // id of the last field written, one entry per open struct
+}
+
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+func (t *thriftWriter) beginStruct() { t.last = append(t.last, 0) }
+
+func (t *thriftWriter) endStruct() {
+	t.buf = append(t.buf, 0) // stop field
+	t.last = t.last[:len(t.last)-1]
+}
+
+// field ids go in the high nibble as a delta from the previous field when they can
+func (t *thriftWriter) field(id int16, typ byte) {
+	top := &t.last[len(t.last)-1]
+	if delta := id - *top; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.buf = binary.AppendVarint(t.buf, int64(id))
+	}
+	*top = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.buf = binary.AppendVarint(t.buf, int64(v)) // zigzag, like thrift
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.buf = binary.AppendVarint(t.buf, v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.listBinary(s)
+}
+
+// starts a nested struct field, close it with endStruct
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.beginStruct()
+}
+
+// starts a list field, the elements follow (structs with beginStruct)
+func (t *thriftWriter) list(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elem)
+	} else {
+		t.buf = append(t.buf, 0xF0|elem)
+		t.buf = binary.AppendUvarint(t.buf, uint64(n))
+	}
+}
+
+func (t *thriftWriter) listI32(v int32) { t.buf = binary.AppendVarint(t.buf, int64(v)) }
+
+func (t *thriftWriter) listBinary(s string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
@@ -0,0 +1,20 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package main
+
+import "os"
+
+// no whole-file lock available here, callers have to make sure only
+// one process opens the database
+func lockFile(f *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
+
+// no error number to tell a full disk by, it fails like any other write
+func isDiskFull(err error) bool {
+	return false
+}
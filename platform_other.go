@@ -0,0 +1,16 @@
+//go:build !linux && !windows
+
+package main
+
+import "os"
+
+// os.File.Sync already does the right thing here
+// (on macOS it uses F_FULLFSYNC so the drive's own cache is flushed too)
+func syncFile(f *os.File) error {
+	return f.Sync()
+}
+
+// no portable way to reserve blocks, the file just grows as pages are written
+func preallocate(f *os.File, size int64) error {
+	return nil
+}
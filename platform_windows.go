@@ -0,0 +1,73 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// LockFileEx and SetFileInformationByHandle aren't wrapped by the syscall
+// package, so they are loaded from kernel32 directly
+var (
+	kernel32                       = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx                 = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx               = kernel32.NewProc("UnlockFileEx")
+	procSetFileInformationByHandle = kernel32.NewProc("SetFileInformationByHandle")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+	errorHandleDiskFull     = syscall.Errno(39)
+	errorDiskFull           = syscall.Errno(112)
+	fileAllocationInfo      = 5 // FILE_INFO_BY_HANDLE_CLASS.FileAllocationInfo
+)
+
+// locks the whole file (every byte, offset 0 length 2^64-1)
+func lockFile(f *os.File, exclusive bool) error {
+	var flags uint32 = lockfileFailImmediately
+	if exclusive {
+		flags |= lockfileExclusiveLock
+	}
+	var ol syscall.Overlapped
+	r, _, err := procLockFileEx.Call(f.Fd(), uintptr(flags), 0, 0xFFFFFFFF, 0xFFFFFFFF, uintptr(unsafe.Pointer(&ol)))
+	if r == 0 {
+		if errors.Is(err, errorLockViolation) {
+			return ErrLocked
+		}
+		return err
+	}
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	var ol syscall.Overlapped
+	r, _, err := procUnlockFileEx.Call(f.Fd(), 0, 0xFFFFFFFF, 0xFFFFFFFF, uintptr(unsafe.Pointer(&ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+func isDiskFull(err error) bool {
+	return errors.Is(err, errorDiskFull) || errors.Is(err, errorHandleDiskFull)
+}
+
+// FlushFileBuffers is the Windows fsync, it also flushes the drive cache
+func syncFile(f *os.File) error {
+	return syscall.FlushFileBuffers(syscall.Handle(f.Fd()))
+}
+
+// sets the allocation size (not the end of file) so the blocks are reserved
+func preallocate(f *os.File, size int64) error {
+	info := struct{ AllocationSize int64 }{size}
+	r, _, err := procSetFileInformationByHandle.Call(f.Fd(), fileAllocationInfo, uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import "sort"
+
+// Prefetch starts loading the pages holding keys into the page cache in the
+// background, so a request handler that knows its key set up front can warm
+// the cache while it does other work:
+//
+//	db.Prefetch([]string{"user:1", "cart:1", "prefs:1"})
+//	... authenticate, parse the request ...
+//	db.Get("user:1") → cache hit (or waits for the read already in flight)
+//
+// unknown keys and pages already cached are skipped. read errors are ignored,
+// the Get that needs the page will run into them again and report them.
+// the returned channel is closed once every page is loaded, callers that
+// just want the warm-up can ignore it.
+func (s *Storage) Prefetch(keys []string) <-chan struct{} {
+	// the index is read here, not in the goroutine, a Put running later must not race with it
+	wanted := make(map[uint32]bool)
+	s.mu.RLock()
+	s.cacheMu.Lock()
+	for _, key := range keys {
+		// a failed lookup is skipped like a failed read
+		if pageID, exists, _ := s.lookup(key); exists && s.pages[pageID] == nil {
+			wanted[pageID] = true
+		}
+	}
+	s.cacheMu.Unlock()
+	s.mu.RUnlock()
+
+	pageIDs := make([]uint32, 0, len(wanted))
+	for pageID := range wanted {
+		pageIDs = append(pageIDs, pageID)
+	}
+	// in file order, so the reads are as sequential as they can be
+	sort.Slice(pageIDs, func(i, j int) bool { return pageIDs[i] < pageIDs[j] })
+	return s.loadPagesInBackground(pageIDs)
+}
+
+// loads pageIDs into the cache one after the other, the channel is closed
+// when they are all done
+func (s *Storage) loadPagesInBackground(pageIDs []uint32) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, pageID := range pageIDs {
+			// a compaction in between swaps the file, the read has to wait for it
+			s.mu.RLock()
+			s.loadPage(pageID)
+			s.mu.RUnlock()
+		}
+	}()
+	return done
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// opening from a reader: a database can be read from any io.ReaderAt instead
+// of a file, to ship a dataset inside another artifact: embedded in the
+// binary with go:embed, an object in object storage read with HTTP range
+// requests (NewStorageFromURL), a section of an archive:
+//
+//	//go:embed countries.db
+//	var countries []byte
+//
+//	db, err := NewStorageFromReader(bytes.NewReader(countries), int64(len(countries)))
+//	name, err := db.Get("country:PT")
+//
+// such a storage is read-only (Options.ReadOnly) and otherwise like one
+// opened from a file: the index is built when it opens, which reads every
+// page once, and pages are cached as they are read (Options.CacheSize bounds
+// that). what would live in files next to the database is off: no WAL, no
+// IndexFile, BTreeIndex or warm set, no Refresh. over HTTP every page read is
+// a request, a dataset that's read a lot wants a CacheSize that holds it.
+
+// NewStorageFromReader opens the read-only database in the first size bytes
+// of r, see reader.go.
+func NewStorageFromReader(r io.ReaderAt, size int64) (*Storage, error) {
+	return NewStorageFromReaderWithOptions(r, size, DefaultOptions())
+}
+
+// NewStorageFromReaderWithOptions is NewStorageFromReader with options, the
+// pipeline (Compress, Transformers) has to be the one the database was
+// written with.
+func NewStorageFromReaderWithOptions(r io.ReaderAt, size int64, opts Options) (*Storage, error) {
+	start := clockOf(opts).Now()
+	if size < HeaderSize {
+		return nil, fmt.Errorf("%w: %d bytes, too short for a database", ErrCorrupted, size)
+	}
+	opts.ReadOnly = true
+	// there is no file to put them next to
+	opts.IndexFile, opts.BTreeIndex, opts.DirectIO, opts.WarmPages = false, false, false, 0
+
+	storage := newStorage(opts)
+	storage.source = io.NewSectionReader(r, 0, size)
+	if err := storage.loadHeader(); err != nil {
+		return nil, err
+	}
+	if err := storage.loadIndex(context.Background()); err != nil {
+		return nil, err
+	}
+	storage.finishOpen(start)
+	storage.startTiering()
+	storage.startWarmUp()
+	return storage, nil
+}
+
+// NewStorageFromURL opens the read-only database at url, reading it with
+// HTTP range requests. the server has to answer a HEAD with the size and
+// support ranges, object stores and http.FileServer do.
+func NewStorageFromURL(url string, opts Options) (*Storage, error) {
+	r := &httpReaderAt{client: http.DefaultClient, url: url}
+	size, err := r.size()
+	if err != nil {
+		return nil, err
+	}
+	return NewStorageFromReaderWithOptions(r, size, opts)
+}
+
+// an io.ReaderAt over HTTP, one range request per read
+type httpReaderAt struct {
+	client *http.Client
+	url    string
+}
+
+func (r *httpReaderAt) size() (int64, error) {
+	resp, err := r.client.Head(r.url)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("HEAD %s: %s", r.url, resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("HEAD %s: no Content-Length", r.url)
+	}
+	return resp.ContentLength, nil
+}
+
+func (r *httpReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(off, 10)+"-"+strconv.FormatInt(off+int64(len(p))-1, 10))
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		return 0, io.EOF
+	default:
+		// a 200 would be the whole object, for every page
+		return 0, fmt.Errorf("GET %s bytes %d-: %s, ranges not supported?", r.url, off, resp.Status)
+	}
+	n, err := io.ReadFull(resp.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF // the range ran past the end
+	}
+	return n, err
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// read-only connections let a reporting job read a live file without going
+// through the process that writes it. the writer opens with
+// Options.AnnounceCheckpoints, the reader with Options.ReadOnly:
+//
+//	writer process                        reader process
+//	Put, Put, Sync ──► orders.db          NewStorageWithOptions(orders.db, ReadOnly)
+//	               └─► orders.db.checkpoint   Get, Get, ...   (view as of open)
+//	Put, Sync ───────► (lsn 1042)         Refresh() ──► reloads, view as of 1042
+//
+// the reader has its own page cache and index, loaded in full when it opens
+// and on every Refresh, so between refreshes it keeps answering from the
+// checkpoint it loaded while the writer carries on. the checkpoint file is
+// written (to a temp file, then renamed) only after the pages and header are
+// on disk, so a Refresh never starts from a half written Sync.
+//
+// the writer doesn't stop while a reader loads. if the header changes during
+// the load, the load is redone, a few times, after that the view can include
+// some changes from after the checkpoint. reads never block the writer.
+//
+// on Windows file locks are mandatory and the writer's lock covers every byte
+// (platform_windows.go), so there a reader can only read while no writer has
+// the file open.
+
+// CheckpointInfo is what the writer announces in <file>.checkpoint.
+type CheckpointInfo struct {
+	LSN        uint64    `json:"lsn"`
+	TotalPages uint32    `json:"total_pages"`
+	Time       time.Time `json:"time"`
+}
+
+const checkpointSuffix = ".checkpoint"
+
+// how many times Refresh reloads when the writer moved underneath it
+const refreshAttempts = 3
+
+// ReadCheckpoint returns the last checkpoint announced for the database file.
+func ReadCheckpoint(filename string) (CheckpointInfo, error) {
+	var info CheckpointInfo
+	data, err := os.ReadFile(filename + checkpointSuffix)
+	if err != nil {
+		return info, fmt.Errorf("read checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, fmt.Errorf("read checkpoint %s: %w", filename+checkpointSuffix, err)
+	}
+	return info, nil
+}
+
+// called by Sync once the pages and the header are on disk
+func (s *Storage) announceCheckpoint() error {
+	info := CheckpointInfo{LSN: s.lsn, TotalPages: s.totalPages, Time: s.clock().Now().UTC()}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	// rename is atomic, a reader sees the old announcement or the new one
+	path := s.file.Name() + checkpointSuffix
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("announce checkpoint: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("announce checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Refresh moves a read-only connection's view to the writer's latest
+// announced checkpoint. it returns false when the view is already there.
+func (s *Storage) Refresh() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.opts.ReadOnly {
+		return false, errors.New("Refresh is for storages opened with Options.ReadOnly")
+	}
+	if s.source != nil {
+		return false, errors.New("Refresh needs a file, the storage was opened from a reader")
+	}
+	info, err := ReadCheckpoint(s.file.Name())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, fmt.Errorf("no checkpoint announced for %s, is the writer opened with Options.AnnounceCheckpoints? %w", s.file.Name(), err)
+		}
+		return false, err
+	}
+	if info.LSN <= s.lsn {
+		return false, nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := s.reload(); err != nil {
+			return false, err
+		}
+		// the header is read again: unchanged means no Sync ran during the load
+		before := s.lsn
+		if err := s.loadHeader(); err != nil {
+			return false, err
+		}
+		if s.lsn == before || attempt == refreshAttempts {
+			return true, nil
+		}
+	}
+}
+
+// throws away the cached pages and index and loads them again from the file
+func (s *Storage) reload() error {
+	s.cacheMu.Lock()
+	s.stats.pagesInvalidated.Add(uint64(len(s.pages)))
+	s.pages = make(map[uint32]*Page)
+	s.pool.forget(s)
+	if s.pageUse != nil {
+		s.pageUse = make(map[uint32]uint64) // the page IDs may mean something else now
+	}
+	if s.pageAccess != nil {
+		s.pageAccess = make(map[uint32]time.Time)
+	}
+	s.cacheMu.Unlock()
+	s.coldMu.Lock()
+	s.coldPages = nil
+	s.coldMu.Unlock()
+	if err := s.resetIndex(); err != nil {
+		return err
+	}
+	s.freePages = nil
+	s.hasTail = false
+	s.values = valueCacheFor(s.opts)
+
+	if err := s.loadHeader(); err != nil {
+		return err
+	}
+	return s.buildIndex(context.Background())
+}
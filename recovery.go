@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"godata/pagefmt"
+)
+
+// every Put and Delete is appended to <file>.wal before a page is touched:
+//
+//	Put("a", "1")  →  WAL: LSN 7 put a=1   →  page 0 in memory  ... Sync → pages, header (LastLSN 7), WAL emptied
+//
+// the WAL write goes to the OS straight away, the pages only on Sync, so a
+// process that dies (kill -9, panic, OOM) between two Syncs loses nothing:
+// the next open replays the WAL entries above the header's LastLSN. the WAL
+// isn't fsynced on every write, a power cut can still lose what wasn't
+// synced, writes made WithSync or under SyncAlways are on disk either way.
+//
+// replaying is safe to repeat: an entry at or below LastLSN is skipped, and
+// a put or delete applied twice in log order ends up the same.
+//
+// LastLSN is the file's, a page can be newer: Sync writes the pages before
+// the header, and a page written for a WithSync write goes out on its own.
+// so every page of a new file carries its own LSN too, in front of its
+// checksum (a header flag, see pagefmt): writePage stamps the LSN of the
+// last write, the page has every change up to it.
+//
+//	WAL:    5 put a=1   6 delete a          page 0 written at LSN 6, no a
+//	replay: a isn't on a page, its last entry is a delete → 5 and 6 skipped
+//
+// recovery goes by the last entry of each key, looked at before anything is
+// replayed (a replayed put changes pages): when the page holding the key
+// was written at that entry's LSN or later, or the last entry is a delete
+// and no page holds the key, the pages have it and none of the key's
+// entries are replayed. replaying the put alone would bring a deleted key
+// back, and an older entry would undo a newer write until the entries
+// after it were replayed too, if they're still there (a power cut before
+// the WAL was fsynced). files from before page LSNs go by record versions
+// when they have them, by LastLSN otherwise.
+
+// opens (creating if needed) the WAL next to the data file and replays it.
+// fresh is set when the data file was just created, a WAL lying around from
+// an older file with the same name has nothing to do with it.
+func (s *Storage) openWAL(fresh bool) error {
+	wal, err := NewWAL(s.file.Name())
+	if err != nil {
+		return err
+	}
+	s.wal = wal
+	wal.checksum = s.checksumAlg
+	if fresh {
+		if err := wal.Truncate(); err != nil {
+			return err
+		}
+	}
+	wal.advanceLSN(s.lsn)
+
+	replayed, err := s.Recover()
+	if err != nil {
+		wal.Close()
+		return err
+	}
+	s.openInfo.Replayed = replayed
+	return nil
+}
+
+// Recover replays the WAL entries that aren't in the pages yet and syncs, it
+// returns how many were applied. opening a storage already does this, after
+// that there is nothing left to replay until the next crash.
+func (s *Storage) Recover() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wal == nil {
+		return 0, nil // read-only, the writer recovers
+	}
+	start, size := s.clock().Now(), s.wal.Size()
+	entries, err := s.wal.ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("recover: %w", err)
+	}
+
+	var fresh []*LogEntry
+	for _, e := range CommittedEntries(entries) {
+		if e.LSN > s.lsn && e.IsData() {
+			fresh = append(fresh, e)
+		}
+	}
+	inPages, err := s.keysInPages(fresh)
+	if err != nil {
+		return 0, fmt.Errorf("recover: %w", err)
+	}
+
+	applied := 0
+	for _, e := range fresh {
+		if inPages[e.Key] {
+			s.lsn = e.LSN
+			continue
+		}
+		switch e.Type {
+		case LogTypePut:
+			err = s.put(e.Key, e.Value, writeOptions{}, e.LSN)
+		case LogTypeDelete:
+			// already gone when the delete made it into the pages before the crash
+			var exists bool
+			if _, exists, err = s.lookup(e.Key); err == nil && !exists {
+				s.lsn = e.LSN
+				continue
+			}
+			if err == nil {
+				err = s.deleteKey(e.Key, writeOptions{}, e.LSN)
+			}
+		}
+		if err != nil {
+			return applied, fmt.Errorf("recover: replaying LSN %d (%s %q): %w", e.LSN, LogTypeName(e.Type), e.Key, err)
+		}
+		applied++
+	}
+	if len(entries) > 0 {
+		// the replayed pages and the new LastLSN go to disk, then the WAL is emptied
+		err = s.sync()
+	}
+	if err == nil {
+		s.stats.recordTiming(&s.stats.lastRecovery, start, size)
+	}
+	return applied, err
+}
+
+// the keys whose last entry the pages have already, none of their entries
+// need replaying
+func (s *Storage) keysInPages(entries []*LogEntry) (map[string]bool, error) {
+	last := make(map[string]*LogEntry)
+	for _, e := range entries {
+		last[e.Key] = e
+	}
+	inPages := make(map[string]bool)
+	for key, e := range last {
+		lsn, exists, err := s.writtenAt(key)
+		if err != nil {
+			return nil, fmt.Errorf("LSN %d (%s %q): %w", e.LSN, LogTypeName(e.Type), key, err)
+		}
+		if (exists && lsn >= e.LSN) || (!exists && e.Type == LogTypeDelete) {
+			inPages[key] = true
+		}
+	}
+	return inPages, nil
+}
+
+// the LSN the record of key was on disk at: its page's, or in a file from
+// before page LSNs the record's version. 0 when the file has neither.
+func (s *Storage) writtenAt(key string) (uint64, bool, error) {
+	pageID, exists, err := s.lookup(key)
+	if err != nil || !exists {
+		return 0, exists, err
+	}
+	switch {
+	case s.pageLSNs:
+		page, err := s.loadPage(pageID)
+		if err != nil {
+			return 0, true, err
+		}
+		return page.lsn, true, nil
+	case s.versioned:
+		return s.recordVersion(key)
+	}
+	return 0, true, nil
+}
+
+func hasPageLSNs(flags uint32) bool {
+	return flags&pagefmt.FlagPageLSN != 0
+}
+
+// the LSN a page read from disk was written at
+func (s *Storage) pageLSN(data []byte) uint64 {
+	return pagefmt.PageLSN(data, s.headerFlags())
+}
+
+// called by writePage before the checksum, the page has every change up to lsn
+func (p *Page) stampLSN(lsn uint64) {
+	if !p.hasLSN {
+		return
+	}
+	binary.LittleEndian.PutUint64(p.Data[p.capacity():], lsn)
+	p.lsn = lsn
+}
+
+// every change goes to the WAL before it touches a page. lsn is non-zero when
+// recovery replays an entry that is in the WAL already.
+func (s *Storage) logWrite(typ byte, key, value string, lsn uint64) error {
+	if lsn == 0 {
+		var err error
+		if lsn, err = s.wal.Append(typ, key, value); err != nil {
+			return s.diskFullError(fmt.Errorf("log %s %q: %w", LogTypeName(typ), key, err))
+		}
+		crashPoint(CrashAfterWALAppend, nil)
+		s.nudgeCheckpointer()
+	}
+	s.lsn = lsn
+	return nil
+}
+
+// with SyncGroupCommit a write waits here, after letting go of s.mu, until
+// lsn (the last entry it logged) is on disk
+func (s *Storage) awaitCommit(lsn uint64, opts []WriteOption) error {
+	if s.wal == nil || !s.resolveWriteOptions(opts).commit {
+		return nil
+	}
+	synced, err := s.wal.SyncTo(lsn)
+	if err != nil {
+		return fmt.Errorf("commit LSN %d: %w", lsn, err)
+	}
+	s.stats.groupCommits.Add(1)
+	if synced {
+		s.stats.groupSyncs.Add(1)
+	}
+	return nil
+}
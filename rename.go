@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// renaming: a rename is a delete of the old key and a put of the new one.
+// done as two calls a crash can land in between and lose the value (or keep
+// both), so Rename and RenamePrefix log all their deletes and puts as one
+// WAL transaction and only touch the pages once its commit is logged:
+//
+//	WAL: TxBegin(12)  delete a  put b=1  TxCommit(12)  →  pages
+//
+// recovery applies a transaction whole or not at all (CommittedEntries), so
+// after a crash the keys are either all under their old names or all under
+// their new ones. with WithSync (or SyncAlways) the WAL is fsynced after the
+// commit, which is what makes the rename survive a power cut.
+//
+// a rename never overwrites, a new name that already belongs to a key that
+// isn't moving fails the whole call with ErrKeyExists before anything is
+// logged. Copy is here too, it's a single put and needs no transaction.
+//
+// Swap and MultiSwap exchange values between keys, one transaction of puts,
+// for rebalancing without a moment where a reader finds a value under two
+// keys or under none:
+//
+//	db.Swap("slot:1", "slot:2")
+//	db.MultiSwap(map[string]string{"a": "b", "b": "c", "c": "a"}) // a's value goes to b, ...
+
+// a key on its way to a new name, value already encoded for the new name
+type keyMove struct {
+	from, to string
+	stored   string
+}
+
+// Rename moves the value of oldKey to newKey.
+func (s *Storage) Rename(oldKey, newKey string, opts ...WriteOption) error {
+	s.lockForWrite()
+	err := s.rename(oldKey, newKey, opts)
+	lsn := s.lsn
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.awaitCommit(lsn, opts)
+}
+
+func (s *Storage) rename(oldKey, newKey string, opts []WriteOption) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	if _, exists, err := s.lookup(oldKey); err != nil {
+		return err
+	} else if !exists {
+		return ErrKeyNotFound
+	}
+	if oldKey == newKey {
+		return nil
+	}
+	return s.moveKeys([]string{oldKey}, func(string) string { return newKey }, opts)
+}
+
+// RenamePrefix gives every key under oldPrefix newPrefix instead and returns
+// how many keys it moved, "user:1" → "member:1" for ("user:", "member:").
+func (s *Storage) RenamePrefix(oldPrefix, newPrefix string, opts ...WriteOption) (int, error) {
+	s.lockForWrite()
+	n, err := s.renamePrefix(oldPrefix, newPrefix, opts)
+	lsn := s.lsn
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return n, s.awaitCommit(lsn, opts)
+}
+
+func (s *Storage) renamePrefix(oldPrefix, newPrefix string, opts []WriteOption) (int, error) {
+	if err := s.checkWritable(); err != nil {
+		return 0, err
+	}
+	var keys []string
+	if err := s.indexPrefix(oldPrefix, func(key string, _ uint32) bool {
+		keys = append(keys, key)
+		return true
+	}); err != nil {
+		return 0, err
+	}
+	if len(keys) == 0 || oldPrefix == newPrefix {
+		return len(keys), nil
+	}
+	rename := func(key string) string { return newPrefix + key[len(oldPrefix):] }
+	if err := s.moveKeys(keys, rename, opts); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// logs and applies the moves of keys as one transaction. the deletes go
+// first, so a new name can be one of the old names being moved away.
+func (s *Storage) moveKeys(keys []string, rename func(string) string, opts []WriteOption) error {
+	if err := s.applyBackpressure(); err != nil {
+		return err
+	}
+	moving := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		moving[key] = true
+	}
+
+	// everything that can fail is checked before the first WAL entry
+	moves := make([]keyMove, 0, len(keys))
+	for _, key := range keys {
+		to := rename(key)
+		if _, exists, err := s.lookup(to); err != nil {
+			return err
+		} else if exists && !moving[to] {
+			return fmt.Errorf("rename %q to %q: %w", key, to, ErrKeyExists)
+		}
+		stored, err := s.storedAs(key, to)
+		if err != nil {
+			return fmt.Errorf("rename %q: %w", key, err)
+		}
+		moves = append(moves, keyMove{from: key, to: to, stored: stored})
+	}
+
+	ops := make([]txOp, 0, 2*len(moves))
+	for _, m := range moves {
+		ops = append(ops, txOp{typ: LogTypeDelete, key: m.from})
+	}
+	for _, m := range moves {
+		ops = append(ops, txOp{typ: LogTypePut, key: m.to, value: m.stored})
+	}
+	if err := s.applyTx(ops, s.resolveWriteOptions(opts)); err != nil {
+		return err
+	}
+	s.stats.deletes.Add(uint64(len(moves)))
+	s.stats.puts.Add(uint64(len(moves)))
+	return nil
+}
+
+// one change of a write that spans several keys
+type txOp struct {
+	typ   byte   // LogTypePut or LogTypeDelete
+	key   string // a delete's key has to exist
+	value string // encoded already
+}
+
+// logs ops as one WAL transaction, then applies them in order. whatever can
+// make an op fail (a missing key, a record too big for a page) has to be
+// checked before, once the commit is logged the ops are carried out.
+func (s *Storage) applyTx(ops []txOp, wo writeOptions) error {
+	lsns, commitLSN, err := s.logTx(ops)
+	if err != nil {
+		return err
+	}
+	crashPoint(CrashAfterWALAppend, nil)
+	s.nudgeCheckpointer()
+	if wo.sync {
+		if err := s.wal.Sync(); err != nil {
+			return err
+		}
+	}
+
+	// from here on the WAL has all of it, a failure is repaired by recovery
+	// replaying it
+	for i, op := range ops {
+		switch op.typ {
+		case LogTypePut:
+			err = s.put(op.key, op.value, writeOptions{}, lsns[i])
+		case LogTypeDelete:
+			err = s.deleteKey(op.key, writeOptions{}, lsns[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.lsn = commitLSN
+	return nil
+}
+
+// Copy puts the value of srcKey under dstKey as well, for cloning a template
+// record. like Rename it doesn't overwrite, an existing dstKey fails with
+// ErrKeyExists.
+func (s *Storage) Copy(srcKey, dstKey string, opts ...WriteOption) error {
+	s.lockForWrite()
+	err := s.copyKey(srcKey, dstKey, opts)
+	lsn := s.lsn
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.awaitCommit(lsn, opts)
+}
+
+func (s *Storage) copyKey(srcKey, dstKey string, opts []WriteOption) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	if err := s.applyBackpressure(); err != nil {
+		return err
+	}
+	if _, exists, err := s.lookup(dstKey); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("copy %q to %q: %w", srcKey, dstKey, ErrKeyExists)
+	}
+	value, err := s.getValue(srcKey)
+	if err != nil {
+		return fmt.Errorf("copy %q: %w", srcKey, err)
+	}
+	if m, ok := parseStreamManifest(value); ok {
+		if err := s.copyStream(m, dstKey, s.resolveWriteOptions(opts)); err != nil {
+			return fmt.Errorf("copy %q: %w", srcKey, err)
+		}
+		s.stats.puts.Add(1)
+		return nil
+	}
+	stored, err := s.storedAs(srcKey, dstKey)
+	if err != nil {
+		return fmt.Errorf("copy %q: %w", srcKey, err)
+	}
+	s.stats.puts.Add(1)
+	return s.put(dstKey, stored, s.resolveWriteOptions(opts), 0)
+}
+
+// Swap exchanges the values of k1 and k2, both have to exist.
+func (s *Storage) Swap(k1, k2 string, opts ...WriteOption) error {
+	return s.MultiSwap(map[string]string{k1: k2, k2: k1}, opts...)
+}
+
+// MultiSwap moves the value of every key in moves to the key it maps to, in
+// one transaction. every key has to exist and be the target of exactly one
+// other (or itself), anything else fails with ErrInvalidSwap before
+// anything is logged. it's meant for a handful of keys, all their values are
+// read and encoded while the write lock is held.
+func (s *Storage) MultiSwap(moves map[string]string, opts ...WriteOption) error {
+	s.lockForWrite()
+	err := s.multiSwap(moves, opts)
+	lsn := s.lsn
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.awaitCommit(lsn, opts)
+}
+
+func (s *Storage) multiSwap(moves map[string]string, opts []WriteOption) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	if err := s.applyBackpressure(); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(moves))
+	targeted := make(map[string]string, len(moves))
+	for from, to := range moves {
+		if _, moving := moves[to]; !moving {
+			return fmt.Errorf("swap %q to %q: %w: %q isn't swapped", from, to, ErrInvalidSwap, to)
+		}
+		if other, taken := targeted[to]; taken {
+			return fmt.Errorf("swap %q and %q to %q: %w", other, from, to, ErrInvalidSwap)
+		}
+		targeted[to] = from
+		keys = append(keys, from)
+	}
+	sort.Strings(keys)
+
+	// everything that can fail is checked before the first WAL entry
+	var ops []txOp
+	for _, from := range keys {
+		to := moves[from]
+		if _, exists, err := s.lookup(from); err != nil {
+			return err
+		} else if !exists {
+			return fmt.Errorf("swap %q: %w", from, ErrKeyNotFound)
+		}
+		if to == from {
+			continue
+		}
+		stored, err := s.storedAs(from, to)
+		if err != nil {
+			return fmt.Errorf("swap %q to %q: %w", from, to, err)
+		}
+		ops = append(ops, txOp{typ: LogTypePut, key: to, value: stored})
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	if err := s.applyTx(ops, s.resolveWriteOptions(opts)); err != nil {
+		return err
+	}
+	s.stats.puts.Add(uint64(len(ops)))
+	return nil
+}
+
+// the value of from the way it has to be stored under to, checked against
+// to's validators and the page size. transformers can depend on the key, so
+// the value is decoded and encoded again, without any the stored bytes are
+// taken as they are.
+func (s *Storage) storedAs(from, to string) (string, error) {
+	var value, stored string
+	if len(s.pipeline) == 0 {
+		pageID, exists, err := s.lookup(from)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return "", ErrKeyNotFound
+		}
+		page, err := s.loadPage(pageID)
+		if err != nil {
+			return "", err
+		}
+		var found bool
+		if stored, found = page.findRecord(from); !found {
+			return "", missingRecord(from, pageID)
+		}
+		value = stored
+	} else {
+		var err error
+		if value, err = s.getValue(from); err != nil {
+			return "", err
+		}
+		if stored, err = s.encodeValue(to, value); err != nil {
+			return "", err
+		}
+	}
+	if err := s.validateValue(to, value); err != nil {
+		return "", err
+	}
+	if err := s.checkRecordSize(to, stored); err != nil {
+		return "", err
+	}
+	return stored, nil
+}
+
+// writes the transaction, rolled back in the WAL if any entry can't be written
+func (s *Storage) logTx(ops []txOp) (lsns []uint64, commitLSN uint64, err error) {
+	txID, err := s.wal.BeginTx()
+	if err != nil {
+		return nil, 0, s.diskFullError(fmt.Errorf("log transaction: %w", err))
+	}
+	abort := func(err error) ([]uint64, uint64, error) {
+		s.wal.AbortTx(txID)
+		return nil, 0, s.diskFullError(fmt.Errorf("log transaction %d: %w", txID, err))
+	}
+	for _, op := range ops {
+		lsn, err := s.wal.AppendTx(txID, op.typ, op.key, op.value)
+		if err != nil {
+			return abort(err)
+		}
+		lsns = append(lsns, lsn)
+	}
+	if commitLSN, err = s.wal.CommitTx(txID); err != nil {
+		return abort(err)
+	}
+	return lsns, commitLSN, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"godata/pagefmt"
+)
+
+// integrity checks and salvage, for files that won't open anymore. Verify
+// needs an open storage, and opening reads every page to build the index, a
+// file cut off by a full disk or with a page of garbage fails right there.
+// CheckIntegrity reads the file raw (see pagefmt) and says what is wrong
+// with it, Repair copies every record it can still read into a new file:
+//
+//	report, err := CheckIntegrity("orders.db")
+//	if !report.OK() {
+//		rep, err := Repair("orders.db", "orders.repaired.db", opts)
+//		// rep.Records made it, rep.Suspect of them came from damaged pages
+//	}
+//
+// a page is checked for its record count and record bounds (every record
+// has to end inside the page, before the checksum in files that have them,
+// and hold an LSN in files with record versions) and for its checksum. a
+// file shorter than its header says is truncated: the pages past its end are
+// missing, the records of the last, partial page before the cut still read.
+//
+// Repair takes the records that parse from every page, damaged or not: a
+// bad checksum says something on the page changed, usually not all of it.
+// the report counts the records that came from such pages. a key found on
+// two pages keeps the record with the higher LSN in files with record
+// versions, the one on the later page otherwise (like opening the file
+// does). the writes in src's WAL that never made it into the pages go on
+// top, up to the first entry that doesn't read. values are copied the way
+// they are stored, so opts needs the pipeline (Compress, Transformers) src
+// was written with. records of pages moved to the cold tier aren't in the
+// file, they are left out.
+
+// IntegrityReport is what CheckIntegrity found in a file.
+type IntegrityReport struct {
+	Pages     uint32        // pages the header says the file has
+	PagesRead uint32        // pages that are in the file, whole or in part
+	ColdPages uint32        // pages in the cold tier, their records aren't in the file
+	Records   int           // records that parse
+	Truncated bool          // the file ends before its last page does
+	Problems  []PageProblem // damaged and missing pages, sorted by page ID
+}
+
+// OK is true when no page had a problem.
+func (r IntegrityReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// RepairReport is the result of a Repair.
+type RepairReport struct {
+	Integrity IntegrityReport // what was wrong with src
+	Records   int             // records written to dst
+	Suspect   int             // of those, how many came from a page with a problem
+	Replayed  int             // WAL entries applied on top of the pages
+}
+
+// CheckIntegrity reads every page of the database file at path without
+// opening it and checks its records and checksum. an error means the file
+// can't be read at all (missing, no valid header), damage is in the report.
+func CheckIntegrity(path string) (IntegrityReport, error) {
+	report, _, err := scanFile(path, func(uint32, string, []byte, uint64) {})
+	return report, err
+}
+
+// Repair copies every record that can still be read from the database file
+// at src, and the writes in its WAL, into a new database at dst opened with
+// opts (see repair.go). dst must not exist, src isn't changed.
+func Repair(src, dst string, opts Options) (RepairReport, error) {
+	var report RepairReport
+	if _, err := os.Stat(dst); err == nil {
+		return report, fmt.Errorf("repair: %s: %w", dst, os.ErrExist)
+	}
+
+	records := make(map[string]salvaged)
+	integrity, header, err := scanFile(src, func(pageID uint32, key string, value []byte, lsn uint64) {
+		if old, ok := records[key]; ok && old.lsn > lsn {
+			return
+		}
+		records[key] = salvaged{value: string(value), lsn: lsn, pageID: pageID}
+	})
+	report.Integrity = integrity
+	if err != nil {
+		return report, fmt.Errorf("repair: %w", err)
+	}
+
+	wal, err := os.ReadFile(src + ".wal")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return report, fmt.Errorf("repair: %w", err)
+	}
+	for _, e := range CommittedEntries(parseWALEntries(wal)) {
+		if e.LSN <= header.LastLSN || !e.IsData() {
+			continue
+		}
+		if old, ok := records[e.Key]; ok && old.lsn > e.LSN {
+			continue // a page was written after the entry already
+		}
+		if e.Type == LogTypePut {
+			records[e.Key] = salvaged{value: e.Value, lsn: e.LSN, fromWAL: true}
+		} else {
+			delete(records, e.Key)
+		}
+		report.Replayed++
+	}
+
+	damaged := make(map[uint32]bool)
+	for _, p := range integrity.Problems {
+		damaged[p.PageID] = true
+	}
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	db, err := NewStorageWithOptions(dst, opts)
+	if err != nil {
+		return report, fmt.Errorf("repair: %w", err)
+	}
+	db.mu.Lock()
+	for _, key := range keys {
+		r := records[key]
+		// stored the way src stored it, the pipeline doesn't run again
+		if err = db.put(key, r.value, writeOptions{}, 0); err != nil {
+			err = fmt.Errorf("repair: %q: %w", key, err)
+			break
+		}
+		report.Records++
+		if !r.fromWAL && damaged[r.pageID] {
+			report.Suspect++
+		}
+	}
+	db.mu.Unlock()
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	return report, err
+}
+
+// a record Repair found, the value as it is stored
+type salvaged struct {
+	value   string
+	lsn     uint64 // 0 in files without record versions
+	pageID  uint32
+	fromWAL bool
+}
+
+// reads the header and every page of the file at path, checking each, and
+// calls fn for every record that parses with its value without the LSN. a
+// record of a damaged page before the damage counts.
+func scanFile(path string, fn func(pageID uint32, key string, value []byte, lsn uint64)) (IntegrityReport, pagefmt.Header, error) {
+	var report IntegrityReport
+	file, err := os.Open(path)
+	if err != nil {
+		return report, pagefmt.Header{}, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return report, pagefmt.Header{}, err
+	}
+	head := make([]byte, pagefmt.HeaderSize)
+	if _, err := file.ReadAt(head, 0); err != nil {
+		return report, pagefmt.Header{}, fmt.Errorf("%s: %w: reading the header: %w", path, ErrCorrupted, err)
+	}
+	header, err := pagefmt.ParseHeader(head)
+	if err != nil {
+		return report, header, fmt.Errorf("%s: %w: %w", path, ErrCorrupted, err)
+	}
+	report.Pages = header.TotalPages
+	checksums := hasPageChecksums(header.Flags)
+	versioned := hasRecordVersions(header.Flags)
+
+	data := make([]byte, pagefmt.PageSize)
+	for id := uint32(0); id < header.TotalPages; id++ {
+		offset := pagefmt.PageOffset(id)
+		if offset >= stat.Size() {
+			report.Truncated = true
+			report.Problems = append(report.Problems, PageProblem{PageID: id, Err: corruptedPage("read page", id, offset,
+				fmt.Errorf("the file ends at %d, pages %d to %d are missing", stat.Size(), id, header.TotalPages-1))})
+			break
+		}
+		n, err := file.ReadAt(data, offset)
+		if err != nil && err != io.EOF {
+			return report, header, &StorageError{Op: "read page", PageID: int64(id), Offset: offset, Err: err}
+		}
+		report.PagesRead++
+		if pagefmt.IsColdPage(data[:n]) {
+			report.ColdPages++
+			continue
+		}
+
+		page := data[:n]
+		limit := n
+		if checksums && n == pagefmt.PageSize {
+			limit = pagefmt.PageSize - pagefmt.ChecksumSize
+		}
+		records, problem := checkPage(page, limit, header.Flags)
+		if n < pagefmt.PageSize {
+			report.Truncated = true
+			problem = fmt.Errorf("the file ends %d bytes into the page", n)
+		} else if problem == nil && checksums {
+			problem = pagefmt.CheckChecksumFlags(page, header.Flags)
+		}
+		if problem != nil {
+			report.Problems = append(report.Problems, PageProblem{PageID: id, Err: corruptedPage("check page", id, offset, problem)})
+		}
+		for _, r := range records {
+			lsn, value := uint64(0), r.Value
+			if versioned {
+				lsn, value, _ = pagefmt.SplitVersion(r.Value)
+			}
+			fn(id, string(r.Key), value, lsn)
+		}
+		report.Records += len(records)
+	}
+	return report, header, nil
+}
+
+// the records of a raw page that end before limit and, in a file with record
+// versions, have one, up to the first that doesn't
+func checkPage(page []byte, limit int, flags uint32) ([]pagefmt.Record, error) {
+	versioned := hasRecordVersions(flags)
+	records, err := pagefmt.ParsePageFlags(page, flags)
+	for i, r := range records {
+		end := r.Offset + pagefmt.RecordHeaderSize + len(r.Key) + len(r.Value)
+		switch {
+		case end > limit:
+			return records[:i], fmt.Errorf("record %d at offset %d runs into the checksum", i, r.Offset)
+		case versioned && len(r.Value) < pagefmt.VersionSize:
+			return records[:i], fmt.Errorf("record %d at offset %d is too short for its LSN", i, r.Offset)
+		}
+	}
+	return records, err
+}
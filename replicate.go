@@ -0,0 +1,134 @@
+package main
+
+import "fmt"
+
+// replicas are fed WAL entries shipped from a primary. shipping is at least
+// once: after a reconnect the primary resends from wherever it thinks the
+// replica was, so the same entries can arrive again. the replica remembers the
+// LSN of the last entry it applied (AppliedLSN, kept in the file header) and
+// skips anything at or below it.
+//
+// the applied LSN reaches the disk in the same Sync as the changes, pages
+// first and the header last, so after a crash it can lag behind the data but
+// never run ahead of it. a lagging LSN means the tail of the last batch is
+// applied again, puts and deletes land on the same result when repeated in
+// order.
+//
+// entries carry values the way the primary stored them, already through its
+// pipeline (compressed, encrypted, ...). they go onto the pages as they are,
+// the replica has to be opened with the same Transformers to read them back,
+// and its validators don't see them: the primary checked them already.
+
+// ApplyReport says what ApplyReplicated did with a batch.
+type ApplyReport struct {
+	Applied    int    // puts and deletes written
+	Duplicates int    // entries at or below the applied LSN, skipped
+	Skipped    int    // entries that didn't need applying: markers, aborted transactions
+	Held       int    // entries left for the next batch, see ApplyReplicated
+	AppliedLSN uint64 // applied LSN after the batch, resend from the entry after this
+}
+
+// AppliedLSN returns the LSN of the last replicated WAL entry applied.
+func (s *Storage) AppliedLSN() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.appliedLSN
+}
+
+// ApplyReplicated applies a batch of a primary's WAL entries, in log order,
+// and syncs. entries already applied are skipped, so the same batch (or an
+// overlapping one) can be sent any number of times.
+//
+// transactions only apply when their commit is in the batch. if the batch
+// ends in the middle of one, everything from its TxBegin on is held back and
+// the applied LSN stops just before it, so the primary resends the whole
+// transaction next time:
+//
+//	batch:   5 put   6 TxBegin(6)   7 put tx6   8 put
+//	applied: 5       held...........................   AppliedLSN = 5
+func (s *Storage) ApplyReplicated(entries []*LogEntry) (ApplyReport, error) {
+	s.lockForWrite()
+	defer s.mu.Unlock()
+	var report ApplyReport
+	if err := s.checkWritable(); err != nil {
+		return report, err
+	}
+
+	var fresh []*LogEntry
+	for i, e := range entries {
+		if i > 0 && e.LSN <= entries[i-1].LSN {
+			return report, fmt.Errorf("replicated entries out of order: LSN %d after %d", e.LSN, entries[i-1].LSN)
+		}
+		if e.LSN <= s.appliedLSN {
+			report.Duplicates++
+			continue
+		}
+		fresh = append(fresh, e)
+	}
+
+	cut := unfinishedTxCut(fresh)
+	report.Held = len(fresh) - cut
+	fresh = fresh[:cut]
+
+	apply := CommittedEntries(fresh)
+	for _, e := range apply {
+		var err error
+		switch e.Type {
+		case LogTypePut:
+			err = s.put(e.Key, e.Value, writeOptions{}, 0)
+		case LogTypeDelete:
+			var exists bool
+			if _, exists, err = s.lookup(e.Key); exists {
+				err = s.deleteKey(e.Key, writeOptions{}, 0)
+			}
+		default:
+			report.Skipped++
+			continue
+		}
+		if err != nil {
+			// nothing of this batch is counted as applied, it all comes again
+			return report, fmt.Errorf("apply LSN %d (%s %q): %w", e.LSN, LogTypeName(e.Type), e.Key, err)
+		}
+		report.Applied++
+	}
+	report.Skipped += len(fresh) - len(apply)
+
+	if len(fresh) > 0 {
+		s.appliedLSN = fresh[len(fresh)-1].LSN
+	}
+	report.AppliedLSN = s.appliedLSN
+	if err := s.sync(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// index of the first entry that has to wait for the next batch: the TxBegin
+// of the earliest transaction that doesn't finish before that point. cutting
+// there can leave an earlier transaction unfinished too, so it repeats until
+// nothing moves.
+func unfinishedTxCut(entries []*LogEntry) int {
+	begins := map[uint64]int{}
+	ends := map[uint64]int{}
+	for i, e := range entries {
+		switch e.Type {
+		case LogTypeTxBegin:
+			begins[e.TxID] = i
+		case LogTypeTxCommit, LogTypeTxAbort:
+			ends[e.TxID] = i
+		}
+	}
+
+	cut := len(entries)
+	for moved := true; moved; {
+		moved = false
+		for txID, begin := range begins {
+			end, finished := ends[txID]
+			if begin < cut && (!finished || end >= cut) {
+				cut = begin
+				moved = true
+			}
+		}
+	}
+	return cut
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// retention: old data goes away by itself instead of through a cron job that
+// deletes it. two kinds, both enforced by Sync (and so by Close and Compact,
+// which sync first):
+//
+//	Options.Retention          whole pages by age, for the append engine (see timeseries.go)
+//	Options.RetentionPolicies  per key prefix, by age and/or by count
+//
+//	opts.RetentionPolicies = []RetentionPolicy{
+//		{Prefix: "events:", MaxAge: 30 * 24 * time.Hour},
+//		{Prefix: "audit:", MaxCount: 100000},
+//	}
+//
+// prefix policies delete key by key, like Delete would: logged to the WAL,
+// so replicas follow, and counted in Stats as KeysExpired. MaxCount keeps the
+// last keys in key order, which for log-style keys (a timestamp or sequence
+// number after the prefix) are the newest. MaxAge needs a time in the key,
+// by default a TimeKey right after the prefix ("events:" + TimeKey(t, ...)),
+// keys without one are only subject to MaxCount.
+
+// RetentionPolicy prunes the keys under one prefix.
+type RetentionPolicy struct {
+	Prefix   string
+	MaxAge   time.Duration // 0 = no age limit
+	MaxCount int           // 0 = no count limit
+	// reads the time from a key under Prefix, nil reads a TimeKey right
+	// after the prefix
+	KeyTime func(key string) (time.Time, bool)
+}
+
+func (p RetentionPolicy) keyTime(key string) (time.Time, bool) {
+	if p.KeyTime != nil {
+		return p.KeyTime(key)
+	}
+	return timeKeyTime(key[len(p.Prefix):])
+}
+
+// called by Sync before the pages are written, so what it removes goes out
+// with them
+func (s *Storage) applyRetention() error {
+	if s.MaintenanceMode() {
+		return nil
+	}
+	now := s.clock().Now()
+	if s.opts.Retention > 0 {
+		if _, err := s.dropBefore(now.Add(-s.opts.Retention)); err != nil {
+			return fmt.Errorf("retention: %w", err)
+		}
+	}
+	if len(s.opts.RetentionPolicies) == 0 || now.Before(s.nextRetentionCheck) {
+		return nil
+	}
+	s.nextRetentionCheck = now.Add(s.opts.RetentionInterval)
+	for _, policy := range s.opts.RetentionPolicies {
+		if err := s.enforceRetention(policy, now); err != nil {
+			return fmt.Errorf("retention %q: %w", policy.Prefix, err)
+		}
+	}
+	return nil
+}
+
+func (s *Storage) enforceRetention(policy RetentionPolicy, now time.Time) error {
+	if policy.MaxAge <= 0 && policy.MaxCount <= 0 {
+		return nil
+	}
+	var keys []string
+	err := s.indexPrefix(policy.Prefix, func(key string, _ uint32) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	// everything before keep goes for the count, anything too old for the age
+	keep := 0
+	if policy.MaxCount > 0 && len(keys) > policy.MaxCount {
+		keep = len(keys) - policy.MaxCount
+	}
+	cutoff := now.Add(-policy.MaxAge)
+	for i, key := range keys {
+		if i >= keep {
+			if policy.MaxAge <= 0 {
+				break
+			}
+			if t, ok := policy.keyTime(key); !ok || !t.Before(cutoff) {
+				continue
+			}
+		}
+		if err := s.deleteKey(key, writeOptions{}, 0); err != nil {
+			return err
+		}
+		s.stats.keysExpired.Add(1)
+	}
+	return nil
+}
+
+// the smallest key above every key starting with prefix, "" when there is
+// none (a prefix of only 0xff bytes, or no prefix)
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sealed snapshots: Seal writes every record into one immutable file made
+// for reading with nothing but mmap, to ship to nodes that only ever read
+// (edge caches, lookup tables built nightly). OpenSealed maps it and answers
+// Gets with a binary search over an offset table, there is no index to
+// build, no WAL and no page cache, and opening a 1GB file costs what
+// checking its CRC costs:
+//
+//	offset 0   header (SealHeaderSize bytes, little endian)
+//	           0  magic "GDSL"  uint32
+//	           4  version       uint32
+//	           8  records       uint64
+//	           16 table offset  uint64
+//	           24 LSN           uint64 (every write up to it is in the file)
+//	           32 created       int64  (unix nanos)
+//	64         records, sorted by key: [keyLen u32][valueLen u32][key][value]
+//	table      one uint64 per record, where it starts
+//	end - 4    CRC32 (IEEE) of everything before it
+//
+// values are written decoded, the way Get returns them: the file needs no
+// transformers to be read, and a store with an encryption transformer
+// seals to plaintext. streamed values go in as their manifest and chunks
+// (see stream.go), Get and Range put them together. the file is written next
+// to path and renamed over it once complete, so a reader never sees half of
+// one.
+//
+//	db.Seal("catalog.sealed")
+//	// on the edge node
+//	f, err := OpenSealed("catalog.sealed")
+//	price, err := f.Get("sku:1042")
+//
+// mmap is used on the unix systems, elsewhere OpenSealed reads the file into
+// memory instead (see mmap_unix.go).
+
+const (
+	SealMagic      = 0x4C534447 // "GDSL"
+	SealVersion    = 1
+	SealHeaderSize = 64
+)
+
+// SealHeader describes a sealed snapshot.
+type SealHeader struct {
+	Records int
+	LSN     uint64 // every write up to and including this one is in the file
+	Created time.Time
+	Bytes   int64 // size of the file
+}
+
+// Seal writes every record into a sealed snapshot at path (see seal.go).
+// like Export, it is the database at one point in time.
+func (s *Storage) Seal(path string) (SealHeader, error) {
+	lsn, records, err := s.snapshotRecords(false)
+	if err != nil {
+		return SealHeader{}, err
+	}
+	header := SealHeader{Records: len(records), LSN: lsn, Created: s.clock().Now().UTC()}
+	if !s.binaryOrder() {
+		// the file is searched in byte order, whatever the collation
+		sort.Slice(records, func(i, j int) bool { return records[i].key < records[j].key })
+	}
+
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return header, fmt.Errorf("seal: %w", err)
+	}
+	defer os.Remove(tmp) // a no-op once it's renamed
+	header.Bytes, err = writeSealed(file, header, records)
+	if err == nil {
+		err = syncFile(file)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		return header, fmt.Errorf("seal: %w", err)
+	}
+	return header, nil
+}
+
+// writes the whole file, records sorted by key, returns its size
+func writeSealed(w io.Writer, header SealHeader, records []snapshotRecord) (int64, error) {
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	offsets := make([]uint64, len(records))
+	at := uint64(SealHeaderSize)
+	for i, r := range records {
+		offsets[i] = at
+		at += 8 + uint64(len(r.key)) + uint64(len(r.value))
+	}
+	head := make([]byte, SealHeaderSize)
+	binary.LittleEndian.PutUint32(head[0:4], SealMagic)
+	binary.LittleEndian.PutUint32(head[4:8], SealVersion)
+	binary.LittleEndian.PutUint64(head[8:16], uint64(len(records)))
+	binary.LittleEndian.PutUint64(head[16:24], at)
+	binary.LittleEndian.PutUint64(head[24:32], header.LSN)
+	binary.LittleEndian.PutUint64(head[32:40], uint64(header.Created.UnixNano()))
+	bw.Write(head)
+
+	var buf [8]byte
+	for _, r := range records {
+		binary.LittleEndian.PutUint32(buf[0:4], uint32(len(r.key)))
+		binary.LittleEndian.PutUint32(buf[4:8], uint32(len(r.value)))
+		bw.Write(buf[:])
+		bw.WriteString(r.key)
+		bw.WriteString(r.value)
+	}
+	for _, offset := range offsets {
+		binary.LittleEndian.PutUint64(buf[:], offset)
+		bw.Write(buf[:])
+	}
+	if err := bw.Flush(); err != nil { // the bufio.Writer keeps the first error
+		return 0, err
+	}
+	binary.LittleEndian.PutUint32(buf[0:4], crc.Sum32())
+	if _, err := w.Write(buf[0:4]); err != nil {
+		return 0, err
+	}
+	return int64(at) + 8*int64(len(records)) + 4, nil
+}
+
+// Sealed is an open sealed snapshot. it never changes, so every method is
+// safe to call from many goroutines.
+type Sealed struct {
+	data   []byte
+	unmap  func() error
+	header SealHeader
+	table  []byte // the offset table
+}
+
+// OpenSealed maps the sealed snapshot at path and checks its CRC, a damaged
+// or cut off file fails with ErrCorrupted.
+func OpenSealed(path string) (*Sealed, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // the mapping stays after the close
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() < SealHeaderSize+4 {
+		return nil, fmt.Errorf("%s: %w: %d bytes", path, ErrCorrupted, stat.Size())
+	}
+	data, unmap, err := mapFile(file, int(stat.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("map %s: %w", path, err)
+	}
+	f := &Sealed{data: data, unmap: unmap}
+	if err := f.parse(); err != nil {
+		unmap()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+func (f *Sealed) parse() error {
+	data := f.data
+	end := len(data) - 4
+	if binary.LittleEndian.Uint32(data[0:4]) != SealMagic {
+		return fmt.Errorf("%w: not a sealed snapshot", ErrCorrupted)
+	}
+	if v := binary.LittleEndian.Uint32(data[4:8]); v != SealVersion {
+		return fmt.Errorf("unsupported sealed snapshot version %d", v)
+	}
+	if crc32.ChecksumIEEE(data[:end]) != binary.LittleEndian.Uint32(data[end:]) {
+		return fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+	}
+	count := binary.LittleEndian.Uint64(data[8:16])
+	table := binary.LittleEndian.Uint64(data[16:24])
+	if table < SealHeaderSize || table > uint64(end) || (uint64(end)-table)/8 != count || (uint64(end)-table)%8 != 0 {
+		return fmt.Errorf("%w: offset table doesn't fit the file", ErrCorrupted)
+	}
+	f.table = data[table:end]
+	f.header = SealHeader{
+		Records: int(count),
+		LSN:     binary.LittleEndian.Uint64(data[24:32]),
+		Created: time.Unix(0, int64(binary.LittleEndian.Uint64(data[32:40]))).UTC(),
+		Bytes:   int64(len(data)),
+	}
+	return nil
+}
+
+// Header describes the snapshot.
+func (f *Sealed) Header() SealHeader { return f.header }
+
+// Len returns how many records the snapshot holds.
+func (f *Sealed) Len() int { return f.header.Records }
+
+// the key and value of record i, pointing into the mapping. the CRC was
+// right, a bad length can only come from a bad Seal, it reads as empty.
+func (f *Sealed) record(i int) (key, value []byte) {
+	at := binary.LittleEndian.Uint64(f.table[i*8 : i*8+8])
+	if at+8 > uint64(len(f.data)) {
+		return nil, nil
+	}
+	keyLen := uint64(binary.LittleEndian.Uint32(f.data[at : at+4]))
+	valueLen := uint64(binary.LittleEndian.Uint32(f.data[at+4 : at+8]))
+	start := at + 8
+	if start+keyLen+valueLen > uint64(len(f.data)) {
+		return nil, nil
+	}
+	return f.data[start : start+keyLen], f.data[start+keyLen : start+keyLen+valueLen]
+}
+
+// the first record whose key is >= key
+func (f *Sealed) search(key string) int {
+	return sort.Search(f.header.Records, func(i int) bool {
+		k, _ := f.record(i)
+		return string(k) >= key
+	})
+}
+
+// Get returns the value of key, ErrKeyNotFound when the snapshot doesn't
+// have it.
+func (f *Sealed) Get(key string) (string, error) {
+	i := f.search(key)
+	if i < f.header.Records {
+		if k, value := f.record(i); string(k) == key {
+			return f.resolveStream(string(value))
+		}
+	}
+	return "", ErrKeyNotFound
+}
+
+// Range calls fn for every key k with start <= k < end in key order (end ""
+// = no bound) until it returns false.
+func (f *Sealed) Range(start, end string, fn func(key, value string) bool) {
+	for i := f.search(start); i < f.header.Records; i++ {
+		key, value := f.record(i)
+		if end != "" && string(key) >= end {
+			return
+		}
+		whole, err := f.resolveStream(string(value))
+		if err != nil {
+			whole = string(value) // a chunk is missing, the manifest is all there is
+		}
+		if !fn(string(key), whole) {
+			return
+		}
+	}
+}
+
+// a streamed value is sealed as its manifest and chunks, like the database
+// holds it (see stream.go), this puts it together again
+func (f *Sealed) resolveStream(value string) (string, error) {
+	m, ok := parseStreamManifest(value)
+	if !ok {
+		return value, nil
+	}
+	var b strings.Builder
+	b.Grow(int(m.size))
+	for n := 0; n < m.chunks; n++ {
+		chunk, err := f.Get(chunkKey(m.gen, n))
+		if err != nil {
+			return "", fmt.Errorf("streamed value chunk %d of %d: %w", n, m.chunks, err)
+		}
+		b.WriteString(chunk)
+	}
+	return b.String(), nil
+}
+
+// Close unmaps the file, the snapshot can't be used after it.
+func (f *Sealed) Close() error {
+	if f.unmap == nil {
+		return nil
+	}
+	err := f.unmap()
+	f.unmap, f.data, f.table = nil, nil, nil
+	f.header.Records = 0
+	return err
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"godata/pagefmt"
+)
+
+// slotted pages: in a file created with Options.SlottedPages a page keeps a
+// slot directory at its end, the offset of every record ordered by key, and
+// the records grow from the front (see pagefmt):
+//
+//	opts.SlottedPages = true
+//	db, err := NewStorageWithOptions("sessions.db", opts)
+//
+//	[count][end] [user:2=cam] [hole] [user:1=isa] ... free ... [→user:2][→user:1] [crc]
+//
+// deleting a record takes its slot out of the directory, which moves the
+// slots after it by 2 bytes each instead of the up to 4KB of records behind
+// it in the heap layout. its bytes are a hole until the page needs the
+// room: an add that doesn't fit between the records and the directory packs
+// the records first. an update whose value is no bigger than the old one is
+// written over the old record in place. with the slots in key order,
+// finding a key on a page is a binary search instead of a walk over every
+// record.
+//
+// the price is the end offset and 2 bytes per record. which layout a file
+// has is a header flag, like record versions: existing files keep theirs,
+// and builds from before the flag can't read slotted files. a slotted page
+// whose slots don't add up fails to load, a search over them would go wrong
+// quietly.
+
+func hasSlottedPages(flags uint32) bool {
+	return flags&pagefmt.FlagSlottedPages != 0
+}
+
+// called by readPage, lookups trust a slotted page's slots to point at records
+func (s *Storage) checkSlots(p *Page) error {
+	if !s.slotted {
+		return nil
+	}
+	if _, err := pagefmt.ParsePageFlags(p.Data[:], s.headerFlags()); err != nil {
+		return corruptedPage("parse slots", p.ID, s.pageOffset(p.ID), err)
+	}
+	return nil
+}
+
+// bytes every page starts with: the record count, and where the records end
+// on a slotted page
+func (s *Storage) pageHeaderSize() int {
+	if s.slotted {
+		return pagefmt.SlottedHeaderSize
+	}
+	return pagefmt.RecordCountSize
+}
+
+// bytes a record takes on a page besides its own, its slot
+func (s *Storage) slotSize() int {
+	if s.slotted {
+		return pagefmt.SlotSize
+	}
+	return 0
+}
+
+// where slot i sits, slot 0 is the last one before the checksum
+func (p *Page) slotAt(i int) int {
+	return p.capacity() - (i+1)*pagefmt.SlotSize
+}
+
+func (p *Page) slot(i int) int {
+	at := p.slotAt(i)
+	return int(binary.LittleEndian.Uint16(p.Data[at : at+pagefmt.SlotSize]))
+}
+
+func (p *Page) setSlot(i, offset int) {
+	at := p.slotAt(i)
+	binary.LittleEndian.PutUint16(p.Data[at:at+pagefmt.SlotSize], uint16(offset))
+}
+
+// where the records end, the next one goes there
+func (p *Page) recordsEnd() int {
+	if end := int(binary.LittleEndian.Uint16(p.Data[2:4])); end > pagefmt.SlottedHeaderSize {
+		return end
+	}
+	return pagefmt.SlottedHeaderSize // never written
+}
+
+func (p *Page) setRecordsEnd(end int) {
+	binary.LittleEndian.PutUint16(p.Data[2:4], uint16(end))
+}
+
+// bytes of the record at offset, header included
+func (p *Page) recordSize(offset int) int {
+	keyLen := int(binary.LittleEndian.Uint16(p.Data[offset : offset+2]))
+	valueLen := int(binary.LittleEndian.Uint16(p.Data[offset+2 : offset+4]))
+	return 4 + keyLen + valueLen
+}
+
+func (p *Page) slotKey(i int) []byte {
+	offset := p.slot(i)
+	keyLen := int(binary.LittleEndian.Uint16(p.Data[offset : offset+2]))
+	return p.Data[offset+4 : offset+4+keyLen]
+}
+
+// the first slot whose key is key or sorts after it
+func (p *Page) searchSlots(key string) (i int, found bool) {
+	n := int(p.RecordCount)
+	i = sort.Search(n, func(i int) bool { return string(p.slotKey(i)) >= key })
+	return i, i < n && string(p.slotKey(i)) == key
+}
+
+// eachRecord for a slotted page, in key order
+func (p *Page) eachSlot(fn func(key, value string, size int) bool) error {
+	for i := 0; i < int(p.RecordCount); i++ {
+		key, value, size, err := deserializeRecord(p.Data[:], p.slot(i))
+		if err != nil {
+			return err
+		}
+		if !fn(key, value, size) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *Page) findSlotted(key string) (string, bool) {
+	i, found := p.searchSlots(key)
+	if !found {
+		return "", false
+	}
+	_, value, _, err := deserializeRecord(p.Data[:], p.slot(i))
+	if err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+// the bytes records and slots take, holes don't count: packRecords gets them back
+func (p *Page) slottedUsedSpace() int {
+	used := pagefmt.SlottedHeaderSize
+	for i := 0; i < int(p.RecordCount); i++ {
+		used += p.recordSize(p.slot(i)) + pagefmt.SlotSize
+	}
+	return used
+}
+
+func (p *Page) addSlotted(key, value string) error {
+	record := serializeRecord(key, value)
+	count := int(p.RecordCount)
+	dir := p.slotAt(count) // where the directory starts with the new slot
+	if p.recordsEnd()+len(record) > dir {
+		if p.slottedUsedSpace()+len(record)+pagefmt.SlotSize > p.capacity() {
+			return ErrPageFull
+		}
+		p.packRecords()
+	}
+	end := p.recordsEnd()
+	copy(p.Data[end:], record)
+
+	// after the copies of key already there, the first one stays the one
+	// that's found, like on a heap page
+	i := sort.Search(count, func(i int) bool { return string(p.slotKey(i)) > key })
+	// the slots from i on move down one to make room
+	copy(p.Data[dir:], p.Data[dir+pagefmt.SlotSize:p.slotAt(i)+pagefmt.SlotSize])
+	p.setSlot(i, end)
+	p.setRecordsEnd(end + len(record))
+	p.RecordCount++
+	p.IsDirty = true
+	return nil
+}
+
+func (p *Page) deleteSlotted(key string) bool {
+	i, found := p.searchSlots(key)
+	if !found {
+		return false
+	}
+	offset := p.slot(i)
+	size := p.recordSize(offset)
+	dir := p.slotAt(int(p.RecordCount) - 1)
+	// the slots after i move up one, over it
+	copy(p.Data[dir+pagefmt.SlotSize:], p.Data[dir:p.slotAt(i)])
+	p.Data[dir], p.Data[dir+1] = 0, 0
+	p.RecordCount--
+	switch {
+	case p.RecordCount == 0:
+		p.setRecordsEnd(pagefmt.SlottedHeaderSize)
+	case offset+size == p.recordsEnd():
+		p.setRecordsEnd(offset) // the last record, no hole
+	}
+	p.IsDirty = true
+	return true
+}
+
+// a value no bigger than the old one goes over the old record
+func (p *Page) replaceSlotted(key, value string) error {
+	if i, found := p.searchSlots(key); found {
+		offset := p.slot(i)
+		record := serializeRecord(key, value)
+		if old := p.recordSize(offset); len(record) <= old {
+			copy(p.Data[offset:], record)
+			if offset+old == p.recordsEnd() {
+				p.setRecordsEnd(offset + len(record))
+			}
+			p.IsDirty = true
+			return nil
+		}
+	}
+	p.deleteSlotted(key)
+	return p.addSlotted(key, value)
+}
+
+// moves the records back to back in slot order, the holes become free space
+func (p *Page) packRecords() {
+	var packed [PageSize]byte
+	end := pagefmt.SlottedHeaderSize
+	for i := 0; i < int(p.RecordCount); i++ {
+		offset := p.slot(i)
+		size := p.recordSize(offset)
+		copy(packed[end:], p.Data[offset:offset+size])
+		p.setSlot(i, end)
+		end += size
+	}
+	dir := p.capacity() - int(p.RecordCount)*pagefmt.SlotSize
+	copy(p.Data[pagefmt.SlottedHeaderSize:dir], packed[pagefmt.SlottedHeaderSize:dir])
+	p.setRecordsEnd(end)
+}
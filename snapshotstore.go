@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// snapshot stores: SnapshotTo hands the state of every bucket to a
+// SnapshotStore, an external system of record (an object store, another
+// database, a directory on a backup disk), so the store is the durable copy
+// and GoData a cache in front of it that can be rebuilt from it:
+//
+//	opts.SnapshotStore = NewDirSnapshotStore("/mnt/backup/app")
+//	opts.SnapshotInterval = 10 * time.Minute
+//	opts.SnapshotBucket = KeyPrefixBucket(":") // "user:1" goes in the "user" snapshot
+//
+// a bucket is a label, the same as for the metrics (see bucketstats.go),
+// without Options.SnapshotBucket everything is one snapshot called "". every
+// snapshot of one run is the database at the same point, the LSN in its
+// SnapshotMeta. the body is an export (see export.go) of the bucket's
+// records, Import puts it back:
+//
+//	{"format":"godata-export","version":1,"lsn":1042,"records":2,...}
+//	{"key":"user:1","value":"isabella"}
+//
+// streamed values go in the bucket of their key, manifest and chunks
+// together (see stream.go), chunks no key points to are left out.
+//
+// after a WriteSnapshot the store's VerifySnapshot is asked to confirm it
+// has the snapshot meta describes (read it back, compare the checksum), a
+// snapshot isn't done until it did. a failure of either stops the run.
+//
+// in the background a snapshot is taken every SnapshotInterval when
+// something was written since the last one, all buckets each time. a failed
+// one is counted in Stats (SnapshotErrors) and tried again at the next tick.
+
+// SnapshotMeta describes one bucket's snapshot.
+type SnapshotMeta struct {
+	Bucket   string
+	LSN      uint64 // every write up to and including this one is in it
+	Records  int
+	Bytes    int64  // size of the body
+	Checksum uint32 // CRC32 (IEEE) of the body
+	Taken    time.Time
+}
+
+// SnapshotStore keeps the snapshots SnapshotTo takes. WriteSnapshot gets
+// the whole body and returns once the store has it, VerifySnapshot checks
+// the stored copy against meta, an error means it isn't there intact.
+type SnapshotStore interface {
+	WriteSnapshot(meta SnapshotMeta, body io.Reader) error
+	VerifySnapshot(meta SnapshotMeta) error
+}
+
+// SnapshotTo takes a snapshot of every bucket and writes them to store, it
+// returns the ones written and verified, in bucket order.
+func (s *Storage) SnapshotTo(store SnapshotStore) ([]SnapshotMeta, error) {
+	lsn, records, err := s.snapshotRecords(false)
+	if err != nil {
+		return nil, err
+	}
+	taken := s.clock().Now().UTC()
+	buckets := s.snapshotBuckets(records)
+	names := make([]string, 0, len(buckets))
+	for name := range buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var done []SnapshotMeta
+	for _, name := range names {
+		meta := SnapshotMeta{Bucket: name, LSN: lsn, Records: len(buckets[name]), Taken: taken}
+		var body bytes.Buffer
+		header := ExportHeader{Format: ExportFormat, Version: ExportVersion, LSN: lsn, Records: meta.Records, Created: taken}
+		if err := writeExport(&body, header, buckets[name]); err != nil {
+			return done, fmt.Errorf("snapshot %q: %w", name, err)
+		}
+		meta.Bytes = int64(body.Len())
+		meta.Checksum = crc32.ChecksumIEEE(body.Bytes())
+		if err := store.WriteSnapshot(meta, &body); err != nil {
+			return done, fmt.Errorf("snapshot %q: write: %w", name, err)
+		}
+		if err := store.VerifySnapshot(meta); err != nil {
+			return done, fmt.Errorf("snapshot %q: verify: %w", name, err)
+		}
+		s.stats.snapshots.Add(1)
+		done = append(done, meta)
+	}
+	return done, nil
+}
+
+// sorts the records into their buckets, chunks go where their manifest
+// went. the records are sorted, so every bucket is too.
+func (s *Storage) snapshotBuckets(records []snapshotRecord) map[string][]snapshotRecord {
+	bucket := s.opts.SnapshotBucket
+	if bucket == nil {
+		bucket = func(string) string { return "" }
+	}
+	chunkBucket := make(map[uint64]string)
+	for _, r := range records {
+		if m, ok := parseStreamManifest(r.value); ok && !strings.HasPrefix(r.key, StreamChunkPrefix) {
+			chunkBucket[m.gen] = bucket(r.key)
+		}
+	}
+	buckets := make(map[string][]snapshotRecord)
+	for _, r := range records {
+		name, owned := "", true
+		if gen, ok := chunkGen(r.key); ok {
+			name, owned = chunkBucket[gen]
+		} else {
+			name = bucket(r.key)
+		}
+		if !owned {
+			continue
+		}
+		buckets[name] = append(buckets[name], r)
+	}
+	if len(buckets) == 0 {
+		buckets[bucket("")] = nil // an empty database still has a snapshot
+	}
+	return buckets
+}
+
+// starts the background snapshots when Options.SnapshotStore and
+// SnapshotInterval are set, called once the storage is open
+func (s *Storage) startSnapshotter() {
+	if s.opts.SnapshotStore == nil || s.opts.SnapshotInterval <= 0 {
+		return
+	}
+	s.snapshotStop = make(chan struct{})
+	s.snapshotDone = make(chan struct{})
+	go s.runSnapshotter()
+}
+
+func (s *Storage) runSnapshotter() {
+	defer close(s.snapshotDone)
+	var last uint64 // the LSN of the last snapshot
+	taken := false
+	for {
+		select {
+		case <-s.snapshotStop:
+			return
+		case <-s.clock().After(s.opts.SnapshotInterval):
+		}
+		s.mu.RLock()
+		lsn := s.lsn
+		s.mu.RUnlock()
+		if taken && lsn == last {
+			continue
+		}
+		metas, err := s.SnapshotTo(s.opts.SnapshotStore)
+		if err != nil {
+			s.stats.snapshotErrors.Add(1)
+			continue
+		}
+		last, taken = metas[0].LSN, true
+	}
+}
+
+// stops the background snapshots and waits for one in progress, called by
+// Close before it takes the lock
+func (s *Storage) stopSnapshotter() {
+	if s.snapshotStop == nil {
+		return
+	}
+	close(s.snapshotStop)
+	<-s.snapshotDone
+	s.snapshotStop = nil
+}
+
+// DirSnapshotStore is a SnapshotStore in a directory, one file per snapshot
+// named after its bucket and LSN ("user-1042.ndjson", "-1042.ndjson" for
+// the bucket ""). older snapshots are left for the caller to clean up.
+type DirSnapshotStore struct {
+	dir string
+}
+
+// NewDirSnapshotStore keeps snapshots in dir, which is created when needed.
+func NewDirSnapshotStore(dir string) *DirSnapshotStore {
+	return &DirSnapshotStore{dir: dir}
+}
+
+// Path returns where the snapshot meta describes is kept.
+func (d *DirSnapshotStore) Path(meta SnapshotMeta) string {
+	return filepath.Join(d.dir, fmt.Sprintf("%s-%d.ndjson", url.PathEscape(meta.Bucket), meta.LSN))
+}
+
+// WriteSnapshot writes the body next to its place and renames it there once
+// it is synced, a crash never leaves half a snapshot under the name.
+func (d *DirSnapshotStore) WriteSnapshot(meta SnapshotMeta, body io.Reader) error {
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return err
+	}
+	path := d.Path(meta)
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp) // a no-op once it's renamed
+	_, err = io.Copy(file, body)
+	if err == nil {
+		err = syncFile(file)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// VerifySnapshot reads the file back and checks its size and CRC.
+func (d *DirSnapshotStore) VerifySnapshot(meta SnapshotMeta) error {
+	data, err := os.ReadFile(d.Path(meta))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != meta.Bytes || crc32.ChecksumIEEE(data) != meta.Checksum {
+		return fmt.Errorf("%s: %w: %d bytes, checksum %08x, want %d bytes, %08x",
+			d.Path(meta), ErrCorrupted, len(data), crc32.ChecksumIEEE(data), meta.Bytes, meta.Checksum)
+	}
+	return nil
+}
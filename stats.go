@@ -0,0 +1,418 @@
+package main
+
+import (
+	"sync/atomic" // counters can be read by a monitoring goroutine while the db is working
+	"time"
+)
+
+// Stats counts what the storage has been doing since it was opened (or last Reset).
+// the counters only grow, so a monitoring agent that wants "ops per scrape interval"
+// either diffs two snapshots or calls Reset after each scrape.
+type Stats struct {
+	gets         atomic.Uint64 // Get calls
+	puts         atomic.Uint64 // Put calls
+	deletes      atomic.Uint64 // Delete calls
+	cacheHits    atomic.Uint64 // loadPage found the page already in memory
+	cacheMisses  atomic.Uint64 // loadPage had to go to the disk
+	coalesced    atomic.Uint64 // misses that waited for a read another goroutine had started
+	valueHits    atomic.Uint64 // Get answered from the value cache
+	valueMisses  atomic.Uint64 // Get had to read the page (only counted with the value cache on)
+	pageReads    atomic.Uint64 // pages read from disk
+	pageWrites   atomic.Uint64 // pages written to disk
+	bytesRead    atomic.Uint64 // bytes read from the data file
+	bytesWritten atomic.Uint64 // bytes written to the data file
+	syncs        atomic.Uint64 // fsync calls on the data file
+	stalls       atomic.Uint64 // writes held up by backpressure (see backpressure.go)
+	stallNanos   atomic.Uint64 // total time those writes were held up
+	rejected     atomic.Uint64 // writes refused with ErrOverloaded
+	compactions  atomic.Uint64 // Compact runs, automatic ones included
+	reclaimed    atomic.Uint64 // bytes the file shrank by compacting
+	since        atomic.Int64  // unix nanos of when counting started
+	clock        Clock         // time source for the snapshot timestamps, set when the storage opens
+	// per-bucket counters, nil unless Options.MetricsBucket is set (see bucketstats.go)
+	buckets *bucketStats
+	// automatic compactions by the threshold that triggered them, one run can
+	// count under several (see autocompact.go)
+	triggeredDeadSpace     atomic.Uint64
+	triggeredTombstones    atomic.Uint64
+	triggeredFragmentation atomic.Uint64
+	// pages dropped whole by retention (see timeseries.go), keys deleted by
+	// prefix retention policies (see retention.go)
+	pagesExpired atomic.Uint64
+	keysExpired  atomic.Uint64
+	// pages dropped from memory to stay within Options.CacheSize (see pagecache.go),
+	// dropped with the rest of the cache when Refresh or Compact reloaded the
+	// file, and dropped because they moved to the cold tier (see tiering.go)
+	pageEvictions    atomic.Uint64
+	pagesInvalidated atomic.Uint64
+	pagesEvictedCold atomic.Uint64
+	// writes that waited for their WAL entry under SyncGroupCommit, and the
+	// fsyncs that covered them
+	groupCommits atomic.Uint64
+	groupSyncs   atomic.Uint64
+	// Checkpoint runs, background ones included, and background ones that failed
+	checkpoints      atomic.Uint64
+	checkpointErrors atomic.Uint64
+	// writes that found the WAL at Options.MaxWALBytes
+	walFull atomic.Uint64
+	// times the storage went read-only because the disk was full
+	diskFull atomic.Uint64
+	// pages moved to Options.ColdTier, and pages read back from it (see tiering.go)
+	pagesTiered atomic.Uint64
+	coldReads   atomic.Uint64
+	// bucket snapshots written to a SnapshotStore, and background runs that
+	// failed (see snapshotstore.go)
+	snapshots      atomic.Uint64
+	snapshotErrors atomic.Uint64
+	// versions writes replaced that were kept for open snapshots (see mvcc.go)
+	versionsKept atomic.Uint64
+	// value cache misses the admission policy kept out of the cache (see valuecache.go)
+	valueRejected atomic.Uint64
+	// torn pages put back from the double-write buffer when the file opened
+	// (see doublewrite.go)
+	pagesRestored atomic.Uint64
+	// page writes read back with Options.VerifyWrites, and the ones that
+	// didn't match (see writeverify.go)
+	pagesVerified   atomic.Uint64
+	writeMismatches atomic.Uint64
+	// the last recovery, checkpoint and compaction, nil until one ran. these
+	// aren't counters, Reset leaves them alone
+	lastRecovery   atomic.Pointer[Timing]
+	lastCheckpoint atomic.Pointer[Timing]
+	lastCompaction atomic.Pointer[Timing]
+}
+
+// Timing says when a maintenance run finished, how long it took and how many
+// bytes it went through. the zero value means it hasn't run yet.
+type Timing struct {
+	At       time.Time
+	Duration time.Duration
+	// recovery: the WAL it read. checkpoint: the pages it wrote. compaction:
+	// the pages of the file it rewrote.
+	Bytes int64
+}
+
+// stores a run that started at start into last
+func (st *Stats) recordTiming(last *atomic.Pointer[Timing], start time.Time, bytes int64) {
+	now := st.now()
+	last.Store(&Timing{At: now, Duration: now.Sub(start), Bytes: bytes})
+}
+
+func loadTiming(last *atomic.Pointer[Timing]) Timing {
+	if t := last.Load(); t != nil {
+		return *t
+	}
+	return Timing{}
+}
+
+func (st *Stats) now() time.Time {
+	if st.clock == nil {
+		return time.Now()
+	}
+	return st.clock.Now()
+}
+
+// StatsSnapshot is a plain copy of the counters at one point in time.
+type StatsSnapshot struct {
+	Gets         uint64
+	Puts         uint64
+	Deletes      uint64
+	CacheHits    uint64
+	CacheMisses  uint64
+	Coalesced    uint64 // cache misses served by a page read already in flight
+	ValueHits    uint64
+	ValueMisses  uint64
+	PageReads    uint64
+	PageWrites   uint64
+	BytesRead    uint64
+	BytesWritten uint64
+	Syncs        uint64
+	Stalls       uint64
+	StallTime    time.Duration
+	Rejected     uint64
+	Compactions  uint64
+	Since        time.Time // when these counters started
+	TakenAt      time.Time // when the snapshot was taken
+	// per-bucket counters by label, nil when per-bucket metrics are off
+	Buckets map[string]BucketSnapshot
+	// bytes compaction took off the file, and the automatic compactions per
+	// threshold that triggered them
+	ReclaimedBytes         uint64
+	TriggeredDeadSpace     uint64
+	TriggeredTombstones    uint64
+	TriggeredFragmentation uint64
+	// pages retention dropped because all their records were too old, and
+	// keys prefix retention policies deleted
+	PagesExpired uint64
+	KeysExpired  uint64
+	// pages dropped from the page cache, by why: evicted for room (dirty ones
+	// were written first), invalidated by a Refresh or Compact that reloaded
+	// the file, moved to the cold tier. only the first says the cache is too
+	// small (see pagecache.go)
+	PageEvictions    uint64
+	PagesInvalidated uint64
+	PagesEvictedCold uint64
+	// writes made durable by group commit, and the WAL fsyncs it took
+	GroupCommits uint64
+	GroupSyncs   uint64
+	// checkpoints taken, and background ones that failed
+	Checkpoints      uint64
+	CheckpointErrors uint64
+	// writes that found the WAL full, they checkpointed or were rejected
+	WALFull uint64
+	// times a full disk suspended writes (see diskfull.go)
+	DiskFull uint64
+	// pages moved to the cold tier, and cache misses served from it
+	PagesTiered uint64
+	ColdReads   uint64
+	// bucket snapshots written and verified, and background runs that failed
+	Snapshots      uint64
+	SnapshotErrors uint64
+	// replaced versions kept for open Snapshots
+	VersionsKept uint64
+	// values AdmitTinyLFU didn't let into the value cache
+	ValueRejected uint64
+	// pages restored from the double-write buffer after a crash
+	PagesRestored uint64
+	// page writes read back and compared, and the ones that read back wrong
+	PagesVerified   uint64
+	WriteMismatches uint64
+	// the last recovery, checkpoint and compaction, for capacity planning:
+	// Sub and Reset pass them on as they are
+	LastRecovery   Timing
+	LastCheckpoint Timing
+	LastCompaction Timing
+}
+
+// Stats returns the live counters of the storage.
+func (s *Storage) Stats() *Stats {
+	return &s.stats
+}
+
+// Snapshot copies the current counter values.
+func (st *Stats) Snapshot() StatsSnapshot {
+	return StatsSnapshot{
+		Gets:         st.gets.Load(),
+		Puts:         st.puts.Load(),
+		Deletes:      st.deletes.Load(),
+		CacheHits:    st.cacheHits.Load(),
+		CacheMisses:  st.cacheMisses.Load(),
+		Coalesced:    st.coalesced.Load(),
+		ValueHits:    st.valueHits.Load(),
+		ValueMisses:  st.valueMisses.Load(),
+		PageReads:    st.pageReads.Load(),
+		PageWrites:   st.pageWrites.Load(),
+		BytesRead:    st.bytesRead.Load(),
+		BytesWritten: st.bytesWritten.Load(),
+		Syncs:        st.syncs.Load(),
+		Stalls:       st.stalls.Load(),
+		StallTime:    time.Duration(st.stallNanos.Load()),
+		Rejected:     st.rejected.Load(),
+		Compactions:  st.compactions.Load(),
+		Since:        time.Unix(0, st.since.Load()),
+		TakenAt:      st.now(),
+		Buckets:      st.buckets.snapshot(false),
+
+		ReclaimedBytes:         st.reclaimed.Load(),
+		TriggeredDeadSpace:     st.triggeredDeadSpace.Load(),
+		TriggeredTombstones:    st.triggeredTombstones.Load(),
+		TriggeredFragmentation: st.triggeredFragmentation.Load(),
+
+		PagesExpired: st.pagesExpired.Load(),
+		KeysExpired:  st.keysExpired.Load(),
+
+		PageEvictions:    st.pageEvictions.Load(),
+		PagesInvalidated: st.pagesInvalidated.Load(),
+		PagesEvictedCold: st.pagesEvictedCold.Load(),
+
+		GroupCommits: st.groupCommits.Load(),
+		GroupSyncs:   st.groupSyncs.Load(),
+
+		Checkpoints:      st.checkpoints.Load(),
+		CheckpointErrors: st.checkpointErrors.Load(),
+
+		WALFull:  st.walFull.Load(),
+		DiskFull: st.diskFull.Load(),
+
+		PagesTiered: st.pagesTiered.Load(),
+		ColdReads:   st.coldReads.Load(),
+
+		Snapshots:      st.snapshots.Load(),
+		SnapshotErrors: st.snapshotErrors.Load(),
+
+		VersionsKept: st.versionsKept.Load(),
+
+		ValueRejected: st.valueRejected.Load(),
+
+		PagesRestored: st.pagesRestored.Load(),
+
+		PagesVerified:   st.pagesVerified.Load(),
+		WriteMismatches: st.writeMismatches.Load(),
+
+		LastRecovery:   loadTiming(&st.lastRecovery),
+		LastCheckpoint: loadTiming(&st.lastCheckpoint),
+		LastCompaction: loadTiming(&st.lastCompaction),
+	}
+}
+
+// Reset sets every counter back to zero and returns the values they had,
+// so "snapshot then reset" can't lose an increment that lands in between two calls.
+func (st *Stats) Reset() StatsSnapshot {
+	now := st.now()
+	snap := StatsSnapshot{
+		Gets:         st.gets.Swap(0),
+		Puts:         st.puts.Swap(0),
+		Deletes:      st.deletes.Swap(0),
+		CacheHits:    st.cacheHits.Swap(0),
+		CacheMisses:  st.cacheMisses.Swap(0),
+		Coalesced:    st.coalesced.Swap(0),
+		ValueHits:    st.valueHits.Swap(0),
+		ValueMisses:  st.valueMisses.Swap(0),
+		PageReads:    st.pageReads.Swap(0),
+		PageWrites:   st.pageWrites.Swap(0),
+		BytesRead:    st.bytesRead.Swap(0),
+		BytesWritten: st.bytesWritten.Swap(0),
+		Syncs:        st.syncs.Swap(0),
+		Stalls:       st.stalls.Swap(0),
+		StallTime:    time.Duration(st.stallNanos.Swap(0)),
+		Rejected:     st.rejected.Swap(0),
+		Compactions:  st.compactions.Swap(0),
+		Since:        time.Unix(0, st.since.Swap(now.UnixNano())),
+		TakenAt:      now,
+		Buckets:      st.buckets.snapshot(true),
+
+		ReclaimedBytes:         st.reclaimed.Swap(0),
+		TriggeredDeadSpace:     st.triggeredDeadSpace.Swap(0),
+		TriggeredTombstones:    st.triggeredTombstones.Swap(0),
+		TriggeredFragmentation: st.triggeredFragmentation.Swap(0),
+
+		PagesExpired: st.pagesExpired.Swap(0),
+		KeysExpired:  st.keysExpired.Swap(0),
+
+		PageEvictions:    st.pageEvictions.Swap(0),
+		PagesInvalidated: st.pagesInvalidated.Swap(0),
+		PagesEvictedCold: st.pagesEvictedCold.Swap(0),
+
+		GroupCommits: st.groupCommits.Swap(0),
+		GroupSyncs:   st.groupSyncs.Swap(0),
+
+		Checkpoints:      st.checkpoints.Swap(0),
+		CheckpointErrors: st.checkpointErrors.Swap(0),
+
+		WALFull:  st.walFull.Swap(0),
+		DiskFull: st.diskFull.Swap(0),
+
+		PagesTiered: st.pagesTiered.Swap(0),
+		ColdReads:   st.coldReads.Swap(0),
+
+		Snapshots:      st.snapshots.Swap(0),
+		SnapshotErrors: st.snapshotErrors.Swap(0),
+
+		VersionsKept: st.versionsKept.Swap(0),
+
+		ValueRejected: st.valueRejected.Swap(0),
+
+		PagesRestored: st.pagesRestored.Swap(0),
+
+		PagesVerified:   st.pagesVerified.Swap(0),
+		WriteMismatches: st.writeMismatches.Swap(0),
+
+		LastRecovery:   loadTiming(&st.lastRecovery),
+		LastCheckpoint: loadTiming(&st.lastCheckpoint),
+		LastCompaction: loadTiming(&st.lastCompaction),
+	}
+	return snap
+}
+
+// Sub returns the difference between two snapshots (s - prev),
+// example: per-interval ops = cur.Sub(prev).Gets
+func (s StatsSnapshot) Sub(prev StatsSnapshot) StatsSnapshot {
+	return StatsSnapshot{
+		Gets:         s.Gets - prev.Gets,
+		Puts:         s.Puts - prev.Puts,
+		Deletes:      s.Deletes - prev.Deletes,
+		CacheHits:    s.CacheHits - prev.CacheHits,
+		CacheMisses:  s.CacheMisses - prev.CacheMisses,
+		Coalesced:    s.Coalesced - prev.Coalesced,
+		ValueHits:    s.ValueHits - prev.ValueHits,
+		ValueMisses:  s.ValueMisses - prev.ValueMisses,
+		PageReads:    s.PageReads - prev.PageReads,
+		PageWrites:   s.PageWrites - prev.PageWrites,
+		BytesRead:    s.BytesRead - prev.BytesRead,
+		BytesWritten: s.BytesWritten - prev.BytesWritten,
+		Syncs:        s.Syncs - prev.Syncs,
+		Stalls:       s.Stalls - prev.Stalls,
+		StallTime:    s.StallTime - prev.StallTime,
+		Rejected:     s.Rejected - prev.Rejected,
+		Compactions:  s.Compactions - prev.Compactions,
+		Since:        prev.TakenAt,
+		TakenAt:      s.TakenAt,
+		Buckets:      subBuckets(s.Buckets, prev.Buckets),
+
+		ReclaimedBytes:         s.ReclaimedBytes - prev.ReclaimedBytes,
+		TriggeredDeadSpace:     s.TriggeredDeadSpace - prev.TriggeredDeadSpace,
+		TriggeredTombstones:    s.TriggeredTombstones - prev.TriggeredTombstones,
+		TriggeredFragmentation: s.TriggeredFragmentation - prev.TriggeredFragmentation,
+
+		PagesExpired: s.PagesExpired - prev.PagesExpired,
+		KeysExpired:  s.KeysExpired - prev.KeysExpired,
+
+		PageEvictions:    s.PageEvictions - prev.PageEvictions,
+		PagesInvalidated: s.PagesInvalidated - prev.PagesInvalidated,
+		PagesEvictedCold: s.PagesEvictedCold - prev.PagesEvictedCold,
+
+		GroupCommits: s.GroupCommits - prev.GroupCommits,
+		GroupSyncs:   s.GroupSyncs - prev.GroupSyncs,
+
+		Checkpoints:      s.Checkpoints - prev.Checkpoints,
+		CheckpointErrors: s.CheckpointErrors - prev.CheckpointErrors,
+
+		WALFull:  s.WALFull - prev.WALFull,
+		DiskFull: s.DiskFull - prev.DiskFull,
+
+		PagesTiered: s.PagesTiered - prev.PagesTiered,
+		ColdReads:   s.ColdReads - prev.ColdReads,
+
+		Snapshots:      s.Snapshots - prev.Snapshots,
+		SnapshotErrors: s.SnapshotErrors - prev.SnapshotErrors,
+
+		VersionsKept: s.VersionsKept - prev.VersionsKept,
+
+		ValueRejected: s.ValueRejected - prev.ValueRejected,
+
+		PagesRestored: s.PagesRestored - prev.PagesRestored,
+
+		PagesVerified:   s.PagesVerified - prev.PagesVerified,
+		WriteMismatches: s.WriteMismatches - prev.WriteMismatches,
+
+		LastRecovery:   s.LastRecovery,
+		LastCheckpoint: s.LastCheckpoint,
+		LastCompaction: s.LastCompaction,
+	}
+}
+
+// ValueHitRate returns the share of value cache lookups that hit, 0 to 1,
+// over the snapshot's interval when it came from Sub or Reset.
+func (s StatsSnapshot) ValueHitRate() float64 {
+	if s.ValueHits+s.ValueMisses == 0 {
+		return 0
+	}
+	return float64(s.ValueHits) / float64(s.ValueHits+s.ValueMisses)
+}
+
+func (st *Stats) countCompaction(reclaimed int64, triggers []CompactTrigger) {
+	st.compactions.Add(1)
+	if reclaimed > 0 {
+		st.reclaimed.Add(uint64(reclaimed))
+	}
+	for _, t := range triggers {
+		switch t {
+		case TriggerDeadSpace:
+			st.triggeredDeadSpace.Add(1)
+		case TriggerTombstones:
+			st.triggeredTombstones.Add(1)
+		case TriggerFragmentation:
+			st.triggeredFragmentation.Add(1)
+		}
+	}
+}
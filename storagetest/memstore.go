@@ -0,0 +1,63 @@
+package storagetest
+
+import (
+	"errors"
+	"sync"
+)
+
+// MemStore is a map based Store. it is the reference the suite itself is
+// tested against, and the model other engines can be compared with.
+type MemStore struct {
+	mu   sync.RWMutex
+	data map[string]string
+}
+
+// the stores opened per path, so reopening sees the same data
+var (
+	memMu     sync.Mutex
+	memByPath = map[string]map[string]string{}
+)
+
+// OpenMemStore is an Opener for MemStore.
+func OpenMemStore(path string) (Store, error) {
+	memMu.Lock()
+	defer memMu.Unlock()
+	data, ok := memByPath[path]
+	if !ok {
+		data = map[string]string{}
+		memByPath[path] = data
+	}
+	return &MemStore{data: data}, nil
+}
+
+var errNotFound = errors.New("key not found")
+
+func (m *MemStore) Put(key, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[key] = value
+	return nil
+}
+
+func (m *MemStore) Get(key string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, ok := m.data[key]
+	if !ok {
+		return "", errNotFound
+	}
+	return value, nil
+}
+
+func (m *MemStore) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data[key]; !ok {
+		return errNotFound
+	}
+	delete(m.data, key)
+	return nil
+}
+
+func (m *MemStore) Sync() error  { return nil }
+func (m *MemStore) Close() error { return nil }
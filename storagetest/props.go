@@ -0,0 +1,251 @@
+package storagetest
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// property based testing: generate random sequences of operations, run them
+// against the engine and a plain map at the same time, and check they agree
+// after every single operation and after every reopen. when a sequence fails
+// it is shrunk to the shortest sequence that still fails, which is usually a
+// handful of operations you can read and turn into a regular test.
+
+// OpKind is the type of one generated operation.
+type OpKind int
+
+const (
+	OpPut OpKind = iota
+	OpDelete
+	OpGet
+	OpSync
+	OpReopen
+)
+
+// Op is one generated operation.
+type Op struct {
+	Kind  OpKind
+	Key   string
+	Value string
+}
+
+func (o Op) String() string {
+	switch o.Kind {
+	case OpPut:
+		return fmt.Sprintf("Put(%q, %s)", o.Key, short(o.Value))
+	case OpDelete:
+		return fmt.Sprintf("Delete(%q)", o.Key)
+	case OpGet:
+		return fmt.Sprintf("Get(%q)", o.Key)
+	case OpSync:
+		return "Sync()"
+	case OpReopen:
+		return "Reopen()"
+	default:
+		return fmt.Sprintf("Op(%d)", int(o.Kind))
+	}
+}
+
+// Generator produces random operation sequences. the zero value works,
+// set fields to steer it towards the part of the engine you're changing.
+type Generator struct {
+	Keys     int            // keyspace size, 0 means 16 (small so keys get reused)
+	MaxValue int            // longest generated value, 0 means 512
+	Weights  map[OpKind]int // relative frequency of each kind, nil means a default mix
+	// Value, if set, replaces the default value generator
+	Value func(r *rand.Rand) string
+}
+
+var defaultWeights = map[OpKind]int{OpPut: 50, OpDelete: 15, OpGet: 25, OpSync: 5, OpReopen: 5}
+
+// Generate returns n random operations.
+func (g Generator) Generate(r *rand.Rand, n int) []Op {
+	keys, weights := g.Keys, g.Weights
+	if keys == 0 {
+		keys = 16
+	}
+	if weights == nil {
+		weights = defaultWeights
+	}
+	// walk the kinds in a fixed order so the same seed gives the same ops
+	kinds := []OpKind{OpPut, OpDelete, OpGet, OpSync, OpReopen}
+	total := 0
+	for _, k := range kinds {
+		total += weights[k]
+	}
+
+	ops := make([]Op, n)
+	for i := range ops {
+		pick := r.Intn(total)
+		for _, k := range kinds {
+			if pick -= weights[k]; pick < 0 {
+				ops[i].Kind = k
+				break
+			}
+		}
+		ops[i].Key = fmt.Sprintf("k%02d", r.Intn(keys))
+		if ops[i].Kind == OpPut {
+			ops[i].Value = g.value(r)
+		}
+	}
+	return ops
+}
+
+func (g Generator) value(r *rand.Rand) string {
+	if g.Value != nil {
+		return g.Value(r)
+	}
+	max := g.MaxValue
+	if max == 0 {
+		max = 512
+	}
+	// mix of empty, tiny and large values, page based engines care about sizes
+	switch r.Intn(4) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprint(r.Intn(1000))
+	default:
+		return strings.Repeat(string(rune('a'+r.Intn(26))), r.Intn(max+1))
+	}
+}
+
+// CheckOps runs ops against a store opened at path and a model map,
+// returning an error naming the first operation where they disagree.
+func CheckOps(open Opener, path string, ops []Op) error {
+	store, err := open(path)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	defer func() { store.Close() }()
+
+	model := map[string]string{}
+	keys := map[string]bool{} // every key ever touched, checked after each op
+
+	for i, op := range ops {
+		keys[op.Key] = true
+		switch op.Kind {
+		case OpPut:
+			if err := store.Put(op.Key, op.Value); err != nil {
+				return fmt.Errorf("op %d %s: %w", i, op, err)
+			}
+			model[op.Key] = op.Value
+		case OpDelete:
+			_, exists := model[op.Key]
+			err := store.Delete(op.Key)
+			if exists != (err == nil) {
+				return fmt.Errorf("op %d %s: err=%v but key exists=%t", i, op, err, exists)
+			}
+			delete(model, op.Key)
+		case OpGet:
+			// checked below with everything else
+		case OpSync:
+			if err := store.Sync(); err != nil {
+				return fmt.Errorf("op %d %s: %w", i, op, err)
+			}
+		case OpReopen:
+			if err := store.Close(); err != nil {
+				return fmt.Errorf("op %d %s: close: %w", i, op, err)
+			}
+			if store, err = open(path); err != nil {
+				return fmt.Errorf("op %d %s: open: %w", i, op, err)
+			}
+		}
+
+		if err := compare(store, model, keys); err != nil {
+			return fmt.Errorf("after op %d %s: %w", i, op, err)
+		}
+	}
+
+	// whatever happened, a clean reopen at the end must see the model
+	if err := store.Close(); err != nil {
+		return fmt.Errorf("final close: %w", err)
+	}
+	if store, err = open(path); err != nil {
+		return fmt.Errorf("final open: %w", err)
+	}
+	if err := compare(store, model, keys); err != nil {
+		return fmt.Errorf("after final reopen: %w", err)
+	}
+	return nil
+}
+
+func compare(store Store, model map[string]string, keys map[string]bool) error {
+	for key := range keys {
+		want, exists := model[key]
+		got, err := store.Get(key)
+		if exists && (err != nil || got != want) {
+			return fmt.Errorf("Get(%q) = %q, %v; model has %s", key, short(got), err, short(want))
+		}
+		if !exists && err == nil {
+			return fmt.Errorf("Get(%q) = %s, model says missing", key, short(got))
+		}
+	}
+	return nil
+}
+
+// Shrink makes a failing sequence as short as it can while fails(ops) stays
+// true, by repeatedly trying to drop chunks of operations (halves, quarters,
+// ... single ops) and keeping every removal that still fails.
+func Shrink(ops []Op, fails func([]Op) bool) []Op {
+	for chunk := len(ops) / 2; chunk >= 1; chunk /= 2 {
+		for start := 0; start+chunk <= len(ops); {
+			candidate := append(append([]Op{}, ops[:start]...), ops[start+chunk:]...)
+			if fails(candidate) {
+				ops = candidate // keep the removal, try the same position again
+			} else {
+				start += chunk
+			}
+		}
+	}
+	return ops
+}
+
+// PropertyConfig describes a property test run.
+type PropertyConfig struct {
+	Open      Opener
+	Seed      int64 // first seed, every run uses the next one
+	Runs      int   // number of random sequences, 0 means 50
+	OpsPerRun int   // length of each sequence, 0 means 200
+	Generator Generator
+}
+
+// Property runs random sequences against the engine and, on failure, reports
+// the seed and the shrunk sequence of operations that reproduces it.
+func Property(t *testing.T, cfg PropertyConfig) {
+	t.Helper()
+	if cfg.Runs == 0 {
+		cfg.Runs = 50
+	}
+	if cfg.OpsPerRun == 0 {
+		cfg.OpsPerRun = 200
+	}
+
+	dir := t.TempDir()
+	attempt := 0
+	// every attempt gets a new file, shrinking replays from scratch
+	check := func(ops []Op) error {
+		attempt++
+		return CheckOps(cfg.Open, filepath.Join(dir, fmt.Sprintf("prop-%d.db", attempt)), ops)
+	}
+
+	for run := 0; run < cfg.Runs; run++ {
+		seed := cfg.Seed + int64(run)
+		ops := cfg.Generator.Generate(rand.New(rand.NewSource(seed)), cfg.OpsPerRun)
+		err := check(ops)
+		if err == nil {
+			continue
+		}
+
+		minimal := Shrink(ops, func(candidate []Op) bool { return check(candidate) != nil })
+		lines := make([]string, len(minimal))
+		for i, op := range minimal {
+			lines[i] = "  " + op.String()
+		}
+		t.Fatalf("seed %d: %v\nshrunk to %d of %d operations (%v):\n%s",
+			seed, err, len(minimal), len(ops), check(minimal), strings.Join(lines, "\n"))
+	}
+}
@@ -0,0 +1,346 @@
+package storagetest
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FakeClock is a clock that only moves when told to. Sleep advances it
+// instead of blocking, so code that waits finishes instantly and the same
+// seed always sees the same times. a channel from After fires once the clock
+// is moved past its deadline.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewFakeClock returns a clock standing at start.
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// After returns a channel that gets the clock's time once it has moved d on.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeTimer{at: c.now.Add(d), c: ch})
+	return ch
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.c <- c.now
+	}
+	c.waiters = waiting
+}
+
+// Actor is one kind of thing that can happen in a simulation: a user write,
+// a flush, a checkpoint, a restart... every step the scheduler picks one
+// actor at random (weighted) and runs one Step of it.
+type Actor struct {
+	Name   string
+	Weight int // relative chance of being picked, 0 means 1
+	Step   func(sim *Sim) error
+}
+
+// SimConfig describes a simulation run.
+type SimConfig struct {
+	Seed  int64
+	Steps int // number of scheduler steps, 0 means 1000
+	Keys  int // size of the keyspace the user actors work on, 0 means 20
+	// opens the store at path using clock as its time source
+	Open func(path string, clock *FakeClock) (Store, error)
+	// extra actors (checkpointers, compactors, ...) scheduled next to the built-in ones
+	Actors []Actor
+}
+
+// Sim is the state of one simulation run, handed to every actor step.
+type Sim struct {
+	Rand  *rand.Rand
+	Clock *FakeClock
+	Store Store
+	path  string
+	open  func(path string, clock *FakeClock) (Store, error)
+	keys  int
+
+	// the model: what every key must hold right now
+	model map[string]string
+	// what every key held at the last Sync. a crash may lose anything after
+	// it, so after a crash a key may hold its synced value or any later one
+	synced  map[string]string
+	written map[string][]*string // values written since the last Sync, nil = deleted
+
+	trace []string
+}
+
+// Simulate runs one deterministic simulation. every decision comes from the
+// seeded random source and the fake clock, so a failure prints a seed that
+// reproduces exactly the same sequence of operations.
+func Simulate(t *testing.T, cfg SimConfig) {
+	t.Helper()
+	if cfg.Steps == 0 {
+		cfg.Steps = 1000
+	}
+	if cfg.Keys == 0 {
+		cfg.Keys = 20
+	}
+
+	sim := &Sim{
+		Rand:    rand.New(rand.NewSource(cfg.Seed)),
+		Clock:   NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+		path:    Path(t),
+		open:    cfg.Open,
+		keys:    cfg.Keys,
+		model:   map[string]string{},
+		synced:  map[string]string{},
+		written: map[string][]*string{},
+	}
+	if err := sim.reopen(); err != nil {
+		t.Fatalf("seed %d: open: %v", cfg.Seed, err)
+	}
+	defer func() { sim.Store.Close() }()
+
+	actors := append(sim.builtinActors(), cfg.Actors...)
+	total := 0
+	for _, a := range actors {
+		total += weight(a)
+	}
+
+	for step := 0; step < cfg.Steps; step++ {
+		// pick an actor, the chance is proportional to its weight
+		n := sim.Rand.Intn(total)
+		var actor Actor
+		for _, a := range actors {
+			if n -= weight(a); n < 0 {
+				actor = a
+				break
+			}
+		}
+
+		sim.Clock.Advance(time.Duration(sim.Rand.Intn(1000)) * time.Millisecond)
+		if err := actor.Step(sim); err != nil {
+			t.Fatalf("seed %d, step %d (%s): %v\nlast operations:\n%s",
+				cfg.Seed, step, actor.Name, err, sim.lastTrace(20))
+		}
+	}
+}
+
+func weight(a Actor) int {
+	if a.Weight <= 0 {
+		return 1
+	}
+	return a.Weight
+}
+
+// Tracef records what an actor did, the tail of the trace is printed on failure.
+func (sim *Sim) Tracef(format string, args ...any) {
+	sim.trace = append(sim.trace, fmt.Sprintf("%s "+format, append([]any{sim.Clock.Now().Format("15:04:05.000")}, args...)...))
+}
+
+func (sim *Sim) lastTrace(n int) string {
+	from := len(sim.trace) - n
+	if from < 0 {
+		from = 0
+	}
+	return "  " + strings.Join(sim.trace[from:], "\n  ")
+}
+
+// RandomKey picks a key from the simulation's keyspace.
+func (sim *Sim) RandomKey() string {
+	return fmt.Sprintf("key:%03d", sim.Rand.Intn(sim.keys))
+}
+
+// Put writes through the store and the model.
+func (sim *Sim) Put(key, value string) error {
+	sim.Tracef("put %s (%d bytes)", key, len(value))
+	if err := sim.Store.Put(key, value); err != nil {
+		return fmt.Errorf("Put(%q): %w", key, err)
+	}
+	sim.model[key] = value
+	v := value
+	sim.written[key] = append(sim.written[key], &v)
+	return nil
+}
+
+// Delete deletes through the store and the model.
+func (sim *Sim) Delete(key string) error {
+	sim.Tracef("delete %s", key)
+	_, exists := sim.model[key]
+	err := sim.Store.Delete(key)
+	if exists && err != nil {
+		return fmt.Errorf("Delete(%q): %w", key, err)
+	}
+	if !exists && err == nil {
+		return fmt.Errorf("Delete(%q) of a missing key succeeded", key)
+	}
+	delete(sim.model, key)
+	sim.written[key] = append(sim.written[key], nil)
+	return nil
+}
+
+// Sync syncs the store, everything in the model is now durable.
+func (sim *Sim) Sync() error {
+	sim.Tracef("sync")
+	if err := sim.Store.Sync(); err != nil {
+		return fmt.Errorf("Sync: %w", err)
+	}
+	sim.markDurable()
+	return nil
+}
+
+func (sim *Sim) markDurable() {
+	sim.synced = make(map[string]string, len(sim.model))
+	for k, v := range sim.model {
+		sim.synced[k] = v
+	}
+	sim.written = map[string][]*string{}
+}
+
+// Check compares every key of the keyspace with the model.
+func (sim *Sim) Check() error {
+	for i := 0; i < sim.keys; i++ {
+		key := fmt.Sprintf("key:%03d", i)
+		want, exists := sim.model[key]
+		got, err := sim.Store.Get(key)
+		switch {
+		case exists && err != nil:
+			return fmt.Errorf("Get(%q): %v, model has %d bytes", key, err, len(want))
+		case exists && got != want:
+			return fmt.Errorf("Get(%q) = %q, model has %q", key, short(got), short(want))
+		case !exists && err == nil:
+			return fmt.Errorf("Get(%q) = %q, model says deleted", key, short(got))
+		}
+	}
+	return nil
+}
+
+func (sim *Sim) reopen() error {
+	store, err := sim.open(sim.path, sim.Clock)
+	if err != nil {
+		return err
+	}
+	sim.Store = store
+	return nil
+}
+
+// Restart closes the store cleanly and opens it again, nothing may be lost.
+func (sim *Sim) Restart() error {
+	sim.Tracef("restart")
+	if err := sim.Store.Close(); err != nil {
+		return fmt.Errorf("Close: %w", err)
+	}
+	sim.markDurable()
+	if err := sim.reopen(); err != nil {
+		return fmt.Errorf("reopen: %w", err)
+	}
+	return sim.Check()
+}
+
+// Crash drops the store without flushing and opens it again. every key must
+// hold its value from the last Sync or one written after it, never anything else.
+func (sim *Sim) Crash() error {
+	crasher, ok := sim.Store.(Crasher)
+	if !ok {
+		return nil
+	}
+	sim.Tracef("crash")
+	if err := crasher.Crash(); err != nil {
+		return fmt.Errorf("Crash: %w", err)
+	}
+	if err := sim.reopen(); err != nil {
+		return fmt.Errorf("reopen after crash: %w", err)
+	}
+
+	// the recovered state becomes the new model
+	recovered := map[string]string{}
+	for i := 0; i < sim.keys; i++ {
+		key := fmt.Sprintf("key:%03d", i)
+		got, err := sim.Store.Get(key)
+		if !sim.acceptable(key, got, err == nil) {
+			return fmt.Errorf("after crash %q holds %q (found=%t), which was never its value since the last sync", key, short(got), err == nil)
+		}
+		if err == nil {
+			recovered[key] = got
+		}
+	}
+	sim.model = recovered
+	sim.markDurable()
+	return nil
+}
+
+// is (value, found) a state the key went through since the last sync?
+func (sim *Sim) acceptable(key, value string, found bool) bool {
+	synced, wasSynced := sim.synced[key]
+	if found == wasSynced && (!found || value == synced) {
+		return true
+	}
+	for _, v := range sim.written[key] {
+		if v == nil && !found {
+			return true
+		}
+		if v != nil && found && *v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// the user workload plus flushing and restarts
+func (sim *Sim) builtinActors() []Actor {
+	return []Actor{
+		{Name: "put", Weight: 40, Step: func(sim *Sim) error {
+			size := sim.Rand.Intn(300)
+			return sim.Put(sim.RandomKey(), strings.Repeat(string(rune('a'+sim.Rand.Intn(26))), size))
+		}},
+		{Name: "delete", Weight: 10, Step: func(sim *Sim) error {
+			return sim.Delete(sim.RandomKey())
+		}},
+		{Name: "get", Weight: 30, Step: func(sim *Sim) error {
+			key := sim.RandomKey()
+			sim.Tracef("get %s", key)
+			want, exists := sim.model[key]
+			got, err := sim.Store.Get(key)
+			if exists != (err == nil) || got != want {
+				return fmt.Errorf("Get(%q) = %q, %v; model has %q (exists=%t)", key, short(got), err, short(want), exists)
+			}
+			return nil
+		}},
+		{Name: "flush", Weight: 8, Step: (*Sim).Sync},
+		{Name: "restart", Weight: 2, Step: (*Sim).Restart},
+		{Name: "crash", Weight: 2, Step: (*Sim).Crash},
+	}
+}
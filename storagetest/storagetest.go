@@ -0,0 +1,294 @@
+// Package storagetest is the behavioural test suite every GoData engine has to
+// pass. an engine plugs in through the small Store interface, so the file
+// engine, an in-memory engine or anything added later are all held to exactly
+// the same rules:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, storagetest.Suite{Open: openMyEngine})
+//	}
+package storagetest
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Store is what the suite needs from an engine.
+type Store interface {
+	Put(key, value string) error
+	Get(key string) (string, error) // must return an error for a missing key
+	Delete(key string) error        // must return an error for a missing key
+	Sync() error                    // makes everything written so far durable
+	Close() error
+}
+
+// Crasher is implemented by stores that can simulate a crash: the handle is
+// dropped without flushing anything, like a killed process would.
+type Crasher interface {
+	Crash() error
+}
+
+// Opener opens the store at path, creating it if it doesn't exist.
+// it is called again with the same path to test reopening.
+type Opener func(path string) (Store, error)
+
+// Suite describes the engine under test.
+type Suite struct {
+	Open Opener
+	// the engine allows Get/Put/Delete from many goroutines at once
+	Concurrent bool
+	// largest value the engine promises to store, 0 means 1000 bytes
+	MaxValueSize int
+}
+
+// Run runs every conformance test as a subtest of t.
+func Run(t *testing.T, suite Suite) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s Suite)
+	}{
+		{"PutGet", testPutGet},
+		{"Update", testUpdate},
+		{"MissingKey", testMissingKey},
+		{"Delete", testDelete},
+		{"EmptyKeyAndValue", testEmptyKeyAndValue},
+		{"LargeValue", testLargeValue},
+		{"ManyKeys", testManyKeys},
+		{"Reopen", testReopen},
+		{"DeleteSurvivesReopen", testDeleteSurvivesReopen},
+		{"SyncedSurvivesCrash", testSyncedSurvivesCrash},
+		{"ConcurrentAccess", testConcurrentAccess},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) { tc.fn(t, suite) })
+	}
+}
+
+// Path returns a fresh database path inside the test's temp dir.
+func Path(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "conformance.db")
+}
+
+func mustOpen(t *testing.T, s Suite, path string) Store {
+	t.Helper()
+	store, err := s.Open(path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	return store
+}
+
+func mustPut(t *testing.T, store Store, key, value string) {
+	t.Helper()
+	if err := store.Put(key, value); err != nil {
+		t.Fatalf("Put(%q): %v", key, err)
+	}
+}
+
+// Expect fails the test unless key holds want.
+func Expect(t *testing.T, store Store, key, want string) {
+	t.Helper()
+	got, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	if got != want {
+		t.Fatalf("Get(%q) = %q, want %q", key, short(got), short(want))
+	}
+}
+
+// ExpectMissing fails the test if key exists.
+func ExpectMissing(t *testing.T, store Store, key string) {
+	t.Helper()
+	if got, err := store.Get(key); err == nil {
+		t.Fatalf("Get(%q) = %q, want a not found error", key, short(got))
+	}
+}
+
+// keeps failure messages readable when values are kilobytes long
+func short(s string) string {
+	if len(s) > 40 {
+		return fmt.Sprintf("%s... (%d bytes)", s[:40], len(s))
+	}
+	return s
+}
+
+func testPutGet(t *testing.T, s Suite) {
+	store := mustOpen(t, s, Path(t))
+	defer store.Close()
+
+	mustPut(t, store, "user:1", "isabella")
+	mustPut(t, store, "user:2", "cam")
+	Expect(t, store, "user:1", "isabella")
+	Expect(t, store, "user:2", "cam")
+}
+
+func testUpdate(t *testing.T, s Suite) {
+	store := mustOpen(t, s, Path(t))
+	defer store.Close()
+
+	mustPut(t, store, "user:1", "isabella")
+	mustPut(t, store, "user:1", "leonor")
+	mustPut(t, store, "user:1", "a much longer value than before")
+	Expect(t, store, "user:1", "a much longer value than before")
+}
+
+func testMissingKey(t *testing.T, s Suite) {
+	store := mustOpen(t, s, Path(t))
+	defer store.Close()
+
+	ExpectMissing(t, store, "nonexistent")
+	if err := store.Delete("nonexistent"); err == nil {
+		t.Fatal("Delete of a missing key should fail")
+	}
+}
+
+func testDelete(t *testing.T, s Suite) {
+	store := mustOpen(t, s, Path(t))
+	defer store.Close()
+
+	mustPut(t, store, "user:1", "isabella")
+	mustPut(t, store, "user:2", "cam")
+	if err := store.Delete("user:1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	ExpectMissing(t, store, "user:1")
+	Expect(t, store, "user:2", "cam")
+
+	// a deleted key can be written again
+	mustPut(t, store, "user:1", "back")
+	Expect(t, store, "user:1", "back")
+}
+
+func testEmptyKeyAndValue(t *testing.T, s Suite) {
+	store := mustOpen(t, s, Path(t))
+	defer store.Close()
+
+	mustPut(t, store, "", "empty key")
+	mustPut(t, store, "empty:value", "")
+	Expect(t, store, "", "empty key")
+	Expect(t, store, "empty:value", "")
+}
+
+func testLargeValue(t *testing.T, s Suite) {
+	size := s.MaxValueSize
+	if size == 0 {
+		size = 1000
+	}
+	store := mustOpen(t, s, Path(t))
+	defer store.Close()
+
+	value := strings.Repeat("ABCDEFGHIJKLMNOPQRSTUVWXYZ", size/26+1)[:size]
+	mustPut(t, store, "large", value)
+	Expect(t, store, "large", value)
+}
+
+func testManyKeys(t *testing.T, s Suite) {
+	store := mustOpen(t, s, Path(t))
+	defer store.Close()
+
+	// enough data to need many pages in a page based engine
+	for i := 0; i < 500; i++ {
+		mustPut(t, store, fmt.Sprintf("key:%04d", i), fmt.Sprintf("value-%d-%s", i, strings.Repeat("x", i%50)))
+	}
+	for i := 0; i < 500; i++ {
+		Expect(t, store, fmt.Sprintf("key:%04d", i), fmt.Sprintf("value-%d-%s", i, strings.Repeat("x", i%50)))
+	}
+}
+
+func testReopen(t *testing.T, s Suite) {
+	path := Path(t)
+	store := mustOpen(t, s, path)
+	mustPut(t, store, "user:1", "isabella")
+	mustPut(t, store, "user:2", "cam")
+	mustPut(t, store, "user:1", "leonor")
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	store = mustOpen(t, s, path)
+	defer store.Close()
+	Expect(t, store, "user:1", "leonor")
+	Expect(t, store, "user:2", "cam")
+}
+
+func testDeleteSurvivesReopen(t *testing.T, s Suite) {
+	path := Path(t)
+	store := mustOpen(t, s, path)
+	mustPut(t, store, "user:1", "isabella")
+	mustPut(t, store, "user:2", "cam")
+	store.Delete("user:1")
+	store.Close()
+
+	store = mustOpen(t, s, path)
+	defer store.Close()
+	ExpectMissing(t, store, "user:1")
+	Expect(t, store, "user:2", "cam")
+}
+
+func testSyncedSurvivesCrash(t *testing.T, s Suite) {
+	path := Path(t)
+	store := mustOpen(t, s, path)
+	crasher, ok := store.(Crasher)
+	if !ok {
+		store.Close()
+		t.Skip("store can't simulate a crash")
+	}
+
+	mustPut(t, store, "user:1", "isabella")
+	mustPut(t, store, "user:2", "cam")
+	if err := store.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if err := crasher.Crash(); err != nil {
+		t.Fatalf("Crash: %v", err)
+	}
+
+	store = mustOpen(t, s, path)
+	defer store.Close()
+	Expect(t, store, "user:1", "isabella")
+	Expect(t, store, "user:2", "cam")
+}
+
+func testConcurrentAccess(t *testing.T, s Suite) {
+	if !s.Concurrent {
+		t.Skip("store is not safe for concurrent use")
+	}
+	store := mustOpen(t, s, Path(t))
+	defer store.Close()
+
+	const writers, perWriter = 4, 100
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*perWriter*2)
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) { // writer
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				if err := store.Put(fmt.Sprintf("w%d:%d", w, i), fmt.Sprint(i)); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+		go func(w int) { // reader racing the writer, missing keys are fine
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				store.Get(fmt.Sprintf("w%d:%d", w, i))
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent Put: %v", err)
+	}
+
+	for w := 0; w < writers; w++ {
+		for i := 0; i < perWriter; i++ {
+			Expect(t, store, fmt.Sprintf("w%d:%d", w, i), fmt.Sprint(i))
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package storagetest
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestMemStoreConformance(t *testing.T) {
+	Run(t, Suite{Open: OpenMemStore, Concurrent: true, MaxValueSize: 1 << 20})
+}
+
+func TestSimulateMemStore(t *testing.T) {
+	for seed := int64(1); seed <= 3; seed++ {
+		Simulate(t, SimConfig{
+			Seed: seed,
+			Open: func(path string, clock *FakeClock) (Store, error) { return OpenMemStore(path) },
+		})
+	}
+}
+
+func TestPropertyMemStore(t *testing.T) {
+	Property(t, PropertyConfig{Open: OpenMemStore, Runs: 10})
+}
+
+func TestShrink_FindsMinimalSequence(t *testing.T) {
+	ops := Generator{Keys: 4}.Generate(rand.New(rand.NewSource(7)), 300)
+	ops = append(ops, Op{Kind: OpPut, Key: "bad"}, Op{Kind: OpDelete, Key: "bad"})
+
+	// "fails" whenever a put of "bad" is later followed by its delete
+	fails := func(ops []Op) bool {
+		put := false
+		for _, op := range ops {
+			if op.Key == "bad" && op.Kind == OpPut {
+				put = true
+			}
+			if op.Key == "bad" && op.Kind == OpDelete && put {
+				return true
+			}
+		}
+		return false
+	}
+
+	minimal := Shrink(ops, fails)
+	if len(minimal) != 2 || minimal[0].Kind != OpPut || minimal[1].Kind != OpDelete {
+		t.Errorf("Expected the put/delete pair, got %v", minimal)
+	}
+}
+
+func TestFakeClock_After(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	c := clock.After(time.Minute)
+	clock.Advance(59 * time.Second)
+	select {
+	case <-c:
+		t.Fatal("After fired before its time")
+	default:
+	}
+	clock.Sleep(time.Second)
+	select {
+	case at := <-c:
+		if want := time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC); !at.Equal(want) {
+			t.Errorf("After sent %v, want %v", at, want)
+		}
+	default:
+		t.Fatal("After didn't fire once the clock got there")
+	}
+}
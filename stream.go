@@ -0,0 +1,402 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// streamed values: PutReader stores a value read from an io.Reader a page at
+// a time and GetReader hands it back as an io.ReadCloser the same way, so a
+// multi-MB document is never in memory whole, not even once. the value is
+// cut into chunks that each fill an empty page (overflow pages), and the key
+// itself holds a small manifest naming them:
+//
+//	"doc:7"                          → manifest: generation 3f2a..., 768 chunks, 3110912 bytes
+//	"__chunk__:3f2a...:00000000"     → bytes 0..4050
+//	"__chunk__:3f2a...:00000001"     → bytes 4051..8101 ...
+//
+// chunks are ordinary records, so the WAL, recovery, compaction and
+// replication treat them like any other, and a Rename moves the manifest
+// without touching them. a Copy writes them again under a generation of its
+// own, so no two keys share chunks. they go in first and the manifest last,
+// in its own WAL entry, so a crash half way leaves the old value in place and
+// chunks nothing points to, GC drops those. every PutReader writes a new
+// generation: a GetReader that is still reading the old one gets an error
+// once PutReader deletes it.
+//
+// Get, Scan and the other reads see the whole value (Get puts it together in
+// memory, GetReader doesn't). a Put or Delete over a streamed value leaves
+// its chunks behind until GC, a PutReader over one deletes them itself.
+// validators don't see streamed values, and MigrateKeys can't rewrite one
+// bigger than a page. scans, counts and exports leave the chunks out (see
+// iterator.go): an export carries a streamed value whole and Import streams
+// it back in. SnapshotTo and Seal copy the manifests and chunks as they are.
+
+// StreamChunkPrefix is where the chunks of streamed values are kept.
+const StreamChunkPrefix = "__chunk__:"
+
+// the start of a manifest, a value a caller wouldn't store
+const streamMarker = "\x00godata-stream\x00"
+
+type streamManifest struct {
+	gen    uint64
+	chunks int
+	size   int64
+}
+
+func (m streamManifest) String() string {
+	return fmt.Sprintf("%s%016x %d %d", streamMarker, m.gen, m.chunks, m.size)
+}
+
+func parseStreamManifest(value string) (streamManifest, bool) {
+	rest, ok := strings.CutPrefix(value, streamMarker)
+	if !ok {
+		return streamManifest{}, false
+	}
+	var m streamManifest
+	if _, err := fmt.Sscanf(rest, "%x %d %d", &m.gen, &m.chunks, &m.size); err != nil {
+		return streamManifest{}, false
+	}
+	return m, true
+}
+
+func chunkKey(gen uint64, n int) string {
+	return fmt.Sprintf("%s%016x:%08d", StreamChunkPrefix, gen, n)
+}
+
+// the generation a chunk key belongs to
+func chunkGen(key string) (uint64, bool) {
+	rest, ok := strings.CutPrefix(key, StreamChunkPrefix)
+	if !ok || len(rest) < 16 {
+		return 0, false
+	}
+	gen, err := strconv.ParseUint(rest[:16], 16, 64)
+	return gen, err == nil
+}
+
+// the whole value behind a manifest, value itself when it isn't one
+func (s *Storage) resolveStream(value string) (string, error) {
+	return s.assembleStream(value, s.getValue)
+}
+
+// resolveStream, reading the chunks with get
+func (s *Storage) assembleStream(value string, get func(key string) (string, error)) (string, error) {
+	m, ok := parseStreamManifest(value)
+	if !ok {
+		return value, nil
+	}
+	var b strings.Builder
+	b.Grow(int(m.size))
+	for n := 0; n < m.chunks; n++ {
+		chunk, err := get(chunkKey(m.gen, n))
+		if err != nil {
+			return "", fmt.Errorf("streamed value chunk %d of %d: %w", n, m.chunks, err)
+		}
+		b.WriteString(chunk)
+	}
+	return b.String(), nil
+}
+
+// PutReader stores everything r returns as the value of key, a page at a
+// time (see stream.go), and returns how many bytes that was.
+func (s *Storage) PutReader(key string, r io.Reader, opts ...WriteOption) (int64, error) {
+	w := &streamWriter{s: s, m: streamManifest{gen: rand.Uint64()}, wo: s.resolveWriteOptions(opts)}
+	s.mu.Lock()
+	if s.streaming == nil {
+		s.streaming = make(map[uint64]bool)
+	}
+	s.streaming[w.m.gen] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.streaming, w.m.gen)
+		s.mu.Unlock()
+	}()
+	buf := make([]byte, s.chunkSize())
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			s.lockForWrite()
+			werr := w.write(buf[:n])
+			s.mu.Unlock()
+			if werr != nil {
+				w.abort()
+				return w.m.size, werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			w.abort()
+			return w.m.size, err
+		}
+	}
+
+	s.mu.Lock()
+	err := w.finish(key)
+	lsn := s.lsn
+	s.mu.Unlock()
+	if err != nil {
+		if !w.done {
+			w.abort()
+		}
+		return w.m.size, err
+	}
+	return w.m.size, s.awaitCommit(lsn, opts)
+}
+
+// a chunk and its key fill an empty page, count and record header included
+func (s *Storage) chunkSize() int {
+	return s.pageCapacity() - s.pageHeaderSize() - 4 - s.slotSize() - s.versionSize() - len(chunkKey(0, 0))
+}
+
+// whether value, encoded, fits in a page under key. one that doesn't goes in
+// with putStreamed
+func (s *Storage) fitsInPage(key, value string) bool {
+	stored, err := s.encodeValue(key, value)
+	return err != nil || s.checkRecordSize(key, stored) == nil
+}
+
+// PutReader for a value in memory already, Import's way in for a streamed
+// value it was given whole. the caller holds s.mu
+func (s *Storage) putStreamed(key, value string, wo writeOptions) error {
+	w := &streamWriter{s: s, m: streamManifest{gen: rand.Uint64()}, wo: wo}
+	for size := s.chunkSize(); value != ""; {
+		n := min(size, len(value))
+		if err := w.write([]byte(value[:n])); err != nil {
+			s.deleteChunks(w.m.gen, w.m.chunks, wo)
+			return err
+		}
+		value = value[n:]
+	}
+	if err := w.finish(key); err != nil {
+		if !w.done {
+			s.deleteChunks(w.m.gen, w.m.chunks, wo)
+		}
+		return err
+	}
+	return nil
+}
+
+type streamWriter struct {
+	s    *Storage
+	m    streamManifest
+	wo   writeOptions
+	done bool // the manifest is in, the chunks are the value now
+}
+
+// writes the next chunk, the caller holds s.mu
+func (w *streamWriter) write(data []byte) error {
+	if err := w.s.checkWritable(); err != nil {
+		return err
+	}
+	if err := w.s.applyBackpressure(); err != nil {
+		return err
+	}
+	return w.writeChunk(data)
+}
+
+func (w *streamWriter) writeChunk(data []byte) error {
+	key := chunkKey(w.m.gen, w.m.chunks)
+	stored, err := w.s.encodeValue(key, string(data))
+	if err != nil {
+		return err
+	}
+	// a transformer made it bigger than a page, it goes in two halves
+	if w.s.checkRecordSize(key, stored) != nil && len(data) > 1 {
+		if err := w.writeChunk(data[:len(data)/2]); err != nil {
+			return err
+		}
+		return w.writeChunk(data[len(data)/2:])
+	}
+	if err := w.s.put(key, stored, w.wo, 0); err != nil {
+		return err
+	}
+	w.m.chunks++
+	w.m.size += int64(len(data))
+	return nil
+}
+
+// points key at the chunks written and deletes the ones of the value it had,
+// the caller holds s.mu
+func (w *streamWriter) finish(key string) error {
+	s := w.s
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	var old streamManifest
+	var replaced bool
+	if _, exists, err := s.lookup(key); err != nil {
+		return err
+	} else if exists {
+		value, err := s.getValue(key)
+		if err != nil {
+			return err
+		}
+		old, replaced = parseStreamManifest(value)
+	}
+
+	stored, err := s.encodeValue(key, w.m.String())
+	if err != nil {
+		return err
+	}
+	if err := s.put(key, stored, w.wo, 0); err != nil {
+		return err
+	}
+	w.done = true
+	s.stats.puts.Add(1)
+	if replaced {
+		return s.deleteChunks(old.gen, old.chunks, w.wo)
+	}
+	return nil
+}
+
+// puts a copy of the streamed value m under key, with chunks of its own:
+// PutReader over either key deletes the chunks of the value it replaces. the
+// caller holds s.mu
+func (s *Storage) copyStream(m streamManifest, key string, wo writeOptions) error {
+	w := &streamWriter{s: s, m: streamManifest{gen: rand.Uint64()}, wo: wo}
+	err := func() error {
+		for n := 0; n < m.chunks; n++ {
+			chunk, err := s.getValue(chunkKey(m.gen, n))
+			if err != nil {
+				return fmt.Errorf("chunk %d of %d: %w", n, m.chunks, err)
+			}
+			if err := w.writeChunk([]byte(chunk)); err != nil {
+				return err
+			}
+		}
+		stored, err := s.encodeValue(key, w.m.String())
+		if err != nil {
+			return err
+		}
+		return s.put(key, stored, wo, 0)
+	}()
+	if err != nil {
+		s.deleteChunks(w.m.gen, w.m.chunks, wo)
+	}
+	return err
+}
+
+// deletes the chunks written so far, after an error
+func (w *streamWriter) abort() {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	w.s.deleteChunks(w.m.gen, w.m.chunks, w.wo)
+}
+
+func (s *Storage) deleteChunks(gen uint64, chunks int, wo writeOptions) error {
+	for n := 0; n < chunks; n++ {
+		key := chunkKey(gen, n)
+		if _, exists, err := s.lookup(key); err != nil {
+			return err
+		} else if !exists {
+			continue
+		}
+		if err := s.deleteKey(key, wo, 0); err != nil {
+			return err
+		}
+		s.stats.deletes.Add(1)
+	}
+	return nil
+}
+
+// GetReader returns the value of key as a reader. a streamed value is read a
+// chunk at a time as the caller reads, any other value is there already.
+func (s *Storage) GetReader(key string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.stats.gets.Add(1)
+	value, err := s.getValue(key)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := parseStreamManifest(value)
+	if !ok {
+		return io.NopCloser(strings.NewReader(value)), nil
+	}
+	return &streamReader{s: s, key: key, m: m}, nil
+}
+
+type streamReader struct {
+	s     *Storage
+	key   string
+	m     streamManifest
+	next  int    // chunk to load next
+	chunk string // what's left of the current one
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for r.chunk == "" {
+		if r.next >= r.m.chunks {
+			return 0, io.EOF
+		}
+		r.s.mu.RLock()
+		chunk, err := r.s.getValue(chunkKey(r.m.gen, r.next))
+		r.s.mu.RUnlock()
+		if err != nil {
+			return 0, fmt.Errorf("read %q: chunk %d of %d: %w", r.key, r.next, r.m.chunks, err)
+		}
+		r.chunk = chunk
+		r.next++
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+func (r *streamReader) Close() error {
+	r.next, r.chunk = r.m.chunks, ""
+	return nil
+}
+
+// deletes the chunks no manifest points to, for GC. finding the manifests
+// takes every value, it only happens when there are chunks at all.
+func (s *Storage) dropOrphanedChunks() (int, error) {
+	chunks := map[uint64][]string{}
+	err := s.indexPrefix(StreamChunkPrefix, func(key string, _ uint32) bool {
+		if gen, ok := chunkGen(key); ok && !s.streaming[gen] {
+			chunks[gen] = append(chunks[gen], key)
+		}
+		return true
+	})
+	if err != nil || len(chunks) == 0 {
+		return 0, err
+	}
+	var keys []string
+	err = s.indexRange("", "", func(key string, _ uint32) bool {
+		if !strings.HasPrefix(key, StreamChunkPrefix) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		value, err := s.getValue(key)
+		if err != nil {
+			return 0, err
+		}
+		if m, ok := parseStreamManifest(value); ok {
+			delete(chunks, m.gen)
+		}
+	}
+
+	dropped := 0
+	wo := s.resolveWriteOptions(nil)
+	for _, orphans := range chunks {
+		for _, key := range orphans {
+			if err := s.deleteKey(key, wo, 0); err != nil {
+				return dropped, err
+			}
+			s.stats.deletes.Add(1)
+			dropped++
+		}
+	}
+	return dropped, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// long running soak test: a mixed read/write/delete workload against a real
+// database, checked against a shadow map, with a report line every interval
+// so slow leaks (memory growing, latency drifting up, file never shrinking)
+// show up over hours.
+
+// StressConfig describes a stress run.
+type StressConfig struct {
+	Duration    time.Duration // how long to run
+	ReadPct     int           // share of Gets, ReadPct+WritePct+DeletePct must be 100
+	WritePct    int           // share of Puts
+	DeletePct   int           // share of Deletes
+	Keys        int           // size of the keyspace
+	ValueSize   int           // largest value written, sizes are random up to this
+	ReportEvery time.Duration // time between report lines
+	VerifyEvery time.Duration // time between full checks against the shadow model
+	Seed        int64
+	Out         io.Writer // report lines go here
+}
+
+// StressInterval is one report line.
+type StressInterval struct {
+	Elapsed   time.Duration
+	Ops       int
+	OpsPerSec float64
+	P50, P99  time.Duration
+	HeapBytes uint64 // live heap after the interval
+	FileBytes int64  // size of the data file
+}
+
+// StressSummary is what a whole run produced.
+type StressSummary struct {
+	Ops          int
+	Verified     int // how many full verifications passed
+	Intervals    []StressInterval
+	HeapGrowth   int64   // heap at the end minus heap after the first interval
+	LatencyDrift float64 // p50 of the last interval divided by p50 of the first
+}
+
+// ParseMix reads a mix like "70r/25w/5d" (any order, missing parts are 0).
+func ParseMix(mix string) (read, write, del int, err error) {
+	for _, part := range strings.Split(mix, "/") {
+		if len(part) < 2 {
+			return 0, 0, 0, fmt.Errorf("bad mix part %q", part)
+		}
+		n, convErr := strconv.Atoi(part[:len(part)-1])
+		if convErr != nil || n < 0 {
+			return 0, 0, 0, fmt.Errorf("bad mix part %q", part)
+		}
+		switch part[len(part)-1] {
+		case 'r':
+			read = n
+		case 'w':
+			write = n
+		case 'd':
+			del = n
+		default:
+			return 0, 0, 0, fmt.Errorf("bad mix part %q, want r, w or d suffix", part)
+		}
+	}
+	if read+write+del != 100 {
+		return 0, 0, 0, fmt.Errorf("mix %q adds up to %d, not 100", mix, read+write+del)
+	}
+	return read, write, del, nil
+}
+
+// RunStress runs the workload against db until cfg.Duration is over,
+// or until the database disagrees with the shadow model.
+func RunStress(db *Storage, cfg StressConfig) (StressSummary, error) {
+	if cfg.Keys <= 0 {
+		cfg.Keys = 10000
+	}
+	if cfg.ValueSize <= 0 {
+		cfg.ValueSize = 200
+	}
+	if cfg.ReportEvery <= 0 {
+		cfg.ReportEvery = time.Minute
+	}
+	if cfg.VerifyEvery <= 0 {
+		cfg.VerifyEvery = 5 * time.Minute
+	}
+	if cfg.Out == nil {
+		cfg.Out = io.Discard
+	}
+
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	clock := db.clock()
+	shadow := map[string]string{}
+	var summary StressSummary
+	var latencies []time.Duration
+
+	start := clock.Now()
+	nextReport := start.Add(cfg.ReportEvery)
+	nextVerify := start.Add(cfg.VerifyEvery)
+	intervalStart := start
+	// whole seconds are plenty for hour long runs, short test runs want more
+	precision := time.Second
+	if cfg.ReportEvery < 10*time.Second {
+		precision = 10 * time.Millisecond
+	}
+
+	fmt.Fprintf(cfg.Out, "%-10s %10s %10s %10s %10s %12s %12s\n", "elapsed", "ops", "ops/s", "p50", "p99", "heap", "file")
+	for {
+		now := clock.Now()
+		if now.Sub(start) >= cfg.Duration {
+			break
+		}
+
+		key := fmt.Sprintf("stress:%d", rng.Intn(cfg.Keys))
+		roll := rng.Intn(100)
+		opStart := clock.Now()
+		switch {
+		case roll < cfg.ReadPct:
+			value, err := db.Get(key)
+			want, exists := shadow[key]
+			if exists != (err == nil) || value != want {
+				return summary, fmt.Errorf("Get(%q) disagrees with the shadow model (found=%t, expected=%t)", key, err == nil, exists)
+			}
+		case roll < cfg.ReadPct+cfg.WritePct:
+			value := strings.Repeat(string(rune('a'+rng.Intn(26))), rng.Intn(cfg.ValueSize+1))
+			if err := db.Put(key, value); err != nil {
+				return summary, fmt.Errorf("Put(%q): %w", key, err)
+			}
+			shadow[key] = value
+		default:
+			_, exists := shadow[key]
+			if err := db.Delete(key); exists != (err == nil) {
+				return summary, fmt.Errorf("Delete(%q) = %v, shadow has key: %t", key, err, exists)
+			}
+			delete(shadow, key)
+		}
+		latencies = append(latencies, clock.Now().Sub(opStart))
+		summary.Ops++
+
+		if now = clock.Now(); !now.Before(nextVerify) {
+			if err := verifyShadow(db, shadow); err != nil {
+				return summary, err
+			}
+			summary.Verified++
+			nextVerify = now.Add(cfg.VerifyEvery)
+		}
+		if !now.Before(nextReport) {
+			interval := stressInterval(db, now.Sub(start), now.Sub(intervalStart), latencies)
+			summary.Intervals = append(summary.Intervals, interval)
+			fmt.Fprintf(cfg.Out, "%-10s %10d %10.0f %10s %10s %12d %12d\n",
+				interval.Elapsed.Round(precision), interval.Ops, interval.OpsPerSec,
+				interval.P50, interval.P99, interval.HeapBytes, interval.FileBytes)
+			latencies = latencies[:0]
+			intervalStart = now
+			nextReport = now.Add(cfg.ReportEvery)
+		}
+	}
+
+	// one last full check so a run never ends without verification
+	if err := verifyShadow(db, shadow); err != nil {
+		return summary, err
+	}
+	summary.Verified++
+
+	if n := len(summary.Intervals); n > 0 {
+		first, last := summary.Intervals[0], summary.Intervals[n-1]
+		summary.HeapGrowth = int64(last.HeapBytes) - int64(first.HeapBytes)
+		if first.P50 > 0 {
+			summary.LatencyDrift = float64(last.P50) / float64(first.P50)
+		}
+	}
+	return summary, nil
+}
+
+func stressInterval(db *Storage, elapsed, length time.Duration, latencies []time.Duration) StressInterval {
+	interval := StressInterval{Elapsed: elapsed, Ops: len(latencies)}
+	if length > 0 {
+		interval.OpsPerSec = float64(len(latencies)) / length.Seconds()
+	}
+	if len(latencies) > 0 {
+		sorted := append([]time.Duration(nil), latencies...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		interval.P50 = sorted[len(sorted)/2]
+		interval.P99 = sorted[len(sorted)*99/100]
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	interval.HeapBytes = mem.HeapAlloc
+	if stat, err := db.file.Stat(); err == nil {
+		interval.FileBytes = stat.Size()
+	}
+	return interval
+}
+
+// every shadow key must read back exactly, and the db must not know extra keys
+func verifyShadow(db *Storage, shadow map[string]string) error {
+	for key, want := range shadow {
+		got, err := db.Get(key)
+		if err != nil || got != want {
+			return fmt.Errorf("verify: %q = %d bytes, %v; shadow has %d bytes", key, len(got), err, len(want))
+		}
+	}
+	db.mu.RLock()
+	keys := db.indexLen()
+	db.mu.RUnlock()
+	if keys != len(shadow) {
+		return fmt.Errorf("verify: database has %d keys, shadow has %d", keys, len(shadow))
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetOption_ChangesSyncPolicy(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	if err := storage.SetOption("sync", "always"); err != nil {
+		t.Fatalf("SetOption failed: %v", err)
+	}
+	if value, _ := storage.Option("sync"); value != "always" {
+		t.Errorf("Expected sync=always, got %q", value)
+	}
+	if !storage.resolveWriteOptions(nil).sync {
+		t.Error("Expected writes to sync after switching policy")
+	}
+
+	if err := storage.SetOption("sync", "sometimes"); err == nil {
+		t.Error("Expected error for invalid value")
+	}
+	if err := storage.SetOption("compress", "true"); err == nil {
+		t.Error("Expected error for option that can't change at runtime")
+	}
+}
+
+func TestSetOption_AutoCompactThresholds(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	for name, value := range map[string]string{
+		"auto_compact_dead_space":    "0.5",
+		"auto_compact_tombstones":    "1000",
+		"auto_compact_fragmentation": "0.25",
+	} {
+		if err := storage.SetOption(name, value); err != nil {
+			t.Fatalf("SetOption(%s) failed: %v", name, err)
+		}
+		if got, _ := storage.Option(name); got != value {
+			t.Errorf("Expected %s=%s, got %q", name, value, got)
+		}
+	}
+	want := AutoCompactPolicy{DeadSpaceRatio: 0.5, Tombstones: 1000, Fragmentation: 0.25}
+	if storage.opts.AutoCompact != want {
+		t.Errorf("Expected policy %+v, got %+v", want, storage.opts.AutoCompact)
+	}
+
+	for name, value := range map[string]string{
+		"auto_compact_dead_space":    "1.5",
+		"auto_compact_tombstones":    "-1",
+		"auto_compact_fragmentation": "half",
+	} {
+		if err := storage.SetOption(name, value); err == nil {
+			t.Errorf("Expected SetOption(%s, %s) to fail", name, value)
+		}
+	}
+	if storage.opts.AutoCompact != want {
+		t.Errorf("A refused value changed the policy: %+v", storage.opts.AutoCompact)
+	}
+}
+
+func TestSetOption_CacheSize(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	for i := 0; i < 20; i++ { // a page each
+		storage.Put(fmt.Sprintf("key%03d", i), strings.Repeat("v", 3000))
+	}
+	if err := storage.SetOption("cache_size", "5"); err == nil {
+		t.Error("Expected SetOption to fail without a page cache budget")
+	}
+	storage.Close()
+
+	storage = openWithCacheSize(t, filename, 10)
+	defer storage.Close()
+	for _, value := range []string{"0", "many"} {
+		if err := storage.SetOption("cache_size", value); err == nil {
+			t.Errorf("Expected cache_size=%s to fail", value)
+		}
+	}
+	if err := storage.SetOption("cache_size", "4"); err != nil {
+		t.Fatalf("SetOption failed: %v", err)
+	}
+	if got, _ := storage.Option("cache_size"); got != "4" {
+		t.Errorf("Expected cache_size=4, got %q", got)
+	}
+	for i := 0; i < 20; i++ {
+		storage.Get(fmt.Sprintf("key%03d", i))
+	}
+	if len(storage.pages) > 4 {
+		t.Errorf("Expected at most 4 cached pages after shrinking, got %d", len(storage.pages))
+	}
+}
+
+// go test -race catches SetOption writing options a Put reads
+func TestSetOption_WhileWriting(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	stop, done := make(chan struct{}), make(chan error)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				done <- nil
+				return
+			default:
+			}
+			policy := []string{"on_close", "group_commit"}[i%2]
+			if err := storage.SetOption("sync", policy); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	for i := 0; i < 500; i++ {
+		if err := storage.Put(fmt.Sprintf("key:%d", i), "value", WithNoSync()); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatalf("SetOption failed: %v", err)
+	}
+}
+
+func TestAdminHandler_GetAndPutOption(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	handler := storage.AdminHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/options/sync", strings.NewReader("always")))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/options", nil))
+	if !strings.Contains(rec.Body.String(), `"sync":"always"`) {
+		t.Errorf("Expected updated value in listing, got %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/options/sync", strings.NewReader("bogus")))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for bad value, got %d", rec.Code)
+	}
+}
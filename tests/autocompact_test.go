@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"godata/storagetest"
+)
+
+func openAutoCompact(t *testing.T, policy AutoCompactPolicy, clock Clock) (*Storage, string) {
+	filename := "test_" + t.Name() + ".db"
+	opts := DefaultOptions()
+	opts.AutoCompact = policy
+	opts.Clock = clock
+	storage, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	return storage, filename
+}
+
+func TestAutoCompact_TombstonesTrigger(t *testing.T) {
+	storage, filename := openAutoCompact(t, AutoCompactPolicy{Tombstones: 10, MinPages: 1}, nil)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	fillAndThin(t, storage) // 5 pages, 10 deletes
+	if err := storage.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	snap := storage.Stats().Snapshot()
+	if snap.Compactions != 1 || snap.TriggeredTombstones != 1 {
+		t.Fatalf("Expected one compaction triggered by tombstones, got %+v", snap)
+	}
+	if snap.TriggeredDeadSpace != 0 || snap.TriggeredFragmentation != 0 {
+		t.Errorf("Thresholds that are off shouldn't trigger: %+v", snap)
+	}
+	if storage.totalPages != 3 || snap.ReclaimedBytes != 2*PageSize {
+		t.Errorf("Expected 3 pages and %d bytes reclaimed, got %d pages, %d bytes", 2*PageSize, storage.totalPages, snap.ReclaimedBytes)
+	}
+	if value, err := storage.Get("k01"); err != nil || value != strings.Repeat("v", 1000) {
+		t.Errorf("k01 damaged by auto-compaction: %q, %v", value, err)
+	}
+
+	// the delete count starts over
+	storage.Delete("k01")
+	storage.Sync()
+	if got := storage.Stats().Snapshot().Compactions; got != 1 {
+		t.Errorf("Expected no second compaction after one delete, got %d", got)
+	}
+}
+
+func TestAutoCompact_DeadSpaceChecksOncePerInterval(t *testing.T) {
+	clock := storagetest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	policy := AutoCompactPolicy{DeadSpaceRatio: 0.5, MinPages: 1, CheckInterval: time.Minute}
+	storage, filename := openAutoCompact(t, policy, clock)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	for i := 0; i < 20; i++ {
+		storage.Put(fmt.Sprintf("k%02d", i), strings.Repeat("v", 1000))
+	}
+	storage.Sync() // full pages, checked and left alone
+	for i := 0; i < 16; i++ {
+		storage.Delete(fmt.Sprintf("k%02d", i))
+	}
+
+	storage.Sync()
+	if got := storage.Stats().Snapshot().Compactions; got != 0 {
+		t.Fatalf("Expected no check within the interval, got %d compactions", got)
+	}
+
+	clock.Advance(time.Minute)
+	storage.Sync()
+	snap := storage.Stats().Snapshot()
+	if snap.Compactions != 1 || snap.TriggeredDeadSpace != 1 {
+		t.Errorf("Expected one compaction triggered by dead space, got %+v", snap)
+	}
+	if storage.totalPages != 1 {
+		t.Errorf("Expected the 4 remaining records on one page, got %d pages", storage.totalPages)
+	}
+}
+
+func TestAutoCompact_SmallFilesAndMaintenanceLeftAlone(t *testing.T) {
+	clock := storagetest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	storage, filename := openAutoCompact(t, AutoCompactPolicy{Tombstones: 1}, clock)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	fillAndThin(t, storage)
+	storage.Sync() // 5 pages, below the default MinPages
+	if got := storage.Stats().Snapshot().Compactions; got != 0 {
+		t.Errorf("Expected a small file to be left alone, got %d compactions", got)
+	}
+
+	storage.opts.AutoCompact.MinPages = 1
+	storage.SetMaintenanceMode(true)
+	clock.Advance(time.Hour)
+	if err := storage.Sync(); err != nil {
+		t.Fatalf("Sync in maintenance mode failed: %v", err)
+	}
+	if got := storage.Stats().Snapshot().Compactions; got != 0 {
+		t.Errorf("Expected no compaction in maintenance mode, got %d", got)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"godata/storagetest"
+)
+
+// every value fills most of a page, so each Put dirties a new one
+func fillPages(storage *Storage, n int) error {
+	value := string(make([]byte, 3000))
+	for i := 0; i < n; i++ {
+		if err := storage.Put(fmt.Sprintf("key:%03d", i), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestBackpressure_Reject(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxDirtyPages = 3
+	opts.Backpressure = BackpressureReject
+	storage, filename := openWithOptions(t, opts)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	if err := fillPages(storage, 3); err != nil {
+		t.Fatalf("Writes under the limit failed: %v", err)
+	}
+	if err := storage.Put("one:more", "x"); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("Expected ErrOverloaded at the limit, got %v", err)
+	}
+	if storage.Stats().Snapshot().Rejected != 1 {
+		t.Errorf("Expected the rejection to be counted")
+	}
+
+	storage.Sync()
+	if err := storage.Put("one:more", "x"); err != nil {
+		t.Errorf("Expected writes to work again after Sync, got %v", err)
+	}
+}
+
+func TestBackpressure_BlockKeepsDirtyPagesBounded(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxDirtyPages = 4
+	storage, filename := openWithOptions(t, opts)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	if err := fillPages(storage, 20); err != nil {
+		t.Fatalf("Writes failed: %v", err)
+	}
+	if dirty := storage.dirtyPages(); dirty > opts.MaxDirtyPages {
+		t.Errorf("Expected at most %d dirty pages, got %d", opts.MaxDirtyPages, dirty)
+	}
+	if snap := storage.Stats().Snapshot(); snap.Stalls == 0 {
+		t.Errorf("Expected stalls to be counted")
+	}
+}
+
+func TestBackpressure_SleepThenFlush(t *testing.T) {
+	clock := storagetest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	opts := DefaultOptions()
+	opts.Clock = clock
+	opts.MaxDirtyPages = 2
+	opts.Backpressure = BackpressureSleep
+	opts.StallDelay = 10 * time.Millisecond
+	storage, filename := openWithOptions(t, opts)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	// 2 writes free, 2 delayed (dirty 2 and 3), the 5th finds 4 = twice the limit and flushes
+	if err := fillPages(storage, 5); err != nil {
+		t.Fatalf("Writes failed: %v", err)
+	}
+	snap := storage.Stats().Snapshot()
+	if snap.Stalls != 3 || snap.StallTime != 20*time.Millisecond {
+		t.Errorf("Expected 3 stalls taking 20ms of (fake) time, got %d taking %s", snap.Stalls, snap.StallTime)
+	}
+	if dirty := storage.dirtyPages(); dirty != 1 {
+		t.Errorf("Expected the flush to leave only the last write dirty, got %d", dirty)
+	}
+}
+
+// the sleep can't land between the read and the write of an Increment
+func TestBackpressure_SleepKeepsIncrementAtomic(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxDirtyPages = 1
+	opts.Backpressure = BackpressureSleep
+	opts.StallDelay = time.Microsecond
+	storage, filename := openWithOptions(t, opts)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if _, err := storage.Increment("hits", 1); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Increment failed: %v", err)
+	}
+	if got, err := storage.Get("hits"); err != nil || got != "800" {
+		t.Errorf("hits = %q, %v, want 800", got, err)
+	}
+	if snap := storage.Stats().Snapshot(); snap.Stalls == 0 {
+		t.Errorf("Expected the writes to be delayed")
+	}
+}
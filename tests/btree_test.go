@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestBTree_InsertDeleteAscendAcrossSplits(t *testing.T) {
+	path := "test_" + t.Name() + btreeSuffix
+	defer os.Remove(path)
+	// a handful of cached nodes, so most of the tree is read back from disk
+	tree, err := openBTree(path, 4)
+	if err != nil {
+		t.Fatalf("openBTree failed: %v", err)
+	}
+
+	// 100 byte keys, ~150 to a leaf, 20000 of them need three levels
+	key := func(i int) string { return fmt.Sprintf("%06d", i) + strings.Repeat("k", 94) }
+	order := rand.New(rand.NewSource(1)).Perm(20000)
+	for _, i := range order {
+		if err := tree.put(key(i), uint32(i)); err != nil {
+			t.Fatalf("put %d failed: %v", i, err)
+		}
+	}
+	for i := 0; i < 20000; i += 2 {
+		if found, err := tree.delete(key(i)); !found || err != nil {
+			t.Fatalf("delete %d: %v, %v", i, found, err)
+		}
+	}
+	if found, _ := tree.delete(key(0)); found {
+		t.Error("Expected a second delete to find nothing")
+	}
+	if tree.len() != 10000 {
+		t.Errorf("Expected 10000 keys, got %d", tree.len())
+	}
+
+	check := func(tree *btree) {
+		t.Helper()
+		for _, i := range []int{1, 2, 9999, 19999} {
+			pageID, found, err := tree.get(key(i))
+			if err != nil || found != (i%2 == 1) || (found && pageID != uint32(i)) {
+				t.Errorf("get %d: %d, %v, %v", i, pageID, found, err)
+			}
+		}
+		var keys []string
+		err := tree.ascend(key(100), key(200), func(k string, _ uint32) bool {
+			keys = append(keys, k)
+			return true
+		})
+		if err != nil || len(keys) != 50 || keys[0] != key(101) || !sort.StringsAreSorted(keys) {
+			t.Errorf("Expected the 50 odd keys 101..199 in order, got %d (%v)", len(keys), err)
+		}
+	}
+	check(tree)
+
+	// flushed and opened again, the tree is read from the file
+	match := btreeMatch{lsn: 7, totalPages: 3, nextPageID: 3}
+	if err := tree.flush(match, []uint32{1}); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	tree.close()
+	tree, err = openBTree(path, 4)
+	if err != nil {
+		t.Fatalf("openBTree failed: %v", err)
+	}
+	defer tree.close()
+	if !tree.matches(match) || len(tree.free) != 1 || tree.len() != 10000 {
+		t.Errorf("Expected the flushed header back, got %+v free %v, %d keys", tree.match, tree.free, tree.len())
+	}
+	check(tree)
+}
+
+func TestBTree_UnflushedWritesAreNotTrusted(t *testing.T) {
+	path := "test_" + t.Name() + btreeSuffix
+	defer os.Remove(path)
+	tree, _ := openBTree(path, 2)
+	match := btreeMatch{lsn: 1, totalPages: 1, nextPageID: 1}
+	tree.put("a", 0)
+	tree.flush(match, nil)
+
+	// evicting dirty nodes writes over the file before the next flush
+	for i := 0; i < 5000; i++ {
+		tree.put(fmt.Sprintf("%0200d", i), 0)
+	}
+	tree.close() // "crash", no flush
+
+	tree, _ = openBTree(path, 2)
+	defer tree.close()
+	if tree.matches(match) {
+		t.Error("Expected a tree written over after its flush not to match")
+	}
+}
+
+func openBTreeStorage(t *testing.T, filename string) *Storage {
+	opts := DefaultOptions()
+	opts.BTreeIndex = true
+	storage, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	return storage
+}
+
+func TestBTreeIndex_ReopenWithoutScanning(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	defer os.Remove(filename + btreeSuffix)
+
+	storage := openBTreeStorage(t, filename)
+	for i := 0; i < 500; i++ {
+		storage.Put(fmt.Sprintf("key%03d", i), strings.Repeat("v", 100))
+	}
+	for i := 0; i < 500; i += 5 {
+		storage.Delete(fmt.Sprintf("key%03d", i))
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	storage = openBTreeStorage(t, filename)
+	defer storage.Close()
+	if len(storage.pages) != 0 || len(storage.pageIndex) != 0 {
+		t.Errorf("Expected no page read and no map, got %d pages and %d map keys", len(storage.pages), len(storage.pageIndex))
+	}
+	if value, err := storage.Get("key001"); err != nil || len(value) != 100 {
+		t.Errorf("Get key001: %d bytes, %v", len(value), err)
+	}
+	if _, err := storage.Get("key005"); err == nil {
+		t.Error("Expected key005 to stay deleted")
+	}
+
+	it := storage.Scan("key100", "key110")
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if it.Err() != nil || strings.Join(keys, ",") != "key101,key102,key103,key104,key106,key107,key108,key109" {
+		t.Errorf("Unexpected scan %v (%v)", keys, it.Err())
+	}
+}
+
+func TestBTreeIndex_RebuiltWhenStale(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	defer os.Remove(filename + btreeSuffix)
+
+	storage := openBTreeStorage(t, filename)
+	storage.Put("a", "1")
+	storage.Close()
+
+	// a writer without the tree changes the file behind its back
+	plain, _ := NewStorage(filename)
+	plain.Put("b", "2")
+	plain.Delete("a")
+	plain.Close()
+
+	storage = openBTreeStorage(t, filename)
+	defer storage.Close()
+	if _, err := storage.Get("a"); err == nil {
+		t.Error("Expected a to be gone after the rebuild")
+	}
+	if value, err := storage.Get("b"); err != nil || value != "2" {
+		t.Errorf("Expected b = 2, got %q, %v", value, err)
+	}
+}
+
+func TestBTreeIndex_SurvivesCompact(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	defer os.Remove(filename + btreeSuffix)
+
+	storage := openBTreeStorage(t, filename)
+	for i := 0; i < 20; i++ {
+		storage.Put(fmt.Sprintf("k%02d", i), strings.Repeat("v", 1000))
+	}
+	for i := 0; i < 20; i += 2 {
+		storage.Delete(fmt.Sprintf("k%02d", i))
+	}
+	if _, err := storage.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	storage.Close()
+
+	storage = openBTreeStorage(t, filename)
+	defer storage.Close()
+	for i := 1; i < 20; i += 2 {
+		if value, err := storage.Get(fmt.Sprintf("k%02d", i)); err != nil || len(value) != 1000 {
+			t.Errorf("k%02d: %d bytes, %v", i, len(value), err)
+		}
+	}
+}
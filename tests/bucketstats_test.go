@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestBucketMetrics_CountsPerPrefix(t *testing.T) {
+	filename := "test_bucket_metrics.db"
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".wal")
+	db, err := NewStorageWithOptions(filename, Options{MetricsBucket: KeyPrefixBucket(":")})
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer db.Close()
+
+	db.Put("tenant1:a", "12345")
+	db.Put("tenant1:b", "1")
+	db.Put("tenant2:a", "xy")
+	db.Get("tenant1:a")
+	db.Get("tenant2:missing")
+	db.Delete("tenant2:a")
+	db.Put("plain", "v")
+
+	buckets := db.Stats().Snapshot().Buckets
+	t1, t2 := buckets["tenant1"], buckets["tenant2"]
+	if t1.Puts != 2 || t1.Gets != 1 || t1.BytesWritten != 6 || t1.BytesRead != 5 {
+		t.Errorf("tenant1 = %+v", t1)
+	}
+	if t2.Puts != 1 || t2.Gets != 1 || t2.Deletes != 1 || t2.BytesRead != 0 {
+		t.Errorf("tenant2 = %+v", t2)
+	}
+	if buckets[""].Puts != 1 {
+		t.Errorf("keys without a prefix should count under \"\", got %+v", buckets)
+	}
+
+	prev := db.Stats().Snapshot()
+	db.Get("tenant1:b")
+	diff := db.Stats().Snapshot().Sub(prev).Buckets
+	if diff["tenant1"].Gets != 1 || diff["tenant2"].Gets != 0 {
+		t.Errorf("Sub by bucket = %+v", diff)
+	}
+
+	reset := db.Stats().Reset()
+	if reset.Buckets["tenant1"].Gets != 2 {
+		t.Errorf("Reset returned %+v", reset.Buckets)
+	}
+	if n := len(db.Stats().Snapshot().Buckets); n != 0 {
+		t.Errorf("%d buckets left after Reset", n)
+	}
+}
+
+func TestBucketMetrics_LabelCap(t *testing.T) {
+	filename := "test_bucket_metrics_cap.db"
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".wal")
+	db, err := NewStorageWithOptions(filename, Options{
+		MetricsBucket:     func(key string) string { return key },
+		MaxMetricsBuckets: 3,
+	})
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer db.Close()
+
+	for _, key := range []string{"a", "b", "c", "d", "e", "a"} {
+		db.Put(key, "v")
+	}
+	buckets := db.Stats().Snapshot().Buckets
+	if len(buckets) != 4 {
+		t.Fatalf("want 3 labels plus %s, got %v", BucketOther, buckets)
+	}
+	if buckets["a"].Puts != 2 || buckets[BucketOther].Puts != 2 {
+		t.Errorf("a = %+v, %s = %+v", buckets["a"], BucketOther, buckets[BucketOther])
+	}
+}
+
+func TestBucketMetrics_OffByDefault(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+	db.Put("tenant1:a", "v")
+	if b := db.Stats().Snapshot().Buckets; b != nil {
+		t.Errorf("Buckets = %v, want nil", b)
+	}
+}
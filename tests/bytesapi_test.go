@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBytesAPI_BinaryKeysAndValues(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+
+	key := []byte{'k', 0x00, 0xFF, 0xFE}
+	value := []byte{0x00, 0x00, 0xC3, 0x28, 0xFF, 'x', 0x00} // NULs and invalid UTF-8
+	other := []byte{0x00}
+
+	for _, compress := range []bool{false, true} {
+		storage, err := NewStorageWithOptions(filename, Options{Compress: compress})
+		if err != nil {
+			t.Fatalf("Failed to open: %v", err)
+		}
+		if err := storage.PutBytes(key, value); err != nil {
+			t.Fatalf("PutBytes failed: %v", err)
+		}
+		storage.PutBytes(other, []byte{})
+		value[0] = 'z' // the caller's slice is free to reuse
+		storage.Close()
+		value[0] = 0x00
+
+		storage, _ = NewStorageWithOptions(filename, Options{Compress: compress})
+		got, err := storage.GetBytes(key)
+		if err != nil || !bytes.Equal(got, value) {
+			t.Errorf("compress=%v: GetBytes = %x, %v, want %x", compress, got, err, value)
+		}
+		if got, err := storage.GetBytes(other); err != nil || len(got) != 0 {
+			t.Errorf("compress=%v: empty value came back as %x, %v", compress, got, err)
+		}
+		if err := storage.DeleteBytes(key); err != nil {
+			t.Errorf("DeleteBytes failed: %v", err)
+		}
+		if _, err := storage.GetBytes(key); err == nil {
+			t.Error("Expected the key to be gone")
+		}
+		storage.DeleteBytes(other)
+		storage.Close()
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"godata/storagetest"
+)
+
+// a backing store that counts how often the cache goes to it
+type countingBacking struct {
+	data  map[string]string
+	loads int
+}
+
+func (b *countingBacking) backing(ttl time.Duration) Backing {
+	return Backing{
+		Load: func(key string) (string, bool, error) {
+			b.loads++
+			v, ok := b.data[key]
+			return v, ok, nil
+		},
+		Store:  func(key, value string) error { b.data[key] = value; return nil },
+		Delete: func(key string) error { delete(b.data, key); return nil },
+		TTL:    ttl,
+	}
+}
+
+func TestCache_ReadThroughWithTTL(t *testing.T) {
+	clock := storagetest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	opts := DefaultOptions()
+	opts.Clock = clock
+	storage, filename := openWithOptions(t, opts)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	source := &countingBacking{data: map[string]string{"user:1": "isabella"}}
+	cache := NewCache(storage, source.backing(time.Minute))
+
+	for i := 0; i < 3; i++ {
+		if v, err := cache.Get("user:1"); err != nil || v != "isabella" {
+			t.Fatalf("Get = %q, %v", v, err)
+		}
+	}
+	if source.loads != 1 {
+		t.Errorf("Expected one load for repeated reads, got %d", source.loads)
+	}
+
+	// the source changes behind the cache's back, the old value is served until it expires
+	source.data["user:1"] = "isa"
+	clock.Advance(30 * time.Second)
+	if v, _ := cache.Get("user:1"); v != "isabella" {
+		t.Errorf("Expected the cached value before the TTL, got %q", v)
+	}
+	clock.Advance(time.Minute)
+	if v, _ := cache.Get("user:1"); v != "isa" || source.loads != 2 {
+		t.Errorf("Expected a reload after the TTL, got %q after %d loads", v, source.loads)
+	}
+
+	if _, err := cache.Get("missing"); err == nil {
+		t.Errorf("Expected a key missing from the source to be missing")
+	}
+}
+
+func TestCache_WriteThrough(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	source := &countingBacking{data: map[string]string{}}
+	cache := NewCache(storage, source.backing(0))
+
+	cache.Put("user:2", "cam")
+	if source.data["user:2"] != "cam" {
+		t.Errorf("Put didn't reach the backing store")
+	}
+	if v, _ := cache.Get("user:2"); v != "cam" || source.loads != 0 {
+		t.Errorf("Expected a local hit after Put, got %q after %d loads", v, source.loads)
+	}
+
+	cache.Delete("user:2")
+	if _, ok := source.data["user:2"]; ok {
+		t.Errorf("Delete didn't reach the backing store")
+	}
+	if _, err := storage.Get("user:2"); err == nil {
+		t.Errorf("Delete left the local copy")
+	}
+
+	// a failing backing store keeps the local copy unchanged
+	failing := source.backing(0)
+	failing.Store = func(string, string) error { return errors.New("backend down") }
+	if err := NewCache(storage, failing).Put("user:3", "x"); err == nil {
+		t.Errorf("Expected the backing store error")
+	}
+	if _, err := storage.Get("user:3"); err == nil {
+		t.Errorf("A failed write-through must not be cached")
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"godata/storagetest"
+)
+
+func TestCheckpoint_EmptiesTheWAL(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	db.Put("a", "1")
+	db.Put("b", "2")
+	lsn, err := db.Checkpoint()
+	if err != nil || lsn != 2 {
+		t.Fatalf("Checkpoint = %d, %v; want 2", lsn, err)
+	}
+	if stat, _ := os.Stat(filename + ".wal"); stat.Size() != 0 {
+		t.Errorf("WAL still holds %d bytes after a checkpoint", stat.Size())
+	}
+	// the pages alone have it now
+	crashStorage(db)
+	db, err = NewStorage(filename)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+	if got, err := db.Get("b"); err != nil || got != "2" {
+		t.Errorf("b = %q, %v; want 2", got, err)
+	}
+}
+
+func TestCheckpoint_MarkersTakeLSNs(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	db.Put("a", "1")
+	db.Put("b", "2")
+	if lsn, err := db.Checkpoint(); err != nil || lsn != 2 {
+		t.Fatalf("Checkpoint = %d, %v; want 2", lsn, err)
+	}
+	// checkpoint-begin is 3, checkpoint-end 4
+	if last := db.wal.LastLSN(); last != 4 {
+		t.Errorf("WAL last LSN = %d, want 4", last)
+	}
+	db.Close()
+
+	// the header has them, they aren't handed out again
+	db, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+	db.Put("c", "3")
+	if entries, _ := db.wal.ReadAll(); len(entries) != 1 || entries[0].LSN != 5 {
+		t.Errorf("Expected c at LSN 5, got %d entries", len(entries))
+	}
+}
+
+// waits up to a second for the background checkpointer to have run
+func waitForCheckpoint(t *testing.T, db *Storage) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for db.Stats().Snapshot().Checkpoints == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no background checkpoint within a second")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCheckpoint_BackgroundOnInterval(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	opts := DefaultOptions()
+	opts.CheckpointInterval = 10 * time.Millisecond
+	db, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer db.Close()
+
+	db.Put("a", "1")
+	waitForCheckpoint(t, db)
+	db.mu.RLock()
+	size := db.wal.Size()
+	db.mu.RUnlock()
+	if size != 0 {
+		t.Errorf("Expected the WAL emptied, %d bytes left", size)
+	}
+}
+
+func TestCheckpoint_IntervalGoesByTheClock(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	clock := storagetest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	opts := DefaultOptions()
+	opts.Clock = clock
+	opts.CheckpointInterval = time.Hour
+	db, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer db.Close()
+
+	db.Put("a", "1")
+	time.Sleep(20 * time.Millisecond)
+	if n := db.Stats().Snapshot().Checkpoints; n != 0 {
+		t.Fatalf("Expected no checkpoint before the clock moved, got %d", n)
+	}
+	deadline := time.Now().Add(time.Second)
+	for db.Stats().Snapshot().Checkpoints == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no checkpoint after the clock moved past the interval")
+		}
+		clock.Advance(time.Hour)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCheckpoint_BackgroundOnWALSize(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	opts := DefaultOptions()
+	opts.CheckpointWALBytes = 4096
+	db, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer db.Close()
+
+	db.Put("a", "1")
+	time.Sleep(20 * time.Millisecond)
+	if got := db.Stats().Snapshot().Checkpoints; got != 0 {
+		t.Errorf("Expected no checkpoint below the limit, got %d", got)
+	}
+	for i := 0; i < 100; i++ {
+		db.Put(fmt.Sprintf("key%d", i), "some value")
+	}
+	waitForCheckpoint(t, db)
+}
+
+func openWithMaxWAL(t *testing.T, filename string, max int64, policy WALFullPolicy) *Storage {
+	opts := DefaultOptions()
+	opts.MaxWALBytes = max
+	opts.WALFull = policy
+	db, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	return db
+}
+
+func TestMaxWALBytes_FullWALForcesACheckpoint(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	db := openWithMaxWAL(t, filename, 1024, WALFullCheckpoint)
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := db.Put(fmt.Sprintf("key%03d", i), "some value"); err != nil {
+			t.Fatalf("Put %d failed: %v", i, err)
+		}
+		// one write over the limit at most
+		if size := db.wal.Size(); size > 1024+100 {
+			t.Fatalf("WAL grew to %d bytes", size)
+		}
+	}
+	snap := db.Stats().Snapshot()
+	if snap.WALFull == 0 || snap.Checkpoints != snap.WALFull {
+		t.Errorf("Expected a checkpoint for every full WAL, got %d full, %d checkpoints", snap.WALFull, snap.Checkpoints)
+	}
+}
+
+func TestMaxWALBytes_RejectFailsTheWrite(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	db := openWithMaxWAL(t, filename, 1024, WALFullReject)
+	defer db.Close()
+
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		err = db.Put(fmt.Sprintf("key%03d", i), "some value")
+	}
+	if !errors.Is(err, ErrWALFull) {
+		t.Fatalf("Expected ErrWALFull, got %v", err)
+	}
+	if err := db.Delete("key000"); !errors.Is(err, ErrWALFull) {
+		t.Errorf("Expected deletes to be rejected too, got %v", err)
+	}
+	// a checkpoint makes room again
+	if _, err := db.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	if err := db.Put("after", "1"); err != nil {
+		t.Errorf("Put after a checkpoint failed: %v", err)
+	}
+}
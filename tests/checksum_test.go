@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"strings"
+	"testing"
+
+	"godata/pagefmt"
+)
+
+func TestPageChecksum_FlippedBitFailsTheRead(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("user:1", "isabella")
+	storage.Sync()
+
+	// one bit of the value, the record still parses fine
+	at := storage.pageOffset(0) + int64(2+4+len("user:1"))
+	b := make([]byte, 1)
+	storage.file.ReadAt(b, at)
+	b[0] ^= 0x01
+	storage.file.WriteAt(b, at)
+	delete(storage.pages, 0)
+
+	_, err := storage.Get("user:1")
+	var se *StorageError
+	if !errors.Is(err, ErrChecksumMismatch) || !errors.As(err, &se) || se.PageID != 0 {
+		t.Errorf("Expected a checksum error on page 0, got %v", err)
+	}
+	report, _ := storage.Verify(1)
+	if len(report.Problems) != 1 || !errors.Is(report.Problems[0].Err, ErrChecksumMismatch) {
+		t.Errorf("Expected Verify to report the checksum, got %v", report.Problems)
+	}
+}
+
+func TestPageChecksum_RecordsLeaveRoomForIt(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	// [count 2][keyLen 2][valLen 2][key 1][value] fills the page exactly
+	if err := storage.Put("k", strings.Repeat("v", PageSize-7)); err == nil {
+		t.Error("Expected a record running into the checksum to be refused")
+	}
+	if err := storage.Put("k", strings.Repeat("v", PageSize-11)); err == nil {
+		t.Error("Expected a record running into the page LSN to be refused")
+	}
+	if err := storage.Put("k", strings.Repeat("v", PageSize-19)); err != nil {
+		t.Errorf("Expected a record up to the page LSN and checksum to fit: %v", err)
+	}
+}
+
+func TestPageChecksum_CRC32C(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	opts := DefaultOptions()
+	opts.Checksum = ChecksumCRC32C
+	storage, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	storage.Put("user:1", "isabella")
+	storage.Close()
+
+	data, _ := os.ReadFile(filename)
+	page := data[HeaderSize : HeaderSize+PageSize]
+	end := PageSize - pagefmt.ChecksumSize
+	if got, want := binary.LittleEndian.Uint32(page[end:]), crc32.Checksum(page[:end], crc32.MakeTable(crc32.Castagnoli)); got != want {
+		t.Errorf("Page checksum = %08x, want the CRC32C %08x", got, want)
+	}
+	if err := pagefmt.CheckChecksum(page); !errors.Is(err, pagefmt.ErrChecksum) {
+		t.Errorf("Expected an IEEE check to fail, got %v", err)
+	}
+
+	// the header says which one, without the option
+	storage, err = NewStorage(filename)
+	if err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	defer storage.Close()
+	if got, err := storage.Get("user:1"); err != nil || got != "isabella" {
+		t.Errorf("user:1 = %q, %v", got, err)
+	}
+	if report, _ := CheckIntegrity(filename); !report.OK() {
+		t.Errorf("CheckIntegrity: %v", report.Problems)
+	}
+}
+
+func TestPageChecksum_Disabled(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	opts := DefaultOptions()
+	opts.DisablePageChecksums = true
+	storage, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	// all of it but the page LSN
+	if err := storage.Put("k", strings.Repeat("v", PageSize-15)); err != nil {
+		t.Errorf("Expected the whole page to be usable: %v", err)
+	}
+	storage.Close()
+
+	// the file says it has none, whatever the options say now
+	data, _ := os.ReadFile(filename)
+	storage, _ = NewStorage(filename)
+	defer storage.Close()
+	if storage.checksums || data[40]&byte(pagefmt.FlagPageChecksums) != 0 {
+		t.Error("Expected the file to stay without checksums")
+	}
+}
+
+func TestChecksum_XXHash64PagesAndWAL(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	opts := DefaultOptions()
+	opts.Checksum = ChecksumXXHash64
+	storage, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	storage.Put("user:1", "isabella")
+	storage.Sync()
+	storage.Put("user:2", "cam")
+	crashStorage(storage)
+
+	data, _ := os.ReadFile(filename)
+	page := data[HeaderSize : HeaderSize+PageSize]
+	end := PageSize - pagefmt.ChecksumSize
+	if got, want := binary.LittleEndian.Uint32(page[end:]), uint32(pagefmt.XXHash64(page[:end])); got != want {
+		t.Errorf("Page checksum = %08x, want the xxHash64 %08x", got, want)
+	}
+	// the entry says how it's checksummed, the type is under it
+	wal, _ := os.ReadFile(filename + ".wal")
+	if len(wal) < walHeaderSize || wal[12] != LogTypePut|byte(ChecksumXXHash64)<<walChecksumShift {
+		t.Fatalf("Expected an xxHash64 put entry, WAL is %x", wal)
+	}
+	entry, err := Deserialize(wal)
+	if err != nil || entry.Type != LogTypePut || entry.Algorithm != ChecksumXXHash64 || !entry.ValidateChecksum() {
+		t.Errorf("Deserialize = %+v, %v", entry, err)
+	}
+
+	// the header says which one, without the option, and the WAL replays
+	storage, err = NewStorage(filename)
+	if err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	defer storage.Close()
+	for key, want := range map[string]string{"user:1": "isabella", "user:2": "cam"} {
+		if got, err := storage.Get(key); err != nil || got != want {
+			t.Errorf("%s = %q, %v", key, got, err)
+		}
+	}
+	if report, _ := CheckIntegrity(filename); !report.OK() {
+		t.Errorf("CheckIntegrity: %v", report.Problems)
+	}
+}
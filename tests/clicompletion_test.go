@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+	if err := db.Put("k", "v"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	// db still has the file open
+	if code := exitCode(runVerify([]string{filename})); code != ExitLocked {
+		t.Errorf("verify of a locked file: exit %d, want %d", code, ExitLocked)
+	}
+	if code := exitCode(runVerify([]string{"no_such_file.db"})); code != ExitNotFound {
+		t.Errorf("verify of a missing file: exit %d, want %d", code, ExitNotFound)
+	}
+
+	bad := "test_exit_code_garbage.db"
+	if err := os.WriteFile(bad, bytes.Repeat([]byte{0xAB}, 64+4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(bad)
+	defer os.Remove(bad + ".wal")
+	if code := exitCode(runVerify([]string{bad})); code != ExitCorrupt {
+		t.Errorf("verify of a garbage file: exit %d, want %d", code, ExitCorrupt)
+	}
+
+	if code := exitCode(runVerify([]string{filename, filename})); code != ExitFailure {
+		t.Errorf("bad arguments: exit %d, want %d", code, ExitFailure)
+	}
+	if code := exitCode(errors.New("anything")); code != ExitFailure {
+		t.Errorf("plain error: exit %d, want %d", code, ExitFailure)
+	}
+	if code := exitCode(nil); code != ExitOK {
+		t.Errorf("nil error: exit %d, want %d", code, ExitOK)
+	}
+}
+
+func TestCompletionScripts(t *testing.T) {
+	var bash, zsh, fish bytes.Buffer
+	writeBashCompletion(&bash)
+	writeZshCompletion(&zsh)
+	writeFishCompletion(&fish)
+
+	for shell, script := range map[string]string{"bash": bash.String(), "zsh": zsh.String(), "fish": fish.String()} {
+		for name := range commands {
+			if !strings.Contains(script, name) {
+				t.Errorf("%s completion doesn't mention command %q", shell, name)
+			}
+		}
+		if !strings.Contains(script, "parallel") || !strings.Contains(script, "output") {
+			t.Errorf("%s completion is missing flags:\n%s", shell, script)
+		}
+	}
+
+	// boolean flags take no value, the others do
+	if !strings.Contains(fish.String(), "-l direct -d") {
+		t.Errorf("fish: --direct should not require a value:\n%s", fish.String())
+	}
+	if !strings.Contains(fish.String(), "-l parallel -r") {
+		t.Errorf("fish: --parallel should require a value:\n%s", fish.String())
+	}
+
+	// collecting the flags must not leave the commands in collecting mode
+	if collectFlags {
+		t.Error("collectFlags still set after generating completions")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func testResult() cliResult {
+	var out cliResult
+	out.add("pages scanned", 12)
+	out.addText("orphaned pages", []uint32{3, 7}, "2 [3 7]")
+	out.addText("problems", []string{"a", "b"}, "page 1: a\npage 2: b")
+	return out
+}
+
+func TestCLIOutput_Table(t *testing.T) {
+	var buf bytes.Buffer
+	testResult().print(&buf, "table")
+	want := "pages scanned:  12\n" +
+		"orphaned pages: 2 [3 7]\n" +
+		"problems:       page 1: a\n" +
+		"                page 2: b\n"
+	if buf.String() != want {
+		t.Errorf("table output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestCLIOutput_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testResult().print(&buf, "json"); err != nil {
+		t.Fatalf("print failed: %v", err)
+	}
+	var got struct {
+		PagesScanned  int      `json:"pages_scanned"`
+		OrphanedPages []uint32 `json:"orphaned_pages"`
+		Problems      []string `json:"problems"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output isn't JSON: %v\n%s", err, buf.String())
+	}
+	if got.PagesScanned != 12 || len(got.OrphanedPages) != 2 || got.Problems[1] != "b" {
+		t.Errorf("decoded %+v", got)
+	}
+}
+
+func TestCLIOutput_RawAndBadFormat(t *testing.T) {
+	var buf bytes.Buffer
+	testResult()[:2].print(&buf, "raw")
+	if buf.String() != "12\n2 [3 7]\n" {
+		t.Errorf("raw output = %q", buf.String())
+	}
+	if err := checkOutputFormat("yaml"); err == nil {
+		t.Error("unknown format should be rejected")
+	}
+}
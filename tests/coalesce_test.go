@@ -0,0 +1,97 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestGet_ConcurrentMissesReadThePageOnce(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("user:1", "isabella")
+	storage.Sync()
+
+	// drop the page from the cache, like an eviction would
+	pageID := storage.pageIndex["user:1"]
+	delete(storage.pages, pageID)
+	before := storage.Stats().Snapshot()
+
+	const readers = 50
+	var start, done sync.WaitGroup
+	start.Add(1)
+	errs := make(chan error, readers)
+	for i := 0; i < readers; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			start.Wait()
+			if v, err := storage.Get("user:1"); err != nil || v != "isabella" {
+				errs <- err
+			}
+		}()
+	}
+	start.Done()
+	done.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Concurrent Get failed: %v", err)
+	}
+
+	diff := storage.Stats().Snapshot().Sub(before)
+	if diff.PageReads != 1 {
+		t.Errorf("Expected the page to be read once, got %d reads", diff.PageReads)
+	}
+	// every miss either did the read or waited for it
+	if diff.CacheMisses != 1+diff.Coalesced {
+		t.Errorf("Expected %d misses to be 1 read + %d coalesced", diff.CacheMisses, diff.Coalesced)
+	}
+}
+
+func TestGet_WaitsForPageReadInFlight(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("user:1", "isabella")
+	storage.Sync()
+	pageID := storage.pageIndex["user:1"]
+	page := storage.pages[pageID]
+
+	// pretend another goroutine is in the middle of reading the page
+	delete(storage.pages, pageID)
+	call := &pageLoad{done: make(chan struct{})}
+	storage.loading[pageID] = call
+	before := storage.Stats().Snapshot()
+
+	const readers = 10
+	results := make(chan string, readers)
+	for i := 0; i < readers; i++ {
+		go func() {
+			v, _ := storage.Get("user:1")
+			results <- v
+		}()
+	}
+
+	// wait until every reader is parked on the read in flight, then finish it
+	for storage.Stats().Snapshot().Sub(before).Coalesced < readers {
+		runtime.Gosched()
+	}
+	storage.cacheMu.Lock()
+	storage.pages[pageID] = page
+	delete(storage.loading, pageID)
+	storage.cacheMu.Unlock()
+	call.page = page
+	close(call.done)
+
+	for i := 0; i < readers; i++ {
+		if v := <-results; v != "isabella" {
+			t.Errorf("Expected the value from the shared read, got %q", v)
+		}
+	}
+	if reads := storage.Stats().Snapshot().Sub(before).PageReads; reads != 0 {
+		t.Errorf("Expected no reads of their own, got %d", reads)
+	}
+}
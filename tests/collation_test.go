@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func openCollated(t *testing.T, filename string, collation Collation, btree bool) *Storage {
+	t.Helper()
+	opts := DefaultOptions()
+	opts.Collation = collation
+	opts.BTreeIndex = btree
+	storage, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	return storage
+}
+
+func scanKeys(t *testing.T, storage *Storage, start, end string) []string {
+	t.Helper()
+	var keys []string
+	it := storage.Scan(start, end)
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	return keys
+}
+
+func TestCollation_NaturalOrderScans(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	storage := openCollated(t, filename, NaturalCollation, false)
+	defer storage.Close()
+
+	for _, key := range []string{"file10", "file2", "file1", "file9", "file02"} {
+		storage.Put(key, "x")
+	}
+	want := []string{"file1", "file02", "file2", "file9", "file10"}
+	if got := scanKeys(t, storage, "", ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("Scan = %v, want %v", got, want)
+	}
+	if got := scanKeys(t, storage, "file2", "file10"); !reflect.DeepEqual(got, []string{"file2", "file9"}) {
+		t.Errorf("Scan(file2, file10) = %v", got)
+	}
+
+	// "file10" starts with "file1" but doesn't sort next to it
+	if n, err := storage.RenamePrefix("file1", "doc1"); err != nil || n != 2 {
+		t.Fatalf("RenamePrefix = %d, %v; want 2", n, err)
+	}
+	if n, _ := storage.EstimateCount("doc1"); n != 2 {
+		t.Errorf("EstimateCount(doc1) = %d, want 2", n)
+	}
+}
+
+func TestCollation_BTreeIsRebuiltForAnotherOrder(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	defer os.Remove(filename + btreeSuffix)
+
+	storage := openCollated(t, filename, FoldCollation, true)
+	for _, key := range []string{"carl", "Bob", "adam", "bob"} {
+		storage.Put(key, "x")
+	}
+	want := []string{"adam", "Bob", "bob", "carl"}
+	if got := scanKeys(t, storage, "", ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("Scan = %v, want %v", got, want)
+	}
+	if got := scanKeys(t, storage, "b", "c"); !reflect.DeepEqual(got, []string{"Bob", "bob"}) {
+		t.Errorf("Scan(b, c) = %v", got)
+	}
+	storage.Close()
+
+	// the tree on disk is in fold order, byte order needs a new one
+	storage = openCollated(t, filename, nil, true)
+	defer storage.Close()
+	want = []string{"Bob", "adam", "bob", "carl"}
+	if got := scanKeys(t, storage, "", ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("Scan after reopening in byte order = %v, want %v", got, want)
+	}
+	if _, err := storage.Get("Bob"); err != nil {
+		t.Errorf("Get(Bob) failed: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// 20 records of ~1KB, four to a page, then every other one deleted
+func fillAndThin(t *testing.T, storage *Storage) {
+	for i := 0; i < 20; i++ {
+		if err := storage.Put(fmt.Sprintf("k%02d", i), strings.Repeat("v", 1000)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	for i := 0; i < 20; i += 2 {
+		if err := storage.Delete(fmt.Sprintf("k%02d", i)); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+}
+
+func TestEstimateCompaction_ChangesNothing(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+	fillAndThin(t, storage)
+	storage.Sync()
+	before, _ := os.Stat(filename)
+
+	est, err := storage.EstimateCompaction()
+	if err != nil {
+		t.Fatalf("EstimateCompaction failed: %v", err)
+	}
+	if est.Pages != 5 || est.LivePages != 3 || est.LiveRecords != 10 {
+		t.Errorf("Expected 5 pages → 3 holding 10 records, got %+v", est)
+	}
+	if est.ReclaimBytes != 2*PageSize {
+		t.Errorf("Expected %d reclaimable bytes, got %d", 2*PageSize, est.ReclaimBytes)
+	}
+
+	after, _ := os.Stat(filename)
+	if after.Size() != before.Size() || storage.totalPages != 5 {
+		t.Errorf("Estimate changed the file: %d → %d bytes, %d pages", before.Size(), after.Size(), storage.totalPages)
+	}
+	if _, err := os.Stat(filename + compactSuffix); !os.IsNotExist(err) {
+		t.Errorf("Estimate left a %s file behind", compactSuffix)
+	}
+}
+
+func TestCompact_ShrinksFileAndKeepsRecords(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+
+	storage, err := NewStorageWithOptions(filename, Options{Compress: true})
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	fillAndThin(t, storage)
+	// an orphaned copy is dropped as well
+	orphan := storage.allocateNewPage()
+	orphan.addRecord("k01", "stale")
+
+	est, _ := storage.EstimateCompaction()
+	report, err := storage.Compact()
+	if err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if report.PagesAfter != est.LivePages || report.ReclaimedBytes != est.ReclaimBytes {
+		t.Errorf("Compact did %+v, estimate said %+v", report, est)
+	}
+	if est.StaleRecords != 1 {
+		t.Errorf("Expected 1 stale record, got %d", est.StaleRecords)
+	}
+
+	// more writes after the swap land in the new file
+	if err := storage.Put("new", "value"); err != nil {
+		t.Fatalf("Put after compact failed: %v", err)
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	stat, _ := os.Stat(filename)
+	if want := int64(HeaderSize + report.PagesAfter*PageSize); stat.Size() > want+PageSize {
+		t.Errorf("Expected the file to shrink to about %d bytes, it's %d", want, stat.Size())
+	}
+
+	reopened, err := NewStorageWithOptions(filename, Options{Compress: true})
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("k%02d", i)
+		value, err := reopened.Get(key)
+		switch {
+		case i%2 == 0 && err == nil:
+			t.Errorf("Deleted key %s is back", key)
+		case i%2 == 1 && value != strings.Repeat("v", 1000):
+			t.Errorf("Key %s lost its value after compaction: %q, %v", key, value, err)
+		}
+	}
+	if value, _ := reopened.Get("new"); value != "value" {
+		t.Errorf("Write after compaction lost, got %q", value)
+	}
+}
+
+func TestCompact_RejectedInMaintenanceMode(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+	storage.SetMaintenanceMode(true)
+
+	if _, err := storage.Compact(); err == nil {
+		t.Error("Expected Compact to fail in maintenance mode")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// run with -race: readers, a scanner and a syncer next to one writer
+func TestStorage_ConcurrentReadersAndWriter(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	const keys = 200
+	for i := 0; i < keys; i++ {
+		storage.Put(fmt.Sprintf("k%03d", i), "v0")
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	errs := make(chan error, 16)
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := fmt.Sprintf("k%03d", (n*7+r)%keys)
+				value, err := storage.Get(key)
+				if err != nil || !strings.HasPrefix(value, "v") {
+					errs <- fmt.Errorf("Get(%s) = %q, %v", key, value, err)
+					return
+				}
+			}
+		}(r)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			it := storage.Iterator()
+			for it.Next() {
+			}
+			if it.Err() != nil {
+				errs <- it.Err()
+				return
+			}
+			storage.Sync()
+		}
+	}()
+
+	// the writer only grows values, so every key keeps existing
+	for round := 1; round <= 20; round++ {
+		for i := 0; i < keys; i++ {
+			value := "v" + strings.Repeat("x", round*10)
+			if err := storage.Put(fmt.Sprintf("k%03d", i), value); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if value, _ := storage.Get("k123"); value != "v"+strings.Repeat("x", 200) {
+		t.Errorf("Expected the last round's value, got %d bytes", len(value))
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPutIfAbsent(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+
+	if ok, err := db.PutIfAbsent("user:alice", "1"); !ok || err != nil {
+		t.Fatalf("Expected the first PutIfAbsent to write, got %v, %v", ok, err)
+	}
+	if ok, err := db.PutIfAbsent("user:alice", "2"); ok || err != nil {
+		t.Errorf("Expected the second PutIfAbsent not to write, got %v, %v", ok, err)
+	}
+	if got, _ := db.Get("user:alice"); got != "1" {
+		t.Errorf("Expected the first value to stay, got %q", got)
+	}
+}
+
+func TestPutIfAbsent_OneWinnerUnderContention(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+
+	var wins atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if ok, _ := db.PutIfAbsent("job:1:owner", fmt.Sprint(i)); ok {
+				wins.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if wins.Load() != 1 {
+		t.Errorf("Expected exactly one winner, got %d", wins.Load())
+	}
+}
+
+func TestReplaceIfPresent(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+
+	if ok, err := db.ReplaceIfPresent("a", "1"); ok || err != nil {
+		t.Errorf("Expected no write for a missing key, got %v, %v", ok, err)
+	}
+	if _, err := db.Get("a"); err == nil {
+		t.Error("Expected a to still not exist")
+	}
+	db.Put("a", "1")
+	if ok, err := db.ReplaceIfPresent("a", "2"); !ok || err != nil {
+		t.Errorf("Expected the replace to write, got %v, %v", ok, err)
+	}
+	if got, _ := db.Get("a"); got != "2" {
+		t.Errorf("a = %q, want 2", got)
+	}
+}
+
+func TestUpdate_NoLostIncrements(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+
+	increment := func(old string, exists bool) (string, bool) {
+		n := 0
+		if exists {
+			fmt.Sscan(old, &n)
+		}
+		return fmt.Sprint(n + 1), true
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				if err := db.Update("hits", increment); err != nil {
+					t.Errorf("Update failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got, _ := db.Get("hits"); got != "200" {
+		t.Errorf("hits = %q, want 200", got)
+	}
+}
+
+func TestUpdate_KeepFalseDeletes(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+
+	db.Put("session", "x")
+	drop := func(string, bool) (string, bool) { return "", false }
+	if err := db.Update("session", drop); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if _, err := db.Get("session"); err == nil {
+		t.Error("Expected session to be deleted")
+	}
+	// nothing there, nothing to delete
+	if err := db.Update("session", drop); err != nil {
+		t.Errorf("Update on a missing key failed: %v", err)
+	}
+}
+
+func TestIncrement_ConcurrentCounters(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				if _, err := db.Increment("hits", 2); err != nil {
+					t.Errorf("Increment failed: %v", err)
+					return
+				}
+				db.Increment(fmt.Sprintf("worker:%d", i), -1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got, _ := db.Get("hits"); got != "400" {
+		t.Errorf("hits = %q, want 400", got)
+	}
+	if got, _ := db.Get("worker:3"); got != "-25" {
+		t.Errorf("a missing key should start at 0, worker:3 = %q", got)
+	}
+
+	// every increment is in the WAL
+	crashStorage(db)
+	db, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+	if n, err := db.Increment("hits", 0); n != 400 || err != nil {
+		t.Errorf("Expected 400 after recovery, got %d, %v", n, err)
+	}
+}
+
+func TestIncrement_RejectsNonIntegers(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+
+	db.Put("name", "alice")
+	if _, err := db.Increment("name", 1); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Expected ErrInvalidValue, got %v", err)
+	}
+	if got, _ := db.Get("name"); got != "alice" {
+		t.Errorf("Expected the value untouched, got %q", got)
+	}
+
+	db.Put("big", fmt.Sprint(int64(math.MaxInt64-1)))
+	if n, err := db.Increment("big", 1); n != math.MaxInt64 || err != nil {
+		t.Errorf("Expected MaxInt64, got %d, %v", n, err)
+	}
+	if _, err := db.Increment("big", 1); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Expected the overflow to fail, got %v", err)
+	}
+}
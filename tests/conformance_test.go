@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sort"
+	"testing"
+
+	"godata/storagetest"
+)
+
+// adapts Storage to the conformance suite's Store interface
+type conformanceStore struct {
+	*Storage
+}
+
+func (c conformanceStore) Put(key, value string) error { return c.Storage.Put(key, value) }
+func (c conformanceStore) Delete(key string) error     { return c.Storage.Delete(key) }
+
+// drops the handle without flushing dirty pages or the header
+func (c conformanceStore) Crash() error { return c.file.Close() }
+
+func openConformanceStore(engine EngineID) storagetest.Opener {
+	return func(path string) (storagetest.Store, error) {
+		opts := DefaultOptions()
+		opts.Engine = engine
+		storage, err := NewStorageWithOptions(path, opts)
+		if err != nil {
+			return nil, err
+		}
+		return conformanceStore{storage}, nil
+	}
+}
+
+// every engine places records its own way, they all have to behave the same
+func TestStorageConformance(t *testing.T) {
+	ids := make([]EngineID, 0, len(engines))
+	for id := range engines {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		t.Run(engines[id].Name(), func(t *testing.T) {
+			storagetest.Run(t, storagetest.Suite{
+				Open:         openConformanceStore(id),
+				Concurrent:   true,
+				MaxValueSize: 4000,
+			})
+		})
+	}
+}
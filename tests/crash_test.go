@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// the crash matrix: for every crash point and a few hit counts, a child
+// process runs the workload below and dies at that point. the parent then
+// reopens the file and checks what recovery has to guarantee.
+
+const crashChildEnv = "GODATA_CRASH_CHILD_DB"
+
+// set for the child when the workload runs with Options.DoubleWrite
+const crashDoubleWriteEnv = "GODATA_CRASH_DOUBLE_WRITE"
+
+// values the workload writes, same length so updates stay on their page
+func crashValue(key string, phase int) string {
+	return fmt.Sprintf("%s-phase%d-%s", key, phase, strings.Repeat("v", 40))
+}
+
+// phase 1 writes keys k00-k59 and syncs, phase 2 updates k00-k29,
+// deletes k30-k39, adds n00-n59 and syncs, phase 3 updates n00-n29 and closes
+func crashWorkload(filename string) error {
+	opts := DefaultOptions()
+	opts.DoubleWrite = os.Getenv(crashDoubleWriteEnv) != ""
+	db, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < 60; i++ {
+		key := fmt.Sprintf("k%02d", i)
+		if err := db.Put(key, crashValue(key, 1)); err != nil {
+			return err
+		}
+	}
+	if err := db.Sync(); err != nil {
+		return err
+	}
+
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("k%02d", i)
+		db.Put(key, crashValue(key, 2))
+	}
+	for i := 30; i < 40; i++ {
+		db.Delete(fmt.Sprintf("k%02d", i))
+	}
+	for i := 0; i < 60; i++ {
+		key := fmt.Sprintf("n%02d", i)
+		db.Put(key, crashValue(key, 2))
+	}
+	if err := db.Sync(); err != nil {
+		return err
+	}
+
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("n%02d", i)
+		db.Put(key, crashValue(key, 3))
+	}
+	return db.Close()
+}
+
+// runs in the child process, does nothing in a normal test run
+func TestCrashChild(t *testing.T) {
+	filename := os.Getenv(crashChildEnv)
+	if filename == "" {
+		t.Skip("only runs as the crash matrix child process")
+	}
+	if err := crashWorkload(filename); err != nil {
+		t.Fatalf("workload failed: %v", err)
+	}
+}
+
+func TestCrashMatrix(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a process per crash point")
+	}
+
+	for _, point := range CrashPoints {
+		for _, hit := range []int{1, 2, 3, 5, 8, 13} {
+			t.Run(fmt.Sprintf("%s:%d", point, hit), func(t *testing.T) {
+				filename := fmt.Sprintf("test_crash_%s_%d.db", point, hit)
+				os.Remove(filename)
+				os.Remove(filename + ".wal")
+				os.Remove(filename + ".dwb")
+				defer os.Remove(filename)
+				defer os.Remove(filename + ".wal")
+				defer os.Remove(filename + ".dwb")
+
+				cmd := exec.Command(os.Args[0], "-test.run=^TestCrashChild$")
+				cmd.Env = append(os.Environ(),
+					crashChildEnv+"="+filename,
+					fmt.Sprintf("GODATA_CRASH_AT=%s:%d", point, hit))
+				if point == CrashMidPageWrite {
+					// a torn page is what the double-write buffer is for,
+					// without it the page stays torn
+					cmd.Env = append(cmd.Env, crashDoubleWriteEnv+"=1")
+				}
+				out, err := cmd.CombinedOutput()
+
+				// either the crash point fired, or the workload finished before reaching it
+				var exit *exec.ExitError
+				crashed := errors.As(err, &exit) && exit.ExitCode() == CrashExitCode
+				if err != nil && !crashed {
+					t.Fatalf("child failed: %v\n%s", err, out)
+				}
+				checkCrashInvariants(t, filename, crashed)
+				if crashed && point == CrashAfterWALAppend {
+					checkWALReplayed(t, filename, hit)
+				}
+			})
+		}
+	}
+}
+
+// the first hit-1 puts returned before the crash and the hit-th one was
+// logged, none of them were synced: all of them have to come back from the WAL
+func checkWALReplayed(t *testing.T, filename string, hit int) {
+	db, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("reopen after crash failed: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < hit; i++ {
+		key := fmt.Sprintf("k%02d", i)
+		if value, err := db.Get(key); err != nil || value != crashValue(key, 1) {
+			t.Errorf("%s = %q, %v after replaying the WAL", key, value, err)
+		}
+	}
+}
+
+func checkCrashInvariants(t *testing.T, filename string, crashed bool) {
+	if _, err := os.Stat(filename); err != nil {
+		return // crashed before the file was even created
+	}
+
+	db, err := NewStorage(filename)
+	if err != nil {
+		// a crash before the very first header write leaves an empty or header-less file
+		t.Fatalf("reopen after crash failed: %v", err)
+	}
+	defer db.Close()
+
+	report, _ := db.Verify(2)
+	if !report.OK() {
+		t.Fatalf("pages damaged after crash: %v", report.Problems)
+	}
+
+	// every key holds a value the workload actually wrote for it, or is missing
+	// because the crash came before it was synced (or after it was deleted)
+	for _, prefix := range []string{"k", "n"} {
+		for i := 0; i < 60; i++ {
+			key := fmt.Sprintf("%s%02d", prefix, i)
+			value, err := db.Get(key)
+			if err != nil {
+				continue
+			}
+			if value != crashValue(key, 1) && value != crashValue(key, 2) && value != crashValue(key, 3) {
+				t.Errorf("%s holds %q, which was never written", key, value)
+			}
+		}
+	}
+
+	if !crashed {
+		// the workload closed cleanly, everything has to be exactly right
+		for i := 0; i < 30; i++ {
+			key := fmt.Sprintf("n%02d", i)
+			if value, _ := db.Get(key); value != crashValue(key, 3) {
+				t.Errorf("%s = %q after a clean close", key, value)
+			}
+		}
+		for i := 30; i < 40; i++ {
+			if _, err := db.Get(fmt.Sprintf("k%02d", i)); err == nil {
+				t.Errorf("k%02d survived its delete", i)
+			}
+		}
+	}
+}
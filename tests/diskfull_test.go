@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"godata/storagetest"
+)
+
+// /dev/full fails every write with ENOSPC
+func openDevFull(t *testing.T) *os.File {
+	f, err := os.OpenFile("/dev/full", os.O_WRONLY, 0)
+	if err != nil {
+		t.Skip("no /dev/full here")
+	}
+	return f
+}
+
+func TestDiskFull_SuspendsWritesUntilThereIsRoom(t *testing.T) {
+	full := openDevFull(t)
+	defer full.Close()
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	clock := storagetest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	opts := DefaultOptions()
+	opts.Clock = clock
+	db, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer db.Close()
+
+	db.Put("a", "1")
+	wal := db.wal.file
+	db.wal.file = full
+	if err := db.Put("b", "2"); !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("Expected ErrDiskFull from the WAL append, got %v", err)
+	}
+	if h := db.Health(); h.Writable || !h.DiskFull || !h.DiskFullSince.Equal(clock.Now()) {
+		t.Errorf("Expected the storage to report a full disk, got %+v", h)
+	}
+	rec := httptest.NewRecorder()
+	db.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /health to answer 503, got %d: %s", rec.Code, rec.Body)
+	}
+
+	// space comes back, the next write only finds out after DiskFullRetry
+	db.wal.file = wal
+	if err := db.Delete("a"); !errors.Is(err, ErrDiskFull) {
+		t.Errorf("Expected writes to stay suspended until the retry, got %v", err)
+	}
+	if got, err := db.Get("a"); err != nil || got != "1" {
+		t.Errorf("Expected reads to keep working, got %q, %v", got, err)
+	}
+	clock.Advance(time.Second)
+	if err := db.Put("b", "2"); err != nil {
+		t.Fatalf("Expected writes to be back, got %v", err)
+	}
+	if h := db.Health(); !h.Writable || h.DiskFull {
+		t.Errorf("Expected the storage to be writable again, got %+v", h)
+	}
+	if got := db.Stats().Snapshot().DiskFull; got != 1 {
+		t.Errorf("Expected one disk full episode, got %d", got)
+	}
+
+	// nothing of the failed write is left behind
+	crashStorage(db)
+	db, err = NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	if got, err := db.Get("b"); err != nil || got != "2" {
+		t.Errorf("b = %q, %v; want 2", got, err)
+	}
+}
+
+func TestDiskFull_SyncClearsIt(t *testing.T) {
+	full := openDevFull(t)
+	defer full.Close()
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+
+	wal := db.wal.file
+	db.wal.file = full
+	db.Put("a", "1")
+	db.wal.file = wal
+	if !db.Health().DiskFull {
+		t.Fatal("Expected the disk to be full")
+	}
+	// any Sync that goes through, the checkpointer's for example
+	if err := db.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if err := db.Put("a", "1"); err != nil {
+		t.Errorf("Expected writes to be back after a Sync, got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// writes one page of a fresh file the way a crash mid-write would leave it:
+// the image made it to the double-write buffer, half of it to the file
+func tearPage(t *testing.T, filename string, opts Options) {
+	db, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	db.Put("user:1", "isabella")
+	db.Sync()
+	db.Put("user:2", "marco")
+	page := db.pages[db.pageIndex["user:2"]]
+	page.stampChecksum()
+	if err := db.doubleWrite(page); err != nil {
+		t.Fatalf("doubleWrite failed: %v", err)
+	}
+	db.file.WriteAt(page.Data[:PageSize/2], db.pageOffset(page.ID))
+	crashStorage(db)
+	db.dwb.Close()
+}
+
+func TestDoubleWrite_RestoresATornPage(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	defer os.Remove(filename + ".dwb")
+	opts := DefaultOptions()
+	opts.DoubleWrite = true
+	tearPage(t, filename, opts)
+
+	// without the buffer the page fails its checksum
+	torn, err := CheckIntegrity(filename)
+	if err != nil || torn.OK() {
+		t.Fatalf("Expected a torn page, got %+v, %v", torn, err)
+	}
+
+	db, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Reopen after the torn write failed: %v", err)
+	}
+	defer db.Close()
+	if got := db.Stats().Snapshot().PagesRestored; got != 1 {
+		t.Errorf("Expected 1 page restored, got %d", got)
+	}
+	for key, want := range map[string]string{"user:1": "isabella", "user:2": "marco"} {
+		if got, err := db.Get(key); err != nil || got != want {
+			t.Errorf("%s = %q, %v; want %q", key, got, err, want)
+		}
+	}
+	if report, _ := db.Verify(1); !report.OK() {
+		t.Errorf("Pages damaged after the restore: %v", report.Problems)
+	}
+}
+
+func TestDoubleWrite_LeftoverBufferWithTheOptionOff(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	defer os.Remove(filename + ".dwb")
+	opts := DefaultOptions()
+	opts.DoubleWrite = true
+	tearPage(t, filename, opts)
+
+	db, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("Reopen without DoubleWrite failed: %v", err)
+	}
+	defer db.Close()
+	if got, err := db.Get("user:2"); err != nil || got != "marco" {
+		t.Errorf("user:2 = %q, %v", got, err)
+	}
+	if _, err := os.Stat(filename + ".dwb"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the leftover buffer to be removed, got %v", err)
+	}
+}
+
+func TestDoubleWrite_TornBufferLeavesThePage(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	defer os.Remove(filename + ".dwb")
+	opts := DefaultOptions()
+	opts.DoubleWrite = true
+	tearPage(t, filename, opts)
+
+	// a buffer that doesn't check out is never copied over a page
+	data, _ := os.ReadFile(filename + ".dwb")
+	data[100] ^= 0xFF
+	os.WriteFile(filename+".dwb", data, 0644)
+
+	db, err := NewStorageWithOptions(filename, opts)
+	if err == nil {
+		db.Close()
+		t.Fatal("Expected the torn page to fail to open")
+	}
+	if !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Expected ErrCorrupted, got %v", err)
+	}
+}
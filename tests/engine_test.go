@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"godata/pagefmt"
+)
+
+func TestEngine_RecordedInHeader(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+
+	opts := DefaultOptions()
+	opts.Engine = EngineSorted
+	storage, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	storage.Put("a", "1")
+	storage.Close()
+
+	data, _ := os.ReadFile(filename)
+	if header, err := pagefmt.ParseHeader(data); err != nil || header.Engine != uint32(EngineSorted) {
+		t.Errorf("Expected engine %d in the header, got %+v (%v)", EngineSorted, header, err)
+	}
+	// the file decides, not the options it is opened with
+	storage, err = NewStorage(filename)
+	if err != nil {
+		t.Fatalf("Failed to reopen: %v", err)
+	}
+	defer storage.Close()
+	if storage.Engine() != "sorted" {
+		t.Errorf("Expected the sorted engine, got %s", storage.Engine())
+	}
+}
+
+func TestEngine_UnknownRejected(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+
+	opts := DefaultOptions()
+	opts.Engine = 99
+	if _, err := NewStorageWithOptions(filename, opts); err == nil {
+		t.Error("Expected an unknown engine to be rejected")
+	}
+	os.Remove(filename)
+
+	storage, _ := NewStorage(filename)
+	storage.Close()
+	f, _ := os.OpenFile(filename, os.O_RDWR, 0644)
+	f.WriteAt([]byte{99}, 36)
+	f.Close()
+	if _, err := NewStorage(filename); err == nil || !strings.Contains(err.Error(), "unknown storage engine") {
+		t.Errorf("Expected the header's engine to be checked, got %v", err)
+	}
+}
+
+func TestEngine_SortedKeepsKeyRangesTogether(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	defer os.Remove(filename + btreeSuffix)
+
+	opts := DefaultOptions()
+	opts.Engine = EngineSorted
+	opts.BTreeIndex = true
+	storage, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	// ~19 records to a page, inserted in random order
+	for _, i := range rand.New(rand.NewSource(1)).Perm(300) {
+		if err := storage.Put(fmt.Sprintf("key%03d", i), strings.Repeat("v", 200)); err != nil {
+			t.Fatalf("Put %d failed: %v", i, err)
+		}
+	}
+
+	// walking the keys in order, a page is never come back to
+	seen := map[uint32]bool{}
+	last := uint32(1<<32 - 1)
+	storage.indexRange("", "", func(key string, pageID uint32) bool {
+		if pageID != last && seen[pageID] {
+			t.Errorf("%s is back on page %d", key, pageID)
+			return false
+		}
+		seen[pageID], last = true, pageID
+		return true
+	})
+	if len(seen) < 16 {
+		t.Errorf("Expected the records spread over 16+ pages, got %d", len(seen))
+	}
+	storage.Close()
+
+	storage, _ = NewStorageWithOptions(filename, opts)
+	defer storage.Close()
+	for i := 0; i < 300; i++ {
+		if value, err := storage.Get(fmt.Sprintf("key%03d", i)); err != nil || len(value) != 200 {
+			t.Fatalf("key%03d: %d bytes, %v", i, len(value), err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStorageError_TruncatedPage(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	value := string(make([]byte, 3000))
+	storage.Put("a", value)
+	storage.Put("b", value) // doesn't fit next to a, goes to page 1
+	storage.Close()
+
+	// cut page 1 short
+	if err := os.Truncate(filename, HeaderSize+PageSize+10); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewStorage(filename)
+	var se *StorageError
+	if !errors.As(err, &se) {
+		t.Fatalf("Expected a StorageError, got %v", err)
+	}
+	if se.Op != "read page" || se.PageID != 1 || se.Offset != HeaderSize+PageSize || !errors.Is(err, io.EOF) {
+		t.Errorf("Unexpected error details: %+v", se)
+	}
+}
+
+func TestStorageError_BadHeader(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	storage.Close()
+
+	f, _ := os.OpenFile(filename, os.O_RDWR, 0644)
+	f.WriteAt([]byte{9, 0, 0, 0}, 4) // version 9
+	f.Close()
+
+	_, err := NewStorage(filename)
+	var se *StorageError
+	if !errors.As(err, &se) || se.Op != "parse header" || se.PageID != -1 || se.Offset != 4 {
+		t.Errorf("Expected a header error pointing at the version, got %v", err)
+	}
+}
+
+func TestStorageError_VerifyPointsAtTheRecord(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("user:1", "isabella")
+	storage.Put("user:2", "cam")
+	storage.Sync()
+
+	// the second record claims a value running past the page
+	second := 2 + 4 + len("user:1") + len("isabella")
+	corrupt := make([]byte, 2)
+	binary.LittleEndian.PutUint16(corrupt, 0xFFFF)
+	storage.file.WriteAt(corrupt, storage.pageOffset(0)+int64(second)+2)
+
+	report, _ := storage.Verify(1)
+	if len(report.Problems) != 1 {
+		t.Fatalf("Expected one problem, got %v", report.Problems)
+	}
+	var se *StorageError
+	if !errors.As(report.Problems[0].Err, &se) || se.Offset != storage.pageOffset(0)+int64(second) {
+		t.Errorf("Expected the error to point at byte %d, got %v", storage.pageOffset(0)+int64(second), report.Problems[0].Err)
+	}
+}
+
+func TestSentinelErrors_MatchWithErrorsIs(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	if _, err := storage.Get("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get: expected ErrKeyNotFound, got %v", err)
+	}
+	if err := storage.Delete("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Delete: expected ErrKeyNotFound, got %v", err)
+	}
+	if err := storage.Rename("missing", "other"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Rename: expected ErrKeyNotFound, got %v", err)
+	}
+
+	page := &Page{ID: 0}
+	if err := page.addRecord("big", strings.Repeat("v", PageSize)); !errors.Is(err, ErrPageFull) {
+		t.Errorf("addRecord: expected ErrPageFull, got %v", err)
+	}
+}
+
+func TestSentinelErrors_CorruptionSaysWhere(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("user:1", "isabella")
+	storage.Put("user:2", "cam")
+	// the index and the page disagree
+	storage.pageIndex["user:2"] = 0
+	page, _ := storage.loadPage(0)
+	page.deleteRecord("user:2")
+
+	_, err := storage.Get("user:2")
+	var se *StorageError
+	if !errors.Is(err, ErrCorrupted) || !errors.As(err, &se) {
+		t.Fatalf("Expected ErrCorrupted in a StorageError, got %v", err)
+	}
+	if se.PageID != 0 || se.Offset != storage.pageOffset(0) {
+		t.Errorf("Expected page 0 at offset %d, got page %d at offset %d", storage.pageOffset(0), se.PageID, se.Offset)
+	}
+	if errors.Is(err, ErrKeyNotFound) {
+		t.Error("A damaged page isn't a missing key")
+	}
+
+	// a checksum mismatch is corruption too
+	storage.Sync()
+	b := []byte{0}
+	at := storage.pageOffset(0) + int64(2+4+len("user:1"))
+	storage.file.ReadAt(b, at)
+	b[0] ^= 0x01
+	storage.file.WriteAt(b, at)
+	delete(storage.pages, 0)
+	if _, err := storage.Get("user:1"); !errors.Is(err, ErrCorrupted) || !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected both ErrCorrupted and ErrChecksumMismatch, got %v", err)
+	}
+}
+
+func TestErrorKind_SameCategoryInEveryProtocol(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	_, notFound := storage.Get("missing")
+	storage.Put("a", "1")
+	storage.Put("b", "2")
+	exists := storage.Rename("a", "b")
+	storage.SetMaintenanceMode(true)
+	readOnly := storage.Put("c", "3")
+
+	for _, tc := range []struct {
+		err  error
+		kind ErrorKind
+		http int
+		grpc uint32
+		resp string
+	}{
+		{notFound, KindNotFound, 404, 5, "NOTFOUND"},
+		{exists, KindConflict, 409, 9, "CONFLICT"},
+		{readOnly, KindReadOnly, 503, 9, "READONLY"},
+		{fmt.Errorf("put: %w", ErrDiskFull), KindQuota, 507, 8, "QUOTA"},
+		{ErrOverloaded, KindUnavailable, 503, 14, "TRYAGAIN"},
+		{&StorageError{Op: "read page", Err: fmt.Errorf("%w: x", ErrCorrupted)}, KindCorrupted, 500, 15, "CORRUPT"},
+		{errors.New("something else"), KindInternal, 500, 13, "ERR"},
+	} {
+		kind := KindOf(tc.err)
+		if kind != tc.kind || kind.HTTPStatus() != tc.http || kind.GRPCCode() != tc.grpc || kind.RESPPrefix() != tc.resp {
+			t.Errorf("%v: got %s (%d, %d, %s), want %s", tc.err, kind, kind.HTTPStatus(), kind.GRPCCode(), kind.RESPPrefix(), tc.kind)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	WriteHTTPError(rec, notFound)
+	if rec.Code != 404 || !strings.Contains(rec.Body.String(), `"kind":"not_found"`) {
+		t.Errorf("Expected a 404 with the kind, got %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 1000 keys under "a:", 4000 under "b:"
+func fillForEstimate(t *testing.T, storage *Storage) {
+	pad := strings.Repeat("x", 100) // a hundred or so keys to a tree leaf
+	for i := 0; i < 5000; i++ {
+		prefix := "b:"
+		if i%5 == 0 {
+			prefix = "a:"
+		}
+		if err := storage.Put(fmt.Sprintf("%s%05d%s", prefix, i, pad), "v", WithNoSync()); err != nil {
+			t.Fatalf("Put %d failed: %v", i, err)
+		}
+	}
+}
+
+func checkEstimate(t *testing.T, storage *Storage, prefix string, want int) {
+	t.Helper()
+	got, err := storage.EstimateCount(prefix)
+	if err != nil {
+		t.Fatalf("EstimateCount(%q) failed: %v", prefix, err)
+	}
+	if got < want*7/10 || got > want*13/10 {
+		t.Errorf("EstimateCount(%q) = %d, want about %d", prefix, got, want)
+	}
+}
+
+func TestEstimateCount_MapIndex(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("x:1", "v")
+	storage.Put("x:2", "v")
+	if got, _ := storage.EstimateCount("x:"); got != 2 {
+		t.Errorf("Expected an exact count for a small index, got %d", got)
+	}
+
+	fillForEstimate(t, storage)
+	checkEstimate(t, storage, "a:", 1000)
+	checkEstimate(t, storage, "b:", 4000)
+	if got, _ := storage.EstimateCount(""); got != 5002 {
+		t.Errorf("Expected every key for the empty prefix, got %d", got)
+	}
+}
+
+func TestEstimateCount_BTreeIndex(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	defer os.Remove(filename + btreeSuffix)
+	opts := DefaultOptions()
+	opts.BTreeIndex = true
+	storage, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer storage.Close()
+
+	fillForEstimate(t, storage)
+	checkEstimate(t, storage, "a:", 1000)
+	checkEstimate(t, storage, "b:", 4000)
+	// a few keys in one leaf are counted exactly
+	if got, _ := storage.EstimateCount("a:0000"); got != 2 {
+		t.Errorf("Expected exactly 2 keys under a:0000, got %d", got)
+	}
+	if got, _ := storage.EstimateCount("c:"); got != 0 {
+		t.Errorf("Expected 0 keys under c:, got %d", got)
+	}
+}
+
+func TestEstimateCount_LeavesOutReservedKeys(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("a", "1")
+	if _, err := storage.PutWithLease("leader", "A", time.Minute); err != nil {
+		t.Fatalf("PutWithLease failed: %v", err)
+	}
+	if _, err := storage.PutReader("doc", strings.NewReader(strings.Repeat("d", 3*PageSize))); err != nil {
+		t.Fatalf("PutReader failed: %v", err)
+	}
+
+	if got, _ := storage.EstimateCount(""); got != 3 {
+		t.Errorf("Expected 3 keys, got %d", got)
+	}
+	for _, prefix := range []string{StreamChunkPrefix, LeaseKeyPrefix, "__"} {
+		if got, _ := storage.EstimateCount(prefix); got != 0 {
+			t.Errorf("EstimateCount(%q) = %d, want 0", prefix, got)
+		}
+	}
+}
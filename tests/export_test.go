@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// decodes an export back into its header and a key → value map
+func readExport(t *testing.T, r io.Reader) (ExportHeader, map[string]string) {
+	t.Helper()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+
+	var header ExportHeader
+	if !scanner.Scan() {
+		t.Fatalf("Export is empty")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		t.Fatalf("Bad export header: %v", err)
+	}
+
+	records := map[string]string{}
+	for scanner.Scan() {
+		var rec ExportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("Bad export line %q: %v", scanner.Text(), err)
+		}
+		records[rec.Key] = rec.Data()
+	}
+	return header, records
+}
+
+func TestExport_RoundTrip(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("user:1", "isabella")
+	storage.Put("user:2", "")
+	storage.Put("blob", "\x00\x01\xff")
+	storage.Put("gone", "x")
+	storage.Delete("gone")
+
+	var buf bytes.Buffer
+	header, err := storage.Export(&buf)
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if header.Format != ExportFormat || header.Records != 3 || header.LSN != 5 {
+		t.Errorf("Unexpected header %+v", header)
+	}
+
+	got, records := readExport(t, &buf)
+	if got.LSN != header.LSN {
+		t.Errorf("Header line has LSN %d, Export returned %d", got.LSN, header.LSN)
+	}
+	want := map[string]string{"user:1": "isabella", "user:2": "", "blob": "\x00\x01\xff"}
+	if len(records) != len(want) {
+		t.Fatalf("Expected %d records, got %v", len(want), records)
+	}
+	for k, v := range want {
+		if records[k] != v {
+			t.Errorf("%q: expected %q, got %q", k, v, records[k])
+		}
+	}
+}
+
+// writes a record into the database in the middle of the export
+type writingWriter struct {
+	w       bytes.Buffer
+	storage *Storage
+	done    bool
+}
+
+func (ww *writingWriter) Write(p []byte) (int, error) {
+	if !ww.done {
+		ww.done = true
+		ww.storage.Put("late", "arrival")
+		ww.storage.Delete("user:1")
+	}
+	return ww.w.Write(p)
+}
+
+func TestExport_IsASnapshot(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("user:1", "isabella")
+	storage.Put("user:2", "cam")
+
+	ww := &writingWriter{storage: storage}
+	header, err := storage.Export(ww)
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	_, records := readExport(t, &ww.w)
+	if _, leaked := records["late"]; leaked || records["user:1"] != "isabella" || len(records) != 2 {
+		t.Errorf("Export doesn't match the state at LSN %d: %v", header.LSN, records)
+	}
+}
+
+func TestLSN_PersistsAcrossReopen(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	storage.Put("a", "1")
+	storage.Put("b", "2")
+	storage.Delete("a")
+	storage.Close()
+
+	reopened, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer reopened.Close()
+
+	header, err := reopened.Export(io.Discard)
+	if err != nil || header.LSN != 3 {
+		t.Errorf("Expected LSN 3 after reopen, got %d (%v)", header.LSN, err)
+	}
+}
+
+func TestExportParquet_Layout(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("user:1", "isabella")
+	storage.Put("user:2", "cam")
+
+	var buf bytes.Buffer
+	header, err := storage.ExportParquet(&buf)
+	if err != nil {
+		t.Fatalf("ExportParquet failed: %v", err)
+	}
+	data := buf.Bytes()
+
+	// "PAR1" <column chunks> <footer> <footer length> "PAR1"
+	if len(data) < 12 || string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		t.Fatalf("Missing Parquet magic")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footerLen <= 0 || footerLen > len(data)-12 {
+		t.Fatalf("Bad footer length %d for a %d byte file", footerLen, len(data))
+	}
+	footer := data[len(data)-8-footerLen : len(data)-8]
+	for _, want := range []string{"key", "value", "size", "timestamp", "godata.lsn", fmt.Sprint(header.LSN)} {
+		if !bytes.Contains(footer, []byte(want)) {
+			t.Errorf("Footer doesn't mention %q", want)
+		}
+	}
+	// PLAIN byte arrays are the length then the bytes, the values must be in there as-is
+	if !bytes.Contains(data, append([]byte{8, 0, 0, 0}, "isabella"...)) {
+		t.Errorf("Value column doesn't hold the value")
+	}
+}
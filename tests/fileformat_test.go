@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"godata/pagefmt"
+)
+
+func TestOpen_UnknownFormatFlag(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	db.Put("user:1", "isabella")
+	db.Close()
+
+	// a flag from a build that knows more than this one
+	file, _ := os.OpenFile(filename, os.O_RDWR, 0644)
+	var flags [4]byte
+	binary.LittleEndian.PutUint32(flags[:], pagefmt.FlagPageChecksums|1<<20)
+	file.WriteAt(flags[:], 40)
+	file.Close()
+
+	if db, err := NewStorage(filename); !errors.Is(err, ErrUnsupportedFormat) {
+		if err == nil {
+			db.Close()
+		}
+		t.Errorf("Expected ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+func TestMigrate_AddsFormatFeatures(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	defer cleanupTestDB(t, filename+backupSuffix)
+
+	old := DefaultOptions()
+	old.DisablePageChecksums = true
+	db, err := NewStorageWithOptions(filename, old)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	for i := 0; i < 40; i++ {
+		db.Put(fmt.Sprintf("order:%03d", i), strings.Repeat("o", 300))
+	}
+	db.Delete("order:007")
+	db.Close()
+
+	opts := DefaultOptions()
+	opts.SlottedPages = true
+	opts.RecordVersions = true
+	report, err := Migrate(filename, opts)
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	want := pagefmt.FlagPageChecksums | pagefmt.FlagRecordVersions | pagefmt.FlagSlottedPages | pagefmt.FlagPageLSN
+	if report.From != pagefmt.FlagPageLSN || report.To != want || report.Records != 39 || report.Backup != filename+backupSuffix {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	db, err = NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open the migrated file: %v", err)
+	}
+	if db.headerFlags() != want {
+		t.Errorf("Migrated file has flags %#x, want %#x", db.headerFlags(), want)
+	}
+	if got, err := db.Get("order:039"); err != nil || got != strings.Repeat("o", 300) {
+		t.Errorf("order:039 = %d bytes, %v", len(got), err)
+	}
+	if _, err := db.Get("order:007"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("order:007 came back: %v", err)
+	}
+	if report, _ := db.Verify(1); !report.OK() {
+		t.Errorf("Migrated file fails verification: %v", report.Problems)
+	}
+	db.Close()
+
+	// the backup is the file as it was
+	backup, err := NewStorage(filename + backupSuffix)
+	if err != nil {
+		t.Fatalf("Failed to open the backup: %v", err)
+	}
+	if backup.headerFlags() != pagefmt.FlagPageLSN || len(backup.pageIndex) != 39 {
+		t.Errorf("Backup has flags %#x and %d keys", backup.headerFlags(), len(backup.pageIndex))
+	}
+	backup.Close()
+
+	// a second migration has nothing to do
+	again, err := Migrate(filename, opts)
+	if err != nil || again.From != want || again.To != want || again.Backup != "" {
+		t.Errorf("Second Migrate = %+v, %v", again, err)
+	}
+	if _, err := os.Stat(filename + migrateSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no %s left behind", migrateSuffix)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestScanWhere_ReturnsOnlyMatches(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	for i := 0; i < 20; i++ {
+		status := "inactive"
+		if i%4 == 0 {
+			status = "active"
+		}
+		storage.Put(fmt.Sprintf("user:%02d", i), fmt.Sprintf(`{"status":%q,"age":%d,"tags":["t%d"]}`, status, 10+i, i))
+	}
+	storage.Put("user:99", "not json")
+	storage.Put("zzz", `{"status":"active"}`)
+
+	it, err := storage.ScanWhere("user:", "user;", `json.status == "active" && json.age >= 18`)
+	if err != nil {
+		t.Fatalf("ScanWhere failed: %v", err)
+	}
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if it.Err() != nil || fmt.Sprint(keys) != "[user:08 user:12 user:16]" {
+		t.Errorf("Expected users 8, 12 and 16, got %v, %v", keys, it.Err())
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	doc := `{"status":"active","age":30,"admin":true,"tags":["a","b"],"owner":null}`
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{`json.status == "active"`, true},
+		{`json.status != "active"`, false},
+		{`json.age > 29.5 && json.age < 31`, true},
+		{`json.age == "30"`, false}, // a number is never a string
+		{`json.admin`, true},
+		{`!json.admin || json.tags.1 == "b"`, true},
+		{`json.tags.5 == null && json.missing == null && json.owner == null`, true},
+		{`(json.age < 18 || json.status == "active") && key >= "user:"`, true},
+		{`value == "x"`, false},
+		{`json.tags == "a"`, false},
+	}
+	for _, c := range cases {
+		f, err := ParseFilter(c.expr)
+		if err != nil {
+			t.Errorf("ParseFilter(%s) failed: %v", c.expr, err)
+			continue
+		}
+		if got := f.Match("user:1", doc); got != c.want {
+			t.Errorf("%s = %v, want %v", c.expr, got, c.want)
+		}
+	}
+
+	for _, bad := range []string{``, `json.status ==`, `status == "a"`, `(json.a`, `json.a == "x`, `json.a = 1`, `json.a == 1 json.b`} {
+		if _, err := ParseFilter(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
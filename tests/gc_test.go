@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGC_ReclaimsOrphanedPage(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("user:1", "isabella")
+
+	// simulate a leftover copy from an interrupted rewrite: a second page
+	// holding user:1 while the index still points at page 0
+	orphan := storage.allocateNewPage()
+	orphan.addRecord("user:1", "stale")
+
+	report, err := storage.GC()
+	if err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if len(report.Orphaned) != 1 || report.Orphaned[0] != orphan.ID {
+		t.Fatalf("Expected page %d orphaned, got %v", orphan.ID, report.Orphaned)
+	}
+	if orphan.RecordCount != 0 {
+		t.Errorf("Expected orphaned page to be wiped, has %d records", orphan.RecordCount)
+	}
+	if value, _ := storage.Get("user:1"); value != "isabella" {
+		t.Errorf("Live record damaged by GC, got %q", value)
+	}
+
+	// fill page 0 so the next record needs another page, it should reuse the orphan
+	total := storage.totalPages
+	storage.Put("big:1", strings.Repeat("x", PageSize-24))
+	if storage.totalPages != total {
+		t.Errorf("Expected free page reuse, file grew from %d to %d pages", total, storage.totalPages)
+	}
+	if storage.pageIndex["big:1"] != orphan.ID {
+		t.Errorf("Expected big:1 on page %d, got %d", orphan.ID, storage.pageIndex["big:1"])
+	}
+}
+
+func TestFreeList_RebuiltOnOpen(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+
+	storage1, _ := NewStorage(filename)
+	storage1.Put("a", strings.Repeat("a", 3000))
+	storage1.Put("b", strings.Repeat("b", 3000)) // second page
+	storage1.Delete("a")
+	storage1.Close()
+
+	storage2, _ := NewStorage(filename)
+	defer storage2.Close()
+	if len(storage2.freePages) != 1 || storage2.freePages[0] != 0 {
+		t.Errorf("Expected page 0 on the free list, got %v", storage2.freePages)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestGetMany_LoadsEachPageOnce(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	for i := 0; i < 40; i++ { // 4 to a page
+		storage.Put(fmt.Sprintf("key%02d", i), fmt.Sprint(i)+strings.Repeat("v", 900))
+	}
+	defer storage.Close()
+	storage.Stats().Reset()
+
+	keys := []string{"key00", "key01", "key02", "key03", "key20", "key21", "key39", "missing", "key00"}
+	values, err := storage.GetMany(keys)
+	if err != nil {
+		t.Fatalf("GetMany failed: %v", err)
+	}
+	snap := storage.Stats().Snapshot()
+	if loads := snap.CacheHits + snap.CacheMisses; loads != 3 {
+		t.Errorf("Expected one page load for each of the 3 pages, got %d", loads)
+	}
+	if len(values) != 7 {
+		t.Errorf("Expected 7 values, got %d", len(values))
+	}
+	if _, ok := values["missing"]; ok {
+		t.Error("Expected the missing key to be left out")
+	}
+	for _, key := range keys[:7] {
+		want, _ := storage.Get(key)
+		if values[key] != want {
+			t.Errorf("GetMany %s doesn't match Get", key)
+		}
+	}
+}
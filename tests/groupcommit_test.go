@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestGroupCommit_ConcurrentWritersShareFsyncs(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	opts := DefaultOptions()
+	opts.Sync = SyncGroupCommit
+	db, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if err := db.Put(fmt.Sprintf("w%d:%d", w, i), "v"); err != nil {
+					t.Errorf("Put failed: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	snap := db.Stats().Snapshot()
+	if snap.GroupCommits != 400 {
+		t.Errorf("Expected 400 group commits, got %d", snap.GroupCommits)
+	}
+	if snap.GroupSyncs == 0 || snap.GroupSyncs > snap.GroupCommits {
+		t.Errorf("Expected between 1 and 400 fsyncs, got %d", snap.GroupSyncs)
+	}
+	// no pages were written, the WAL has it all
+	if snap.PageWrites != 0 {
+		t.Errorf("Expected no page writes before Sync, got %d", snap.PageWrites)
+	}
+	crashStorage(db)
+
+	db, err = NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+	for w := 0; w < 8; w++ {
+		for i := 0; i < 50; i++ {
+			if _, err := db.Get(fmt.Sprintf("w%d:%d", w, i)); err != nil {
+				t.Fatalf("w%d:%d lost: %v", w, i, err)
+			}
+		}
+	}
+}
+
+func TestGroupCommit_NoSyncSkipsTheWait(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	opts := DefaultOptions()
+	opts.Sync = SyncGroupCommit
+	db, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer db.Close()
+
+	db.Put("a", "1", WithNoSync())
+	db.Delete("a")
+	if got := db.Stats().Snapshot().GroupCommits; got != 1 {
+		t.Errorf("Expected only the delete to wait, got %d commits", got)
+	}
+	if policy, err := ParseSyncPolicy(SyncGroupCommit.String()); err != nil || policy != SyncGroupCommit {
+		t.Errorf("Expected group_commit to round trip, got %v, %v", policy, err)
+	}
+}
+
+func TestWAL_SyncToCoversEarlierEntries(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	wal, err := NewWAL(filename)
+	if err != nil {
+		t.Fatalf("NewWAL failed: %v", err)
+	}
+	defer wal.Close()
+
+	wal.Append(LogTypePut, "a", "1")
+	lsn, _ := wal.Append(LogTypePut, "b", "2")
+	if synced, err := wal.SyncTo(lsn); !synced || err != nil {
+		t.Errorf("Expected SyncTo to fsync, got %v, %v", synced, err)
+	}
+	// already on disk, no second fsync
+	if synced, err := wal.SyncTo(lsn - 1); synced || err != nil {
+		t.Errorf("Expected no fsync for an earlier LSN, got %v, %v", synced, err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exports a fresh database holding records
+func exportOf(t *testing.T, records map[string]string) string {
+	t.Helper()
+	source, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer source.Close()
+
+	for k, v := range records {
+		source.Put(k, v)
+	}
+	var buf bytes.Buffer
+	if _, err := source.Export(&buf); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	return buf.String()
+}
+
+func TestImport_ConflictPolicies(t *testing.T) {
+	export := exportOf(t, map[string]string{"a": "new", "b": "same", "c": "fresh"})
+
+	tests := []struct {
+		policy                                 ConflictPolicy
+		inserted, unchanged, overwritten, skip int
+		wantA                                  string
+	}{
+		{ConflictSkip, 1, 1, 0, 1, "old"},
+		{ConflictOverwrite, 1, 1, 1, 0, "new"},
+	}
+	for _, tt := range tests {
+		// not subtests, setupTestDB names the file after the test
+		func() {
+			storage, filename := setupTestDB(t)
+			defer cleanupTestDB(t, filename)
+			defer storage.Close()
+			storage.Put("a", "old")
+			storage.Put("b", "same")
+
+			report, err := storage.Import(strings.NewReader(export), tt.policy)
+			if err != nil {
+				t.Fatalf("%s: Import failed: %v", tt.policy, err)
+			}
+			if report.Records != 3 || report.Inserted != tt.inserted || report.Unchanged != tt.unchanged ||
+				report.Overwritten != tt.overwritten || report.Skipped != tt.skip {
+				t.Errorf("%s: unexpected report %+v", tt.policy, report)
+			}
+			if got, _ := storage.Get("a"); got != tt.wantA {
+				t.Errorf("%s: expected a=%q, got %q", tt.policy, tt.wantA, got)
+			}
+			if got, _ := storage.Get("c"); got != "fresh" {
+				t.Errorf("%s: expected c to be imported, got %q", tt.policy, got)
+			}
+		}()
+	}
+}
+
+func TestImport_FailWritesNothing(t *testing.T) {
+	export := exportOf(t, map[string]string{"a": "new", "c": "fresh"})
+
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+	storage.Put("a", "old")
+
+	report, err := storage.Import(strings.NewReader(export), ConflictFail)
+	if !errors.Is(err, ErrImportConflict) || len(report.Conflicts) != 1 {
+		t.Fatalf("Expected one conflict, got %v (%+v)", err, report)
+	}
+	if _, err := storage.Get("c"); err == nil {
+		t.Errorf("A failed import must not write any record")
+	}
+}
+
+func TestImport_RejectsBadInput(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	for _, input := range []string{
+		"",
+		`{"format":"something-else","version":1}`,
+		`{"format":"godata-export","version":1}` + "\n" + `{"key":"a","value":"1"}` + "\nnot json\n",
+	} {
+		if _, err := storage.Import(strings.NewReader(input), ConflictOverwrite); err == nil {
+			t.Errorf("Expected %q to be rejected", input)
+		}
+	}
+	if _, err := storage.Get("a"); err == nil {
+		t.Errorf("A rejected import must not write any record")
+	}
+}
+
+func TestImport_StreamedValueRoundTrip(t *testing.T) {
+	src, srcFile := setupTestDB(t)
+	defer cleanupTestDB(t, srcFile)
+	defer src.Close()
+
+	doc := string(randomBytes(5*PageSize, 3))
+	if _, err := src.PutReader("doc", strings.NewReader(doc)); err != nil {
+		t.Fatalf("PutReader failed: %v", err)
+	}
+	if _, err := src.PutWithLease("leader", "A", time.Minute); err != nil {
+		t.Fatalf("PutWithLease failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := src.Export(&buf); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	header, records := readExport(t, bytes.NewReader(buf.Bytes()))
+	if header.Records != 2 || len(records) != 2 || records["doc"] != doc {
+		t.Fatalf("Expected doc whole and leader, got %d records (header says %d)", len(records), header.Records)
+	}
+
+	dstFile := "test_" + t.Name() + "_dst.db"
+	dst, err := NewStorage(dstFile)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer cleanupTestDB(t, dstFile)
+	defer dst.Close()
+	if _, err := dst.Import(&buf, ConflictFail); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if got, err := dst.Get("doc"); err != nil || got != doc {
+		t.Errorf("Expected the streamed value back (%d bytes), got %d bytes, %v", len(doc), len(got), err)
+	}
+	if countChunks(t, dst) < 5 {
+		t.Errorf("Expected the imported value to be streamed, got %d chunks", countChunks(t, dst))
+	}
+}
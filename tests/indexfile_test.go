@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func openIndexed(t *testing.T, filename string) *Storage {
+	opts := DefaultOptions()
+	opts.IndexFile = true
+	storage, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	return storage
+}
+
+func fillIndexed(t *testing.T, filename string) {
+	storage := openIndexed(t, filename)
+	for i := 0; i < 10; i++ {
+		storage.Put(fmt.Sprintf("k%d", i), strings.Repeat("v", 1800))
+	}
+	storage.Delete("k0")
+	storage.Delete("k1") // page 0 is empty now
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestIndexFile_LoadedInsteadOfScanning(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	defer os.Remove(filename + indexSuffix)
+	fillIndexed(t, filename)
+
+	storage := openIndexed(t, filename)
+	defer storage.Close()
+	if len(storage.pages) != 0 {
+		t.Errorf("Expected no page read on open, %d are cached", len(storage.pages))
+	}
+	if len(storage.pageIndex) != 8 || len(storage.freePages) != 1 || storage.freePages[0] != 0 {
+		t.Errorf("Expected 8 keys and page 0 free, got %d keys, free %v", len(storage.pageIndex), storage.freePages)
+	}
+	for i := 2; i < 10; i++ {
+		if value, err := storage.Get(fmt.Sprintf("k%d", i)); err != nil || len(value) != 1800 {
+			t.Errorf("k%d: %d bytes, %v", i, len(value), err)
+		}
+	}
+}
+
+func TestIndexFile_StaleOrDamagedFallsBackToScan(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	defer os.Remove(filename + indexSuffix)
+	fillIndexed(t, filename)
+
+	// a writer that doesn't keep the index file moves the LSN on
+	plain, _ := NewStorage(filename)
+	plain.Put("k2", "changed")
+	plain.Put("extra", "x")
+	plain.Close()
+
+	storage := openIndexed(t, filename)
+	if len(storage.pages) == 0 {
+		t.Error("Expected a scan for a stale index file")
+	}
+	if value, _ := storage.Get("extra"); value != "x" {
+		t.Errorf("Key written after the index file was saved is missing, got %q", value)
+	}
+	storage.Close()
+
+	// a flipped byte fails the checksum
+	saved, _ := os.ReadFile(filename + indexSuffix)
+	saved[30] ^= 0xFF
+	os.WriteFile(filename+indexSuffix, saved, 0644)
+	storage = openIndexed(t, filename)
+	defer storage.Close()
+	if len(storage.pages) == 0 || len(storage.pageIndex) != 9 {
+		t.Errorf("Expected a scan finding 9 keys, got %d cached pages, %d keys", len(storage.pages), len(storage.pageIndex))
+	}
+}
+
+func TestIndexFile_DroppedByCompact(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	defer os.Remove(filename + indexSuffix)
+	fillIndexed(t, filename)
+
+	storage := openIndexed(t, filename)
+	if _, err := storage.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if _, err := os.Stat(filename + indexSuffix); !os.IsNotExist(err) {
+		t.Error("Expected Compact to remove the index file")
+	}
+	storage.Close()
+
+	storage = openIndexed(t, filename)
+	defer storage.Close()
+	if value, err := storage.Get("k9"); err != nil || len(value) != 1800 {
+		t.Errorf("k9 after compaction: %d bytes, %v", len(value), err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func collectScan(it *Iterator) []string {
+	var got []string
+	for it.Next() {
+		got = append(got, it.Key()+"="+it.Value())
+	}
+	return got
+}
+
+func TestScan_SortedRange(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	// spread over several pages so the page order isn't the key order
+	for _, i := range []int{7, 2, 9, 0, 5, 3, 8, 1, 6, 4} {
+		storage.Put(fmt.Sprintf("k%d", i), fmt.Sprintf("%d%s", i, strings.Repeat("x", 1500)))
+	}
+	storage.Put("other", "o")
+
+	it := storage.Scan("k3", "k7")
+	got := collectScan(it)
+	if it.Err() != nil {
+		t.Fatalf("Scan failed: %v", it.Err())
+	}
+	var keys []string
+	for _, kv := range got {
+		keys = append(keys, kv[:2])
+	}
+	if strings.Join(keys, ",") != "k3,k4,k5,k6" {
+		t.Errorf("Expected k3..k6 in order, got %v", keys)
+	}
+	if !strings.HasPrefix(got[0], "k3=3x") {
+		t.Errorf("Expected k3's value, got %.10q", got[0])
+	}
+
+	all := collectScan(storage.Iterator())
+	if len(all) != 11 || !strings.HasPrefix(all[10], "other=") {
+		t.Errorf("Expected all 11 keys ending with other, got %d", len(all))
+	}
+}
+
+func TestScan_SeesChangesMadeWhileScanning(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		storage.Put(k, "1")
+	}
+
+	it := storage.Iterator()
+	if !it.Next() || it.Key() != "a" {
+		t.Fatalf("Expected a first, got %q", it.Key())
+	}
+	storage.Delete("b")
+	storage.Put("c", "2")
+	storage.Put("bb", "new") // not in the key list
+
+	got := collectScan(it)
+	if strings.Join(got, ",") != "c=2,d=1" {
+		t.Errorf("Expected c=2,d=1, got %v", got)
+	}
+	if it.Next() || it.Key() != "" {
+		t.Error("Expected Next to stay false at the end")
+	}
+}
+
+func TestScan_CompressedValuesAndEmptyRange(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	storage, err := NewStorageWithOptions(filename, Options{Compress: true})
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer storage.Close()
+
+	value := strings.Repeat("compressible ", 50)
+	storage.Put("z", value)
+	got := collectScan(storage.Iterator())
+	if len(got) != 1 || got[0] != "z="+value {
+		t.Errorf("Expected the decoded value, got %v", got)
+	}
+	if got := collectScan(storage.Scan("a", "b")); len(got) != 0 {
+		t.Errorf("Expected an empty range, got %v", got)
+	}
+}
+
+func TestScan_LeavesOutReservedKeys(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("a", "1")
+	if _, err := storage.PutWithLease("leader", "A", time.Minute); err != nil {
+		t.Fatalf("PutWithLease failed: %v", err)
+	}
+	doc := strings.Repeat("d", 3*PageSize)
+	if _, err := storage.PutReader("doc", strings.NewReader(doc)); err != nil {
+		t.Fatalf("PutReader failed: %v", err)
+	}
+
+	var keys []string
+	it := storage.Iterator()
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if it.Err() != nil {
+		t.Fatalf("Scan failed: %v", it.Err())
+	}
+	if strings.Join(keys, ",") != "a,doc,leader" {
+		t.Errorf("Expected only the callers' keys, got %v", keys)
+	}
+	if got := collectScan(storage.Scan(LeaseKeyPrefix, prefixEnd(LeaseKeyPrefix))); len(got) != 0 {
+		t.Errorf("Expected nothing under %q, got %d keys", LeaseKeyPrefix, len(got))
+	}
+}
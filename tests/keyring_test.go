@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestKeyring_ShreddingABucket(t *testing.T) {
+	keys := "test_" + t.Name() + ".keys"
+	defer os.Remove(keys)
+	master := bytes.Repeat([]byte{7}, 32)
+	ring, err := OpenKeyring(keys, master, KeyPrefixBucket(":"))
+	if err != nil {
+		t.Fatalf("OpenKeyring failed: %v", err)
+	}
+	opts := DefaultOptions()
+	opts.Transformers = []ValueTransformer{ring}
+	db, filename := openWithOptions(t, opts)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+
+	db.Put("tenant1:name", "isabella")
+	db.Put("tenant2:name", "marco")
+	db.Sync()
+	data, _ := os.ReadFile(filename)
+	if bytes.Contains(data, []byte("isabella")) || bytes.Contains(data, []byte("marco")) {
+		t.Errorf("a value is stored in the clear")
+	}
+
+	if err := ring.Shred("tenant1"); err != nil {
+		t.Fatalf("Shred failed: %v", err)
+	}
+	db.values = nil // a fresh process, nothing decoded in memory
+	if _, err := db.Get("tenant1:name"); !errors.Is(err, ErrKeyShredded) {
+		t.Errorf("Expected ErrKeyShredded, got %v", err)
+	}
+	if got, err := db.Get("tenant2:name"); err != nil || got != "marco" {
+		t.Errorf("tenant2:name = %q, %v", got, err)
+	}
+	if err := db.Delete("tenant1:name"); err != nil {
+		t.Errorf("Delete of a shredded value failed: %v", err)
+	}
+	// the bucket starts over with a new key
+	db.Put("tenant1:name", "cam")
+	if got, err := db.Get("tenant1:name"); err != nil || got != "cam" {
+		t.Errorf("tenant1:name = %q, %v", got, err)
+	}
+
+	// the shredding is in the file, the wrong master key opens nothing
+	again, err := OpenKeyring(keys, master, KeyPrefixBucket(":"))
+	if err != nil || len(again.ciphers) != 2 {
+		t.Errorf("Reopened keyring has %d keys, %v", len(again.ciphers), err)
+	}
+	if _, err := OpenKeyring(keys, bytes.Repeat([]byte{8}, 32), KeyPrefixBucket(":")); err == nil {
+		t.Errorf("Expected the wrong master key to fail")
+	}
+}
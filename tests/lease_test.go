@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"godata/storagetest"
+)
+
+func TestLease_FencesOutAnExpiredHolder(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := storagetest.NewFakeClock(start)
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	opts := DefaultOptions()
+	opts.Clock = clock
+	db, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+
+	tokenA, err := db.PutWithLease("leader", "A", 10*time.Second)
+	if err != nil || tokenA != 1 {
+		t.Fatalf("PutWithLease = %d, %v; want token 1", tokenA, err)
+	}
+	if _, err := db.PutWithLease("leader", "B", 10*time.Second); !errors.Is(err, ErrLeaseHeld) {
+		t.Errorf("Expected ErrLeaseHeld while A holds it, got %v", err)
+	}
+	if err := db.PutFenced("leader", "A again", tokenA); err != nil {
+		t.Errorf("Expected the holder's write to go through: %v", err)
+	}
+
+	clock.Advance(30 * time.Second)
+	tokenB, err := db.PutWithLease("leader", "B", 10*time.Second)
+	if err != nil || tokenB != 2 {
+		t.Fatalf("PutWithLease after expiry = %d, %v; want token 2", tokenB, err)
+	}
+	if err := db.PutFenced("leader", "A late", tokenA); !errors.Is(err, ErrStaleToken) {
+		t.Errorf("Expected ErrStaleToken for A's late write, got %v", err)
+	}
+	if err := db.RenewLease("leader", tokenA, time.Minute); !errors.Is(err, ErrStaleToken) {
+		t.Errorf("Expected A's renewal to be refused, got %v", err)
+	}
+	if got, _ := db.Get("leader"); got != "B" {
+		t.Errorf("leader = %q, want B", got)
+	}
+	if err := db.RenewLease("leader", tokenB, time.Minute); err != nil {
+		t.Errorf("RenewLease failed: %v", err)
+	}
+	if token, expires, _ := db.Lease("leader"); token != 2 || !expires.Equal(clock.Now().Add(time.Minute)) {
+		t.Errorf("Lease = %d until %v", token, expires)
+	}
+	db.Close()
+
+	// tokens keep counting up after a restart
+	db, err = NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+	clock.Advance(2 * time.Minute)
+	if token, err := db.PutWithLease("leader", "C", time.Second); err != nil || token != 3 {
+		t.Errorf("PutWithLease after reopening = %d, %v; want token 3", token, err)
+	}
+}
+
+func TestLease_NoTokenWithoutALease(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+
+	db.Put("plain", "1")
+	if err := db.PutFenced("plain", "2", 0); !errors.Is(err, ErrStaleToken) {
+		t.Errorf("Expected ErrStaleToken on a key without a lease, got %v", err)
+	}
+}
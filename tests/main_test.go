@@ -20,6 +20,7 @@ func cleanupTestDB(t *testing.T, filename string) {
 	if err := os.Remove(filename); err != nil {
 		t.Logf("Warning: failed to remove test file %s: %v", filename, err)
 	}
+	os.Remove(filename + ".wal")
 }
 
 func TestNewStorage_CreateNewDatabase(t *testing.T) {
@@ -292,3 +293,31 @@ func TestBuildIndex_AfterReopen(t *testing.T) {
 		}
 	}
 }
+
+func TestPut_UpdateGrowsPastFullPage(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	// fill page 0 almost completely
+	storage.Put("user:1", "isabella")
+	filler := make([]byte, PageSize-60)
+	storage.Put("filler", string(filler))
+
+	// the bigger value no longer fits on page 0, it has to move
+	bigger := "isabella the first of her name, keeper of the primary key"
+	if err := storage.Put("user:1", bigger); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	value, err := storage.Get("user:1")
+	if err != nil {
+		t.Fatalf("Get after growing update failed: %v", err)
+	}
+	if value != bigger {
+		t.Errorf("Expected %q, got %q", bigger, value)
+	}
+	if storage.pageIndex["user:1"] == storage.pageIndex["filler"] {
+		t.Error("Expected user:1 to move off the full page")
+	}
+}
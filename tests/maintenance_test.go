@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMaintenanceMode_RejectsWritesAllowsReads(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("user:1", "isabella")
+	storage.SetMaintenanceMode(true)
+
+	if err := storage.Put("user:2", "cam"); !errors.Is(err, ErrReadOnlyMode) {
+		t.Errorf("Expected ErrReadOnlyMode from Put, got %v", err)
+	}
+	if err := storage.Delete("user:1"); !errors.Is(err, ErrReadOnlyMode) {
+		t.Errorf("Expected ErrReadOnlyMode from Delete, got %v", err)
+	}
+	if value, err := storage.Get("user:1"); err != nil || value != "isabella" {
+		t.Errorf("Expected reads to keep working, got %q, %v", value, err)
+	}
+
+	// switched back through the runtime option
+	if err := storage.SetOption("maintenance", "false"); err != nil {
+		t.Fatalf("SetOption failed: %v", err)
+	}
+	if err := storage.Put("user:2", "cam"); err != nil {
+		t.Errorf("Expected writes after leaving maintenance mode, got %v", err)
+	}
+}
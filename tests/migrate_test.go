@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// "user:N" → "users/N", upper-cased values, "tmp:" keys dropped
+func migrateUsers(key, value string) (string, string, bool) {
+	if strings.HasPrefix(key, "tmp:") {
+		return "", "", false
+	}
+	if id, ok := strings.CutPrefix(key, "user:"); ok {
+		return "users/" + id, strings.ToUpper(value), true
+	}
+	return key, value, true
+}
+
+func TestMigrateKeys_RewritesTheKeyspace(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	for i := 0; i < 25; i++ {
+		storage.Put(fmt.Sprintf("user:%02d", i), fmt.Sprintf("name%d", i))
+	}
+	storage.Put("tmp:1", "x")
+	storage.Put("config", "keep")
+
+	var batches []int
+	report, err := storage.MigrateKeys(migrateUsers, WithMigrationBatch(10),
+		WithMigrationProgress(func(r MigrateReport) { batches = append(batches, r.Scanned) }))
+	if err != nil {
+		t.Fatalf("MigrateKeys failed: %v", err)
+	}
+	if report.Scanned != 27 || report.Changed != 25 || report.Deleted != 1 || report.Batches != 3 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if len(batches) != 3 || batches[0] != 10 {
+		t.Errorf("Expected progress after every batch, got %v", batches)
+	}
+	if got, err := storage.Get("users/07"); err != nil || got != "NAME7" {
+		t.Errorf("users/07 = %q, %v; want NAME7", got, err)
+	}
+	for _, gone := range []string{"user:07", "tmp:1"} {
+		if _, err := storage.Get(gone); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected %s to be gone, got %v", gone, err)
+		}
+	}
+	if got, _ := storage.Get("config"); got != "keep" {
+		t.Errorf("config = %q, want it left alone", got)
+	}
+}
+
+func TestMigrateKeys_ConflictStopsTheBatch(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("user:1", "a")
+	storage.Put("users/1", "already there")
+	_, err := storage.MigrateKeys(migrateUsers)
+	if !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("Expected ErrKeyExists, got %v", err)
+	}
+	if got, _ := storage.Get("user:1"); got != "a" {
+		t.Errorf("Expected the batch not to be applied, user:1 = %q", got)
+	}
+}
+
+func TestMigrateKeys_ResumesAfterACrash(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	for i := 0; i < 30; i++ {
+		db.Put(fmt.Sprintf("user:%02d", i), "v")
+	}
+	// the first batch goes through, the second fails on a conflict
+	db.Put("users/15", "in the way")
+	_, err := db.MigrateKeys(migrateUsers, WithMigrationName("v2"), WithMigrationBatch(10))
+	if !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("Expected the second batch to fail, got %v", err)
+	}
+	crashStorage(db)
+
+	db, err = NewStorage(filename)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+	db.Delete("users/15")
+	seen := map[string]bool{}
+	report, err := db.MigrateKeys(func(key, value string) (string, string, bool) {
+		seen[key] = true
+		return migrateUsers(key, value)
+	}, WithMigrationName("v2"), WithMigrationBatch(10))
+	if err != nil {
+		t.Fatalf("resumed MigrateKeys failed: %v", err)
+	}
+	if !report.Resumed || seen["user:05"] || !seen["user:10"] {
+		t.Errorf("Expected to resume after user:09, report %+v, saw user:05=%t user:10=%t", report, seen["user:05"], seen["user:10"])
+	}
+	for i := 0; i < 30; i++ {
+		if got, err := db.Get(fmt.Sprintf("users/%02d", i)); err != nil || got != "V" {
+			t.Errorf("users/%02d = %q, %v; want V", i, got, err)
+		}
+	}
+	if _, err := db.Get(MigrationKeyPrefix + "v2"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected the position record to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"godata/pagefmt"
+)
+
+func TestSnapshot_ReadsTheStateItWasTakenAt(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("a", "1")
+	storage.Put("b", "1")
+	snap := storage.Snapshot()
+	storage.Put("a", "2")
+	storage.Delete("b")
+	storage.Put("c", "3")
+
+	if got, err := snap.Get("a"); err != nil || got != "1" {
+		t.Errorf("snapshot a = %q, %v; want 1", got, err)
+	}
+	if got, err := snap.Get("b"); err != nil || got != "1" {
+		t.Errorf("snapshot b = %q, %v; want 1", got, err)
+	}
+	if _, err := snap.Get("c"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected c missing from the snapshot, got %v", err)
+	}
+	var got []string
+	it := snap.Scan("", "")
+	for it.Next() {
+		got = append(got, it.Key()+"="+it.Value())
+	}
+	if want := []string{"a=1", "b=1"}; !reflect.DeepEqual(got, want) || it.Err() != nil {
+		t.Errorf("snapshot Scan = %v, %v; want %v", got, it.Err(), want)
+	}
+	if value, _ := storage.Get("a"); value != "2" {
+		t.Errorf("a = %q, want 2", value)
+	}
+
+	snap.Close()
+	if _, err := snap.Get("a"); !errors.Is(err, ErrSnapshotClosed) {
+		t.Errorf("Expected ErrSnapshotClosed, got %v", err)
+	}
+	storage.mu.RLock()
+	kept := len(storage.history)
+	storage.mu.RUnlock()
+	if kept != 0 {
+		t.Errorf("Expected no versions kept without snapshots, %d keys have some", kept)
+	}
+}
+
+func TestSnapshot_StreamedValueReplacedAfterIt(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	old := strings.Repeat("o", 9000)
+	storage.PutReader("doc", strings.NewReader(old))
+	snap := storage.Snapshot()
+	defer snap.Close()
+	// the old chunks are deleted, the snapshot still has them
+	storage.PutReader("doc", strings.NewReader(strings.Repeat("n", 5000)))
+	if got, err := snap.Get("doc"); err != nil || got != old {
+		t.Errorf("snapshot doc = %d bytes, %v; want the old %d", len(got), err, len(old))
+	}
+}
+
+func TestRecordVersions_StoredWithTheRecord(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	opts := DefaultOptions()
+	opts.RecordVersions = true
+	storage, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	storage.Put("a", "1") // LSN 1
+	storage.Put("b", "1") // LSN 2
+	storage.Put("a", "2") // LSN 3
+	if value, version, err := storage.GetWithVersion("a"); err != nil || value != "2" || version != 3 {
+		t.Errorf("GetWithVersion(a) = %q, %d, %v; want 2, 3", value, version, err)
+	}
+	// no snapshot kept a's first value, b hasn't changed since LSN 2
+	if _, err := storage.GetAt("a", 1); !errors.Is(err, ErrVersionGone) {
+		t.Errorf("Expected ErrVersionGone, got %v", err)
+	}
+	if got, err := storage.GetAt("b", 2); err != nil || got != "1" {
+		t.Errorf("GetAt(b, 2) = %q, %v; want 1", got, err)
+	}
+	snap := storage.Snapshot()
+	storage.Put("b", "2") // LSN 4
+	if got, err := storage.GetAt("b", 3); err != nil || got != "1" {
+		t.Errorf("GetAt(b, 3) = %q, %v; want 1", got, err)
+	}
+	snap.Close()
+	storage.Close()
+
+	storage, err = NewStorageWithOptions(filename, DefaultOptions())
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer storage.Close()
+	if value, version, err := storage.GetWithVersion("b"); err != nil || value != "2" || version != 4 {
+		t.Errorf("after reopen GetWithVersion(b) = %q, %d, %v; want 2, 4", value, version, err)
+	}
+
+	// tools see the LSN in front of the value
+	page, err := pagefmt.ReadPage(storage.file, 0)
+	if err != nil {
+		t.Fatalf("ReadPage failed: %v", err)
+	}
+	records, _ := pagefmt.ParsePage(page)
+	for _, r := range records {
+		lsn, value, ok := pagefmt.SplitVersion(r.Value)
+		if !ok || lsn == 0 || len(value) != 1 {
+			t.Errorf("record %q: LSN %d, value %q", r.Key, lsn, value)
+		}
+	}
+}
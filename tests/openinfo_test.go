@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOpenInfo_CleanOpenAndCrash(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	if info := db.OpenInfo(); !info.Created || info.Recovered() || len(info.Warnings) != 0 {
+		t.Errorf("Unexpected info for a new file: %+v", info)
+	}
+	for i := 0; i < 20; i++ {
+		db.Put("user:"+strings.Repeat("x", i), strings.Repeat("v", 400))
+	}
+	db.Close()
+
+	db, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	info := db.OpenInfo()
+	if info.Created || info.Recovered() || len(info.Warnings) != 0 || info.PagesRead < 2 {
+		t.Errorf("Unexpected info for a clean open: %+v", info)
+	}
+
+	db.Put("user:1", "isabella")
+	db.Delete("user:")
+	crashStorage(db)
+	db, err = NewStorage(filename)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+	info = db.OpenInfo()
+	if !info.Recovered() || info.Replayed != 2 || len(info.Warnings) != 1 || !strings.Contains(info.Warnings[0], "2 WAL entries replayed") {
+		t.Errorf("Unexpected info after a crash: %+v", info)
+	}
+}
+
+func TestOpenInfo_StaleIndexFile(t *testing.T) {
+	opts := DefaultOptions()
+	opts.IndexFile = true
+	db, filename := openWithOptions(t, opts)
+	defer cleanupTestDB(t, filename)
+	defer os.Remove(filename + indexSuffix)
+	db.Put("user:1", "isabella")
+	db.Close()
+
+	os.WriteFile(filename+".idx", []byte("not an index"), 0644)
+	db, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+	if info := db.OpenInfo(); !info.IndexRebuilt || len(info.Warnings) != 1 {
+		t.Errorf("Expected the rebuild to be reported, got %+v", info)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// reads a key straight from the page bytes on disk, skipping the page cache,
+// so it only sees what has actually been written
+func diskValue(t *testing.T, storage *Storage, key string) (string, bool) {
+	t.Helper()
+	pageID, ok := storage.pageIndex[key]
+	if !ok {
+		return "", false
+	}
+	page := &Page{ID: pageID}
+	if _, err := storage.file.ReadAt(page.Data[:], storage.pageOffset(pageID)); err != nil {
+		return "", false
+	}
+	page.RecordCount = binary.LittleEndian.Uint16(page.Data[0:2])
+	return page.findRecord(key)
+}
+
+func TestPut_WithSyncOverridesSyncOnClose(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	if err := storage.Put("order:1", "paid", WithSync()); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := storage.Put("order:2", "pending"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	if value, ok := diskValue(t, storage, "order:1"); !ok || value != "paid" {
+		t.Errorf("Expected synced order:1 on disk, got %q", value)
+	}
+	if _, ok := diskValue(t, storage, "order:2"); ok {
+		t.Error("Expected unsynced order:2 to still be only in memory")
+	}
+}
+
+func TestPut_WithNoSyncOverridesSyncAlways(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Sync = SyncAlways
+	storage, filename := openWithOptions(t, opts)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	if err := storage.Put("event:1", "click", WithNoSync()); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, ok := diskValue(t, storage, "event:1"); ok {
+		t.Error("Expected event:1 to still be only in memory")
+	}
+
+	if err := storage.Put("user:1", "isabella"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, ok := diskValue(t, storage, "user:1"); !ok {
+		t.Error("Expected user:1 on disk under SyncAlways")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openWithCacheSize(t *testing.T, filename string, size int) *Storage {
+	opts := DefaultOptions()
+	opts.CacheSize = size
+	storage, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	return storage
+}
+
+func TestPageCache_StaysWithinBudget(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	for i := 0; i < 100; i++ { // a page each
+		storage.Put(fmt.Sprintf("key%03d", i), strings.Repeat("v", 3000))
+	}
+	storage.Close()
+
+	// the index build reads every page, they don't all stay
+	storage = openWithCacheSize(t, filename, 10)
+	defer storage.Close()
+	if len(storage.pages) > 10 {
+		t.Errorf("Expected at most 10 cached pages after open, got %d", len(storage.pages))
+	}
+	for i := 0; i < 100; i++ {
+		if value, err := storage.Get(fmt.Sprintf("key%03d", i)); err != nil || len(value) != 3000 {
+			t.Fatalf("key%03d: %d bytes, %v", i, len(value), err)
+		}
+	}
+	if len(storage.pages) > 10 {
+		t.Errorf("Expected at most 10 cached pages after reading, got %d", len(storage.pages))
+	}
+	if storage.Stats().Snapshot().PageEvictions < 90 {
+		t.Errorf("Expected 90+ evictions, got %d", storage.Stats().Snapshot().PageEvictions)
+	}
+}
+
+func TestPageCache_DirtyPagesWrittenBeforeEviction(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	storage := openWithCacheSize(t, filename, 4)
+
+	for i := 0; i < 50; i++ {
+		storage.Put(fmt.Sprintf("key%03d", i), fmt.Sprintf("%03d", i)+strings.Repeat("v", 3000))
+	}
+	// over budget by what the last write touched at most: the page it
+	// looked at and the new one it dirtied
+	if len(storage.pages) > 6 {
+		t.Errorf("Expected at most 6 cached pages, got %d", len(storage.pages))
+	}
+	for i := 0; i < 50; i++ {
+		if value, err := storage.Get(fmt.Sprintf("key%03d", i)); err != nil || !strings.HasPrefix(value, fmt.Sprintf("%03d", i)) {
+			t.Fatalf("key%03d read back wrong: %v", i, err)
+		}
+	}
+
+	// evicted pages are ahead of the header, the WAL brings the rest back
+	crashStorage(storage)
+	storage = openWithCacheSize(t, filename, 4)
+	defer storage.Close()
+	for i := 0; i < 50; i++ {
+		if value, err := storage.Get(fmt.Sprintf("key%03d", i)); err != nil || !strings.HasPrefix(value, fmt.Sprintf("%03d", i)) {
+			t.Fatalf("key%03d after a crash: %v", i, err)
+		}
+	}
+}
+
+func TestBufferPool_SharedBetweenStorages(t *testing.T) {
+	nameA, nameB := "test_"+t.Name()+"_a.db", "test_"+t.Name()+"_b.db"
+	defer cleanupTestDB(t, nameA)
+	defer cleanupTestDB(t, nameB)
+	for _, filename := range []string{nameA, nameB} {
+		storage, err := NewStorage(filename)
+		if err != nil {
+			t.Fatalf("Failed to open: %v", err)
+		}
+		for i := 0; i < 30; i++ { // a page each
+			storage.Put(fmt.Sprintf("key%03d", i), strings.Repeat("v", 3000))
+		}
+		storage.Close()
+	}
+
+	pool := NewBufferPool(10)
+	opts := DefaultOptions()
+	opts.CacheSize = 1000 // ignored, the pool decides
+	opts.BufferPool = pool
+	a, err := NewStorageWithOptions(nameA, opts)
+	if err != nil {
+		t.Fatalf("Failed to open a: %v", err)
+	}
+	b, err := NewStorageWithOptions(nameB, opts)
+	if err != nil {
+		t.Fatalf("Failed to open b: %v", err)
+	}
+	defer b.Close()
+
+	for i := 0; i < 30; i++ {
+		a.Get(fmt.Sprintf("key%03d", i))
+	}
+	if len(a.pages) < 5 {
+		t.Fatalf("Expected a to hold most of the pool, got %d pages", len(a.pages))
+	}
+	// reading b takes the pages a isn't using
+	for i := 0; i < 30; i++ {
+		if value, err := b.Get(fmt.Sprintf("key%03d", i)); err != nil || len(value) != 3000 {
+			t.Fatalf("key%03d: %d bytes, %v", i, len(value), err)
+		}
+	}
+	if n := len(a.pages) + len(b.pages); n > 10 || pool.Len() != n {
+		t.Errorf("Expected at most 10 pages between them, got %d + %d (pool %d)", len(a.pages), len(b.pages), pool.Len())
+	}
+	if len(a.pages) > 1 {
+		t.Errorf("Expected b's reads to evict a's pages, a still has %d", len(a.pages))
+	}
+
+	// a closed storage gives its pages back
+	a.Close()
+	if pool.Len() != len(b.pages) {
+		t.Errorf("Expected only b's %d pages in the pool, got %d", len(b.pages), pool.Len())
+	}
+}
+
+func TestBufferPool_SkipsAStorageWhosePagesAreInUse(t *testing.T) {
+	nameA, nameB := "test_"+t.Name()+"_a.db", "test_"+t.Name()+"_b.db"
+	defer cleanupTestDB(t, nameA)
+	defer cleanupTestDB(t, nameB)
+	opts := DefaultOptions()
+	opts.BufferPool = NewBufferPool(10)
+	a, err := NewStorageWithOptions(nameA, opts)
+	if err != nil {
+		t.Fatalf("Failed to open a: %v", err)
+	}
+	defer a.Close()
+	b, err := NewStorageWithOptions(nameB, opts)
+	if err != nil {
+		t.Fatalf("Failed to open b: %v", err)
+	}
+	defer b.Close()
+	for i := 0; i < 8; i++ {
+		b.Put(fmt.Sprintf("key%03d", i), strings.Repeat("v", 3000))
+	}
+	b.Sync()
+
+	// b's cache lock is taken (a reader of b in the middle of loading a
+	// page), a's writes go past its pages instead of waiting on it
+	b.cacheMu.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 30; i++ {
+			a.Put(fmt.Sprintf("key%03d", i), strings.Repeat("v", 3000))
+		}
+		a.Sync()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("a's eviction waited for b's cache lock")
+	}
+	b.cacheMu.Unlock()
+	<-done
+}
+
+func TestPageCache_EvictionReasons(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	for i := 0; i < 20; i++ { // a page each
+		storage.Put(fmt.Sprintf("key%03d", i), strings.Repeat("v", 3000))
+	}
+	for i := 0; i < 20; i += 2 {
+		storage.Delete(fmt.Sprintf("key%03d", i))
+	}
+	cached := len(storage.pages)
+
+	if _, err := storage.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	stats := storage.Stats().Snapshot()
+	if stats.PagesInvalidated != uint64(cached) {
+		t.Errorf("Expected the %d cached pages invalidated by Compact, got %d", cached, stats.PagesInvalidated)
+	}
+	if stats.PageEvictions != 0 || stats.PagesEvictedCold != 0 {
+		t.Errorf("Expected no other evictions without a budget, got %d and %d", stats.PageEvictions, stats.PagesEvictedCold)
+	}
+	if stats.Sub(stats).PagesInvalidated != 0 || storage.Stats().Reset().PagesInvalidated != stats.PagesInvalidated {
+		t.Errorf("PagesInvalidated not carried through Sub and Reset")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"godata/pagefmt"
+)
+
+// pagefmt must read exactly what the storage writes
+func TestPagefmt_ReadsARealFile(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	want := map[string]string{}
+	value := string(make([]byte, 900))
+	for i := 0; i < 12; i++ {
+		key := "key:" + string(rune('A'+i))
+		storage.Put(key, value)
+		want[key] = value
+	}
+	storage.Delete("key:C")
+	delete(want, "key:C")
+	storage.Close()
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	raw := make([]byte, pagefmt.HeaderSize)
+	f.ReadAt(raw, 0)
+	header, err := pagefmt.ParseHeader(raw)
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	if header.LastLSN != 13 || header.TotalPages < 3 {
+		t.Errorf("Unexpected header %+v", header)
+	}
+
+	got := map[string]string{}
+	for id := uint32(0); id < header.TotalPages; id++ {
+		page, err := pagefmt.ReadPage(f, id)
+		if err != nil {
+			t.Fatalf("ReadPage(%d) failed: %v", id, err)
+		}
+		records, err := pagefmt.ParsePage(page)
+		if err != nil {
+			t.Fatalf("ParsePage(%d) failed: %v", id, err)
+		}
+		// every page was written by the Close, after the last write
+		if lsn := pagefmt.PageLSN(page, header.Flags); lsn != 13 {
+			t.Errorf("page %d has LSN %d, want 13", id, lsn)
+		}
+		for _, r := range records {
+			got[string(r.Key)] = string(r.Value)
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d records, found %d", len(want), len(got))
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%q differs", k)
+		}
+	}
+	if PageSize != pagefmt.PageSize || HeaderSize != pagefmt.HeaderSize || MagicNumber != pagefmt.Magic || Version != pagefmt.Version {
+		t.Errorf("pagefmt constants drifted from the storage's")
+	}
+}
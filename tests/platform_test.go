@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewStorage_SecondOpenIsLocked(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	if _, err := NewStorage(filename); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Expected ErrLocked while the file is open, got %v", err)
+	}
+
+	// closing releases the lock
+	storage.Close()
+	reopened, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("Expected reopen after Close to work, got %v", err)
+	}
+	reopened.Close()
+}
+
+func TestPreallocatePages(t *testing.T) {
+	opts := DefaultOptions()
+	opts.PreallocatePages = 16
+	storage, filename := openWithOptions(t, opts)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	if err := storage.Put("user:1", "isabella"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	// best effort: when the platform supports it, 16 pages are reserved past page 0
+	if storage.preallocatedPages != 0 && storage.preallocatedPages != 17 {
+		t.Errorf("Expected 17 preallocated pages, got %d", storage.preallocatedPages)
+	}
+	if value, _ := storage.Get("user:1"); value != "isabella" {
+		t.Errorf("Expected 'isabella', got %q", value)
+	}
+}
+
+func TestNewStorageContext_GivesUpAtTheDeadline(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := NewStorageContext(ctx, filename, DefaultOptions())
+	if !errors.Is(err, ErrLocked) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected ErrLocked and the deadline, got %v", err)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("Expected to wait for the deadline, gave up after %s", waited)
+	}
+}
+
+func TestLockWait_OpensOnceTheLockIsReleased(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		storage.Close()
+	}()
+
+	opts := DefaultOptions()
+	opts.LockWait = 5 * time.Second
+	reopened, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Expected the open to wait for the lock, got %v", err)
+	}
+	reopened.Close()
+}
+
+func TestNewStorageContext_StopsIndexBuild(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	storage.Put("user:1", "isabella")
+	storage.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewStorageContext(ctx, filename, DefaultOptions()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the index build to stop, got %v", err)
+	}
+
+	// and the file is unlocked again afterwards
+	reopened, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	reopened.Close()
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestPrefetch_WarmsThePageCache(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	value := string(make([]byte, 1500))
+	for i := 0; i < 6; i++ {
+		storage.Put("key:"+string(rune('A'+i)), value)
+	}
+	storage.Sync()
+	for id := range storage.pages {
+		delete(storage.pages, id) // start cold
+	}
+
+	before := storage.Stats().Snapshot()
+	<-storage.Prefetch([]string{"key:A", "key:B", "key:F", "missing"})
+	prefetched := storage.Stats().Snapshot().Sub(before)
+
+	// A and B share a page, F is on another one
+	if prefetched.PageReads != 2 {
+		t.Errorf("Expected 2 page reads, got %d", prefetched.PageReads)
+	}
+	for _, key := range []string{"key:A", "key:B", "key:F"} {
+		if _, err := storage.Get(key); err != nil {
+			t.Fatalf("Get(%q) failed: %v", key, err)
+		}
+	}
+	if reads := storage.Stats().Snapshot().Sub(before).PageReads; reads != 2 {
+		t.Errorf("Expected the Gets to hit the cache, got %d more reads", reads-2)
+	}
+
+	// nothing left to do
+	<-storage.Prefetch([]string{"key:A"})
+	if reads := storage.Stats().Snapshot().Sub(before).PageReads; reads != 2 {
+		t.Errorf("Expected cached pages to be skipped, got %d reads", reads)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a database file's bytes, the way go:embed would hand them over
+func databaseBytes(t *testing.T, records map[string]string) []byte {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	for key, value := range records {
+		if err := db.Put(key, value); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestNewStorageFromReader_ReadsEmbeddedData(t *testing.T) {
+	records := map[string]string{"country:PT": "Portugal", "country:BR": "Brazil"}
+	for i := 0; i < 30; i++ {
+		records[fmt.Sprintf("filler:%02d", i)] = strings.Repeat("x", 400) // a few pages
+	}
+	data := databaseBytes(t, records)
+
+	db, err := NewStorageFromReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewStorageFromReader failed: %v", err)
+	}
+	if got, err := db.Get("country:PT"); err != nil || got != "Portugal" {
+		t.Errorf("country:PT = %q, %v", got, err)
+	}
+	it := db.Scan("country:", "country;")
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if len(keys) != 2 || keys[0] != "country:BR" {
+		t.Errorf("Scan = %v, %v", keys, it.Err())
+	}
+	if report, err := db.Verify(2); err != nil || !report.OK() {
+		t.Errorf("Verify = %+v, %v", report, err)
+	}
+
+	if err := db.Put("country:ES", "Spain"); !errors.Is(err, ErrOpenedReadOnly) {
+		t.Errorf("Put = %v, want ErrOpenedReadOnly", err)
+	}
+	if _, err := db.Refresh(); err == nil {
+		t.Errorf("Refresh worked without a file")
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
+
+func TestNewStorageFromReader_TooShort(t *testing.T) {
+	_, err := NewStorageFromReader(bytes.NewReader(make([]byte, 10)), 10)
+	if !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Expected ErrCorrupted, got %v", err)
+	}
+}
+
+func TestNewStorageFromURL_RangeRequests(t *testing.T) {
+	data := databaseBytes(t, map[string]string{"sku:1042": "9.99"})
+	var ranges atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			ranges.Add(1)
+		}
+		http.ServeContent(w, r, "catalog.db", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	db, err := NewStorageFromURL(server.URL+"/catalog.db", DefaultOptions())
+	if err != nil {
+		t.Fatalf("NewStorageFromURL failed: %v", err)
+	}
+	defer db.Close()
+	if got, err := db.Get("sku:1042"); err != nil || got != "9.99" {
+		t.Errorf("sku:1042 = %q, %v", got, err)
+	}
+	if ranges.Load() == 0 {
+		t.Errorf("Expected the pages to be read with range requests")
+	}
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	if _, err := NewStorageFromURL(notFound.URL+"/catalog.db", DefaultOptions()); err == nil {
+		t.Errorf("Expected an error for a 404")
+	}
+}
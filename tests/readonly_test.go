@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestReadOnly_RefreshFollowsCheckpoints(t *testing.T) {
+	filename := "test_readonly.db"
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".checkpoint")
+	defer os.Remove(filename + ".wal")
+
+	writer, err := NewStorageWithOptions(filename, Options{AnnounceCheckpoints: true})
+	if err != nil {
+		t.Fatalf("Failed to open writer: %v", err)
+	}
+	defer writer.Close()
+	writer.Put("order:1", "paid")
+	if err := writer.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	// the writer holds the lock, the reader doesn't need it
+	reader, err := NewStorageWithOptions(filename, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to open reader: %v", err)
+	}
+	defer reader.Close()
+	if v, err := reader.Get("order:1"); err != nil || v != "paid" {
+		t.Fatalf("reader Get = %q, %v", v, err)
+	}
+	if err := reader.Put("order:2", "x"); !errors.Is(err, ErrOpenedReadOnly) {
+		t.Errorf("Put on a reader = %v, want ErrOpenedReadOnly", err)
+	}
+	if changed, err := reader.Refresh(); err != nil || changed {
+		t.Errorf("Refresh with nothing new = %t, %v", changed, err)
+	}
+
+	// unsynced changes aren't announced, the reader keeps its view
+	writer.Put("order:2", "shipped")
+	writer.Delete("order:1")
+	if changed, _ := reader.Refresh(); changed {
+		t.Errorf("Refresh moved before a checkpoint")
+	}
+	if _, err := reader.Get("order:2"); err == nil {
+		t.Errorf("reader sees a write from after its checkpoint")
+	}
+
+	writer.Sync()
+	info, err := ReadCheckpoint(filename)
+	if err != nil || info.LSN != 3 {
+		t.Fatalf("ReadCheckpoint = %+v, %v", info, err)
+	}
+	changed, err := reader.Refresh()
+	if err != nil || !changed {
+		t.Fatalf("Refresh after a checkpoint = %t, %v", changed, err)
+	}
+	if v, err := reader.Get("order:2"); err != nil || v != "shipped" {
+		t.Errorf("after Refresh order:2 = %q, %v", v, err)
+	}
+	if _, err := reader.Get("order:1"); err == nil {
+		t.Errorf("after Refresh order:1 should be gone")
+	}
+}
+
+func TestReadOnly_NeedsAnExistingFile(t *testing.T) {
+	if _, err := NewStorageWithOptions("test_readonly_missing.db", Options{ReadOnly: true}); err == nil {
+		t.Fatal("opening a missing file read-only should fail")
+	}
+	if _, err := os.Stat("test_readonly_missing.db"); err == nil {
+		os.Remove("test_readonly_missing.db")
+		t.Error("a read-only open created the file")
+	}
+
+	// a writer without announcements gives the reader nothing to refresh to
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	db.Put("k", "v")
+	db.Sync()
+	defer db.Close()
+	reader, err := NewStorageWithOptions(filename, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to open reader: %v", err)
+	}
+	defer reader.Close()
+	if _, err := reader.Refresh(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Refresh without announcements = %v", err)
+	}
+}
+
+// a reader opens the file once, read-only: nothing left open after Close,
+// and a file it can't write to is fine
+func TestReadOnly_OpensTheFileOnce(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	db.Put("k", "v")
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := os.Chmod(filename, 0444); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filename, 0644)
+
+	openFiles := func() int {
+		fds, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Skip("no /proc/self/fd to count open files")
+		}
+		return len(fds)
+	}
+	before := openFiles()
+	for i := 0; i < 5; i++ {
+		reader, err := NewStorageWithOptions(filename, Options{ReadOnly: true})
+		if err != nil {
+			t.Fatalf("Failed to open reader: %v", err)
+		}
+		if v, err := reader.Get("k"); err != nil || v != "v" {
+			t.Errorf("reader Get = %q, %v", v, err)
+		}
+		if err := reader.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+	if after := openFiles(); after != before {
+		t.Errorf("Expected %d open files after closing the readers, got %d", before, after)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// drops the storage without syncing, like a process that was killed
+func crashStorage(db *Storage) {
+	db.wal.Close()
+	unlockFile(db.file)
+	db.file.Close()
+}
+
+func TestRecovery_ReplaysUnsyncedWrites(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	db.Put("kept", "1")
+	db.Sync()
+	db.Put("a", "1")
+	db.Put("b", "1")
+	db.Put("b", "2")
+	db.Delete("kept")
+	crashStorage(db)
+
+	db, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+	for key, want := range map[string]string{"a": "1", "b": "2"} {
+		if got, err := db.Get(key); err != nil || got != want {
+			t.Errorf("%s = %q, %v; want %q", key, got, err, want)
+		}
+	}
+	if _, err := db.Get("kept"); err == nil {
+		t.Errorf("the delete of kept wasn't replayed")
+	}
+	if db.lsn != 5 {
+		t.Errorf("lsn after recovery = %d, want 5", db.lsn)
+	}
+
+	// recovery synced and emptied the WAL, there is nothing left to replay
+	if n, err := db.Recover(); n != 0 || err != nil {
+		t.Errorf("second Recover = %d, %v", n, err)
+	}
+	if stat, _ := os.Stat(filename + ".wal"); stat.Size() != 0 {
+		t.Errorf("WAL still holds %d bytes after recovery", stat.Size())
+	}
+	if err := db.Put("c", "1"); err != nil || db.lsn != 6 {
+		t.Errorf("next write got LSN %d (%v), want 6", db.lsn, err)
+	}
+}
+
+func TestRecovery_AppliesOnlyCommittedTransactions(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	db.Put("a", "1")
+	db.Sync()
+	if err := db.Rename("a", "b"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	// one rolled back, one cut off by the crash before its commit
+	aborted, _ := db.wal.BeginTx()
+	db.wal.AppendTx(aborted, LogTypePut, "c", "1")
+	db.wal.AbortTx(aborted)
+	torn, _ := db.wal.BeginTx()
+	db.wal.AppendTx(torn, LogTypeDelete, "b", "")
+	db.wal.AppendTx(torn, LogTypePut, "d", "1")
+	crashStorage(db)
+
+	db, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+	if got, err := db.Get("b"); err != nil || got != "1" {
+		t.Errorf("b = %q, %v; want the committed rename", got, err)
+	}
+	if _, err := db.Get("a"); err == nil {
+		t.Errorf("a is still there after the committed rename")
+	}
+	for _, key := range []string{"c", "d"} {
+		if _, err := db.Get(key); err == nil {
+			t.Errorf("%s is there, its transaction never committed", key)
+		}
+	}
+}
+
+func TestRecovery_SkipsEntriesOlderThanTheRecord(t *testing.T) {
+	opts := DefaultOptions()
+	opts.RecordVersions = true
+	db, filename := openWithOptions(t, opts)
+	defer cleanupTestDB(t, filename)
+
+	db.Put("a", "1")
+	db.Sync()
+	db.Delete("a")
+	walSize := db.wal.Size()
+	db.Put("a", "3")
+	// the page made it to disk without the header, the last WAL entry didn't
+	db.writePage(db.pages[db.pageIndex["a"]])
+	crashStorage(db)
+	os.Truncate(filename+".wal", walSize)
+
+	db, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+	// replaying the delete would lose the newer value
+	if got, version, err := db.GetWithVersion("a"); err != nil || got != "3" || version != 3 {
+		t.Errorf("a = %q at %d, %v; want 3 at 3", got, version, err)
+	}
+}
+
+func TestRecovery_SkipsWhatThePageHas(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	db.Put("kept", "1")
+	db.Sync()
+	db.Put("a", "1")
+	db.Delete("a")
+	db.Put("kept", "2")
+	db.Put("b", strings.Repeat("b", PageSize-24)) // too big to share page 0
+	// page 0 made it to disk after the delete, the header and page 1 didn't
+	db.writePage(db.pages[db.pageIndex["kept"]])
+	crashStorage(db)
+
+	db, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+	// the put of a alone would bring it back
+	if replayed := db.OpenInfo().Replayed; replayed != 1 {
+		t.Errorf("replayed %d entries, want only the put of b", replayed)
+	}
+	if _, err := db.Get("a"); err == nil {
+		t.Errorf("a came back after its delete")
+	}
+	for key, want := range map[string]string{"kept": "2", "b": strings.Repeat("b", PageSize-24)} {
+		if got, err := db.Get(key); err != nil || got != want {
+			t.Errorf("%s = %d bytes, %v; want %d", key, len(got), err, len(want))
+		}
+	}
+}
+
+func TestRecovery_SyncEmptiesTheWAL(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+
+	db.Put("a", "1")
+	if stat, _ := os.Stat(filename + ".wal"); stat.Size() == 0 {
+		t.Fatalf("Put didn't reach the WAL")
+	}
+	db.Sync()
+	if stat, _ := os.Stat(filename + ".wal"); stat.Size() != 0 {
+		t.Errorf("WAL holds %d bytes after Sync", stat.Size())
+	}
+}
+
+func TestRecovery_IgnoresWALOfAnOlderFile(t *testing.T) {
+	filename := "test_recovery_stale.db"
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".wal")
+
+	db, _ := NewStorage(filename)
+	db.Put("old", "1")
+	crashStorage(db)
+	os.Remove(filename) // the WAL stays behind
+
+	db, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Get("old"); err == nil {
+		t.Errorf("a new file picked up the WAL of the one deleted before it")
+	}
+}
+
+func TestRecovery_OversizedRecordIsNotLogged(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+
+	db.Put("k", "small")
+	if err := db.Put("k", string(make([]byte, PageSize))); err == nil {
+		t.Fatal("a value bigger than a page should be rejected")
+	}
+	if v, _ := db.Get("k"); v != "small" {
+		t.Errorf("failed update lost the old value, got %q", v)
+	}
+	entries, _ := db.wal.ReadAll()
+	if len(entries) != 1 {
+		t.Errorf("WAL has %d entries, want only the successful put", len(entries))
+	}
+}
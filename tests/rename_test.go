@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestRename_MovesTheValue(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+
+	db.Put("a", "1")
+	db.Put("b", "2")
+	if err := db.Rename("a", "c"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if _, err := db.Get("a"); err == nil {
+		t.Error("Expected a to be gone")
+	}
+	if got, err := db.Get("c"); err != nil || got != "1" {
+		t.Errorf("c = %q, %v; want 1", got, err)
+	}
+
+	if err := db.Rename("c", "b"); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("Expected ErrKeyExists renaming onto b, got %v", err)
+	}
+	if got, _ := db.Get("b"); got != "2" {
+		t.Errorf("b was overwritten with %q", got)
+	}
+	if err := db.Rename("missing", "d"); err == nil {
+		t.Error("Expected renaming a missing key to fail")
+	}
+}
+
+func TestRenamePrefix_MovesEveryKey(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+
+	for i := 0; i < 5; i++ {
+		db.Put(fmt.Sprintf("user:%d", i), fmt.Sprint(i))
+	}
+	db.Put("users", "not under the prefix")
+	// the new names overlap the old ones, "user:1" becomes "user:user:1"
+	n, err := db.RenamePrefix("user:", "user:user:")
+	if err != nil || n != 5 {
+		t.Fatalf("RenamePrefix = %d, %v; want 5", n, err)
+	}
+	for i := 0; i < 5; i++ {
+		if got, err := db.Get(fmt.Sprintf("user:user:%d", i)); err != nil || got != fmt.Sprint(i) {
+			t.Errorf("user:user:%d = %q, %v", i, got, err)
+		}
+		if _, err := db.Get(fmt.Sprintf("user:%d", i)); err == nil {
+			t.Errorf("user:%d is still there", i)
+		}
+	}
+	if got, _ := db.Get("users"); got != "not under the prefix" {
+		t.Errorf("users = %q", got)
+	}
+
+	// one taken name stops all of them
+	db.Put("member:3", "taken")
+	if _, err := db.RenamePrefix("user:user:", "member:"); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("Expected ErrKeyExists, got %v", err)
+	}
+	if _, err := db.Get("user:user:0"); err != nil {
+		t.Errorf("Expected nothing moved after the conflict: %v", err)
+	}
+}
+
+func TestRename_SurvivesACrash(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	db.Put("old:1", "a")
+	db.Put("old:2", "b")
+	db.Sync()
+	if _, err := db.RenamePrefix("old:", "new:"); err != nil {
+		t.Fatalf("RenamePrefix failed: %v", err)
+	}
+	crashStorage(db)
+
+	db, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+	for key, want := range map[string]string{"new:1": "a", "new:2": "b"} {
+		if got, err := db.Get(key); err != nil || got != want {
+			t.Errorf("%s = %q, %v; want %q", key, got, err, want)
+		}
+	}
+	if _, err := db.Get("old:1"); err == nil {
+		t.Error("old:1 is still there after recovery")
+	}
+}
+
+func TestRename_TornRenameIsRolledBack(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	db.Put("a", "1")
+	db.Sync()
+	db.Rename("a", "b")
+	entries, _ := db.wal.ReadAll()
+	commit := entries[len(entries)-1]
+	if commit.Type != LogTypeTxCommit {
+		t.Fatalf("Expected the rename to end in a commit, got %s", LogTypeName(commit.Type))
+	}
+	crashStorage(db)
+
+	// the crash came before the commit reached the WAL: delete a and put b
+	// are in it, neither may be applied
+	stat, _ := os.Stat(filename + ".wal")
+	os.Truncate(filename+".wal", stat.Size()-int64(len(commit.Serialize())))
+
+	db, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+	if got, err := db.Get("a"); err != nil || got != "1" {
+		t.Errorf("a = %q, %v; want 1", got, err)
+	}
+	if _, err := db.Get("b"); err == nil {
+		t.Error("b exists without the commit")
+	}
+}
+
+func TestCopy_DuplicatesTheValue(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	opts := DefaultOptions()
+	// a transformer that mixes in the key, a copied value has to be encoded again
+	xorKey := func(key string, data []byte) ([]byte, error) {
+		out := make([]byte, len(data))
+		for i := range data {
+			out[i] = data[i] ^ key[i%len(key)]
+		}
+		return out, nil
+	}
+	opts.Transformers = []ValueTransformer{TransformFuncs{EncodeFunc: xorKey, DecodeFunc: xorKey}}
+	db, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer db.Close()
+
+	db.Put("template:invoice", `{"lines":[]}`)
+	if err := db.Copy("template:invoice", "invoice:1"); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	for _, key := range []string{"template:invoice", "invoice:1"} {
+		if got, err := db.Get(key); err != nil || got != `{"lines":[]}` {
+			t.Errorf("%s = %q, %v", key, got, err)
+		}
+	}
+	if err := db.Copy("template:invoice", "invoice:1"); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("Expected ErrKeyExists copying onto invoice:1, got %v", err)
+	}
+	if err := db.Copy("missing", "invoice:2"); err == nil {
+		t.Error("Expected copying a missing key to fail")
+	}
+}
+
+func TestSwap_ExchangesValues(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	db.Put("a", "1")
+	db.Put("b", "2")
+	db.Put("c", "3")
+	db.Sync()
+	if err := db.Swap("a", "b"); err != nil {
+		t.Fatalf("Swap failed: %v", err)
+	}
+	if err := db.MultiSwap(map[string]string{"a": "b", "b": "c", "c": "a"}); err != nil {
+		t.Fatalf("MultiSwap failed: %v", err)
+	}
+	crashStorage(db)
+
+	// both went in as transactions, recovery replays them whole
+	db, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+	for key, want := range map[string]string{"a": "3", "b": "2", "c": "1"} {
+		if got, err := db.Get(key); err != nil || got != want {
+			t.Errorf("%s = %q, %v; want %q", key, got, err, want)
+		}
+	}
+}
+
+func TestMultiSwap_RejectsLosingAValue(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+
+	db.Put("a", "1")
+	db.Put("b", "2")
+	if err := db.MultiSwap(map[string]string{"a": "b"}); !errors.Is(err, ErrInvalidSwap) {
+		t.Errorf("Expected ErrInvalidSwap moving onto a key that stays, got %v", err)
+	}
+	if err := db.MultiSwap(map[string]string{"a": "b", "b": "b"}); !errors.Is(err, ErrInvalidSwap) {
+		t.Errorf("Expected ErrInvalidSwap for two values onto b, got %v", err)
+	}
+	if err := db.Swap("a", "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if got, _ := db.Get("a"); got != "1" {
+		t.Errorf("a changed to %q by a failed swap", got)
+	}
+}
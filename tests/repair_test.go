@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fills a file with records spread over several pages and closes it
+func writeRepairFixture(t *testing.T, filename string, n int) {
+	db, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		if err := db.Put(fmt.Sprintf("key:%03d", i), strings.Repeat("v", 500)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestCheckIntegrity_CleanFile(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	writeRepairFixture(t, filename, 40)
+
+	report, err := CheckIntegrity(filename)
+	if err != nil {
+		t.Fatalf("CheckIntegrity failed: %v", err)
+	}
+	if !report.OK() || report.Truncated || report.Records != 40 {
+		t.Errorf("Unexpected report for a clean file: %+v", report)
+	}
+	if report.Pages < 2 || report.PagesRead != report.Pages {
+		t.Errorf("Expected every page read, got %d of %d", report.PagesRead, report.Pages)
+	}
+}
+
+func TestRepair_TruncatedFile(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	repaired := "test_" + t.Name() + "_repaired.db"
+	defer cleanupTestDB(t, filename)
+	defer cleanupTestDB(t, repaired)
+	writeRepairFixture(t, filename, 40)
+
+	// a full disk cut the file off in the middle of page 2
+	if err := os.Truncate(filename, HeaderSize+2*PageSize+1000); err != nil {
+		t.Fatal(err)
+	}
+	if db, err := NewStorage(filename); err == nil {
+		db.Close()
+		t.Fatal("Expected the truncated file to fail to open")
+	}
+
+	report, err := CheckIntegrity(filename)
+	if err != nil {
+		t.Fatalf("CheckIntegrity failed: %v", err)
+	}
+	if report.OK() || !report.Truncated || report.PagesRead != 3 {
+		t.Fatalf("Expected a truncated file with 3 pages read, got %+v", report)
+	}
+	if !errors.Is(report.Problems[0].Err, ErrCorrupted) || report.Problems[0].PageID != 2 {
+		t.Errorf("Expected page 2 reported first, got %v", report.Problems)
+	}
+
+	rep, err := Repair(filename, repaired, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+	if rep.Records != report.Records || rep.Records < 14 || rep.Suspect == 0 {
+		t.Errorf("Unexpected repair report: %+v", rep)
+	}
+
+	db, err := NewStorage(repaired)
+	if err != nil {
+		t.Fatalf("Failed to open the repaired file: %v", err)
+	}
+	defer db.Close()
+	if got := len(db.pageIndex); got != rep.Records {
+		t.Errorf("Repaired file holds %d records, report says %d", got, rep.Records)
+	}
+	if got, err := db.Get("key:000"); err != nil || got != strings.Repeat("v", 500) {
+		t.Errorf("key:000 = %d bytes, %v", len(got), err)
+	}
+	if verify, _ := db.Verify(1); !verify.OK() {
+		t.Errorf("Repaired file fails verification: %v", verify.Problems)
+	}
+
+	// repair never writes over an existing file
+	if _, err := Repair(filename, repaired, DefaultOptions()); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Expected os.ErrExist, got %v", err)
+	}
+}
+
+func TestRepair_BadPageAndUnsyncedWrites(t *testing.T) {
+	db, filename := setupTestDB(t)
+	repaired := "test_" + t.Name() + "_repaired.db"
+	defer cleanupTestDB(t, filename)
+	defer cleanupTestDB(t, repaired)
+
+	db.Put("a", "1")
+	db.Put("b", "1")
+	db.Sync()
+	db.Put("b", "2")
+	db.Put("c", "3")
+	db.Delete("a")
+	crashStorage(db)
+
+	// one flipped byte in b's value fails the page checksum
+	file, _ := os.OpenFile(filename, os.O_RDWR, 0644)
+	at := int64(HeaderSize + 2 + 4 + 1 + 1 + 4 + 1)
+	file.WriteAt([]byte{'X'}, at)
+	file.Close()
+
+	report, err := CheckIntegrity(filename)
+	if err != nil {
+		t.Fatalf("CheckIntegrity failed: %v", err)
+	}
+	if len(report.Problems) != 1 || !errors.Is(report.Problems[0].Err, ErrCorrupted) {
+		t.Fatalf("Expected the checksum failure of page 0, got %v", report.Problems)
+	}
+
+	rep, err := Repair(filename, repaired, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+	// a and b come from the damaged page, the WAL deletes a and rewrites b
+	if rep.Replayed != 3 || rep.Records != 2 || rep.Suspect != 0 {
+		t.Errorf("Unexpected repair report: %+v", rep)
+	}
+	restored, err := NewStorage(repaired)
+	if err != nil {
+		t.Fatalf("Failed to open the repaired file: %v", err)
+	}
+	defer restored.Close()
+	for key, want := range map[string]string{"b": "2", "c": "3"} {
+		if got, err := restored.Get(key); err != nil || got != want {
+			t.Errorf("%s = %q, %v; want %q", key, got, err, want)
+		}
+	}
+	if _, err := restored.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("the delete of a wasn't replayed: %v", err)
+	}
+}
+
+func TestCheckIntegrity_NotADatabase(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer os.Remove(filename)
+	os.WriteFile(filename, []byte(strings.Repeat("garbage ", 100)), 0644)
+
+	if _, err := CheckIntegrity(filename); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Expected ErrCorrupted, got %v", err)
+	}
+}
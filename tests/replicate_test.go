@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// writes a primary's WAL and reads it back, like a shipper would
+func primaryEntries(t *testing.T, write func(w *WAL)) []*LogEntry {
+	wal, _ := openTestWAL(t)
+	write(wal)
+	entries, err := wal.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	return entries
+}
+
+func TestApplyReplicated_SkipsEntriesAlreadyApplied(t *testing.T) {
+	entries := primaryEntries(t, func(w *WAL) {
+		w.Append(LogTypePut, "a", "1")
+		w.Append(LogTypePut, "b", "1")
+		w.Append(LogTypeDelete, "a", "")
+		w.Append(LogTypePut, "b", "2")
+	})
+
+	replica, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	report, err := replica.ApplyReplicated(entries[:3])
+	if err != nil {
+		t.Fatalf("ApplyReplicated failed: %v", err)
+	}
+	if report.Applied != 3 || report.AppliedLSN != 3 {
+		t.Errorf("first batch: %+v", report)
+	}
+
+	// reconnect: the primary resends from the start
+	report, err = replica.ApplyReplicated(entries)
+	if err != nil {
+		t.Fatalf("ApplyReplicated failed: %v", err)
+	}
+	if report.Duplicates != 3 || report.Applied != 1 || report.AppliedLSN != 4 {
+		t.Errorf("resent batch: %+v", report)
+	}
+	if _, err := replica.Get("a"); err == nil {
+		t.Errorf("a was deleted on the primary, re-applying brought it back")
+	}
+
+	// the applied LSN is in the header, a restart doesn't forget it
+	replica.Close()
+	replica, err = NewStorage(filename)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer replica.Close()
+	if replica.AppliedLSN() != 4 {
+		t.Errorf("AppliedLSN after reopen = %d, want 4", replica.AppliedLSN())
+	}
+	report, _ = replica.ApplyReplicated(entries)
+	if report.Duplicates != 4 || report.Applied != 0 {
+		t.Errorf("batch after reopen: %+v", report)
+	}
+	if v, _ := replica.Get("b"); v != "2" {
+		t.Errorf("b = %q, want 2", v)
+	}
+}
+
+func TestApplyReplicated_HoldsBackUnfinishedTransactions(t *testing.T) {
+	entries := primaryEntries(t, func(w *WAL) {
+		w.Append(LogTypePut, "a", "1") // 1
+		tx, _ := w.BeginTx()           // 2
+		w.AppendTx(tx, LogTypePut, "t", "1")
+		w.Append(LogTypePut, "b", "1") // 4, after the begin, so it waits too
+		w.AppendCheckpointBegin(4)
+		w.CommitTx(tx) // 6
+		aborted, _ := w.BeginTx()
+		w.AppendTx(aborted, LogTypePut, "x", "1")
+		w.AbortTx(aborted)
+	})
+
+	replica, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer replica.Close()
+
+	// the first batch ends before the commit
+	report, err := replica.ApplyReplicated(entries[:5])
+	if err != nil {
+		t.Fatalf("ApplyReplicated failed: %v", err)
+	}
+	if report.Applied != 1 || report.Held != 4 || report.AppliedLSN != 1 {
+		t.Errorf("first batch: %+v", report)
+	}
+	if _, err := replica.Get("t"); err == nil {
+		t.Errorf("uncommitted transaction was applied")
+	}
+
+	report, err = replica.ApplyReplicated(entries)
+	if err != nil {
+		t.Fatalf("ApplyReplicated failed: %v", err)
+	}
+	if report.Duplicates != 1 || report.Applied != 2 || report.Held != 0 || report.AppliedLSN != 9 {
+		t.Errorf("second batch: %+v", report)
+	}
+	for key, want := range map[string]bool{"a": true, "t": true, "b": true, "x": false} {
+		if _, err := replica.Get(key); (err == nil) != want {
+			t.Errorf("%s present = %t, want %t", key, err == nil, want)
+		}
+	}
+}
+
+func TestApplyReplicated_StoresValuesAsThePrimaryEncodedThem(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Compress = true
+	opts.Transformers = []ValueTransformer{xorTransformer}
+	primary, filename := openWithOptions(t, opts)
+	defer cleanupTestDB(t, filename)
+	defer primary.Close()
+	long := strings.Repeat("compressible ", 100)
+	primary.Put("a", "hello")
+	primary.Put("b", long)
+	primary.Delete("a")
+	primary.Put("c", "world")
+	entries, err := primary.wal.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+
+	replicaFile := "test_" + t.Name() + "_replica.db"
+	replica, err := NewStorageWithOptions(replicaFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to open the replica: %v", err)
+	}
+	defer cleanupTestDB(t, replicaFile)
+	defer replica.Close()
+	if _, err := replica.ApplyReplicated(entries); err != nil {
+		t.Fatalf("ApplyReplicated failed: %v", err)
+	}
+	// the values went through the pipeline once, on the primary
+	for key, want := range map[string]string{"b": long, "c": "world"} {
+		if got, err := replica.Get(key); err != nil || got != want {
+			t.Errorf("replica %s = %.20q, %v; want %.20q", key, got, err, want)
+		}
+	}
+	if _, err := replica.Get("a"); err == nil {
+		t.Error("Expected a to be deleted on the replica")
+	}
+}
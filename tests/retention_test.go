@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"godata/storagetest"
+)
+
+func openRetention(t *testing.T, policies []RetentionPolicy, clock Clock) (*Storage, string) {
+	filename := "test_" + t.Name() + ".db"
+	opts := DefaultOptions()
+	opts.RetentionPolicies = policies
+	opts.Clock = clock
+	storage, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	return storage, filename
+}
+
+func TestRetentionPolicy_MaxCountKeepsTheLastKeys(t *testing.T) {
+	storage, filename := openRetention(t, []RetentionPolicy{{Prefix: "audit:", MaxCount: 3}}, nil)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	for i := 0; i < 10; i++ {
+		storage.Put(fmt.Sprintf("audit:%03d", i), "x")
+	}
+	storage.Put("user:1", "isa") // other prefixes aren't touched
+	if err := storage.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	var keys []string
+	for it := storage.Scan("", ""); it.Next(); {
+		keys = append(keys, it.Key())
+	}
+	if fmt.Sprint(keys) != "[audit:007 audit:008 audit:009 user:1]" {
+		t.Errorf("Unexpected keys after retention: %v", keys)
+	}
+	if got := storage.Stats().Snapshot().KeysExpired; got != 7 {
+		t.Errorf("Expected 7 keys expired, got %d", got)
+	}
+}
+
+func TestRetentionPolicy_MaxAgeReadsTheTimeAfterThePrefix(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := storagetest.NewFakeClock(start)
+	storage, filename := openRetention(t, []RetentionPolicy{{Prefix: "events:", MaxAge: time.Hour}}, clock)
+	defer cleanupTestDB(t, filename)
+
+	for i := 0; i < 6; i++ { // one every 20 minutes
+		storage.Put("events:"+TimeKey(start.Add(time.Duration(i)*20*time.Minute), ""), "e")
+	}
+	storage.Put("events:latest", "no time in this one")
+	clock.Advance(2 * time.Hour) // the window starts at start + 1h
+	storage.Close()
+
+	// the deletes went through the WAL and the pages like any other
+	storage, _ = openRetention(t, nil, nil)
+	defer storage.Close()
+	for i := 0; i < 6; i++ {
+		_, err := storage.Get("events:" + TimeKey(start.Add(time.Duration(i)*20*time.Minute), ""))
+		if (err == nil) != (i >= 3) {
+			t.Errorf("event at %d minutes: kept=%t", i*20, err == nil)
+		}
+	}
+	if _, err := storage.Get("events:latest"); err != nil {
+		t.Errorf("Expected a key without a time to stay: %v", err)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, want := range map[string]string{"events:": "events;", "a\xff": "b", "\xff\xff": "", "": ""} {
+		if got := prefixEnd(prefix); got != want {
+			t.Errorf("prefixEnd(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+}
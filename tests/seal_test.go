@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSeal_OpenSealedReadsEveryRecord(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Compress = true // sealed values are the decoded ones
+	storage, err := NewStorageWithOptions("test_"+t.Name()+".db", opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer cleanupTestDB(t, storage.file.Name())
+	defer storage.Close()
+
+	for i := 0; i < 500; i++ {
+		storage.Put(fmt.Sprintf("sku:%04d", i), fmt.Sprintf("price %d", i*3))
+	}
+	storage.Put("config", "")
+	path := filepath.Join(t.TempDir(), "catalog.sealed")
+	header, err := storage.Seal(path)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if header.Records != 501 || header.LSN != storage.lsn {
+		t.Errorf("Unexpected header %+v", header)
+	}
+	storage.Put("sku:9999", "after the seal")
+
+	f, err := OpenSealed(path)
+	if err != nil {
+		t.Fatalf("OpenSealed failed: %v", err)
+	}
+	defer f.Close()
+	if f.Len() != 501 || f.Header().Bytes != header.Bytes {
+		t.Errorf("Expected the header Seal returned, got %+v", f.Header())
+	}
+	for _, i := range []int{0, 1, 250, 499} {
+		if got, err := f.Get(fmt.Sprintf("sku:%04d", i)); err != nil || got != fmt.Sprintf("price %d", i*3) {
+			t.Errorf("Get sku:%04d = %q, %v", i, got, err)
+		}
+	}
+	if got, err := f.Get("config"); got != "" || err != nil {
+		t.Errorf("Expected the empty value, got %q, %v", got, err)
+	}
+	for _, key := range []string{"sku:9999", "a", "zzz", "sku:00"} {
+		if _, err := f.Get(key); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Get %q: expected ErrKeyNotFound, got %v", key, err)
+		}
+	}
+
+	var keys []string
+	f.Range("sku:0100", "sku:0105", func(key, _ string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) != 5 || keys[0] != "sku:0100" || keys[4] != "sku:0104" {
+		t.Errorf("Unexpected range %v", keys)
+	}
+}
+
+func TestSeal_DamagedFileIsRejected(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("a", "1")
+	storage.Put("b", "2")
+	path := filepath.Join(t.TempDir(), "s.sealed")
+	if _, err := storage.Seal(path); err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+
+	flipped := append([]byte(nil), data...)
+	flipped[SealHeaderSize+9] ^= 0x01 // in the first key
+	os.WriteFile(path, flipped, 0644)
+	if _, err := OpenSealed(path); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Expected ErrCorrupted for a flipped bit, got %v", err)
+	}
+	os.WriteFile(path, data[:len(data)-10], 0644)
+	if _, err := OpenSealed(path); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Expected ErrCorrupted for a cut off file, got %v", err)
+	}
+}
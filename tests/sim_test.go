@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"godata/storagetest"
+)
+
+func openSimStore(path string, clock *storagetest.FakeClock) (storagetest.Store, error) {
+	opts := DefaultOptions()
+	opts.Clock = clock
+	storage, err := NewStorageWithOptions(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	return conformanceStore{storage}, nil
+}
+
+func TestSimulation_Storage(t *testing.T) {
+	for seed := int64(1); seed <= 10; seed++ {
+		storagetest.Simulate(t, storagetest.SimConfig{
+			Seed:  seed,
+			Steps: 2000,
+			Open:  openSimStore,
+		})
+	}
+}
+
+func TestClock_InjectedIntoStats(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := storagetest.NewFakeClock(start)
+	opts := DefaultOptions()
+	opts.Clock = clock
+	storage, filename := openWithOptions(t, opts)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	clock.Advance(time.Minute)
+	snap := storage.Stats().Snapshot()
+	if !snap.Since.Equal(start) || !snap.TakenAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("Expected fake clock times, got since=%v taken=%v", snap.Since, snap.TakenAt)
+	}
+}
+
+func TestProperties_Storage(t *testing.T) {
+	storagetest.Property(t, storagetest.PropertyConfig{
+		Open:      openConformanceStore(EngineHeap),
+		Seed:      1,
+		Runs:      30,
+		OpsPerRun: 300,
+		Generator: storagetest.Generator{MaxValue: 2000},
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"godata/pagefmt"
+)
+
+func openSlotted(t *testing.T) (*Storage, string) {
+	opts := DefaultOptions()
+	opts.SlottedPages = true
+	return openWithOptions(t, opts)
+}
+
+// where key's record starts on its page
+func recordOffset(t *testing.T, storage *Storage, key string) int {
+	page := storage.pages[storage.pageIndex[key]]
+	i, found := page.searchSlots(key)
+	if !found {
+		t.Fatalf("%s is not on its page", key)
+	}
+	return page.slot(i)
+}
+
+func TestSlottedPages_DeleteAndShrinkDontMoveRecords(t *testing.T) {
+	storage, filename := openSlotted(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	for _, key := range []string{"user:3", "user:1", "user:4", "user:2"} {
+		storage.Put(key, "value of "+key)
+	}
+	after := recordOffset(t, storage, "user:4")
+	storage.Delete("user:3")
+	if got := recordOffset(t, storage, "user:4"); got != after {
+		t.Errorf("Expected user:4 to stay at %d after a delete, moved to %d", after, got)
+	}
+	at := recordOffset(t, storage, "user:1")
+	storage.Put("user:1", "short")
+	if got := recordOffset(t, storage, "user:1"); got != at {
+		t.Errorf("Expected a smaller value written in place at %d, got %d", at, got)
+	}
+	storage.Put("user:2", strings.Repeat("longer ", 10))
+
+	want := map[string]string{"user:1": "short", "user:2": strings.Repeat("longer ", 10), "user:4": "value of user:4"}
+	for key, value := range want {
+		if got, err := storage.Get(key); err != nil || got != value {
+			t.Errorf("%s = %q, %v; want %q", key, got, err, value)
+		}
+	}
+	if _, err := storage.Get("user:3"); err == nil {
+		t.Error("Expected user:3 gone")
+	}
+	storage.Sync()
+	if report, _ := storage.Verify(1); !report.OK() {
+		t.Errorf("Slotted pages fail verification: %v", report.Problems)
+	}
+}
+
+func TestSlottedPages_HolesAreReused(t *testing.T) {
+	storage, filename := openSlotted(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	for i := 0; i < 8; i++ {
+		storage.Put(fmt.Sprintf("key%d", i), strings.Repeat(fmt.Sprint(i), 480))
+	}
+	if storage.totalPages != 1 {
+		t.Fatalf("Expected the records on one page, got %d pages", storage.totalPages)
+	}
+	// the holes together make room, the space after the records doesn't
+	storage.Delete("key2")
+	storage.Delete("key5")
+	storage.Put("key9", strings.Repeat("9", 900))
+	if storage.totalPages != 1 {
+		t.Errorf("Expected the page packed to make room, got %d pages", storage.totalPages)
+	}
+	for _, i := range []int{0, 1, 3, 4, 6, 7, 9} {
+		key := fmt.Sprintf("key%d", i)
+		if got, err := storage.Get(key); err != nil || !strings.HasPrefix(got, fmt.Sprint(i)) {
+			t.Errorf("%s damaged by packing: %d bytes, %v", key, len(got), err)
+		}
+	}
+}
+
+func TestSlottedPages_FlagSurvivesReopenAndCompact(t *testing.T) {
+	storage, filename := openSlotted(t)
+	defer cleanupTestDB(t, filename)
+	for i := 0; i < 60; i++ {
+		storage.Put(fmt.Sprintf("key%02d", i), strings.Repeat("v", 300))
+	}
+	for i := 0; i < 60; i += 3 {
+		storage.Delete(fmt.Sprintf("key%02d", i))
+	}
+	storage.Close()
+
+	// the file decides, not the options it's opened with
+	storage, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer storage.Close()
+	if !storage.slotted || storage.headerFlags()&pagefmt.FlagSlottedPages == 0 {
+		t.Fatal("Expected the file to stay slotted")
+	}
+	if _, err := storage.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if !storage.slotted {
+		t.Error("Expected the compacted file to be slotted")
+	}
+	for i := 0; i < 60; i++ {
+		_, err := storage.Get(fmt.Sprintf("key%02d", i))
+		if deleted := i%3 == 0; deleted == (err == nil) {
+			t.Errorf("key%02d: %v", i, err)
+		}
+	}
+	if report, _ := storage.Verify(1); !report.OK() {
+		t.Errorf("Compacted slotted file fails verification: %v", report.Problems)
+	}
+	if integrity, err := CheckIntegrity(filename); err != nil || !integrity.OK() || integrity.Records != 40 {
+		t.Errorf("CheckIntegrity = %+v, %v", integrity, err)
+	}
+}
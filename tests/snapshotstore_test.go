@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// keeps snapshots in memory, verifyErr fails every VerifySnapshot
+type memSnapshotStore struct {
+	mu        sync.Mutex
+	bodies    map[string][]byte
+	verifyErr error
+}
+
+func (m *memSnapshotStore) WriteSnapshot(meta SnapshotMeta, body io.Reader) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.bodies == nil {
+		m.bodies = map[string][]byte{}
+	}
+	m.bodies[meta.Bucket] = data
+	return nil
+}
+
+func (m *memSnapshotStore) VerifySnapshot(meta SnapshotMeta) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.verifyErr != nil {
+		return m.verifyErr
+	}
+	if int64(len(m.bodies[meta.Bucket])) != meta.Bytes {
+		return errors.New("size mismatch")
+	}
+	return nil
+}
+
+func TestSnapshotTo_OneSnapshotPerBucket(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	opts := DefaultOptions()
+	opts.SnapshotBucket = KeyPrefixBucket(":")
+	db, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer db.Close()
+
+	db.Put("user:1", "isabella")
+	db.Put("user:2", "marco")
+	db.Put("order:1", "3 apples")
+	if _, err := db.PutReader("user:3", strings.NewReader(strings.Repeat("x", 10000))); err != nil {
+		t.Fatalf("PutReader failed: %v", err)
+	}
+
+	store := &memSnapshotStore{}
+	metas, err := db.SnapshotTo(store)
+	if err != nil {
+		t.Fatalf("SnapshotTo failed: %v", err)
+	}
+	if len(metas) != 2 || metas[0].Bucket != "order" || metas[1].Bucket != "user" {
+		t.Fatalf("Expected the order and user snapshots, got %+v", metas)
+	}
+	if metas[0].Records != 1 || metas[0].LSN != metas[1].LSN {
+		t.Errorf("Unexpected metas %+v", metas)
+	}
+	if got := db.Stats().Snapshot().Snapshots; got != 2 {
+		t.Errorf("Expected 2 snapshots in Stats, got %d", got)
+	}
+
+	// the user snapshot alone rebuilds the users, streamed one included
+	restoredName := "test_" + t.Name() + "_restored.db"
+	defer cleanupTestDB(t, restoredName)
+	restored, err := NewStorage(restoredName)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer restored.Close()
+	if _, err := restored.Import(bytes.NewReader(store.bodies["user"]), ConflictFail); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if got, err := restored.Get("user:2"); err != nil || got != "marco" {
+		t.Errorf("user:2 = %q, %v; want marco", got, err)
+	}
+	if got, err := restored.Get("user:3"); err != nil || len(got) != 10000 {
+		t.Errorf("user:3 = %d bytes, %v; want 10000", len(got), err)
+	}
+	if _, err := restored.Get("order:1"); err == nil {
+		t.Errorf("order:1 shouldn't be in the user snapshot")
+	}
+}
+
+func TestSnapshotTo_VerifyFailureStopsTheRun(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("a", "1")
+	broken := errors.New("object store lost it")
+	metas, err := storage.SnapshotTo(&memSnapshotStore{verifyErr: broken})
+	if !errors.Is(err, broken) {
+		t.Fatalf("Expected the verify error, got %v", err)
+	}
+	if len(metas) != 0 || storage.Stats().Snapshot().Snapshots != 0 {
+		t.Errorf("An unverified snapshot counted as done: %+v", metas)
+	}
+}
+
+func TestSnapshotTo_BackgroundToDirectory(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	dir := t.TempDir()
+	store := NewDirSnapshotStore(dir)
+	opts := DefaultOptions()
+	opts.SnapshotStore = store
+	opts.SnapshotInterval = 10 * time.Millisecond
+	db, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer db.Close()
+
+	db.Put("a", "1")
+	deadline := time.Now().Add(time.Second)
+	for db.Stats().Snapshot().Snapshots == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no background snapshot within a second")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	meta := SnapshotMeta{Bucket: "", LSN: 1}
+	data, err := os.ReadFile(store.Path(meta))
+	if err != nil {
+		t.Fatalf("Snapshot file missing: %v", err)
+	}
+	header, records := readExport(t, bytes.NewReader(data))
+	if header.LSN != 1 || records["a"] != "1" {
+		t.Errorf("Unexpected snapshot %+v %v", header, records)
+	}
+
+	// a damaged file fails verification
+	os.WriteFile(store.Path(meta), append(data, '\n'), 0644)
+	meta.Bytes = int64(len(data))
+	if err := store.VerifySnapshot(meta); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Expected ErrCorrupted, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStats_SnapshotAndReset(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("user:1", "isabella")
+	storage.Put("user:2", "cam")
+	storage.Get("user:1")
+	storage.Delete("user:2")
+
+	before := storage.Stats().Snapshot()
+	if before.Puts != 2 || before.Gets != 1 || before.Deletes != 1 {
+		t.Fatalf("Unexpected counters: puts=%d gets=%d deletes=%d", before.Puts, before.Gets, before.Deletes)
+	}
+
+	// Reset hands back what it cleared
+	cleared := storage.Stats().Reset()
+	if cleared.Puts != 2 {
+		t.Errorf("Expected Reset to return 2 puts, got %d", cleared.Puts)
+	}
+
+	storage.Get("user:1")
+	after := storage.Stats().Snapshot()
+	if after.Puts != 0 || after.Gets != 1 {
+		t.Errorf("Expected fresh counters after reset, got puts=%d gets=%d", after.Puts, after.Gets)
+	}
+	if !after.Since.After(before.Since) {
+		t.Error("Expected Since to move forward on Reset")
+	}
+}
+
+func TestStats_SnapshotDelta(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("user:1", "isabella")
+	first := storage.Stats().Snapshot()
+
+	storage.Get("user:1")
+	storage.Get("user:1")
+	delta := storage.Stats().Snapshot().Sub(first)
+
+	if delta.Gets != 2 || delta.Puts != 0 {
+		t.Errorf("Expected delta of 2 gets and 0 puts, got gets=%d puts=%d", delta.Gets, delta.Puts)
+	}
+	if delta.CacheHits != 2 {
+		t.Errorf("Expected both gets to hit the cache, got %d hits", delta.CacheHits)
+	}
+}
+
+func TestStats_LastMaintenanceTimings(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	db.Put("a", "1")
+	db.Put("b", "2")
+	crashStorage(db)
+
+	db, err := NewStorage(filename)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer db.Close()
+	snap := db.Stats().Snapshot()
+	if snap.LastRecovery.At.IsZero() || snap.LastRecovery.Bytes == 0 {
+		t.Errorf("Expected the replayed WAL to be timed, got %+v", snap.LastRecovery)
+	}
+	if !snap.LastCheckpoint.At.IsZero() || !snap.LastCompaction.At.IsZero() {
+		t.Errorf("Expected no checkpoint or compaction yet, got %+v", snap)
+	}
+
+	db.Put("c", "3")
+	if _, err := db.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	db.Delete("a")
+	if _, err := db.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	// not counters, a reset keeps them
+	db.Stats().Reset()
+	snap = db.Stats().Snapshot()
+	if snap.LastCheckpoint.At.IsZero() || snap.LastCheckpoint.Bytes < PageSize || snap.LastCheckpoint.Duration < 0 {
+		t.Errorf("Expected the checkpoint's page write, got %+v", snap.LastCheckpoint)
+	}
+	if snap.LastCompaction.At.Before(snap.LastCheckpoint.At) || snap.LastCompaction.Bytes < PageSize {
+		t.Errorf("Expected the compaction after the checkpoint, got %+v", snap.LastCompaction)
+	}
+}
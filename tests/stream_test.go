@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func randomBytes(n int, seed int64) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func countChunks(t *testing.T, storage *Storage) int {
+	// Scan leaves them out, they're counted off the index
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	n := 0
+	err := storage.indexPrefix(StreamChunkPrefix, func(string, uint32) bool {
+		n++
+		return true
+	})
+	if err != nil {
+		t.Fatalf("indexPrefix failed: %v", err)
+	}
+	return n
+}
+
+func TestPutReader_StreamsLargeValues(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	doc := randomBytes(3<<20, 1)
+	n, err := storage.PutReader("doc:7", bytes.NewReader(doc))
+	if err != nil || n != int64(len(doc)) {
+		t.Fatalf("PutReader wrote %d bytes: %v", n, err)
+	}
+	r, err := storage.GetReader("doc:7")
+	if err != nil {
+		t.Fatalf("GetReader failed: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, doc) {
+		t.Fatalf("Expected the document back, got %d bytes, %v", len(got), err)
+	}
+	if value, err := storage.Get("doc:7"); err != nil || value != string(doc) {
+		t.Errorf("Expected Get to put the value together, got %d bytes, %v", len(value), err)
+	}
+	chunks := countChunks(t, storage)
+	if chunks < len(doc)/PageSize {
+		t.Errorf("Expected at least %d chunks, got %d", len(doc)/PageSize, chunks)
+	}
+	sealed := filepath.Join(t.TempDir(), "s.sealed")
+	storage.Seal(sealed)
+	if f, err := OpenSealed(sealed); err != nil {
+		t.Errorf("OpenSealed failed: %v", err)
+	} else {
+		if value, err := f.Get("doc:7"); err != nil || value != string(doc) {
+			t.Errorf("Expected the sealed value put together, got %d bytes, %v", len(value), err)
+		}
+		f.Close()
+	}
+
+	// a new value replaces the chunks of the old one
+	small := randomBytes(10000, 2)
+	if _, err := storage.PutReader("doc:7", bytes.NewReader(small)); err != nil {
+		t.Fatalf("PutReader failed: %v", err)
+	}
+	if got := countChunks(t, storage); got != 3 {
+		t.Errorf("Expected the old chunks gone and 3 new ones, got %d", got)
+	}
+
+	// the chunks and the manifest are in the WAL like any write
+	crashStorage(storage)
+	storage, err = NewStorage(filename)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer storage.Close()
+	r, _ = storage.GetReader("doc:7")
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, small) {
+		t.Errorf("Expected the value after recovery, got %d bytes, %v", len(got), err)
+	}
+
+	// plain values read as a reader too
+	storage.Put("plain", "hello")
+	r, _ = storage.GetReader("plain")
+	if got, _ := io.ReadAll(r); string(got) != "hello" {
+		t.Errorf("Expected hello, got %q", got)
+	}
+}
+
+func TestPutReader_TransformersAndGC(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Compress = true // random bytes grow by the marker, chunks get split
+	storage, err := NewStorageWithOptions("test_"+t.Name()+".db", opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	defer cleanupTestDB(t, storage.file.Name())
+	defer storage.Close()
+
+	doc := randomBytes(100000, 3)
+	if _, err := storage.PutReader("doc", bytes.NewReader(doc)); err != nil {
+		t.Fatalf("PutReader failed: %v", err)
+	}
+	values, err := storage.GetMany([]string{"doc"})
+	if err != nil || values["doc"] != string(doc) {
+		t.Fatalf("Expected GetMany to put the value together: %v", err)
+	}
+
+	// a Put over it leaves the chunks to GC
+	storage.Put("doc", "small now")
+	if countChunks(t, storage) == 0 {
+		t.Fatal("Expected the chunks to stay until GC")
+	}
+	report, err := storage.GC()
+	if err != nil || report.OrphanedChunks == 0 || countChunks(t, storage) != 0 {
+		t.Errorf("Expected GC to drop the chunks, got %+v, %v", report, err)
+	}
+
+	// a reader that fails half way leaves nothing behind
+	failing := io.MultiReader(strings.NewReader(strings.Repeat("x", 20000)), iotestErrReader{})
+	if _, err := storage.PutReader("doc", failing); err == nil {
+		t.Error("Expected the reader's error")
+	}
+	if got, _ := storage.Get("doc"); got != "small now" || countChunks(t, storage) != 0 {
+		t.Errorf("Expected the old value and no chunks, got %q", got)
+	}
+}
+
+type iotestErrReader struct{}
+
+func (iotestErrReader) Read([]byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestPutReader_OverACopiedValue(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+
+	doc := randomBytes(20000, 4)
+	if _, err := storage.PutReader("a", bytes.NewReader(doc)); err != nil {
+		t.Fatalf("PutReader failed: %v", err)
+	}
+	if err := storage.Copy("a", "b"); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	// replacing a drops a's chunks, b has its own
+	if _, err := storage.PutReader("a", bytes.NewReader(randomBytes(20000, 5))); err != nil {
+		t.Fatalf("PutReader failed: %v", err)
+	}
+	if got, err := storage.Get("b"); err != nil || got != string(doc) {
+		t.Fatalf("Get b after a was replaced: %v", err)
+	}
+	if report, err := storage.GC(); err != nil || report.OrphanedChunks != 0 {
+		t.Errorf("Expected no orphaned chunks, got %+v, %v", report, err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMix(t *testing.T) {
+	r, w, d, err := ParseMix("70r/25w/5d")
+	if err != nil || r != 70 || w != 25 || d != 5 {
+		t.Errorf("ParseMix = %d %d %d, %v", r, w, d, err)
+	}
+	if _, _, _, err := ParseMix("100w"); err != nil {
+		t.Errorf("Expected a write-only mix to parse, got %v", err)
+	}
+	for _, bad := range []string{"70r/25w", "70x/30w", "r/100w", ""} {
+		if _, _, _, err := ParseMix(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestRunStress_Short(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	var out bytes.Buffer
+	summary, err := RunStress(storage, StressConfig{
+		Duration:    300 * time.Millisecond,
+		ReadPct:     70,
+		WritePct:    25,
+		DeletePct:   5,
+		Keys:        200,
+		ReportEvery: 100 * time.Millisecond,
+		VerifyEvery: 50 * time.Millisecond,
+		Seed:        1,
+		Out:         &out,
+	})
+	if err != nil {
+		t.Fatalf("RunStress failed: %v", err)
+	}
+	if summary.Ops == 0 || summary.Verified < 2 {
+		t.Errorf("Expected operations and several verifications, got %d ops, %d verified", summary.Ops, summary.Verified)
+	}
+	if len(summary.Intervals) == 0 || summary.Intervals[0].FileBytes < HeaderSize {
+		t.Errorf("Expected report intervals with the file size, got %+v", summary.Intervals)
+	}
+	if !strings.Contains(out.String(), "ops/s") {
+		t.Errorf("Expected a report header, got %q", out.String())
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"godata/pagefmt"
+	"godata/storagetest"
+)
+
+func openTiered(t *testing.T, tier ColdTier, clock Clock) *Storage {
+	opts := DefaultOptions()
+	opts.ColdTier = tier
+	opts.ColdAfter = 24 * time.Hour
+	opts.Clock = clock
+	storage, err := NewStorageWithOptions("test_"+t.Name()+".db", opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	return storage
+}
+
+func newTestTier(t *testing.T) *FileTier {
+	tier, err := NewFileTier(filepath.Join(t.TempDir(), "cold"))
+	if err != nil {
+		t.Fatalf("NewFileTier failed: %v", err)
+	}
+	t.Cleanup(func() { tier.Close() })
+	return tier
+}
+
+// fills 10 pages, 4 records to a page
+func fillColdPages(t *testing.T, storage *Storage) {
+	for i := 0; i < 40; i++ {
+		if err := storage.Put(fmt.Sprintf("key%02d", i), strings.Repeat(fmt.Sprint(i%10), 900)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := storage.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+}
+
+func isStub(t *testing.T, storage *Storage, key string) bool {
+	pageID, _, _ := storage.lookup(key)
+	data, err := pagefmt.ReadPage(storage.file, pageID)
+	if err != nil {
+		t.Fatalf("ReadPage failed: %v", err)
+	}
+	return pagefmt.IsColdPage(data)
+}
+
+func TestColdTier_MovesUnusedPagesOut(t *testing.T) {
+	clock := storagetest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	tier := newTestTier(t)
+	storage := openTiered(t, tier, clock)
+	filename := storage.file.Name()
+	defer cleanupTestDB(t, filename)
+
+	fillColdPages(t, storage)
+	if got := storage.Stats().Snapshot().PagesTiered; got != 0 {
+		t.Fatalf("Expected nothing moved while the pages are new, got %d", got)
+	}
+	clock.Advance(48 * time.Hour)
+	storage.Get("key00") // keeps its page in the file
+	if err := storage.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if got := storage.Stats().Snapshot().PagesTiered; got != 9 {
+		t.Errorf("Expected 9 pages moved, got %d", got)
+	}
+	if got := storage.Stats().Snapshot().PagesEvictedCold; got != 9 {
+		t.Errorf("Expected the 9 moved pages out of the cache, got %d", got)
+	}
+	if isStub(t, storage, "key00") || !isStub(t, storage, "key39") {
+		t.Error("Expected the unused pages, and only those, to be stubs")
+	}
+	if report, _ := storage.Verify(1); !report.OK() {
+		t.Errorf("Expected stubs to verify, got %v", report.Problems)
+	}
+
+	// read from the tier, and back into the file on the next Sync
+	if got, err := storage.Get("key39"); err != nil || got != strings.Repeat("9", 900) {
+		t.Fatalf("Get of a cold key failed: %v", err)
+	}
+	if got := storage.Stats().Snapshot().ColdReads; got != 1 {
+		t.Errorf("Expected 1 cold read, got %d", got)
+	}
+	storage.Sync()
+	if isStub(t, storage, "key39") {
+		t.Error("Expected the used page back in the file")
+	}
+	storage.Close()
+
+	// the stubs are still there after an open
+	storage = openTiered(t, tier, clock)
+	if got, err := storage.Get("key21"); err != nil || got != strings.Repeat("1", 900) {
+		t.Errorf("Get after reopening failed: %q, %v", got, err)
+	}
+	storage.Close()
+
+	if _, err := NewStorage(filename); !errors.Is(err, ErrNoColdTier) {
+		t.Errorf("Expected ErrNoColdTier without the tier, got %v", err)
+	}
+}
+
+func TestColdTier_WritesAndCompactionBringPagesBack(t *testing.T) {
+	clock := storagetest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	storage := openTiered(t, newTestTier(t), clock)
+	defer cleanupTestDB(t, storage.file.Name())
+	defer storage.Close()
+
+	fillColdPages(t, storage)
+	moved, err := storage.MoveColdPages(clock.Now().Add(time.Hour))
+	if err != nil || moved != 10 {
+		t.Fatalf("Expected 10 pages moved, got %d, %v", moved, err)
+	}
+
+	// a change lands in the file
+	storage.Put("key05", "new")
+	storage.Sync()
+	if isStub(t, storage, "key05") {
+		t.Error("Expected the changed page back in the file")
+	}
+
+	for i := 0; i < 40; i += 2 {
+		storage.Delete(fmt.Sprintf("key%02d", i))
+	}
+	if _, err := storage.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	for i := 1; i < 40; i += 2 {
+		key := fmt.Sprintf("key%02d", i)
+		if isStub(t, storage, key) {
+			t.Errorf("Expected %s in the file after compacting", key)
+		}
+		if _, err := storage.Get(key); err != nil {
+			t.Errorf("Get %s failed: %v", key, err)
+		}
+	}
+	if got, _ := storage.Get("key05"); got != "new" {
+		t.Errorf("Expected the changed value, got %q", got)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"godata/storagetest"
+)
+
+func openTimeSeries(t *testing.T, retention time.Duration, clock Clock) (*Storage, string) {
+	filename := "test_" + t.Name() + ".db"
+	opts := DefaultOptions()
+	opts.Engine = EngineAppend
+	opts.Retention = retention
+	opts.Clock = clock
+	storage, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	return storage, filename
+}
+
+func TestAppendEngine_WritesToTheTail(t *testing.T) {
+	storage, filename := openTimeSeries(t, 0, nil)
+	defer cleanupTestDB(t, filename)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 40; i++ { // 4 to a page
+		storage.Put(TimeKey(start.Add(time.Duration(i)*time.Second), ":cpu"), strings.Repeat("v", 900))
+	}
+	// room on the first page isn't looked for
+	storage.Delete(TimeKey(start, ":cpu"))
+	storage.Put(TimeKey(start.Add(time.Hour), ":cpu"), "x")
+	if pageID, _, _ := storage.lookup(TimeKey(start.Add(time.Hour), ":cpu")); pageID != 9 {
+		t.Errorf("Expected the new record on the tail page 9, got page %d", pageID)
+	}
+	storage.Close()
+
+	// after an open the tail is found again
+	storage, _ = openTimeSeries(t, 0, nil)
+	defer storage.Close()
+	if storage.Engine() != "append" {
+		t.Errorf("Expected the append engine, got %s", storage.Engine())
+	}
+	storage.Put(TimeKey(start.Add(2*time.Hour), ":cpu"), "y")
+	if pageID, _, _ := storage.lookup(TimeKey(start.Add(2*time.Hour), ":cpu")); pageID != 9 {
+		t.Errorf("Expected the tail page 9 after reopening, got page %d", pageID)
+	}
+}
+
+func TestRetention_DropsWholeOldPagesOnSync(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := storagetest.NewFakeClock(start)
+	storage, filename := openTimeSeries(t, time.Hour, clock)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	// one record a minute for 2 hours, 4 to a page
+	for i := 0; i < 120; i++ {
+		storage.Put(TimeKey(start.Add(time.Duration(i)*time.Minute), ""), strings.Repeat("v", 900))
+	}
+	clock.Advance(2 * time.Hour) // now = start + 2h, the window starts at start + 1h
+	if err := storage.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	// minutes 0..59 are 15 full pages
+	if got := storage.Stats().Snapshot().PagesExpired; got != 15 {
+		t.Errorf("Expected 15 pages dropped, got %d", got)
+	}
+	if _, err := storage.Get(TimeKey(start.Add(59*time.Minute), "")); err == nil {
+		t.Error("Expected minute 59 to be dropped")
+	}
+	if _, err := storage.Get(TimeKey(start.Add(60*time.Minute), "")); err != nil {
+		t.Errorf("Expected minute 60 to be kept: %v", err)
+	}
+	if len(storage.freePages) != 15 {
+		t.Errorf("Expected the dropped pages on the free list, got %v", storage.freePages)
+	}
+
+	// new data goes on the freed pages instead of growing the file
+	pages := storage.totalPages
+	for i := 0; i < 8; i++ {
+		storage.Put(TimeKey(start.Add(3*time.Hour+time.Duration(i)*time.Minute), ""), strings.Repeat("v", 900))
+	}
+	if storage.totalPages != pages {
+		t.Errorf("Expected no new pages, went from %d to %d", pages, storage.totalPages)
+	}
+}
+
+func TestRetention_KeysWithoutTimeAreKept(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	storage, filename := openTimeSeries(t, 0, nil)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put(TimeKey(start, ""), "old")
+	storage.Put("config", "keep me") // same page, no time in the key
+	dropped, err := storage.DropBefore(start.Add(time.Hour))
+	if err != nil || dropped != 0 {
+		t.Errorf("Expected nothing dropped, got %d, %v", dropped, err)
+	}
+	if _, err := storage.Get(TimeKey(start, "")); err != nil {
+		t.Errorf("Expected the old record to stay with its page: %v", err)
+	}
+
+	for i := 1; i <= 4; i++ { // a page of its own
+		storage.Put(TimeKey(start.Add(time.Duration(i)*time.Second), fmt.Sprint(i)), strings.Repeat("v", 1500))
+	}
+	if dropped, _ := storage.DropBefore(start.Add(time.Hour)); dropped < 1 {
+		t.Errorf("Expected the page of only old records to go, dropped %d", dropped)
+	}
+}
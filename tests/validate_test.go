@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidators_RejectMalformedValues(t *testing.T) {
+	storage, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.RegisterValidator("user:", JSONObject("name", "email"))
+
+	if err := storage.Put("user:1", `{"name":"isa","email":"isa@example.com"}`); err != nil {
+		t.Fatalf("Valid value rejected: %v", err)
+	}
+
+	err := storage.Put("user:2", `{"name":"cam"}`)
+	if !errors.Is(err, ErrInvalidValue) || !strings.Contains(err.Error(), `missing field "email"`) {
+		t.Errorf("Expected a descriptive ErrInvalidValue, got %v", err)
+	}
+	if _, err := storage.Get("user:2"); err == nil {
+		t.Errorf("Rejected value was written")
+	}
+
+	// other prefixes aren't affected
+	if err := storage.Put("session:1", "not json"); err != nil {
+		t.Errorf("Unrelated key rejected: %v", err)
+	}
+}
+
+func TestValidators_AllMatchingPrefixesRun(t *testing.T) {
+	var ran []string
+	record := func(name string) Validator {
+		return ValidatorFunc(func(key string, value []byte) error {
+			ran = append(ran, name)
+			if name == "long" && len(value) > 3 {
+				return errors.New("too long")
+			}
+			return nil
+		})
+	}
+
+	opts := DefaultOptions()
+	opts.Validators = map[string]Validator{"": record("all"), "user:": record("user"), "user:admin:": record("long")}
+	storage, filename := openWithOptions(t, opts)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	if err := storage.Put("user:admin:1", "root"); err == nil {
+		t.Errorf("Expected the most specific validator to reject the value")
+	}
+	if strings.Join(ran, ",") != "all,user,long" {
+		t.Errorf("Expected validators to run shortest prefix first, ran %v", ran)
+	}
+
+	// removing it lets the write through
+	storage.RegisterValidator("user:admin:", nil)
+	if err := storage.Put("user:admin:1", "root"); err != nil {
+		t.Errorf("Put failed after removing the validator: %v", err)
+	}
+	if len(opts.Validators) != 3 {
+		t.Errorf("RegisterValidator changed the caller's Options map")
+	}
+}
+
+func TestValidJSON(t *testing.T) {
+	v := ValidJSON()
+	if v.Validate("k", []byte(`[1, 2]`)) != nil || v.Validate("k", []byte(`{"a":`)) == nil {
+		t.Errorf("ValidJSON accepts the wrong documents")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestValueCache_RepeatReadsSkipThePage(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ValueCacheSize = 2
+	opts.Compress = true
+	storage, filename := openWithOptions(t, opts)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	storage.Put("user:1", "isabella")
+	for i := 0; i < 5; i++ {
+		if v, err := storage.Get("user:1"); err != nil || v != "isabella" {
+			t.Fatalf("Get = %q, %v", v, err)
+		}
+	}
+	snap := storage.Stats().Snapshot()
+	if snap.ValueMisses != 1 || snap.ValueHits != 4 {
+		t.Errorf("Expected 1 miss and 4 hits, got %d and %d", snap.ValueMisses, snap.ValueHits)
+	}
+
+	// writes must never leave a stale value behind
+	storage.Put("user:1", "isa")
+	if v, _ := storage.Get("user:1"); v != "isa" {
+		t.Errorf("Expected the new value after Put, got %q", v)
+	}
+	storage.Delete("user:1")
+	if _, err := storage.Get("user:1"); err == nil {
+		t.Errorf("Expected the key to be gone after Delete")
+	}
+}
+
+func TestValueCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := newValueCache(2)
+	c.put("a", "1")
+	c.put("b", "2")
+	c.get("a") // b is now the oldest
+	c.put("c", "3")
+
+	if _, ok := c.get("b"); ok {
+		t.Errorf("Expected b to be evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok := c.get(k); !ok {
+			t.Errorf("Expected %s to still be cached", k)
+		}
+	}
+	if newValueCache(0) != nil {
+		t.Errorf("Size 0 must turn the cache off")
+	}
+}
+
+// hot keys read over and over, then one pass over many cold ones, then the
+// hot keys again: the value cache hit rate of that last round
+func hotKeysAfterScan(t *testing.T, admission AdmissionPolicy) StatsSnapshot {
+	opts := DefaultOptions()
+	opts.ValueCacheSize = 50
+	opts.ValueCacheAdmission = admission
+	storage, filename := openWithOptions(t, opts)
+	defer cleanupTestDB(t, filename)
+	defer storage.Close()
+
+	for i := 0; i < 10; i++ {
+		storage.Put(fmt.Sprintf("hot:%d", i), "h")
+	}
+	for i := 0; i < 100; i++ {
+		storage.Put(fmt.Sprintf("scan:%03d", i), "s")
+	}
+	// often enough for the hot keys' counters to top out, no scanned key
+	// can count more whatever it shares slots with
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 10; i++ {
+			storage.Get(fmt.Sprintf("hot:%d", i))
+		}
+	}
+	for i := 0; i < 100; i++ {
+		storage.Get(fmt.Sprintf("scan:%03d", i))
+	}
+
+	before := storage.Stats().Snapshot()
+	for i := 0; i < 10; i++ {
+		if v, err := storage.Get(fmt.Sprintf("hot:%d", i)); err != nil || v != "h" {
+			t.Fatalf("Get = %q, %v", v, err)
+		}
+	}
+	after := storage.Stats().Snapshot()
+	interval := after.Sub(before)
+	interval.ValueRejected = after.ValueRejected
+	return interval
+}
+
+func TestValueCache_TinyLFUKeepsHotKeysThroughAScan(t *testing.T) {
+	lru := hotKeysAfterScan(t, AdmitAll)
+	if lru.ValueHitRate() != 0 || lru.ValueRejected != 0 {
+		t.Errorf("Plain LRU: hit rate %.2f, %d rejected; want the scan to evict everything", lru.ValueHitRate(), lru.ValueRejected)
+	}
+	lfu := hotKeysAfterScan(t, AdmitTinyLFU)
+	if lfu.ValueHitRate() != 1 {
+		t.Errorf("TinyLFU: hit rate %.2f after the scan, want 1", lfu.ValueHitRate())
+	}
+	// the first 40 fill the cache up, the rest find it full
+	if lfu.ValueRejected != 60 {
+		t.Errorf("Expected the scanned keys to be rejected, got %d", lfu.ValueRejected)
+	}
+}
+
+func TestValueCache_TinyLFUAdmitsKeysThatTurnHot(t *testing.T) {
+	c := valueCacheFor(Options{ValueCacheSize: 2, ValueCacheAdmission: AdmitTinyLFU})
+	for _, k := range []string{"a", "b"} {
+		c.get(k)
+		c.put(k, k)
+	}
+	// c is read once, no more often than a or b
+	c.get("c")
+	if c.put("c", "c") {
+		t.Fatalf("Expected c to be rejected")
+	}
+	for i := 0; i < 3; i++ {
+		c.get("c")
+	}
+	if !c.put("c", "c") {
+		t.Fatalf("Expected c to be admitted once it's read more often than the LRU entry")
+	}
+	if _, ok := c.get("a"); ok {
+		t.Errorf("Expected a, the least recently used, to make room")
+	}
+	if AdmitTinyLFU.String() != "tinylfu" {
+		t.Errorf("String() = %q", AdmitTinyLFU.String())
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestWAL(t *testing.T) (*WAL, string) {
+	path := "test_" + t.Name() + ".db"
+	os.Remove(path + ".wal")
+	wal, err := NewWAL(path)
+	if err != nil {
+		t.Fatalf("NewWAL failed: %v", err)
+	}
+	t.Cleanup(func() {
+		wal.Close()
+		os.Remove(path + ".wal")
+	})
+	return wal, path
+}
+
+func TestWAL_AppendReadAndReopen(t *testing.T) {
+	wal, path := openTestWAL(t)
+
+	wal.Append(LogTypePut, "user:1", "john_doe")
+	wal.Append(LogTypePut, "user:2", "jane_smith")
+	lsn, err := wal.Append(LogTypeDelete, "user:1", "")
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if lsn != 3 {
+		t.Errorf("third entry got LSN %d, want 3", lsn)
+	}
+	if err := wal.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	entries, err := wal.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+	if e := entries[1]; e.LSN != 2 || e.Type != LogTypePut || e.Key != "user:2" || e.Value != "jane_smith" {
+		t.Errorf("second entry = %+v", e)
+	}
+	if e := entries[2]; e.Type != LogTypeDelete || e.Key != "user:1" || e.Value != "" {
+		t.Errorf("third entry = %+v", e)
+	}
+
+	wal.Close()
+	wal2, err := NewWAL(path)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer wal2.Close()
+	if wal2.LastLSN() != 3 {
+		t.Errorf("LastLSN after reopen = %d, want 3", wal2.LastLSN())
+	}
+	if lsn, _ := wal2.Append(LogTypePut, "user:3", "x"); lsn != 4 {
+		t.Errorf("first append after reopen got LSN %d, want 4", lsn)
+	}
+}
+
+func TestWAL_StopsAtCorruptEntry(t *testing.T) {
+	wal, path := openTestWAL(t)
+	wal.Append(LogTypePut, "a", "1")
+	wal.Append(LogTypePut, "b", "2")
+	wal.Close()
+
+	// flip a byte in the second entry's value
+	data, err := os.ReadFile(path + ".wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-5] ^= 0xFF
+	os.WriteFile(path+".wal", data, 0644)
+
+	wal2, err := NewWAL(path)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer wal2.Close()
+	entries, _ := wal2.ReadAll()
+	if len(entries) != 1 || entries[0].Key != "a" {
+		t.Fatalf("want only the first entry back, got %d", len(entries))
+	}
+	if wal2.LastLSN() != 1 {
+		t.Errorf("LastLSN = %d, want 1", wal2.LastLSN())
+	}
+}
+
+func TestWAL_AdminEntries(t *testing.T) {
+	wal, _ := openTestWAL(t)
+
+	wal.Append(LogTypePut, "k", "v")
+	wal.AppendCheckpointBegin(1)
+	wal.AppendBucketCreate("users")
+	wal.AppendCompaction(false, "pages=12")
+	wal.AppendCompaction(true, "pages=7")
+	wal.AppendBucketDelete("users")
+	wal.AppendCheckpointEnd(1)
+	wal.Append(LogTypeDelete, "k", "")
+
+	entries, err := wal.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	wantTypes := []string{"put", "checkpoint-begin", "bucket-create", "compaction-begin",
+		"compaction-end", "bucket-delete", "checkpoint-end", "delete"}
+	if len(entries) != len(wantTypes) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(wantTypes))
+	}
+	var data int
+	for i, e := range entries {
+		if got := LogTypeName(e.Type); got != wantTypes[i] {
+			t.Errorf("entry %d is %s, want %s", i, got, wantTypes[i])
+		}
+		if e.IsData() == e.IsAdmin() {
+			t.Errorf("entry %d (%s): IsData=%t IsAdmin=%t", i, LogTypeName(e.Type), e.IsData(), e.IsAdmin())
+		}
+		if e.IsData() {
+			data++
+		}
+	}
+	if data != 2 {
+		t.Errorf("%d data entries, want 2", data)
+	}
+
+	if e := entries[2]; e.Key != "users" {
+		t.Errorf("bucket-create key = %q", e.Key)
+	}
+	if lsn, err := entries[6].CheckpointLSN(); err != nil || lsn != 1 {
+		t.Errorf("CheckpointLSN = %d, %v; want 1", lsn, err)
+	}
+	if _, err := entries[0].CheckpointLSN(); err == nil {
+		t.Error("CheckpointLSN on a put should fail")
+	}
+}
+
+func TestWAL_AppendRejectsBadEntries(t *testing.T) {
+	wal, _ := openTestWAL(t)
+
+	if _, err := wal.Append(0, "k", "v"); err == nil {
+		t.Error("type 0 should be rejected")
+	}
+	if _, err := wal.Append(200, "k", "v"); err == nil {
+		t.Error("unknown type should be rejected")
+	}
+	if _, err := wal.Append(LogTypePut, "k", string(make([]byte, 70000))); err == nil {
+		t.Error("value over 64KB should be rejected")
+	}
+	if wal.LastLSN() != 0 {
+		t.Errorf("rejected appends used up LSNs, LastLSN = %d", wal.LastLSN())
+	}
+}
+
+func TestWAL_CommittedEntriesOnlyKeepsCommittedTransactions(t *testing.T) {
+	wal, path := openTestWAL(t)
+
+	tx1, _ := wal.BeginTx()
+	wal.AppendTx(tx1, LogTypePut, "a", "1")
+	wal.Append(LogTypePut, "b", "1") // outside any transaction
+	tx2, _ := wal.BeginTx()
+	wal.AppendTx(tx2, LogTypePut, "c", "1")
+	wal.AppendTx(tx1, LogTypeDelete, "d", "")
+	wal.AbortTx(tx2)
+	if _, err := wal.CommitTx(tx1); err != nil {
+		t.Fatalf("CommitTx failed: %v", err)
+	}
+	tx3, _ := wal.BeginTx()
+	wal.AppendTx(tx3, LogTypePut, "e", "1") // never committed, like a crash mid-transaction
+	wal.Close()
+
+	wal2, err := NewWAL(path)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer wal2.Close()
+	entries, _ := wal2.ReadAll()
+	if entries[1].TxID != tx1 || entries[2].TxID != 0 {
+		t.Errorf("TxIDs not read back: %d, %d", entries[1].TxID, entries[2].TxID)
+	}
+
+	var got []string
+	for _, e := range CommittedEntries(entries) {
+		got = append(got, LogTypeName(e.Type)+" "+e.Key)
+	}
+	want := []string{"put b", "put a", "delete d"}
+	if len(got) != len(want) {
+		t.Fatalf("committed entries = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("committed entries = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestWAL_TxMisuse(t *testing.T) {
+	wal, _ := openTestWAL(t)
+
+	if _, err := wal.AppendTx(42, LogTypePut, "k", "v"); err == nil {
+		t.Error("AppendTx on a transaction that was never begun should fail")
+	}
+	if _, err := wal.Append(LogTypeTxCommit, "", ""); err == nil {
+		t.Error("Append should not write transaction markers")
+	}
+	tx, _ := wal.BeginTx()
+	if _, err := wal.AppendTx(tx, LogTypeBucketCreate, "users", ""); err == nil {
+		t.Error("only puts and deletes belong in a transaction")
+	}
+	wal.CommitTx(tx)
+	if _, err := wal.CommitTx(tx); err == nil {
+		t.Error("committing twice should fail")
+	}
+	if _, err := wal.AppendTx(tx, LogTypePut, "k", "v"); err == nil {
+		t.Error("AppendTx after commit should fail")
+	}
+}
+
+func TestWAL_ConcurrentAppends(t *testing.T) {
+	wal, _ := openTestWAL(t)
+
+	const writers, each = 8, 200
+	lsns := make([][]uint64, writers)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				lsn, err := wal.Append(LogTypePut, fmt.Sprintf("w%d:%03d", w, i), "v")
+				if err != nil {
+					t.Errorf("Append failed: %v", err)
+					return
+				}
+				lsns[w] = append(lsns[w], lsn)
+			}
+			// a transaction in the middle of the others
+			txID, _ := wal.BeginTx()
+			wal.AppendTx(txID, LogTypePut, fmt.Sprintf("tx%d", w), "v")
+			if _, err := wal.CommitTx(txID); err != nil {
+				t.Errorf("CommitTx failed: %v", err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	entries, err := wal.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	total := writers * (each + 3)
+	if len(entries) != total || wal.LastLSN() != uint64(total) {
+		t.Fatalf("Expected %d entries, got %d (last LSN %d)", total, len(entries), wal.LastLSN())
+	}
+	// the file is in LSN order, without gaps
+	for i, e := range entries {
+		if e.LSN != uint64(i+1) {
+			t.Fatalf("Entry %d has LSN %d", i, e.LSN)
+		}
+	}
+	// each writer's entries went out in the order it appended them
+	for w, got := range lsns {
+		for i, lsn := range got {
+			if want := fmt.Sprintf("w%d:%03d", w, i); entries[lsn-1].Key != want {
+				t.Fatalf("LSN %d holds %q, want %q", lsn, entries[lsn-1].Key, want)
+			}
+		}
+	}
+	if n := len(CommittedEntries(entries)); n != writers*(each+1) {
+		t.Errorf("Expected every transaction committed, got %d entries", n)
+	}
+	if wal.Size() == 0 {
+		t.Error("Expected Size to count the appends")
+	}
+}
+
+func TestWAL_FailedAppendDoesntBurnItsLSN(t *testing.T) {
+	wal, _ := openTestWAL(t)
+	wal.Append(LogTypePut, "a", "1")
+
+	file := wal.file
+	readOnly, err := os.Open(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	wal.file = readOnly
+	if _, err := wal.Append(LogTypePut, "b", "2"); err == nil {
+		t.Fatal("Expected the append to a read-only file to fail")
+	}
+	wal.file = file
+
+	// the failed entry didn't burn its LSN
+	if lsn, err := wal.Append(LogTypePut, "c", "3"); err != nil || lsn != 2 {
+		t.Errorf("Append after the failure = %d, %v; want LSN 2", lsn, err)
+	}
+	if entries, _ := wal.ReadAll(); len(entries) != 2 || entries[1].Key != "c" {
+		t.Errorf("Expected a and c in the log, got %d entries", len(entries))
+	}
+}
+
+func TestWAL_AppendQueuedBehindAFailedWriteFails(t *testing.T) {
+	wal, _ := openTestWAL(t)
+	file := wal.file
+
+	// a full pipe: the next write blocks until the read end is closed, then fails
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	w.Write(make([]byte, 1<<20))
+	w.SetWriteDeadline(time.Time{})
+
+	// one P, so the test goroutine gets going again before the queued appender
+	// looks at its outcome, and the LSNs it hands out again are written first
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	wal.mu.Lock()
+	wal.file = w
+	wal.mu.Unlock()
+	waitFor := func(cond func() bool) {
+		for {
+			wal.mu.Lock()
+			ok := cond()
+			wal.mu.Unlock()
+			if ok {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	first, queued := make(chan error, 1), make(chan error, 1)
+	go func() {
+		_, err := wal.Append(LogTypePut, "a", "1")
+		first <- err
+	}()
+	waitFor(func() bool { return wal.writing })
+	go func() {
+		_, err := wal.Append(LogTypePut, "b", "2")
+		queued <- err
+	}()
+	waitFor(func() bool { return len(wal.pending) > 0 })
+
+	r.Close()
+	if err := <-first; err == nil {
+		t.Fatal("Expected the append to a closed pipe to fail")
+	}
+	// the failed write is over, nothing is writing to the pipe any more
+	wal.mu.Lock()
+	wal.file = file
+	wal.mu.Unlock()
+	// LSNs 1 and 2 again, written this time
+	for _, key := range []string{"c", "d"} {
+		if _, err := wal.Append(LogTypePut, key, "3"); err != nil {
+			t.Fatalf("Append after the failure: %v", err)
+		}
+	}
+	if err := <-queued; err == nil {
+		t.Error("Expected the append queued behind the failed write to fail too")
+	}
+	entries, _ := wal.ReadAll()
+	if len(entries) != 2 || entries[0].Key != "c" || entries[1].Key != "d" {
+		t.Errorf("Expected c and d in the log, got %d entries", len(entries))
+	}
+}
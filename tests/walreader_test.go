@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWALReader_FollowsLiveWrites(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+
+	db.Put("user:1", "isabella")
+	r, err := db.TailWAL(0)
+	if err != nil {
+		t.Fatalf("TailWAL failed: %v", err)
+	}
+	defer r.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if e, err := r.Next(ctx); err != nil || e.LSN != 1 || e.Key != "user:1" {
+		t.Fatalf("first entry = %+v, %v", e, err)
+	}
+
+	// Next waits for the writes of another goroutine
+	go func() {
+		for i := 2; i <= 50; i++ {
+			db.Put(fmt.Sprintf("user:%d", i), "v")
+		}
+		db.Delete("user:1")
+	}()
+	for want := uint64(2); want <= 51; want++ {
+		e, err := r.Next(ctx)
+		if err != nil {
+			t.Fatalf("Next for LSN %d failed: %v", want, err)
+		}
+		if e.LSN != want {
+			t.Fatalf("got LSN %d, want %d", e.LSN, want)
+		}
+	}
+
+	// nothing more, it waits until ctx says stop
+	short, stop := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer stop()
+	if _, err := r.Next(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to time out, got %v", err)
+	}
+}
+
+func TestWALReader_CheckpointedAway(t *testing.T) {
+	db, filename := setupTestDB(t)
+	defer cleanupTestDB(t, filename)
+	defer db.Close()
+	ctx := context.Background()
+
+	db.Put("a", "1")
+	db.Put("b", "2")
+	caughtUp, _ := db.TailWAL(0)
+	caughtUp.Next(ctx)
+	caughtUp.Next(ctx)
+	behind, _ := db.TailWAL(0)
+
+	// the checkpoint empties the log
+	db.Sync()
+	db.Put("c", "3")
+
+	if e, err := caughtUp.Next(ctx); err != nil || e.Key != "c" {
+		t.Errorf("Expected the reader to go on after the checkpoint, got %+v, %v", e, err)
+	}
+	if _, err := behind.Next(ctx); !errors.Is(err, ErrWALGap) {
+		t.Errorf("Expected ErrWALGap, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWarmSet_RecentPagesSavedAndLoaded(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	defer os.Remove(filename + warmSuffix)
+	opts := DefaultOptions()
+	opts.WarmPages = 2
+
+	storage, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	// two records a page, k0/k1 on page 0 ... k8/k9 on page 4
+	for i := 0; i < 10; i++ {
+		storage.Put(fmt.Sprintf("k%d", i), strings.Repeat("v", 1800))
+	}
+	storage.Get("k2") // page 1
+	storage.Get("k6") // page 3
+	storage.Get("k7") // page 3 again, most recent
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filename + warmSuffix)
+	if err != nil {
+		t.Fatalf("No warm set written: %v", err)
+	}
+	var set warmSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		t.Fatalf("Bad warm set %s: %v", data, err)
+	}
+	if fmt.Sprint(set.Pages) != "[3 1]" {
+		t.Errorf("Expected pages [3 1], got %v", set.Pages)
+	}
+
+	reopened, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	<-reopened.WarmedUp()
+	for _, id := range set.Pages {
+		if reopened.pages[id] == nil {
+			t.Errorf("Page %d not in the cache after warm-up", id)
+		}
+	}
+	// the index build isn't counted as use, the warm set is, so it carries
+	// over to the next run if this one closes without using anything
+	if got := reopened.recentPages(10); len(got) != 2 {
+		t.Errorf("Expected the 2 warm pages as the only uses, got %v", got)
+	}
+}
+
+func TestWarmSet_BadFileIgnored(t *testing.T) {
+	filename := "test_" + t.Name() + ".db"
+	defer cleanupTestDB(t, filename)
+	defer os.Remove(filename + warmSuffix)
+	os.WriteFile(filename+warmSuffix, []byte("not json"), 0644)
+
+	opts := DefaultOptions()
+	opts.WarmPages = 4
+	storage, err := NewStorageWithOptions(filename, opts)
+	if err != nil {
+		t.Fatalf("Open with a bad warm set failed: %v", err)
+	}
+	<-storage.WarmedUp()
+	storage.Close()
+
+	// without the option nothing is written
+	os.Remove(filename + warmSuffix)
+	storage, _ = NewStorage(filename)
+	storage.Close()
+	if _, err := os.Stat(filename + warmSuffix); !os.IsNotExist(err) {
+		t.Error("Expected no warm set without Options.WarmPages")
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"godata/pagefmt"
+)
+
+// cold tiering: pages nobody used for Options.ColdAfter move to
+// Options.ColdTier, somewhere slower and cheaper (a file on another disk, an
+// object store), and are read from there when a key on them is wanted. the
+// index doesn't change, the page keeps its ID and its place in the file, the
+// file only holds a stub for it in the meantime:
+//
+//	data file   [page 0][page 1][stub 2][page 3][stub 4]
+//	                               │               │
+//	cold tier                   [page 2]        [page 4]
+//
+// a stub is a page with pagefmt.ColdPageCount as its record count. Sync moves
+// the cold pages out after it wrote the dirty ones, only clean pages go: the
+// page is written to the tier first and the stub into the file after it, so
+// a crash in between leaves the page in both places, and the file's copy is
+// the one that is read.
+//
+// a cache miss on a stub reads the page from the tier (ColdReads in Stats).
+// a cold page that gets used is written into the file again by the next
+// Sync, and the tier's copy deleted once the file has it, the same happens
+// when the page changes. when it goes unused again it goes out again.
+//
+//	tier, _ := NewFileTier("/mnt/archive/app.db.cold")
+//	opts.ColdTier = tier
+//	opts.ColdAfter = 30 * 24 * time.Hour
+//
+// when pages were last used isn't stored, after an open every page counts as
+// used at the open. the pages an open reads to build the index aren't used
+// by anyone, but with the map index that still means every stub is read
+// from the tier once per open, BTreeIndex avoids it. a copy of the data file
+// (a backup) needs the tier as well, a compaction brings every page back
+// into the file and empties the tier.
+
+// ColdTier stores the pages moved out of the data file, as the whole
+// PageSize bytes. WritePage has to have the page durable before it returns,
+// ReadPage may be called by several goroutines at once.
+type ColdTier interface {
+	ReadPage(pageID uint32, buf []byte) error
+	WritePage(pageID uint32, data []byte) error
+	DeletePage(pageID uint32) error
+}
+
+// FileTier is a ColdTier in a second file, page n at n*PageSize. the caller
+// closes it after the storage.
+type FileTier struct {
+	file *os.File
+}
+
+// NewFileTier opens the tier file at path, creating it if needed.
+func NewFileTier(path string) (*FileTier, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileTier{file: file}, nil
+}
+
+func (t *FileTier) ReadPage(pageID uint32, buf []byte) error {
+	_, err := t.file.ReadAt(buf, int64(pageID)*PageSize)
+	return err
+}
+
+func (t *FileTier) WritePage(pageID uint32, data []byte) error {
+	if _, err := t.file.WriteAt(data, int64(pageID)*PageSize); err != nil {
+		return err
+	}
+	return syncFile(t.file)
+}
+
+// DeletePage leaves the bytes where they are, nothing reads them once the
+// data file has the page again, and the page's next trip to the tier
+// overwrites them.
+func (t *FileTier) DeletePage(pageID uint32) error {
+	return nil
+}
+
+func (t *FileTier) Close() error {
+	return t.file.Close()
+}
+
+// starts tracking page use, called once the storage is open so the pages
+// the open read don't count
+func (s *Storage) startTiering() {
+	if s.opts.ColdTier == nil {
+		return
+	}
+	s.cacheMu.Lock()
+	s.pageAccess = make(map[uint32]time.Time)
+	s.tierStart = s.clock().Now()
+	s.cacheMu.Unlock()
+}
+
+// notes a page use, called with cacheMu held
+func (s *Storage) notePageAccess(pageID uint32) {
+	if s.pageAccess != nil {
+		s.pageAccess[pageID] = s.clock().Now()
+	}
+}
+
+func isColdStub(data []byte) bool {
+	return pagefmt.IsColdPage(data)
+}
+
+// reads a page that has a stub in the file from the tier into buf
+func (s *Storage) readColdPage(pageID uint32, buf []byte) error {
+	if s.opts.ColdTier == nil {
+		return &StorageError{Op: "read cold page", PageID: int64(pageID), Offset: s.pageOffset(pageID), Err: ErrNoColdTier}
+	}
+	if err := s.opts.ColdTier.ReadPage(pageID, buf); err != nil {
+		return &StorageError{Op: "read cold page", PageID: int64(pageID), Offset: s.pageOffset(pageID), Err: err}
+	}
+	s.stats.coldReads.Add(1)
+	s.markCold(pageID)
+	return s.checkPageChecksum(pageID, buf)
+}
+
+func (s *Storage) markCold(pageID uint32) {
+	s.coldMu.Lock()
+	defer s.coldMu.Unlock()
+	if s.coldPages == nil {
+		s.coldPages = make(map[uint32]bool)
+	}
+	s.coldPages[pageID] = true
+}
+
+func (s *Storage) isCold(pageID uint32) bool {
+	s.coldMu.Lock()
+	defer s.coldMu.Unlock()
+	return s.coldPages[pageID]
+}
+
+// called by writePage once the file has a cold page again. a copy the tier
+// failed to delete is only wasted space, the file's page is the one read.
+func (s *Storage) dropColdCopy(page *Page) {
+	s.opts.ColdTier.DeletePage(page.ID)
+	page.cold = false
+	s.coldMu.Lock()
+	delete(s.coldPages, page.ID)
+	s.coldMu.Unlock()
+}
+
+// the pages known to be in the tier
+func (s *Storage) coldPageIDs() []uint32 {
+	s.coldMu.Lock()
+	defer s.coldMu.Unlock()
+	ids := make([]uint32, 0, len(s.coldPages))
+	for id := range s.coldPages {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// deletes the tier's copies after a compaction wrote them all into the file
+func (s *Storage) dropColdCopies(ids []uint32) {
+	for _, id := range ids {
+		s.opts.ColdTier.DeletePage(id)
+	}
+}
+
+// MoveColdPages moves every clean page nobody used since cutoff to
+// Options.ColdTier and returns how many it moved, cold pages used since are
+// written back into the file. Sync calls it with now - ColdAfter.
+func (s *Storage) MoveColdPages(cutoff time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkWritable(); err != nil {
+		return 0, err
+	}
+	if s.opts.ColdTier == nil {
+		return 0, ErrNoColdTier
+	}
+	return s.moveColdPages(cutoff)
+}
+
+// called by Sync
+func (s *Storage) applyTiering() error {
+	if s.opts.ColdTier == nil || s.opts.ColdAfter <= 0 || s.MaintenanceMode() {
+		return nil
+	}
+	if _, err := s.moveColdPages(s.clock().Now().Add(-s.opts.ColdAfter)); err != nil {
+		return fmt.Errorf("cold tier: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) moveColdPages(cutoff time.Time) (int, error) {
+	// cold pages in use again go back into the file first, writePage
+	// deletes the tier's copy
+	s.cacheMu.Lock()
+	var back []*Page
+	for id, page := range s.pages {
+		if at, used := s.pageAccess[id]; page.cold && used && at.After(cutoff) {
+			back = append(back, page)
+		}
+	}
+	s.cacheMu.Unlock()
+	for _, page := range back {
+		if err := s.writePage(page); err != nil {
+			return 0, err
+		}
+	}
+
+	moved := 0
+	buf := make([]byte, s.pageSize)
+	for id := uint32(0); id < s.totalPages; id++ {
+		s.cacheMu.Lock()
+		at, used := s.pageAccess[id]
+		page, cached := s.pages[id]
+		s.cacheMu.Unlock()
+		if !used {
+			at = s.tierStart
+		}
+		if at.After(cutoff) || cached && (page.IsDirty || page.cold) || s.isCold(id) {
+			continue
+		}
+
+		// the file has what the cache has, the page is clean
+		offset := s.pageOffset(id)
+		if _, err := s.file.ReadAt(buf, offset); err != nil {
+			return moved, &StorageError{Op: "read page", PageID: int64(id), Offset: offset, Err: err}
+		}
+		s.stats.pageReads.Add(1)
+		s.stats.bytesRead.Add(uint64(s.pageSize))
+		if isColdStub(buf) {
+			s.markCold(id) // moved out before this open
+			continue
+		}
+		if buf[0] == 0 && buf[1] == 0 {
+			continue // empty, nothing to move
+		}
+		if err := s.checkPageChecksum(id, buf); err != nil {
+			return moved, err
+		}
+
+		if err := s.opts.ColdTier.WritePage(id, buf); err != nil {
+			return moved, &StorageError{Op: "write cold page", PageID: int64(id), Offset: offset, Err: err}
+		}
+		s.markCold(id)
+		stub := &Page{ID: id, RecordCount: pagefmt.ColdPageCount, checksummed: s.checksums, checksumAlg: s.checksumAlg, hasLSN: s.pageLSNs}
+		if err := s.writePage(stub); err != nil {
+			return moved, err
+		}
+		s.cacheMu.Lock()
+		if _, cached := s.pages[id]; cached {
+			s.stats.pagesEvictedCold.Add(1)
+		}
+		delete(s.pages, id)
+		delete(s.pageAccess, id)
+		s.pool.remove(s, id)
+		s.cacheMu.Unlock()
+		moved++
+	}
+	if moved > 0 {
+		s.stats.pagesTiered.Add(uint64(moved))
+	}
+	return moved, nil
+}
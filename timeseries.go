@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// time-series: the append engine and time-window retention, for event and
+// metric ingestion where keys only ever grow (a timestamp up front) and old
+// data is thrown away by age rather than deleted key by key.
+//
+// the append engine puts every new record on the last page it wrote to, and
+// starts a new page (an empty one from the free list, or a new one at the
+// end) when that is full. it never searches the file for room, so a write
+// costs the same on a 10GB file as on an empty one, and records written
+// around the same time share a page:
+//
+//	page 4 [09:00 ... 09:02]  page 7 [09:02 ... 09:05]  page 2 [09:05 ... ← tail
+//
+// with Options.Retention set, every Sync drops the pages whose newest record
+// is older than the window, whole: the records leave the index and the page
+// goes on the free list, where the tail picks it up again. that takes no
+// WAL entries and no per-key deletes. a page is only dropped once all of it
+// is old, so up to a page's worth of records outlives the window a little.
+//
+//	opts.Engine = EngineAppend
+//	opts.Retention = 7 * 24 * time.Hour
+//	db.Put(TimeKey(time.Now(), ":cpu"), "0.93")
+//
+// the time of a record comes from its key, TimeKey's format by default, or
+// Options.KeyTime. keys it can't read a time from are never dropped. dropped
+// records aren't logged, a replica applies its own retention.
+
+type appendEngine struct{}
+
+func (appendEngine) ID() EngineID { return EngineAppend }
+func (appendEngine) Name() string { return "append" }
+
+func (appendEngine) place(s *Storage, key string, size int) (*Page, error) {
+	// after an open (or a compaction) the tail is wherever the newest key is
+	if !s.hasTail {
+		_, pageID, found, err := s.indexLast()
+		if err != nil {
+			return nil, err
+		}
+		s.tailPage, s.hasTail = pageID, found
+	}
+	if s.hasTail && s.tailPage < s.totalPages {
+		page, err := s.loadPage(s.tailPage)
+		if err != nil {
+			return nil, err
+		}
+		if page.usedSpace()+size <= page.capacity() {
+			return page, nil
+		}
+	}
+	page := s.allocateNewPage()
+	s.tailPage, s.hasTail = page.ID, true
+	return page, nil
+}
+
+// TimeKey returns a key that sorts by t: its Unix nanoseconds, zero padded
+// to 19 digits, followed by suffix (a series name, a sequence number, ...).
+// the default Options.KeyTime reads the time back from it.
+func TimeKey(t time.Time, suffix string) string {
+	return fmt.Sprintf("%019d%s", t.UnixNano(), suffix)
+}
+
+// reads the time TimeKey put at the start of key
+func timeKeyTime(key string) (time.Time, bool) {
+	if len(key) < 19 {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(key[:19], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+func (s *Storage) keyTime(key string) (time.Time, bool) {
+	if s.opts.KeyTime != nil {
+		return s.opts.KeyTime(key)
+	}
+	return timeKeyTime(key)
+}
+
+// DropBefore removes every page all of whose records are older than cutoff
+// and returns how many it dropped. Sync calls it with now - Retention.
+func (s *Storage) DropBefore(cutoff time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkWritable(); err != nil {
+		return 0, err
+	}
+	return s.dropBefore(cutoff)
+}
+
+func (s *Storage) dropBefore(cutoff time.Time) (int, error) {
+	// old keys come first, so walking the index in order stops at the first
+	// one inside the window. the pages passed on the way are the candidates.
+	var candidates []uint32
+	seen := map[uint32]bool{}
+	err := s.indexRange("", "", func(key string, pageID uint32) bool {
+		if t, ok := s.keyTime(key); ok && !t.Before(cutoff) {
+			return false
+		}
+		if !seen[pageID] {
+			seen[pageID] = true
+			candidates = append(candidates, pageID)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	dropped := 0
+	for _, pageID := range candidates {
+		page, err := s.loadPage(pageID)
+		if err != nil {
+			return dropped, err
+		}
+		// a key written out of order, or without a time, keeps the page
+		var keys []string
+		old := true
+		var lookupErr error
+		page.eachRecord(func(key, _ string, _ int) bool {
+			if id, ok, err := s.lookup(key); err != nil {
+				lookupErr = err
+				return false
+			} else if !ok || id != pageID {
+				return true // an orphaned copy, goes with the page
+			}
+			t, ok := s.keyTime(key)
+			old = ok && t.Before(cutoff)
+			keys = append(keys, key)
+			return old
+		})
+		if lookupErr != nil {
+			return dropped, lookupErr
+		}
+		if !old {
+			continue
+		}
+		for _, key := range keys {
+			if err := s.indexDelete(key); err != nil {
+				return dropped, err
+			}
+			s.values.remove(key)
+		}
+		page.Data = [PageSize]byte{}
+		page.RecordCount = 0
+		page.IsDirty = true
+		s.addFreePage(pageID)
+		dropped++
+	}
+	if dropped > 0 {
+		s.stats.pagesExpired.Add(uint64(dropped))
+	}
+	return dropped, nil
+}
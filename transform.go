@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ValueTransformer changes a value on its way to disk (Encode) and undoes
+// the change on the way back (Decode). compression, encryption or any
+// application-specific encoding can be written as one.
+// the key is passed in so a transformer can behave differently per key,
+// it must not be changed.
+type ValueTransformer interface {
+	Encode(key string, value []byte) ([]byte, error)
+	Decode(key string, stored []byte) ([]byte, error)
+}
+
+// TransformFuncs turns two plain functions into a ValueTransformer.
+type TransformFuncs struct {
+	EncodeFunc func(key string, value []byte) ([]byte, error)
+	DecodeFunc func(key string, stored []byte) ([]byte, error)
+}
+
+func (t TransformFuncs) Encode(key string, value []byte) ([]byte, error) {
+	return t.EncodeFunc(key, value)
+}
+
+func (t TransformFuncs) Decode(key string, stored []byte) ([]byte, error) {
+	return t.DecodeFunc(key, stored)
+}
+
+// the pipeline is built once when the storage is opened:
+//
+//	Put: value → [compression] → Transformers[0] → Transformers[1] → disk
+//	Get: disk → Transformers[1] → Transformers[0] → [compression] → value
+//
+// compression always runs first on write, because encrypted or encoded bytes
+// look random and don't compress.
+// the same pipeline has to be configured every time a file is opened,
+// values written through one pipeline can't be read back through another.
+func buildPipeline(opts Options) []ValueTransformer {
+	var pipeline []ValueTransformer
+	if opts.Compress {
+		pipeline = append(pipeline, &deflateTransformer{
+			level:        flate.DefaultCompression,
+			skipPrefixes: opts.IncompressiblePrefixes,
+		})
+	}
+	return append(pipeline, opts.Transformers...)
+}
+
+// runs the value through every stage in order, before it is written
+func (s *Storage) encodeValue(key, value string) (string, error) {
+	if len(s.pipeline) == 0 {
+		return value, nil
+	}
+	data := []byte(value)
+	for _, t := range s.pipeline {
+		var err error
+		if data, err = t.Encode(key, data); err != nil {
+			return "", fmt.Errorf("encoding value for %q: %w", key, err)
+		}
+	}
+	return string(data), nil
+}
+
+// undoes encodeValue, running the stages backwards
+func (s *Storage) decodeValue(key, stored string) (string, error) {
+	if len(s.pipeline) == 0 {
+		return stored, nil
+	}
+	data := []byte(stored)
+	for i := len(s.pipeline) - 1; i >= 0; i-- {
+		var err error
+		if data, err = s.pipeline[i].Decode(key, data); err != nil {
+			return "", fmt.Errorf("decoding value for %q: %w", key, err)
+		}
+	}
+	return string(data), nil
+}
+
+// deflate compression, every stored value starts with one marker byte:
+//
+//	[0x00][raw value]       ← stored as is (compressing didn't make it smaller)
+//	[0x01][deflate stream]  ← compressed
+const (
+	deflateMarkerRaw        = 0x00
+	deflateMarkerCompressed = 0x01
+)
+
+type deflateTransformer struct {
+	level        int
+	skipPrefixes []string // keys under these prefixes are stored raw without trying
+}
+
+func (d *deflateTransformer) Encode(key string, value []byte) ([]byte, error) {
+	// no point burning CPU on data we know won't shrink,
+	// the raw marker means Decode doesn't need to know about the prefixes
+	for _, prefix := range d.skipPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return rawDeflateValue(value), nil
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(deflateMarkerCompressed)
+	w, err := flate.NewWriter(&buf, d.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	// small or random values often grow, keep those raw
+	if buf.Len() >= len(value)+1 {
+		return rawDeflateValue(value), nil
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *deflateTransformer) Decode(key string, stored []byte) ([]byte, error) {
+	if len(stored) == 0 {
+		return nil, errors.New("missing compression marker")
+	}
+	switch stored[0] {
+	case deflateMarkerRaw:
+		return stored[1:], nil
+	case deflateMarkerCompressed:
+		r := flate.NewReader(bytes.NewReader(stored[1:]))
+		defer r.Close()
+		return io.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("unknown compression marker 0x%02X", stored[0])
+	}
+}
+
+// the value with the "stored as is" marker in front
+func rawDeflateValue(value []byte) []byte {
+	out := make([]byte, 1+len(value))
+	out[0] = deflateMarkerRaw
+	copy(out[1:], value)
+	return out
+}
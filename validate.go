@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Validator checks a value before Put writes it. returning an error rejects
+// the write, the message should say what's wrong with the value so the
+// caller can fix their data (it ends up in the error Put returns).
+type Validator interface {
+	Validate(key string, value []byte) error
+}
+
+// ValidatorFunc turns a plain function into a Validator.
+type ValidatorFunc func(key string, value []byte) error
+
+func (f ValidatorFunc) Validate(key string, value []byte) error {
+	return f(key, value)
+}
+
+// validators are registered per key prefix, every one whose prefix matches
+// the key runs, shortest prefix first, so a "" validator can check
+// something about all values and "user:" can add what's specific to users:
+//
+//	db.RegisterValidator("user:", JSONObject("name", "email"))
+//	db.Put("user:1", `{"name":"isa"}`) → invalid value "user:1" (prefix "user:"): missing field "email"
+
+// RegisterValidator adds v for keys starting with prefix, replacing the one
+// already registered for exactly that prefix. a nil v removes it.
+func (s *Storage) RegisterValidator(prefix string, v Validator) {
+	s.optsMu.Lock()
+	defer s.optsMu.Unlock()
+	// copy, the map may be shared with the Options the caller passed in
+	validators := make(map[string]Validator, len(s.opts.Validators)+1)
+	for p, existing := range s.opts.Validators {
+		validators[p] = existing
+	}
+	if v == nil {
+		delete(validators, prefix)
+	} else {
+		validators[prefix] = v
+	}
+	s.opts.Validators = validators
+}
+
+// runs every validator registered for a prefix of key
+func (s *Storage) validateValue(key, value string) error {
+	s.optsMu.RLock()
+	validators := s.opts.Validators
+	s.optsMu.RUnlock()
+	if len(validators) == 0 {
+		return nil
+	}
+
+	var prefixes []string
+	for prefix := range validators {
+		if strings.HasPrefix(key, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Strings(prefixes) // a prefix of a prefix sorts before it
+	for _, prefix := range prefixes {
+		if err := validators[prefix].Validate(key, []byte(value)); err != nil {
+			return fmt.Errorf("%w %q (prefix %q): %w", ErrInvalidValue, key, prefix, err)
+		}
+	}
+	return nil
+}
+
+// ValidJSON accepts any well-formed JSON document.
+func ValidJSON() Validator {
+	return ValidatorFunc(func(key string, value []byte) error {
+		if !json.Valid(value) {
+			return errors.New("not valid JSON")
+		}
+		return nil
+	})
+}
+
+// JSONObject accepts a JSON object that has every one of the required fields.
+// it's the common case of a schema without pulling in a JSON Schema library,
+// anything more specific is a ValidatorFunc away.
+func JSONObject(required ...string) Validator {
+	return ValidatorFunc(func(key string, value []byte) error {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(value, &fields); err != nil {
+			return fmt.Errorf("not a JSON object: %w", err)
+		}
+		for _, name := range required {
+			if _, ok := fields[name]; !ok {
+				return fmt.Errorf("missing field %q", name)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"container/list"
+	"hash/maphash"
+	"sync"
+)
+
+// a small LRU of decoded values, in front of the page cache. a hit skips the
+// page lookup, the record scan and the decode pipeline (decompression,
+// decryption...) entirely, which is most of the cost of reading a hot key.
+//
+//	Get: value cache → page cache → disk
+//
+// it holds plain values, so every write to a key drops its entry, the next
+// Get puts the new value back.
+//
+// plain LRU lets any read in: one Scan or export over a million keys reads
+// each once and pushes out every hot key on the way. with
+// Options.ValueCacheAdmission set to AdmitTinyLFU a full cache only takes a
+// new key in when it has been read more often than the entry it would push
+// out (the LRU one):
+//
+//	Get("user:1") x 40    frequency 15 (counters stop there)
+//	Scan over k1..k1M     each k read once, 1 < 15, rejected, user:1 stays
+//
+// how often a key was read is estimated, not stored: a count-min sketch of
+// four rows of small counters, one row per hash, taking the smallest of the
+// four. it costs 16 bytes per entry however many keys come by, and every
+// 10 x size reads all counters are halved, so what was hot an hour ago
+// doesn't stay in forever. Stats count the keys it turned away
+// (ValueRejected), compare ValueHitRate with the policy on and off to see
+// whether it pays for a workload.
+type valueCache struct {
+	mu      sync.Mutex // Gets reorder the list, so even reads need it
+	size    int        // max number of entries
+	entries map[string]*list.Element
+	order   *list.List       // front = most recently used
+	sketch  *frequencySketch // read frequencies, nil when every key is admitted
+}
+
+// AdmissionPolicy decides which keys get into a full value cache.
+type AdmissionPolicy int
+
+const (
+	// AdmitAll takes every key in, pushing out the least recently used one
+	AdmitAll AdmissionPolicy = iota
+	// AdmitTinyLFU takes a key in only when it is read more often than the
+	// least recently used one, see valuecache.go
+	AdmitTinyLFU
+)
+
+func (p AdmissionPolicy) String() string {
+	switch p {
+	case AdmitAll:
+		return "all"
+	case AdmitTinyLFU:
+		return "tinylfu"
+	}
+	return "unknown"
+}
+
+type valueEntry struct {
+	key, value string
+}
+
+// returns nil for size 0, every method is a no-op on a nil cache
+func newValueCache(size int) *valueCache {
+	if size <= 0 {
+		return nil
+	}
+	return &valueCache{size: size, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// the value cache Options ask for
+func valueCacheFor(opts Options) *valueCache {
+	c := newValueCache(opts.ValueCacheSize)
+	if c != nil && opts.ValueCacheAdmission == AdmitTinyLFU {
+		c.sketch = newFrequencySketch(opts.ValueCacheSize)
+	}
+	return c
+}
+
+func (c *valueCache) get(key string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sketch.increment(key)
+	elem, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*valueEntry).value, true
+}
+
+// false when the admission policy turned the key away
+func (c *valueCache) put(key, value string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*valueEntry).value = value
+		c.order.MoveToFront(elem)
+		return true
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		victim := oldest.Value.(*valueEntry).key
+		if c.sketch != nil && c.sketch.estimate(key) <= c.sketch.estimate(victim) {
+			return false
+		}
+		c.order.Remove(oldest)
+		delete(c.entries, victim)
+	}
+	c.entries[key] = c.order.PushFront(&valueEntry{key, value})
+	return true
+}
+
+func (c *valueCache) remove(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// a count-min sketch of how often keys were read, for AdmitTinyLFU
+type frequencySketch struct {
+	seed    maphash.Seed
+	rows    [4][]uint8
+	mask    uint64
+	samples int // increments since the counters were last halved
+	window  int // and how many there are between two halvings
+}
+
+// counters saturate here, a key read 15 times is as hot as it gets
+const maxFrequency = 15
+
+func newFrequencySketch(size int) *frequencySketch {
+	// four counters per entry in each row keep collisions rare
+	width := 64
+	for width < 4*size {
+		width <<= 1
+	}
+	f := &frequencySketch{seed: maphash.MakeSeed(), mask: uint64(width - 1), window: 10 * size}
+	for i := range f.rows {
+		f.rows[i] = make([]uint8, width)
+	}
+	return f
+}
+
+// where key counts in each row. every row gets a hash of its own (splitmix64
+// steps from one maphash), two keys that share a slot in one row rarely do
+// in another.
+func (f *frequencySketch) slots(key string) [4]uint64 {
+	h := maphash.String(f.seed, key)
+	var slots [4]uint64
+	for i := range slots {
+		h += 0x9E3779B97F4A7C15
+		z := (h ^ h>>30) * 0xBF58476D1CE4E5B9
+		z = (z ^ z>>27) * 0x94D049BB133111EB
+		slots[i] = (z ^ z>>31) & f.mask
+	}
+	return slots
+}
+
+// called for every read, nil-safe like the cache
+func (f *frequencySketch) increment(key string) {
+	if f == nil {
+		return
+	}
+	for i, slot := range f.slots(key) {
+		if f.rows[i][slot] < maxFrequency {
+			f.rows[i][slot]++
+		}
+	}
+	if f.samples++; f.samples >= f.window {
+		f.halve()
+	}
+}
+
+func (f *frequencySketch) estimate(key string) uint8 {
+	least := uint8(maxFrequency)
+	for i, slot := range f.slots(key) {
+		least = min(least, f.rows[i][slot])
+	}
+	return least
+}
+
+// ages every count, keys that stopped being read lose their lead
+func (f *frequencySketch) halve() {
+	for _, row := range f.rows {
+		for i := range row {
+			row[i] >>= 1
+		}
+	}
+	f.samples /= 2
+}
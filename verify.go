@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sync"
+	"time"
+
+	"godata/pagefmt"
+)
+
+// PageProblem is one page that failed verification.
+type PageProblem struct {
+	PageID uint32
+	Err    error
+}
+
+// VerifyReport is the result of checking every page in the file.
+type VerifyReport struct {
+	Pages    uint32        // pages checked
+	Pending  uint32        // new pages only in memory so far, the next Sync writes them
+	Bytes    int64         // bytes read from disk
+	Workers  int           // how many goroutines did the reading
+	DirectIO bool          // pages were read with O_DIRECT, bypassing the OS cache
+	Duration time.Duration // wall clock time of the whole scan
+	Checksum uint32        // CRC32 of all page checksums in page order, handy to compare two copies of a file
+	Problems []PageProblem // pages that failed, sorted by page ID
+}
+
+// Throughput returns how many MB per second were verified.
+func (r VerifyReport) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / (1024 * 1024) / r.Duration.Seconds()
+}
+
+// OK is true when no page had a problem.
+func (r VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Verify reads every page straight from disk (skipping the cache) and checks
+// that its records are readable, using `workers` goroutines in parallel.
+// os.File.ReadAt is safe to call from many goroutines because every call
+// carries its own offset, so the workers don't need to share a file position.
+// pages added since the last Sync may not be in the file yet, they're left
+// out (VerifyReport.Pending) rather than reported as cut off.
+func (s *Storage) Verify(workers int) (VerifyReport, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	report := VerifyReport{Workers: workers}
+	start := s.clock().Now()
+
+	// with DirectIO the scan uses its own O_DIRECT handle, if that can't be
+	// opened (no support, tmpfs, ...) we quietly use the normal file
+	var direct *directReader
+	if s.opts.DirectIO {
+		if dr, err := openDirectReader(s.file.Name()); err == nil {
+			direct = dr
+			defer direct.Close()
+			report.DirectIO = true
+		}
+	}
+
+	// workers pull page IDs from this channel, results land in per-page slots
+	// so nobody needs a lock to record them
+	ids := make(chan uint32)
+	sums := make([]uint32, s.totalPages)
+	errs := make([]error, s.totalPages)
+	dirty := s.dirtyPageIDs()
+	pending := make([]bool, s.totalPages)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, s.pageSize) // one buffer per worker, reused for every page
+			var scratch []byte
+			if direct != nil {
+				scratch = alignedBlock(directPageSpan(s.pageSize))
+			}
+			for id := range ids {
+				var err error
+				if direct != nil {
+					err = direct.ReadAt(buf, s.pageOffset(id), scratch)
+				} else {
+					_, err = s.readAt(buf, s.pageOffset(id))
+				}
+				if err != nil && dirty[id] && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+					pending[id] = true // past the end of the file, never written
+					continue
+				}
+				if err != nil {
+					errs[id] = &StorageError{Op: "read page", PageID: int64(id), Offset: s.pageOffset(id), Err: err}
+					continue
+				}
+				sums[id] = crc32.ChecksumIEEE(buf)
+				// a bad record says more about what's wrong than the checksum
+				if at, err := verifyPageData(buf, s.headerFlags()); err != nil {
+					errs[id] = corruptedPage("parse record", id, s.pageOffset(id)+int64(at), err)
+				} else if err := s.checkPageChecksum(id, buf); err != nil {
+					errs[id] = err
+				}
+			}
+		}()
+	}
+
+	for id := uint32(0); id < s.totalPages; id++ {
+		ids <- id
+	}
+	close(ids)
+	wg.Wait()
+
+	// fold the per-page checksums together in order
+	all := make([]byte, 4*len(sums))
+	for id, sum := range sums {
+		binary.LittleEndian.PutUint32(all[id*4:id*4+4], sum)
+		if pending[id] {
+			report.Pending++
+		}
+		if errs[id] != nil {
+			report.Problems = append(report.Problems, PageProblem{PageID: uint32(id), Err: errs[id]})
+		}
+	}
+	report.Checksum = crc32.ChecksumIEEE(all)
+	report.Pages = s.totalPages - report.Pending
+	report.Bytes = int64(report.Pages) * int64(s.pageSize)
+	report.Duration = s.clock().Now().Sub(start)
+	s.stats.pageReads.Add(uint64(report.Pages))
+	s.stats.bytesRead.Add(uint64(report.Bytes))
+
+	return report, nil
+}
+
+// the pages changed in memory but not written yet
+func (s *Storage) dirtyPageIDs() map[uint32]bool {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	ids := make(map[uint32]bool)
+	for id, page := range s.pages {
+		if page.IsDirty {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// walks the records of a raw page the same way buildIndex does, but instead
+// of stopping quietly at a bad record it says what is wrong, and where in
+// the page the bad record starts. the parsing is pagefmt's, so verify and
+// external tools agree on what a valid page is.
+func verifyPageData(data []byte, flags uint32) (int, error) {
+	_, err := pagefmt.ParsePageFlags(data, flags)
+	var corrupt *pagefmt.CorruptError
+	if errors.As(err, &corrupt) {
+		return corrupt.Offset, err
+	}
+	return 0, err
+}
@@ -1,46 +1,182 @@
 package main
 
 import (
-	'encoding/binary'
-	'errors'
-	'hash/crc32'
-	'os'
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 )
 
 // Log entry types for what kind of operation is being logged
 const (
-	LogTypePut = 1 // insert or update a key-value pair
+	LogTypePut    = 1 // insert or update a key-value pair
 	LogTypeDelete = 2 // delete a key-value pair
+
+	// administrative entries, they record structural events instead of data
+	// changes. recovery and CDC consumers use IsData/IsAdmin to tell them apart,
+	// replaying a data change twice is wrong, skipping a marker is harmless.
+	LogTypeCheckpointBegin = 3 // Value = LSN the checkpoint covers (decimal)
+	LogTypeCheckpointEnd   = 4 // Value = same LSN, every change up to it is in the pages
+	LogTypeBucketCreate    = 5 // Key = bucket name
+	LogTypeBucketDelete    = 6 // Key = bucket name
+	LogTypeCompactionBegin = 7 // Value = free-form description (pages before, reason, ...)
+	LogTypeCompactionEnd   = 8 // Value = free-form description (pages after, bytes reclaimed, ...)
+
+	// transaction framing. every entry carries a TxID, 0 means it isn't part of
+	// a transaction and stands on its own. a transaction's TxID is the LSN of its
+	// TxBegin entry, its changes only count once the TxCommit is in the log:
+	//
+	//	LSN 7  TxBegin           TxID 7
+	//	LSN 8  Put  a=1          TxID 7
+	//	LSN 9  Put  b=1          TxID 0   <- someone else, applies on its own
+	//	LSN 10 Delete c          TxID 7
+	//	LSN 11 TxCommit          TxID 7   <- a=1 and delete c apply here
+	//
+	// a transaction with a TxAbort, or with neither (crashed halfway), is dropped
+	// completely by CommittedEntries.
+	LogTypeTxBegin  = 9
+	LogTypeTxCommit = 10
+	LogTypeTxAbort  = 11
+
+	logTypeMax = LogTypeTxAbort
 )
 
-// LogEntry represents a single entry in the log 
+const (
+	walHeaderSize   = 8 + 4 + 1 + 8 + 2 + 2 // LSN, EntrySize, Type, TxID, KeyLen, ValueLen
+	walChecksumSize = 4
+	walMaxFieldLen  = 0xFFFF // KeyLen and ValueLen are uint16
+	// the top bits of the type byte say how the entry is checksummed, zero
+	// for CRC32 like the entries from before them (see checksum.go)
+	walChecksumShift = 6
+	walTypeMask      = 1<<walChecksumShift - 1
+)
+
+// LogEntry represents a single entry in the log
 type LogEntry struct {
-	LSN       uint64 // Log Sequence Number - unique ID for the entry
-	EntrySize uint32 // Total size of the entry in bytes
-	Type      byte   // PUT or DELETE
-	KeyLen    uint16 // Length of the key string
-	ValueLen  uint16 // Length of the value string (0 for DELETE)
-	Key       string // The actual key string
-	Value     string // The actual value string (empty for DELETE)
-	Checksum  uint32 // Checksum of the entry using CRC32 hash to detect corruption
-}
-
-//WAL manages the write-ahead log file
-type Wal struct {
+	LSN       uint64            // Log Sequence Number - unique ID for the entry
+	EntrySize uint32            // Total size of the entry in bytes
+	Type      byte              // one of the LogType constants
+	TxID      uint64            // transaction the entry belongs to, 0 for none
+	KeyLen    uint16            // Length of the key string
+	ValueLen  uint16            // Length of the value string (0 for DELETE)
+	Key       string            // The actual key string
+	Value     string            // The actual value string (empty for DELETE)
+	Checksum  uint32            // Checksum of the entry using CRC32 hash (by default) to detect corruption
+	Algorithm ChecksumAlgorithm // how Checksum is computed, CRC32 unless the database picked another
+}
+
+// WAL manages the write-ahead log file. its methods are safe to call from
+// many goroutines, appends that arrive together go out in one write (see
+// appendEntry).
+type WAL struct {
 	file *os.File // the actual log file .wal on the disk
-	path string // the path to the WAL log file
-	lastLSN uint64 // the last LSN assigned used for an entry in the log
+	path string   // the path to the WAL log file
+	// how new entries are checksummed, the database's algorithm
+	checksum ChecksumAlgorithm
+	// guards lastLSN, openTx, size and the appends waiting to be written
+	mu      sync.Mutex
+	lastLSN uint64          // the last LSN assigned used for an entry in the log
+	openTx  map[uint64]bool // transactions begun and not yet committed or aborted
+	size    int64           // bytes in the file, for the checkpointer
+	// appends: entries numbered and not written yet, the batch they go out
+	// in, and whether one appender is writing (the others wait)
+	pending []byte
+	batch   *walBatch
+	writing bool
+	wrote   *sync.Cond // broadcast when a write finishes, or fails
+	// group commit (see SyncTo): the last LSN written to the file, read
+	// without the storage lock, and the last one known to be on disk
+	written    atomic.Uint64
+	groupMu    sync.Mutex
+	synced     *sync.Cond // broadcast when an fsync finishes
+	syncing    bool       // an fsync is running, the others wait for it
+	durableLSN uint64
+	// for WALReaders (see walreader.go), under mu: how often the file was
+	// emptied, a channel closed when entries were written or it was, and
+	// whether Close ran
+	truncations uint64
+	changed     chan struct{}
+	closed      bool
+}
+
+// the outcome of one write of queued entries, every appender whose entry
+// went out in it waits for done and returns err. LSNs can't tell: a failed
+// write hands its LSNs out again, a later write can reach past an entry
+// that never made it.
+type walBatch struct {
+	done bool
+	err  error
 }
 
-// Serialize converts a LogEntry into a byte slice for writing to disk
+// LogTypeName returns a readable name for an entry type ("put", "checkpoint-begin", ...).
+func LogTypeName(typ byte) string {
+	switch typ {
+	case LogTypePut:
+		return "put"
+	case LogTypeDelete:
+		return "delete"
+	case LogTypeCheckpointBegin:
+		return "checkpoint-begin"
+	case LogTypeCheckpointEnd:
+		return "checkpoint-end"
+	case LogTypeBucketCreate:
+		return "bucket-create"
+	case LogTypeBucketDelete:
+		return "bucket-delete"
+	case LogTypeCompactionBegin:
+		return "compaction-begin"
+	case LogTypeCompactionEnd:
+		return "compaction-end"
+	case LogTypeTxBegin:
+		return "tx-begin"
+	case LogTypeTxCommit:
+		return "tx-commit"
+	case LogTypeTxAbort:
+		return "tx-abort"
+	}
+	return fmt.Sprintf("type(%d)", typ)
+}
+
+// IsData reports whether the entry changes a key (put or delete).
+func (e *LogEntry) IsData() bool {
+	return e.Type == LogTypePut || e.Type == LogTypeDelete
+}
+
+// IsAdmin reports whether the entry is a structural event: checkpoint,
+// bucket or compaction marker.
+func (e *LogEntry) IsAdmin() bool {
+	return e.Type >= LogTypeCheckpointBegin && e.Type <= LogTypeCompactionEnd
+}
+
+// IsTxMarker reports whether the entry begins, commits or aborts a transaction.
+func (e *LogEntry) IsTxMarker() bool {
+	return e.Type >= LogTypeTxBegin && e.Type <= LogTypeTxAbort
+}
+
+// CheckpointLSN returns the LSN a checkpoint-begin/end entry covers.
+func (e *LogEntry) CheckpointLSN() (uint64, error) {
+	if e.Type != LogTypeCheckpointBegin && e.Type != LogTypeCheckpointEnd {
+		return 0, fmt.Errorf("LSN %d is a %s entry, not a checkpoint", e.LSN, LogTypeName(e.Type))
+	}
+	return strconv.ParseUint(e.Value, 10, 64)
+}
+
+// Serialize converts a LogEntry into a byte slice for writing to disk.
+// it also fills in EntrySize and Checksum on e.
 func (e *LogEntry) Serialize() []byte {
 
 	//calculate total size needed for the entry
-	totalSize := 8 + 4 + 1 + 2 + 2 + len(e.Key) + len(e.Value) + 4 // 8 bytes for LSN, 4 bytes for EntrySize, 1 byte for Type, 2 bytes for KeyLen, 2 bytes for ValueLen, len(Key) bytes for Key, len(Value) bytes for Value, 4 bytes for Checksum
+	totalSize := walHeaderSize + len(e.Key) + len(e.Value) + walChecksumSize // 8 bytes for LSN, 4 bytes for EntrySize, 1 byte for Type, 8 bytes for TxID, 2 bytes for KeyLen, 2 bytes for ValueLen, len(Key) bytes for Key, len(Value) bytes for Value, 4 bytes for Checksum
+	e.EntrySize = uint32(totalSize)
+	e.KeyLen = uint16(len(e.Key))
+	e.ValueLen = uint16(len(e.Value))
 
-	// create byte array to hold everything 
+	// create byte array to hold everything
 	data := make([]byte, totalSize)
-	
+
 	offset := 0
 
 	// Write entry info to the byte array
@@ -48,60 +184,63 @@ func (e *LogEntry) Serialize() []byte {
 	offset += 8
 	binary.LittleEndian.PutUint32(data[offset:offset+4], e.EntrySize)
 	offset += 4
-	binary.LittleEndian.PutUint8(data[offset:offset+1], e.Type)
+	data[offset] = e.Type | byte(e.Algorithm)<<walChecksumShift
 	offset += 1
+	binary.LittleEndian.PutUint64(data[offset:offset+8], e.TxID)
+	offset += 8
 	binary.LittleEndian.PutUint16(data[offset:offset+2], e.KeyLen)
 	offset += 2
 	binary.LittleEndian.PutUint16(data[offset:offset+2], e.ValueLen)
 	offset += 2
 
-	copy(data[offset:offset+len(e.Key)], []byte(e.Key))
+	copy(data[offset:offset+len(e.Key)], e.Key)
 	offset += len(e.Key)
-	copy(data[offset:offset+len(e.Value)], []byte(e.Value))
+	copy(data[offset:offset+len(e.Value)], e.Value)
 	offset += len(e.Value)
 
-
 	// data = [
-    // // LSN (8 bytes)
-    // 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-    // // EntrySize (4 bytes) 
-    // 0x21, 0x00, 0x00, 0x00, 
-    // // Type (1 byte)
-    // 0x01,  // PUT
-    // // KeyLen (2 bytes)
-    // 0x06, 0x00,  
-    // // ValueLen (2 bytes)
-    // 0x04, 0x00, 
-    // // Key "user:1" (6 bytes)
-    // 0x75, 0x73, 0x65, 0x72, 0x3A, 0x31,  // u s e r : 1
-    // // Value "john" (4 bytes)
-    // 0x6A, 0x6F, 0x68, 0x6E,  // j o h n
-    // // Checksum space (4 bytes) - still empty!
-    // 0x00, 0x00, 0x00, 0x00
+	// // LSN (8 bytes)
+	// 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	// // EntrySize (4 bytes)
+	// 0x27, 0x00, 0x00, 0x00,
+	// // Type (1 byte)
+	// 0x01,  // PUT
+	// // TxID (8 bytes)
+	// 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,  // not in a transaction
+	// // KeyLen (2 bytes)
+	// 0x06, 0x00,
+	// // ValueLen (2 bytes)
+	// 0x04, 0x00,
+	// // Key "user:1" (6 bytes)
+	// 0x75, 0x73, 0x65, 0x72, 0x3A, 0x31,  // u s e r : 1
+	// // Value "john" (4 bytes)
+	// 0x6A, 0x6F, 0x68, 0x6E,  // j o h n
+	// // Checksum space (4 bytes) - still empty!
+	// 0x00, 0x00, 0x00, 0x00
 	// ]
-	// offset = 27 (bytes 0-26) 
+	// offset = 35 (bytes 0-34)
 
 	//checksum is a fingerprint for the data. It is a single number that represents all the data.
 	//it is used to detect corruption of the data. it is calculated by taking the data and running it through a hash function. returns a single number. if one byte changes, the checksum will change, alerting you that something is wrong.
 
-	// checksumData = data[0:26] 
-	//bytes 0-26 contain all the entry info and the key and value.
-	checksumData := data[0:offset] //we dont use data[0:] because we dont want to include the checksum in the checksum calculation. 
+	// checksumData = data[0:35]
+	//bytes 0-34 contain all the entry info and the key and value.
+	checksumData := data[0:offset] //we dont use data[0:] because we dont want to include the checksum in the checksum calculation.
 
-	//this runs the CRC32 hash function on the checksumData and returns a 32 bit number.
+	//this runs the CRC32 hash function (or the one the database picked, see checksum.go) on the checksumData and returns a 32 bit number.
 	//very sensitive to small changes in the data.
-	//Input:  27 bytes [0x01, 0x00, 0x00, ..., 0x6E] 
+	//Input:  35 bytes [0x01, 0x00, 0x00, ..., 0x6E]
 	//Output: 0x8A3F2B1C (a single 32-bit number)
-	checksum := crc32.ChecksumIEEE(checksumData)
+	e.Checksum = e.Algorithm.sum(checksumData)
 
 	//this converts the checksum into 4 bytes and writes it to the data array at the offset.
 	binary.LittleEndian.PutUint32(data[offset:offset+4], e.Checksum)
 
 	//Before:
-	//data[27:31] = [0x00, 0x00, 0x00, 0x00]  // Empty checksum space
+	//data[35:39] = [0x00, 0x00, 0x00, 0x00]  // Empty checksum space
 
 	//After PutUint32 with checksum = 0x8A3F2B1C:
-	//data[27:31] = [0x1C, 0x2B, 0x3F, 0x8A]  // Little-endian bytes
+	//data[35:39] = [0x1C, 0x2B, 0x3F, 0x8A]  // Little-endian bytes
 
 	return data
 }
@@ -109,7 +248,7 @@ func (e *LogEntry) Serialize() []byte {
 // Deserialize converts a byte slice into a LogEntry object
 func Deserialize(data []byte) (*LogEntry, error) {
 	//need at least minimum header size initialized
-	minHeaderSize := 8 + 4 + 1 + 2 + 2 + 4 // LSN, EntrySize, Type, KeyLen, ValueLen, Checksum
+	minHeaderSize := walHeaderSize + walChecksumSize // LSN, EntrySize, Type, KeyLen, ValueLen, Checksum
 	if len(data) < minHeaderSize {
 		return nil, errors.New("insufficient data for log entry header")
 	}
@@ -118,10 +257,10 @@ func Deserialize(data []byte) (*LogEntry, error) {
 	entry := &LogEntry{}
 
 	// Read LSN (8 bytes)
-	entry.LSN = binary.LittleEndian.Uint64(data[offset:offset+8])
+	entry.LSN = binary.LittleEndian.Uint64(data[offset : offset+8])
 	offset += 8
 	// Read EntrySize (4 bytes)
-	entry.EntrySize = binary.LittleEndian.Uint32(data[offset:offset+4])
+	entry.EntrySize = binary.LittleEndian.Uint32(data[offset : offset+4])
 	offset += 4
 
 	// Validate we have enough data
@@ -129,14 +268,18 @@ func Deserialize(data []byte) (*LogEntry, error) {
 		return nil, errors.New("incomplete log entry")
 	}
 
-	// Read Type (1 byte)
-	entry.Type = data[offset]
+	// Read Type (1 byte), its top bits are the checksum algorithm
+	entry.Type = data[offset] & walTypeMask
+	entry.Algorithm = ChecksumAlgorithm(data[offset] >> walChecksumShift)
 	offset += 1
+	// Read TxID (8 bytes)
+	entry.TxID = binary.LittleEndian.Uint64(data[offset : offset+8])
+	offset += 8
 	// Read KeyLen (2 bytes)
-	entry.KeyLen = binary.LittleEndian.Uint16(data[offset:offset+2])
+	entry.KeyLen = binary.LittleEndian.Uint16(data[offset : offset+2])
 	offset += 2
 	// Read ValueLen (2 bytes)
-	entry.ValueLen = binary.LittleEndian.Uint16(data[offset:offset+2])
+	entry.ValueLen = binary.LittleEndian.Uint16(data[offset : offset+2])
 	offset += 2
 
 	// Read Key
@@ -145,158 +288,337 @@ func Deserialize(data []byte) (*LogEntry, error) {
 	}
 	entry.Key = string(data[offset : offset+int(entry.KeyLen)])
 	offset += int(entry.KeyLen)
-	
+
 	// Read Value
 	if offset+int(entry.ValueLen) > len(data) {
 		return nil, errors.New("invalid value length")
 	}
 	entry.Value = string(data[offset : offset+int(entry.ValueLen)])
 	offset += int(entry.ValueLen)
-	
+
 	// Read Checksum (4 bytes)
 	if offset+4 > len(data) {
 		return nil, errors.New("missing checksum")
 	}
 	entry.Checksum = binary.LittleEndian.Uint32(data[offset : offset+4])
-	
+
 	return entry, nil
 }
 
 // checks if the checksum of the entry is valid
 func (e *LogEntry) ValidateChecksum() bool {
+	stored := e.Checksum
 
-	//re-serialize the entry
-	data := e.Serialize()
+	//re-serialize a copy of the entry, Serialize overwrites Checksum
+	copied := *e
+	data := copied.Serialize()
 
 	//calculate the checksum of data except the last 4 bytes
-	checksumData := data[0:len(data)-4]
-	//run the CRC32 hash function on the checksumData and returns a 32 bit number.
-	calculatedChecksum := crc32.ChecksumIEEE(checksumData)
+	checksumData := data[0 : len(data)-4]
+	//run the entry's hash function on the checksumData and returns a 32 bit number.
+	calculatedChecksum := e.Algorithm.sum(checksumData)
 
 	//compare the checksum of the re-serialized data to the checksum in the entry
-	return calculatedChecksum == e.Checksum
+	return calculatedChecksum == stored
 }
 
+// NewWAL opens (or creates) the log that belongs to the database at path.
 func NewWAL(path string) (*WAL, error) {
 	// WAL file path is the database path + ".wal" (ex. "test.db.wal")
-	walPath := dbPath + ".wal"
+	walPath := path + ".wal"
 
-	//
-	file, err := os.OpenFile(walPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	// read-write so ReadAll and the LSN scan can read it back
+	file, err := os.OpenFile(walPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open WAL file: %w", err)
 	}
 
 	wal := &WAL{
-		file: file,
-		path: walPath,
+		file:    file,
+		path:    walPath,
 		lastLSN: 0,
 	}
+	wal.wrote = sync.NewCond(&wal.mu)
 
 	stat, err := file.Stat()
 	if err != nil {
+		file.Close()
 		return nil, fmt.Errorf("failed to stat WAL file: %w", err)
 	}
 
+	wal.size = stat.Size()
 	if stat.Size() > 0 {
 		if err := wal.scanForLastLSN(); err != nil {
+			file.Close()
 			return nil, fmt.Errorf("failed to scan WAL file: %w", err)
 		}
 	}
 
-	// start the background goroutine to flush the WAL to disk
 	return wal, nil
-
 }
 
+// **What this does:**
+// - Reads through the entire WAL file
+// - Finds the highest LSN number
+// - Sets `lastLSN` so new entries continue from there
+//
+// **Example:**
+//
+//	WAL file contains:
+//	Entry 1: LSN=1
+//	Entry 2: LSN=2
+//	Entry 3: LSN=3
+//
+//	After scan: w.lastLSN = 3
+//	Next append will use: LSN=4
 func (w *WAL) scanForLastLSN() error {
-	// Get file size
-	stat, err := w.file.Stat()
+	entries, err := w.ReadAll()
 	if err != nil {
 		return err
 	}
-	
-	fileSize := stat.Size()
-	offset := int64(0)
-	
-	// Read through all entries
-	for offset < fileSize {
-		// Read entry header to get size
-		headerBuf := make([]byte, 12) // LSN(8) + EntrySize(4)
-		_, err := w.file.ReadAt(headerBuf, offset)
-		if err != nil {
-			// Reached end or corrupted entry
-			break
-		}
-		
-		lsn := binary.LittleEndian.Uint64(headerBuf[0:8])
-		entrySize := binary.LittleEndian.Uint32(headerBuf[8:12])
-		
+	for _, entry := range entries {
 		// Update lastLSN if this is higher
-		if lsn > w.lastLSN {
-			w.lastLSN = lsn
+		if entry.LSN > w.lastLSN {
+			w.lastLSN = entry.LSN
 		}
-		
-		// Move to next entry
-		offset += int64(entrySize)
 	}
-	
+	w.written.Store(w.lastLSN)
 	return nil
-	// **What this does:**
-// - Reads through the entire WAL file
-// - Finds the highest LSN number
-// - Sets `lastLSN` so new entries continue from there
-
-// **Example:**
-// ```
-// WAL file contains:
-// Entry 1: LSN=1
-// Entry 2: LSN=2  
-// Entry 3: LSN=3
+}
 
-// After scan: w.lastLSN = 3
-// Next append will use: LSN=4
+// Size returns how many bytes the log holds.
+func (w *WAL) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size
 }
 
+// LastLSN returns the LSN of the last entry appended (0 for an empty log).
+func (w *WAL) LastLSN() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastLSN
+}
 
 // Append writes a new log entry to the WAL
+//
+//	wal.Append(LogTypePut, "user:1", "john")
+//
+// Step by step:
+// 1. w.lastLSN++ → now lastLSN = 1
+// 2. Create entry with LSN=1
+// 3. Serialize: [39 bytes of data]
+// 4. Write to file at end
+// 5. Return LSN=1
 func (w *WAL) Append(typ byte, key, value string) (uint64, error) {
-	// Increment LSN for this new entry
-	w.lastLSN++
-	
-	// Create the log entry
-	entry := &LogEntry{
-		LSN:      w.lastLSN,
-		Type:     typ,
-		Key:      key,
-		Value:    value,
-		KeyLen:   uint16(len(key)),
-		ValueLen: uint16(len(value)),
-	}
-	
-	// Serialize to bytes
-	data := entry.Serialize()
-	
+	if typ == 0 || typ > logTypeMax {
+		return 0, fmt.Errorf("unknown WAL entry type %d", typ)
+	}
+	if typ >= LogTypeTxBegin {
+		return 0, fmt.Errorf("%s entries are written with BeginTx/CommitTx/AbortTx", LogTypeName(typ))
+	}
+	return w.appendEntry(&LogEntry{Type: typ, Key: key, Value: value})
+}
+
+// gives the entry the next LSN and writes it. the lock is only held to
+// number the entry and queue its bytes, the write happens outside it: the
+// appender that finds nobody writing writes everything queued so far, the
+// ones arriving meanwhile queue up behind it and go out with the next write,
+// the same way SyncTo shares fsyncs:
+//
+//	A: LSN 7 → write [7] ──────────────┐ returns
+//	B: LSN 8 → queued → write [8 9] ───┐ returns
+//	C: LSN 9 → queued → written by B ──┘ returns
+//
+// entries go to the file in LSN order, and each appender returns once its
+// own entry is written. a failed write fails every entry queued with it and
+// behind it, their LSNs are handed out again.
+func (w *WAL) appendEntry(entry *LogEntry) (uint64, error) {
+	if len(entry.Key) > walMaxFieldLen || len(entry.Value) > walMaxFieldLen {
+		return 0, fmt.Errorf("WAL entry too large: key %d bytes, value %d bytes (max %d each)", len(entry.Key), len(entry.Value), walMaxFieldLen)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	entry.LSN = w.lastLSN + 1
+	entry.Algorithm = w.checksum
+	if entry.Type == LogTypeTxBegin {
+		entry.TxID = entry.LSN // a transaction is named after its begin entry
+	}
+
+	// Serialize to bytes, queued behind the entries numbered before it
+	w.pending = append(w.pending, entry.Serialize()...)
+	w.lastLSN = entry.LSN
+	if w.batch == nil {
+		w.batch = &walBatch{}
+	}
+	batch := w.batch
+
+	for !batch.done {
+		if w.writing {
+			w.wrote.Wait()
+			continue
+		}
+		w.writePending()
+	}
+	if batch.err != nil {
+		return 0, batch.err
+	}
+	return entry.LSN, nil
+}
+
+// writes every queued entry with one write, called with w.mu held. the lock
+// is let go of during the write, so more appends can queue up.
+func (w *WAL) writePending() {
+	data, batch, last := w.pending, w.batch, w.lastLSN
+	w.pending, w.batch = nil, nil
+	w.writing = true
+	w.mu.Unlock()
+
 	// Write to file (goes to end because we opened with O_APPEND)
 	n, err := w.file.Write(data)
+	if err == nil && n != len(data) {
+		err = fmt.Errorf("incomplete WAL write: wrote %d of %d bytes", n, len(data))
+	}
+
+	w.mu.Lock()
+	w.writing = false
+	batch.done = true
+	defer w.wrote.Broadcast()
+	if err != nil {
+		// a torn entry would hide every entry after it from recovery (a full
+		// disk that frees up again), it goes. the entries queued meanwhile
+		// are numbered after the lost ones, they fail too, and a failed
+		// write doesn't burn LSNs
+		if n > 0 {
+			w.file.Truncate(w.size)
+		}
+		batch.err = fmt.Errorf("failed to write to WAL: %w", err)
+		if w.batch != nil {
+			w.batch.done, w.batch.err = true, batch.err
+		}
+		w.pending, w.batch = nil, nil
+		w.lastLSN = w.written.Load()
+		return
+	}
+	w.size += int64(n)
+	w.written.Store(last)
+	w.notifyReaders()
+}
+
+// BeginTx starts a transaction and returns its TxID. changes logged with
+// AppendTx under that TxID are ignored by recovery until CommitTx is logged.
+func (w *WAL) BeginTx() (uint64, error) {
+	txID, err := w.appendEntry(&LogEntry{Type: LogTypeTxBegin})
+	if err != nil {
+		return 0, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.openTx == nil {
+		w.openTx = map[uint64]bool{}
+	}
+	w.openTx[txID] = true
+	return txID, nil
+}
+
+// a transaction belongs to one goroutine, the others only share the lock
+func (w *WAL) txOpen(txID uint64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.openTx[txID]
+}
+
+// AppendTx logs a put or delete as part of transaction txID.
+func (w *WAL) AppendTx(txID uint64, typ byte, key, value string) (uint64, error) {
+	if typ != LogTypePut && typ != LogTypeDelete {
+		return 0, fmt.Errorf("only put and delete can be part of a transaction, not %s", LogTypeName(typ))
+	}
+	if !w.txOpen(txID) {
+		return 0, fmt.Errorf("transaction %d is not open", txID)
+	}
+	return w.appendEntry(&LogEntry{Type: typ, TxID: txID, Key: key, Value: value})
+}
+
+// CommitTx logs the commit of txID, once it's synced the transaction survives a crash.
+func (w *WAL) CommitTx(txID uint64) (uint64, error) {
+	return w.endTx(txID, LogTypeTxCommit)
+}
+
+// AbortTx logs that txID was rolled back, recovery drops its changes.
+func (w *WAL) AbortTx(txID uint64) (uint64, error) {
+	return w.endTx(txID, LogTypeTxAbort)
+}
+
+func (w *WAL) endTx(txID uint64, typ byte) (uint64, error) {
+	if !w.txOpen(txID) {
+		return 0, fmt.Errorf("transaction %d is not open", txID)
+	}
+	lsn, err := w.appendEntry(&LogEntry{Type: typ, TxID: txID})
 	if err != nil {
-		return 0, fmt.Errorf("failed to write to WAL: %w", err)
+		return 0, err
 	}
-	
-	if n != len(data) {
-		return 0, fmt.Errorf("incomplete WAL write: wrote %d of %d bytes", n, len(data))
+	w.mu.Lock()
+	delete(w.openTx, txID)
+	w.mu.Unlock()
+	return lsn, nil
+}
+
+// CommittedEntries picks out what recovery should apply from entries read
+// with ReadAll, in the order it should be applied. entries outside a
+// transaction come through where they are. the changes of a committed
+// transaction come through together at its commit. aborted and unfinished
+// transactions are left out, and so are the begin/commit/abort markers.
+func CommittedEntries(entries []*LogEntry) []*LogEntry {
+	committed := make([]*LogEntry, 0, len(entries))
+	pending := map[uint64][]*LogEntry{}
+	for _, e := range entries {
+		switch {
+		case e.Type == LogTypeTxBegin:
+			pending[e.TxID] = []*LogEntry{}
+		case e.Type == LogTypeTxCommit:
+			committed = append(committed, pending[e.TxID]...)
+			delete(pending, e.TxID)
+		case e.Type == LogTypeTxAbort:
+			delete(pending, e.TxID)
+		case e.TxID != 0:
+			// a change whose begin isn't in the log (truncated away) can't be trusted either
+			if ops, open := pending[e.TxID]; open {
+				pending[e.TxID] = append(ops, e)
+			}
+		default:
+			committed = append(committed, e)
+		}
 	}
-	
-	return w.lastLSN, nil
+	return committed
+}
 
-	// wal.Append(LogTypePut, "user:1", "john")
+// AppendCheckpointBegin logs that a checkpoint covering everything up to lsn has started.
+func (w *WAL) AppendCheckpointBegin(lsn uint64) (uint64, error) {
+	return w.Append(LogTypeCheckpointBegin, "", strconv.FormatUint(lsn, 10))
+}
 
-// Step by step:
-// 1. w.lastLSN++ → now lastLSN = 1
-// 2. Create entry with LSN=1
-// 3. Serialize: [31 bytes of data]
-// 4. Write to file at end
-// 5. Return LSN=1
+// AppendCheckpointEnd logs that every change up to lsn is now in the pages.
+func (w *WAL) AppendCheckpointEnd(lsn uint64) (uint64, error) {
+	return w.Append(LogTypeCheckpointEnd, "", strconv.FormatUint(lsn, 10))
+}
+
+// AppendBucketCreate logs that bucket name was created.
+func (w *WAL) AppendBucketCreate(name string) (uint64, error) {
+	return w.Append(LogTypeBucketCreate, name, "")
+}
+
+// AppendBucketDelete logs that bucket name was dropped.
+func (w *WAL) AppendBucketDelete(name string) (uint64, error) {
+	return w.Append(LogTypeBucketDelete, name, "")
+}
+
+// AppendCompaction logs a compaction marker, begin or end, with a short description.
+func (w *WAL) AppendCompaction(end bool, detail string) (uint64, error) {
+	if end {
+		return w.Append(LogTypeCompactionEnd, "", detail)
+	}
+	return w.Append(LogTypeCompactionBegin, "", detail)
 }
 
 // Sync forces the OS to write buffered data to physical disk
@@ -305,89 +627,142 @@ func (w *WAL) Sync() error {
 	return w.file.Sync()
 }
 
+// SyncTo returns once the entry lsn is on disk. callers arriving while an
+// fsync is running wait for it and then share the next one, so a crowd of
+// writers costs one fsync per batch instead of one each:
+//
+//	writer A: append 7 → SyncTo(7) → fsync ─────────────┐ returns
+//	writer B: append 8 → SyncTo(8) → waits ─→ fsync (8, 9) ─┐ returns
+//	writer C: append 9 → SyncTo(9) → waits ─→ covered by B's ─┘ returns
+//
+// synced says whether this caller ran an fsync itself.
+func (w *WAL) SyncTo(lsn uint64) (synced bool, err error) {
+	w.groupMu.Lock()
+	defer w.groupMu.Unlock()
+	if w.synced == nil {
+		w.synced = sync.NewCond(&w.groupMu)
+	}
+	for w.durableLSN < lsn {
+		if w.syncing {
+			w.synced.Wait()
+			continue
+		}
+		// everything written before the fsync starts is covered by it
+		w.syncing = true
+		target := w.written.Load()
+		w.groupMu.Unlock()
+		err := w.file.Sync()
+		w.groupMu.Lock()
+		w.syncing = false
+		w.synced.Broadcast()
+		if err != nil {
+			return synced, err
+		}
+		synced = true
+		if target > w.durableLSN {
+			w.durableLSN = target
+		}
+	}
+	return synced, nil
+}
+
 // ReadAll reads all log entries from the WAL file
+//
+// **What this does:**
+// - Reads the entire WAL file into memory
+// - Parses each entry one by one
+// - **Stops at first corrupted entry** (incomplete or bad checksum)
+// - Returns all valid entries
+//
+// **Example:**
+//
+//	WAL file (100 bytes):
+//	[Entry 1: 31 bytes, checksum ✓]
+//	[Entry 2: 35 bytes, checksum ✓]
+//	[Entry 3: 20 bytes, checksum ✗] ← Corrupted!
+//	[Entry 4: 14 bytes] ← Never checked
+//
+//	ReadAll() returns: [Entry 1, Entry 2]
+//	Stops at corrupted Entry 3
 func (w *WAL) ReadAll() ([]*LogEntry, error) {
 	// Get file size
 	stat, err := w.file.Stat()
 	if err != nil {
 		return nil, err
 	}
-	
+
 	fileSize := stat.Size()
 	if fileSize == 0 {
 		return []*LogEntry{}, nil // Empty WAL
 	}
-	
+
 	// Read entire file into memory
 	data := make([]byte, fileSize)
 	_, err = w.file.ReadAt(data, 0)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read WAL: %w", err)
 	}
-	
-	// Parse entries
+	return parseWALEntries(data), nil
+}
+
+// the entries of a whole WAL file up to the first bad one, for ReadAll and
+// for tools that read a WAL they didn't open (see repair.go)
+func parseWALEntries(data []byte) []*LogEntry {
 	entries := []*LogEntry{}
 	offset := 0
-	
+
 	for offset < len(data) {
 		// Need at least 12 bytes for header
 		if offset+12 > len(data) {
 			break // Not enough data for another entry
 		}
-		
+
 		// Read entry size
 		entrySize := binary.LittleEndian.Uint32(data[offset+8 : offset+12])
-		
+
+		// a size smaller than an empty entry would never move offset forward
+		if int(entrySize) < walHeaderSize+walChecksumSize {
+			break
+		}
+
 		// Check if we have complete entry
 		if offset+int(entrySize) > len(data) {
 			// Incomplete entry - stop here (probably crashed during write)
 			break
 		}
-		
+
 		// Deserialize entry
-		entry, err := DeserializeLogEntry(data[offset : offset+int(entrySize)])
+		entry, err := Deserialize(data[offset : offset+int(entrySize)])
 		if err != nil {
 			// Corrupted entry - stop here
 			break
 		}
-		
+
 		// Verify checksum
-		if !entry.VerifyChecksum() {
+		if !entry.ValidateChecksum() {
 			// Checksum mismatch - stop here (corrupted!)
 			break
 		}
-		
+
 		// Entry is valid, add to list
 		entries = append(entries, entry)
-		
+
 		// Move to next entry
 		offset += int(entrySize)
 	}
-	
-	return entries, nil
-	// **What this does:**
-// - Reads the entire WAL file into memory
-// - Parses each entry one by one
-// - **Stops at first corrupted entry** (incomplete or bad checksum)
-// - Returns all valid entries
-
-// **Example:**
-// 
-// WAL file (100 bytes):
-// [Entry 1: 31 bytes, checksum ✓]
-// [Entry 2: 35 bytes, checksum ✓]
-// [Entry 3: 20 bytes, checksum ✗] ← Corrupted!
-// [Entry 4: 14 bytes] ← Never checked
 
-// ReadAll() returns: [Entry 1, Entry 2]
-// Stops at corrupted Entry 3
+	return entries
 }
 
-
-
-
-// Close closes the WAL file
+// Close closes the WAL file, after the write that is running
 func (w *WAL) Close() error {
+	w.mu.Lock()
+	for w.writing {
+		w.wrote.Wait()
+	}
+	w.closed = true
+	w.notifyReaders()
+	w.mu.Unlock()
 	if w.file != nil {
 		return w.file.Close()
 	}
@@ -395,95 +770,43 @@ func (w *WAL) Close() error {
 }
 
 // Truncate removes all entries from the WAL
-// Used after checkpoint when all operations are safely in pages
+// Used after checkpoint when all operations are safely in pages.
+// LSNs keep counting up from where they were while the WAL stays open.
+// entries still queued by appends go into the emptied file.
 func (w *WAL) Truncate() error {
-	// Close current file
-	if err := w.file.Close(); err != nil {
-		return err
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.writing {
+		w.wrote.Wait()
 	}
-	
-	// Delete the file
-	if err := os.Remove(w.path); err != nil {
-		return err
+	// cut the file to nothing in place, the file never goes missing, so a
+	// crash right now leaves either the full log or an empty one
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate WAL: %w", err)
 	}
-	
-	// Create new empty WAL
-	file, err := os.OpenFile(w.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
-	if err != nil {
+	w.size = 0
+	w.truncations++
+	w.notifyReaders()
+	if err := w.file.Sync(); err != nil {
 		return err
 	}
-	
-	w.file = file
-	w.lastLSN = 0
-	
+	// the pages have everything, nobody waiting in SyncTo needs an fsync
+	w.groupMu.Lock()
+	w.durableLSN = w.written.Load()
+	if w.synced != nil {
+		w.synced.Broadcast()
+	}
+	w.groupMu.Unlock()
 	return nil
+}
 
-// 	// What this does:
-
-// Deletes the entire WAL file
-// Creates a fresh empty one
-// Used after checkpoint (we'll cover this later)
-}
-// TestWALOperations tests writing and reading WAL entries
-func TestWALOperations() {
-	fmt.Println("\n=== Testing WAL Operations ===")
-	
-	// Clean up any existing test WAL
-	os.Remove("test_wal.db.wal")
-	
-	// 1. Create new WAL
-	wal, err := NewWAL("test_wal.db")
-	if err != nil {
-		fmt.Printf("❌ Failed to create WAL: %v\n", err)
-		return
-	}
-	fmt.Println("✓ Created WAL file")
-	
-	// 2. Write some entries
-	lsn1, _ := wal.Append(LogTypePut, "user:1", "john_doe")
-	fmt.Printf("✓ Appended entry LSN=%d: PUT user:1=john_doe\n", lsn1)
-	
-	lsn2, _ := wal.Append(LogTypePut, "user:2", "jane_smith")
-	fmt.Printf("✓ Appended entry LSN=%d: PUT user:2=jane_smith\n", lsn2)
-	
-	lsn3, _ := wal.Append(LogTypeDelete, "user:1", "")
-	fmt.Printf("✓ Appended entry LSN=%d: DELETE user:1\n", lsn3)
-	
-	// 3. Sync to disk
-	wal.Sync()
-	fmt.Println("✓ Synced to disk")
-	
-	// 4. Read entries back
-	entries, err := wal.ReadAll()
-	if err != nil {
-		fmt.Printf("❌ Failed to read WAL: %v\n", err)
-		return
+// makes sure the next LSN handed out is above lsn. after a Truncate and a
+// reopen the log is empty, the database header knows where LSNs were.
+func (w *WAL) advanceLSN(lsn uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if lsn > w.lastLSN {
+		w.lastLSN = lsn
+		w.written.Store(lsn)
 	}
-	
-	fmt.Printf("\n✓ Read %d entries from WAL:\n", len(entries))
-	for _, entry := range entries {
-		typeName := "PUT"
-		if entry.Type == LogTypeDelete {
-			typeName = "DELETE"
-		}
-		fmt.Printf("  LSN=%d %s %s=%s (checksum: %08X)\n", 
-			entry.LSN, typeName, entry.Key, entry.Value, entry.Checksum)
-	}
-	
-	// 5. Close
-	wal.Close()
-	fmt.Println("\n✓ Closed WAL")
-	
-	// 6. Reopen and verify persistence
-	fmt.Println("\n--- Testing Persistence ---")
-	wal2, _ := NewWAL("test_wal.db")
-	fmt.Printf("✓ Reopened WAL, lastLSN=%d\n", wal2.lastLSN)
-	
-	entries2, _ := wal2.ReadAll()
-	fmt.Printf("✓ Still has %d entries after reopen\n", len(entries2))
-	
-	wal2.Close()
-	
-	// Cleanup
-	os.Remove("test_wal.db.wal")
-}
\ No newline at end of file
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// tailing the WAL: a WALReader follows a storage's log from an LSN on and
+// waits for new entries, for a process that ships them somewhere (a
+// replica, see ApplyReplicated, an audit log, a change feed) while the
+// storage keeps writing:
+//
+//	r, err := db.TailWAL(lastShipped)
+//	defer r.Close()
+//	for {
+//		entry, err := r.Next(ctx) // blocks until there is one
+//		if errors.Is(err, ErrWALGap) {
+//			// the entries after lastShipped were checkpointed away, start over from a copy
+//		}
+//		ship(entry)
+//	}
+//
+// entries come in LSN order, every one of them: puts and deletes, the
+// markers of transactions, checkpoints and compactions. a transaction's
+// entries can't be acted on before its commit (CommittedEntries, or
+// ApplyReplicated on the other end, takes care of that). an entry is handed
+// out once it was written to the file, before it was fsynced: a power cut
+// can take back what a reader already has, which a replica fed with it
+// then has and the primary doesn't.
+//
+// the WAL is emptied by every checkpoint (Sync, the checkpointer), its
+// entries are in the pages then. a reader that falls behind a checkpoint
+// gets ErrWALGap, the entries it needs next are gone from the log. it
+// reads the file with ReadAt, no lock of the storage is held while it waits
+// or reads.
+
+// ErrWALGap is returned by WALReader.Next when the entries it has to hand
+// out next were emptied out of the WAL by a checkpoint.
+var ErrWALGap = errors.New("WAL entries are gone, checkpointed away")
+
+// WALReader follows a storage's WAL, see walreader.go. it belongs to one
+// goroutine.
+type WALReader struct {
+	wal         *WAL
+	last        uint64 // the LSN handed out last
+	offset      int64  // where the next unread entry starts in the file
+	truncations uint64 // the WAL's count when offset was right
+	buffered    []*LogEntry
+}
+
+// TailWAL returns a reader of the WAL entries after LSN after. a storage
+// opened read-only has no WAL to follow.
+func (s *Storage) TailWAL(after uint64) (*WALReader, error) {
+	if s.wal == nil {
+		return nil, fmt.Errorf("tail WAL: %w", ErrOpenedReadOnly)
+	}
+	s.wal.mu.Lock()
+	truncations := s.wal.truncations
+	s.wal.mu.Unlock()
+	return &WALReader{wal: s.wal, last: after, truncations: truncations}, nil
+}
+
+// Next returns the entry after the last one it returned, waiting for it to
+// be written until ctx is done.
+func (r *WALReader) Next(ctx context.Context) (*LogEntry, error) {
+	for {
+		if len(r.buffered) > 0 {
+			e := r.buffered[0]
+			r.buffered = r.buffered[1:]
+			r.last = e.LSN
+			return e, nil
+		}
+		changed, err := r.read()
+		if err != nil || len(r.buffered) > 0 {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// reads the entries written since the last read into buffered, and returns
+// the channel that says there are more
+func (r *WALReader) read() (<-chan struct{}, error) {
+	w := r.wal
+	w.mu.Lock()
+	if w.changed == nil {
+		w.changed = make(chan struct{})
+	}
+	changed, truncations, size, closed := w.changed, w.truncations, w.size, w.closed
+	w.mu.Unlock()
+	if closed {
+		return nil, errors.New("tail WAL: the storage was closed")
+	}
+	if truncations != r.truncations {
+		r.offset, r.truncations = 0, truncations
+	}
+	if size <= r.offset {
+		return changed, nil
+	}
+
+	data := make([]byte, size-r.offset)
+	if _, err := w.file.ReadAt(data, r.offset); err != nil {
+		return nil, fmt.Errorf("tail WAL: %w", err)
+	}
+	w.mu.Lock()
+	emptied := w.truncations != truncations
+	w.mu.Unlock()
+	if emptied {
+		// the bytes may be from after it, read again from the start
+		return closedChan(), nil
+	}
+
+	for _, e := range parseWALEntries(data) {
+		r.offset += int64(e.EntrySize)
+		if e.LSN <= r.last {
+			continue // before the LSN the reader started at
+		}
+		if want := r.last + 1; e.LSN != want && len(r.buffered) == 0 {
+			return nil, fmt.Errorf("%w: LSN %d is next, the WAL goes on at %d", ErrWALGap, want, e.LSN)
+		}
+		r.buffered = append(r.buffered, e)
+	}
+	return changed, nil
+}
+
+// Close lets go of the reader, the storage stays open.
+func (r *WALReader) Close() error {
+	r.buffered = nil
+	return nil
+}
+
+// wakes up the readers waiting for entries, called with w.mu held
+func (w *WAL) notifyReaders() {
+	if w.changed != nil {
+		close(w.changed)
+		w.changed = nil
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// cache warm-up: with Options.WarmPages set, Close writes the IDs of the
+// pages used most recently to <file>.warm, and the next open loads them back
+// into the page cache in the background, so a restarted instance doesn't
+// answer its first requests from a cold disk:
+//
+//	run 1:  Get, Get, Put ...  Close ──► orders.db.warm  {"pages":[12,3,40,...]}
+//	run 2:  open ──► serving right away, pages 12, 3, 40 ... loading behind it
+//
+// the list is only a hint. a missing or unreadable file means no warm-up, and
+// pages that no longer exist are skipped.
+
+const warmSuffix = ".warm"
+
+type warmSet struct {
+	Pages []uint32 `json:"pages"` // most recently used first
+}
+
+// counts a page use, called by loadPage with cacheMu held. pageUse is nil
+// (tracking off) until the storage is open, the index build touches every
+// page and says nothing about what the application uses.
+func (s *Storage) notePageUse(pageID uint32) {
+	if s.pageUse == nil {
+		return
+	}
+	s.useTick++
+	s.pageUse[pageID] = s.useTick
+}
+
+// the n most recently used pages, most recent first
+func (s *Storage) recentPages(n int) []uint32 {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	pages := make([]uint32, 0, len(s.pageUse))
+	for id := range s.pageUse {
+		pages = append(pages, id)
+	}
+	sort.Slice(pages, func(i, j int) bool { return s.pageUse[pages[i]] > s.pageUse[pages[j]] })
+	if len(pages) > n {
+		pages = pages[:n]
+	}
+	return pages
+}
+
+// called by Close
+func (s *Storage) saveWarmSet() error {
+	if s.opts.WarmPages <= 0 {
+		return nil
+	}
+	data, err := json.Marshal(warmSet{Pages: s.recentPages(s.opts.WarmPages)})
+	if err != nil {
+		return err
+	}
+	path := s.file.Name() + warmSuffix
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("save warm set: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("save warm set: %w", err)
+	}
+	return nil
+}
+
+// starts tracking page use and loads the pages the last run left in the warm
+// set, called once the storage is open
+func (s *Storage) startWarmUp() {
+	if s.opts.WarmPages <= 0 {
+		s.warmDone = closedChan()
+		return
+	}
+	s.pageUse = make(map[uint32]uint64)
+
+	var set warmSet
+	data, err := os.ReadFile(s.file.Name() + warmSuffix)
+	if err == nil {
+		err = json.Unmarshal(data, &set)
+	}
+	if err != nil {
+		s.warmDone = closedChan()
+		return
+	}
+	var pages []uint32
+	for _, id := range set.Pages {
+		if id < s.totalPages && len(pages) < s.opts.WarmPages {
+			pages = append(pages, id)
+		}
+	}
+	// in file order, like Prefetch
+	sort.Slice(pages, func(i, j int) bool { return pages[i] < pages[j] })
+	s.warmDone = s.loadPagesInBackground(pages)
+}
+
+// WarmedUp returns a channel that is closed once the pages of the warm set
+// are loaded, right away when there was nothing to load.
+func (s *Storage) WarmedUp() <-chan struct{} {
+	return s.warmDone
+}
+
+func closedChan() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// verified writes: with Options.VerifyWrites writePage reads every page back
+// once it is synced and compares it with what it wrote, for deployments on
+// storage that can't be trusted (cheap SSDs, network block devices, RAID
+// controllers with a cache of their own):
+//
+//	opts.VerifyWrites = true
+//	opts.DirectIO = true // read the disk, not the OS cache
+//
+// without DirectIO the read back comes from the OS page cache, which holds
+// what was just written: it catches a write that went wrong on the way
+// there, not a disk that stored something else. with it the read goes
+// through an O_DIRECT handle to the device, where O_DIRECT isn't
+// available (tmpfs, some platforms) it falls back to the normal one.
+//
+// a page that reads back different fails the write with ErrCorrupted and
+// stays dirty, the next flush writes it again. Stats count the pages read
+// back (PagesVerified) and the mismatches (WriteMismatches). the price is a
+// page read for every page written.
+
+// called by writePage after the sync
+func (s *Storage) verifyWrite(page *Page, offset int64) error {
+	if !s.opts.VerifyWrites {
+		return nil
+	}
+	if s.verifier == nil && s.opts.DirectIO {
+		if dr, err := openDirectReader(s.file.Name()); err == nil {
+			s.verifier = dr
+		}
+	}
+
+	got := make([]byte, PageSize)
+	var err error
+	if s.verifier != nil {
+		err = s.verifier.ReadAt(got, offset, alignedBlock(directPageSpan(PageSize)))
+	} else {
+		_, err = s.file.ReadAt(got, offset)
+	}
+	if err != nil {
+		return &StorageError{Op: "verify page write", PageID: int64(page.ID), Offset: offset, Err: err}
+	}
+	s.stats.pagesVerified.Add(1)
+	if i := firstDifference(got, page.Data[:]); i >= 0 {
+		s.stats.writeMismatches.Add(1)
+		return corruptedPage("verify page write", page.ID, offset,
+			fmt.Errorf("the page reads back different from what was written, from byte %d on", i))
+	}
+	return nil
+}
+
+// the index of the first byte a and b differ in, -1 when they don't
+func firstDifference(a, b []byte) int {
+	if bytes.Equal(a, b) {
+		return -1
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return len(a)
+}
+
+func (s *Storage) closeVerifier() {
+	if s.verifier != nil {
+		s.verifier.Close()
+		s.verifier = nil
+	}
+}